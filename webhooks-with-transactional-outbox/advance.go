@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// invoiceTransitions lists the statuses advance can move an invoice to from
+// each status it picks up. Paid and overdue invoices are left alone.
+var invoiceTransitions = map[string][]string{
+	"draft": {"sent"},
+	"sent":  {"paid", "overdue"},
+}
+
+// advanceOrder is the order advance reads invoices in, so a run reports
+// drafts before the invoices that were already sent
+var advanceOrder = []string{"draft", "sent"}
+
+// errInvoiceChanged is returned when an invoice no longer has the status it
+// was read with, because something else advanced it first
+var errInvoiceChanged = errors.New("invoice status changed concurrently")
+
+// nextInvoiceStatus picks the status an invoice moves to from status
+func nextInvoiceStatus(status string) (string, bool) {
+	next := invoiceTransitions[status]
+	if len(next) == 0 {
+		return "", false
+	}
+	return next[random.Intn(len(next))], true
+}
+
+// invoiceFromRow converts a stored invoice back into the shape events carry
+func invoiceFromRow(row db.Invoice) Invoice {
+	return Invoice{
+		ID:          row.ID,
+		BusinessID:  row.BusinessID,
+		AmountCents: row.AmountCents,
+		Currency:    row.Currency,
+		Status:      row.Status,
+		CreatedAt:   row.CreatedAt.Time,
+		Description: row.Description.String,
+	}
+}
+
+// advanceInvoice moves the invoice to status and writes the matching
+// invoice.<status> event in a single transaction, so either both are
+// written or neither is
+func advanceInvoice(ctx context.Context, store Store, row db.Invoice, status string, opts ingestOptions) (Event, error) {
+	invoice := invoiceFromRow(row)
+	invoice.Status = status
+	event, err := newEvent(invoice.BusinessID, "invoice."+status, invoice)
+	if err != nil {
+		return Event{}, fmt.Errorf("error building invoice.%s event: %v", status, err)
+	}
+	event.AggregateID = invoice.ID
+
+	ctx, cancel := dbContext(ctx, opts.DBTimeout)
+	defer cancel()
+
+	tx, err := store.Begin(ctx, nil)
+	if err != nil {
+		return Event{}, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Only move the invoice on from the status it was read with, so two
+	// concurrent runs can't both advance it
+	updated, err := tx.UpdateInvoiceStatus(ctx, db.UpdateInvoiceStatusParams{
+		Status:     status,
+		ID:         row.ID,
+		FromStatus: row.Status,
+	})
+	if err != nil {
+		return Event{}, fmt.Errorf("error updating invoice status: %v", err)
+	}
+	if updated == 0 {
+		return Event{}, errInvoiceChanged
+	}
+
+	events := []Event{event}
+	if err := createEvents(ctx, tx, events, opts); err != nil {
+		return Event{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Event{}, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return events[0], nil
+}
+
+// runAdvance moves up to limit invoices of each status in invoiceTransitions
+// one step along their lifecycle. The invoices are all read before any is
+// advanced, so a draft is never sent and paid in the same run.
+func runAdvance(ctx context.Context, store Store, limit int64, opts ingestOptions) error {
+	var invoices []db.Invoice
+	for _, status := range advanceOrder {
+		dbCtx, cancel := dbContext(ctx, opts.DBTimeout)
+		rows, err := store.GetInvoicesByStatus(dbCtx, db.GetInvoicesByStatusParams{
+			Status: status,
+			Limit:  limit,
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error fetching %s invoices: %v", status, err)
+		}
+		invoices = append(invoices, rows...)
+	}
+
+	advanced := 0
+	for _, invoice := range invoices {
+		if ctx.Err() != nil {
+			break
+		}
+		status, ok := nextInvoiceStatus(invoice.Status)
+		if !ok {
+			continue
+		}
+
+		event, err := advanceInvoice(ctx, store, invoice, status, opts)
+		if errors.Is(err, errInvoiceChanged) {
+			slog.Info("Skipping invoice advanced elsewhere", "invoice_id", invoice.ID, "business_id", invoice.BusinessID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error advancing invoice %s after advancing %d: %v", invoice.ID, advanced, err)
+		}
+		slog.Info("Advanced invoice", "invoice_id", invoice.ID, "business_id", invoice.BusinessID, "from", invoice.Status, "to", status, "event_type", event.Type)
+		advanced++
+	}
+
+	fmt.Printf("Advanced %d invoices\n", advanced)
+	return nil
+}
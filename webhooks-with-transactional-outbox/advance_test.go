@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// failingCodec is a JSON codec that fails to encode every payload, so the
+// event of a change can't be written
+type failingCodec struct{}
+
+func (failingCodec) Name() string { return codecJSON }
+
+func (failingCodec) Encode(payload []byte) ([]byte, error) {
+	return nil, errors.New("codec unavailable")
+}
+
+// storeDraftInvoice stores a draft invoice with its invoice.created event,
+// returning the invoice as read back from the database
+func storeDraftInvoice(t *testing.T, store Store) db.Invoice {
+	t.Helper()
+	invoice := generateInvoice(businessIDs[0])
+	invoice.Status = "draft"
+	if _, err := createInvoiceWithEvents(context.Background(), store, invoice, testIngestOptions(t)); err != nil {
+		t.Fatalf("storing invoice: %v", err)
+	}
+	drafts, err := store.GetInvoicesByStatus(context.Background(), db.GetInvoicesByStatusParams{Status: "draft", Limit: 10})
+	if err != nil {
+		t.Fatalf("fetching drafts: %v", err)
+	}
+	if len(drafts) != 1 {
+		t.Fatalf("%d drafts stored, want 1", len(drafts))
+	}
+	return drafts[0]
+}
+
+// invoiceStatus reads the current status of an invoice
+func invoiceStatus(t *testing.T, dbConn *sql.DB, id string) string {
+	t.Helper()
+	var status string
+	if err := dbConn.QueryRow("SELECT status FROM invoices WHERE id = ?", id).Scan(&status); err != nil {
+		t.Fatalf("reading invoice status: %v", err)
+	}
+	return status
+}
+
+func TestAdvanceInvoice(t *testing.T) {
+	t.Run("draft is sent", func(t *testing.T) {
+		store, dbConn := newTestStore(t)
+		draft := storeDraftInvoice(t, store)
+
+		if err := runAdvance(context.Background(), store, 10, testIngestOptions(t)); err != nil {
+			t.Fatalf("advancing invoices: %v", err)
+		}
+
+		if status := invoiceStatus(t, dbConn, draft.ID); status != "sent" {
+			t.Errorf("invoice is %s, want sent", status)
+		}
+		events, err := store.ListEvents(context.Background())
+		if err != nil {
+			t.Fatalf("listing events: %v", err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("%d events stored, want invoice.created and invoice.sent", len(events))
+		}
+		sent := events[1]
+		if sent.EventType != "invoice.sent" || sent.AggregateID.String != draft.ID {
+			t.Errorf("stored %s event for %q, want invoice.sent for %s", sent.EventType, sent.AggregateID.String, draft.ID)
+		}
+		var payload struct {
+			Data Invoice `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(sent.Payload), &payload); err != nil {
+			t.Fatalf("decoding payload: %v", err)
+		}
+		if payload.Data.Status != "sent" {
+			t.Errorf("event carries an invoice that is %s, want sent", payload.Data.Status)
+		}
+	})
+
+	t.Run("failed event rolls back status", func(t *testing.T) {
+		store, dbConn := newTestStore(t)
+		draft := storeDraftInvoice(t, store)
+
+		opts := testIngestOptions(t)
+		opts.Codec = failingCodec{}
+		if _, err := advanceInvoice(context.Background(), store, draft, "sent", opts); err == nil {
+			t.Fatalf("advancing with a failing codec succeeded, want an error")
+		}
+
+		if status := invoiceStatus(t, dbConn, draft.ID); status != "draft" {
+			t.Errorf("invoice is %s after its event failed, want draft", status)
+		}
+		events, err := store.ListEvents(context.Background())
+		if err != nil {
+			t.Fatalf("listing events: %v", err)
+		}
+		if len(events) != 1 {
+			t.Errorf("%d events stored, want only invoice.created", len(events))
+		}
+	})
+
+	t.Run("invoice advanced elsewhere", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		draft := storeDraftInvoice(t, store)
+
+		if _, err := advanceInvoice(context.Background(), store, draft, "sent", testIngestOptions(t)); err != nil {
+			t.Fatalf("advancing invoice: %v", err)
+		}
+		// draft still holds the status it was read with
+		if _, err := advanceInvoice(context.Background(), store, draft, "sent", testIngestOptions(t)); !errors.Is(err, errInvoiceChanged) {
+			t.Errorf("advancing a stale invoice returned %v, want %v", err, errInvoiceChanged)
+		}
+	})
+}
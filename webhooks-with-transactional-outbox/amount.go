@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Amounts are integers counted in the minor unit of their currency, cents
+// for USD and yen for JPY, so they are stored, summed and compared exactly.
+// They only become decimals to be read or written by people, by the
+// functions below, which never go through a float.
+
+// defaultCurrencyDecimals is the decimal places an amount in a currency
+// missing from invoiceCurrencies is read with. Such an invoice is rejected by
+// validateInvoice along with its other offending fields, so the amount only
+// needs to be read well enough to be checked.
+const defaultCurrencyDecimals = 2
+
+// parseAmount converts a decimal amount such as "19.99" into minor units of
+// currency, 1999 for USD. It has at most as many decimal places as the
+// currency, so nothing is rounded away.
+func parseAmount(amount, currency string) (int64, error) {
+	decimals, ok := invoiceCurrencies[currency]
+	if !ok {
+		decimals = defaultCurrencyDecimals
+	}
+
+	whole, fraction, hasPoint := strings.Cut(amount, ".")
+	negative := strings.HasPrefix(whole, "-")
+	whole = strings.TrimPrefix(whole, "-")
+	if whole == "" || strings.HasPrefix(whole, "+") || hasPoint && fraction == "" {
+		return 0, fmt.Errorf("amount %q is not a number", amount)
+	}
+	if len(fraction) > decimals {
+		return 0, fmt.Errorf("amount %q has more than the %d decimal places of %s", amount, decimals, currency)
+	}
+
+	digits := whole + fraction + strings.Repeat("0", decimals-len(fraction))
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || strings.ContainsAny(digits, "+-") {
+		return 0, fmt.Errorf("amount %q is not a number", amount)
+	}
+	if negative {
+		units = -units
+	}
+	return units, nil
+}
+
+// formatAmount formats minor units of currency as a decimal with the
+// currency's decimal places, 1999 USD as "19.99". Units of a currency
+// missing from invoiceCurrencies are formatted as they are.
+func formatAmount(units int64, currency string) string {
+	decimals := invoiceCurrencies[currency]
+	if decimals == 0 {
+		return strconv.FormatInt(units, 10)
+	}
+
+	sign := ""
+	digits := strconv.FormatInt(units, 10)
+	if units < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	point := len(digits) - decimals
+	return sign + digits[:point] + "." + digits[point:]
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestParseAndFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		units    int64
+		formats  string
+	}{
+		{"19.99", "USD", 1999, "19.99"},
+		{"0.01", "EUR", 1, "0.01"},
+		{"120.5", "GBP", 12050, "120.50"},
+		{"7", "USD", 700, "7.00"},
+		{"-5.25", "USD", -525, "-5.25"},
+		{"1500", "JPY", 1500, "1500"},
+	}
+	for _, tt := range tests {
+		units, err := parseAmount(tt.amount, tt.currency)
+		if err != nil {
+			t.Errorf("parsing %s %s: %v", tt.amount, tt.currency, err)
+			continue
+		}
+		if units != tt.units {
+			t.Errorf("%s %s parsed as %d, want %d", tt.amount, tt.currency, units, tt.units)
+		}
+		if got := formatAmount(units, tt.currency); got != tt.formats {
+			t.Errorf("%d %s formatted as %q, want %q", units, tt.currency, got, tt.formats)
+		}
+	}
+
+	for _, tt := range []struct{ amount, currency string }{
+		{"19.999", "USD"},
+		{"19.5", "JPY"},
+		{"19.", "USD"},
+		{".5", "USD"},
+		{"1,000", "USD"},
+		{"+5", "USD"},
+	} {
+		if units, err := parseAmount(tt.amount, tt.currency); err == nil {
+			t.Errorf("parsing %s %s gave %d, want an error", tt.amount, tt.currency, units)
+		}
+	}
+}
+
+func TestAmountsDontDrift(t *testing.T) {
+	// Ten 0.10 add up to 0.9999999999999999 as floats
+	var total int64
+	for i := 0; i < 10; i++ {
+		units, err := parseAmount("0.10", "USD")
+		if err != nil {
+			t.Fatalf("parsing: %v", err)
+		}
+		total += units
+	}
+	if total != 100 || formatAmount(total, "USD") != "1.00" {
+		t.Errorf("ten 0.10 USD add up to %d cents, %s, want 100, 1.00", total, formatAmount(total, "USD"))
+	}
+
+	// Every amount survives being stored and read back
+	store, dbConn := newTestStore(t)
+	invoice := generateInvoice(businessIDs[0])
+	invoice.AmountCents, _ = parseAmount("19.99", "USD")
+	if _, err := createInvoiceWithEvents(context.Background(), store, invoice, testIngestOptions(t)); err != nil {
+		t.Fatalf("storing invoice: %v", err)
+	}
+	var stored int64
+	if err := dbConn.QueryRow("SELECT amount_cents FROM invoices WHERE id = ?", invoice.ID).Scan(&stored); err != nil {
+		t.Fatalf("reading invoice: %v", err)
+	}
+	if stored != 1999 || formatAmount(stored, "USD") != "19.99" {
+		t.Errorf("stored %d cents, %s, want 1999, 19.99", stored, formatAmount(stored, "USD"))
+	}
+}
+
+func TestAmountMigration(t *testing.T) {
+	ctx := context.Background()
+	conn := openTestDB(t)
+	migrations, err := driverMigrations(driverSQLite)
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	var before []migration
+	for _, m := range migrations {
+		if m.Name == "invoice_amount_cents" {
+			break
+		}
+		before = append(before, m)
+	}
+	if _, err := newMigrator(conn, before).up(ctx); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+
+	// 19.99 * 100 is 1998.9999999999998 as a float, so the amounts must be
+	// rounded, not truncated
+	if _, err := conn.Exec(`INSERT INTO businesses (id, name) VALUES ('b', 'b');
+		INSERT INTO invoices (id, business_id, amount, currency, status) VALUES
+			('INV-1', 'b', 19.99, 'USD', 'paid'),
+			('INV-2', 'b', 1500, 'JPY', 'paid')`); err != nil {
+		t.Fatalf("storing invoices: %v", err)
+	}
+	// One invoice of 1 in each currency, which migrates to a unit followed
+	// by as many zeros as the currency has decimal places
+	want := map[string]int64{"INV-1": 1999, "INV-2": 1500}
+	for currency, decimals := range invoiceCurrencies {
+		id := "INV-" + currency
+		if _, err := conn.Exec("INSERT INTO invoices (id, business_id, amount, currency, status) VALUES (?, 'b', 1, ?, 'paid')", id, currency); err != nil {
+			t.Fatalf("storing invoice: %v", err)
+		}
+		want[id] = 1
+		for i := 0; i < decimals; i++ {
+			want[id] *= 10
+		}
+	}
+	if _, err := newMigrator(conn, migrations).up(ctx); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+
+	for id, want := range want {
+		var units int64
+		if err := conn.QueryRow("SELECT amount_cents FROM invoices WHERE id = ?", id).Scan(&units); err != nil {
+			t.Fatalf("reading %s: %v", id, err)
+		}
+		if units != want {
+			t.Errorf("%s migrated to %d minor units, want %d", id, units, want)
+		}
+	}
+}
+
+func TestAmountMigrationsAgree(t *testing.T) {
+	// The migrations can't read invoiceCurrencies, so each spells out its
+	// own scale per currency, which TestAmountMigration checks against it
+	// for SQLite. The Postgres migration must use the same one.
+	scale := func(driver string) string {
+		up, err := fs.ReadFile(db.Migrations, "migrations/"+driver+"/0012_invoice_amount_cents.up.sql")
+		if err != nil {
+			t.Fatalf("reading %s migration: %v", driver, err)
+		}
+		start := strings.Index(string(up), "CASE currency")
+		end := strings.Index(string(up), " END")
+		if start < 0 || end < start {
+			t.Fatalf("%s migration has no CASE currency ... END", driver)
+		}
+		return string(up[start:end])
+	}
+	if sqlite, postgres := scale("sqlite"), scale("postgres"); sqlite != postgres {
+		t.Errorf("the SQLite migration scales amounts by\n%s\nthe Postgres one by\n%s", sqlite, postgres)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// Statuses of a row in event_attempts
+const (
+	attemptDelivered = "delivered"
+	attemptFailed    = "failed"
+)
+
+// recordAttempt adds a delivery attempt of event to event_attempts, failed
+// with cause when it is set. It is written straight to the store rather
+// than in a lock transaction, so the history of a batch survives a worker
+// that dies before committing it. A row that can't be written is only
+// logged, since the delivery itself stands either way.
+func (p *eventProcessor) recordAttempt(ctx context.Context, event db.Event, latency time.Duration, cause error) {
+	ctx, cancel := dbContext(context.WithoutCancel(ctx), p.dbTimeout)
+	defer cancel()
+
+	attempt := db.CreateEventAttemptParams{
+		EventID:   event.ID,
+		Status:    attemptDelivered,
+		LatencyMs: latency.Milliseconds(),
+	}
+	if cause != nil {
+		attempt.Status = attemptFailed
+		attempt.Error = sql.NullString{String: cause.Error(), Valid: true}
+	}
+	if err := p.store.CreateEventAttempt(ctx, attempt); err != nil {
+		slog.Error("Error recording delivery attempt", "event_id", event.ID, "error", err)
+	}
+}
+
+func runAttempts(ctx context.Context, queries Querier, eventID string) error {
+	attempts, err := queries.ListEventAttempts(ctx, eventID)
+	if err != nil {
+		return fmt.Errorf("error listing attempts: %v", err)
+	}
+	if len(attempts) == 0 {
+		fmt.Printf("No delivery attempts recorded for event %s.\n", eventID)
+		return nil
+	}
+
+	fmt.Printf("Event ID:  %s\n\n", eventID)
+	fmt.Printf("%-4s  %-20s  %-9s  %8s  %s\n", "#", "ATTEMPTED AT", "STATUS", "LATENCY", "ERROR")
+	for _, attempt := range attempts {
+		attemptError := "-"
+		if attempt.Error.Valid {
+			attemptError = attempt.Error.String
+		}
+		latency := time.Duration(attempt.LatencyMs) * time.Millisecond
+		fmt.Printf("%-4d  %-20s  %-9s  %8s  %s\n", attempt.AttemptNo, attempt.AttemptedAt.UTC().Format(time.RFC3339), attempt.Status, latency, attemptError)
+	}
+	return nil
+}
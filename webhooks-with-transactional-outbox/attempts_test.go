@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEventAttempts(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 1, testIngestOptions(t))
+	stored, err := store.ListEvents(context.Background())
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	eventID := stored[0].ID
+
+	publisher := &fakePublisher{err: errors.New("sink unavailable")}
+	processor := &eventProcessor{store: store, publisher: publisher, retry: testWorkerOptions().Retry, throttle: &throttle{}}
+
+	// Two failed deliveries, then one that goes through
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			publisher.err = nil
+		}
+		event, err := store.GetEventByID(context.Background(), eventID)
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		processor.process(context.Background(), event)
+	}
+
+	attempts, err := store.ListEventAttempts(context.Background(), eventID)
+	if err != nil {
+		t.Fatalf("listing attempts: %v", err)
+	}
+	wantStatuses := []string{attemptFailed, attemptFailed, attemptDelivered}
+	if len(attempts) != len(wantStatuses) {
+		t.Fatalf("recorded %d attempts, want %d", len(attempts), len(wantStatuses))
+	}
+	for i, attempt := range attempts {
+		if attempt.AttemptNo != int64(i+1) || attempt.Status != wantStatuses[i] {
+			t.Errorf("attempt %d is #%d %s, want #%d %s", i, attempt.AttemptNo, attempt.Status, i+1, wantStatuses[i])
+		}
+		if failed := attempt.Status == attemptFailed; attempt.Error.Valid != failed || (failed && attempt.Error.String != "sink unavailable") {
+			t.Errorf("attempt #%d has error %v, want the sink's error only on failures", attempt.AttemptNo, attempt.Error)
+		}
+	}
+
+	if err := runAttempts(context.Background(), store, eventID); err != nil {
+		t.Errorf("printing attempts: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// bannerLine is a single setting shown in the startup banner
+type bannerLine struct {
+	name  string
+	value string
+}
+
+// enabled renders a boolean setting for the banner
+func enabled(on bool) string {
+	if on {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// workerBanner lists the effective runtime configuration of the worker
+func workerBanner(publisher Publisher, opts workerOptions) []bannerLine {
+	dispatch := opts.DispatchMode
+	if opts.DispatchMode == dispatchPerBusiness {
+		dispatch = fmt.Sprintf("%s (up to %d events per business)", dispatch, opts.PerBusinessLimit)
+	}
+	dispatch = fmt.Sprintf("%s, concurrency %d", dispatch, opts.Concurrency)
+
+	retries := fmt.Sprintf("up to %d, backoff %v doubling to %v", opts.Retry.MaxRetries, opts.Retry.BaseDelay, opts.Retry.MaxDelay)
+	if s, ok := publisher.(*httpPublisher); ok {
+		retries = fmt.Sprintf("%s (%d immediate per delivery)", retries, s.retries)
+	}
+
+	pollInterval := opts.PollInterval.String()
+	if opts.MaxPollInterval > opts.PollInterval {
+		pollInterval = fmt.Sprintf("%s doubling to %v while idle", pollInterval, opts.MaxPollInterval)
+	}
+	if opts.PollJitter > 0 {
+		pollInterval = fmt.Sprintf("%s, ±%.0f%% jitter", pollInterval, opts.PollJitter*100)
+	}
+	if opts.Once {
+		pollInterval = "none, exits once drained"
+	}
+
+	semantics := resolveDeliverySemantics(opts)
+	claims := "none"
+	switch {
+	case semantics == semanticsClaimFirst:
+		claims = fmt.Sprintf("marked processing, visibility timeout %v", opts.VisibilityTimeout)
+		if opts.RecoverOnStart {
+			claims += ", this worker's own recovered on start"
+		}
+	case opts.DispatchMode == dispatchPool && opts.Driver == driverPostgres:
+		claims = "FOR UPDATE SKIP LOCKED"
+	}
+
+	rateLimit := "unlimited"
+	if opts.MaxRPS > 0 {
+		rateLimit = fmt.Sprintf("%g requests/s", opts.MaxRPS)
+	}
+
+	payloadLimit := "unlimited"
+	if opts.MaxPayloadBytes > 0 {
+		payloadLimit = fmt.Sprintf("%d bytes", opts.MaxPayloadBytes)
+	}
+
+	breaker := "disabled"
+	if opts.BreakerThreshold > 0 {
+		breaker = fmt.Sprintf("opens after %d failures in a row, cooldown %v", opts.BreakerThreshold, opts.BreakerCooldown)
+	}
+
+	shutdown := "cancels deliveries in flight"
+	if opts.ShutdownTimeout > 0 {
+		shutdown = fmt.Sprintf("waits up to %v for deliveries in flight", opts.ShutdownTimeout)
+	}
+
+	notifications := "disabled"
+	if opts.Listener != nil {
+		notifications = fmt.Sprintf("LISTEN %s, fallback poll every %v", notifyChannel, opts.NotifyFallback)
+	}
+
+	metrics := "disabled"
+	if opts.MetricsFile != "" {
+		metrics = fmt.Sprintf("%s every %v", opts.MetricsFile, opts.MetricsInterval)
+	}
+
+	prometheusEndpoint, healthEndpoint, pprofEndpoint := "disabled", "disabled", "disabled"
+	if opts.MetricsAddr != "" {
+		prometheusEndpoint = fmt.Sprintf("http://%s/metrics", opts.MetricsAddr)
+		healthEndpoint = fmt.Sprintf("http://%s/healthz, stuck after %v; http://%s/readyz", opts.MetricsAddr, opts.LivenessTimeout, opts.MetricsAddr)
+		if opts.Pprof {
+			pprofEndpoint = fmt.Sprintf("http://%s/debug/pprof/", opts.MetricsAddr)
+		}
+	}
+
+	faults := "disabled"
+	if opts.FailRate > 0 || opts.FailLatency > 0 {
+		faults = fmt.Sprintf("TESTING ONLY, failing %g%% of deliveries after %v", opts.FailRate*100, opts.FailLatency)
+	}
+
+	tracing := "disabled"
+	if opts.Tracer != nil {
+		tracing = fmt.Sprintf("OTLP/HTTP to %s", opts.OTLPEndpoint)
+	}
+
+	return []bannerLine{
+		{"version", version},
+		{"driver", opts.Driver},
+		{"worker id", opts.WorkerID},
+		{"queue", opts.Queue},
+		{"publisher", fmt.Sprint(publisher)},
+		{"dispatch", dispatch},
+		{"batch size", fmt.Sprint(opts.BatchSize)},
+		{"delivery semantics", semantics},
+		{"claims", claims},
+		{"poll interval", pollInterval},
+		{"notifications", notifications},
+		{"retries", retries},
+		{"publisher rate limit", rateLimit},
+		{"circuit breaker", breaker},
+		{"fault injection", faults},
+		{"max payload size", payloadLimit},
+		{"shutdown", shutdown},
+		{"dead-letter queue", fmt.Sprintf("dead_letter_events after %d retries", opts.Retry.MaxRetries)},
+		{"encryption", enabled(opts.Cipher != nil)},
+		{"delta payloads", enabled(opts.Delta)},
+		{"metrics file", metrics},
+		{"prometheus endpoint", prometheusEndpoint},
+		{"health endpoint", healthEndpoint},
+		{"pprof", pprofEndpoint},
+		{"tracing", tracing},
+	}
+}
+
+// printBanner logs the banner lines with aligned values, or as a single
+// record when logging JSON
+func printBanner(title string, lines []bannerLine) {
+	if jsonLogs {
+		attrs := make([]any, 0, len(lines)*2)
+		for _, line := range lines {
+			attrs = append(attrs, strings.NewReplacer(" ", "_", "-", "_").Replace(line.name), line.value)
+		}
+		slog.Info(title, attrs...)
+		return
+	}
+
+	width := 0
+	for _, line := range lines {
+		if len(line.name) > width {
+			width = len(line.name)
+		}
+	}
+
+	log.Printf("%s %s", title, strings.Repeat("-", 40))
+	for _, line := range lines {
+		log.Printf("  %-*s  %s", width+1, line.name+":", line.value)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// benchPublisher stands in for a downstream that takes latency to accept
+// each event, recording when every event was delivered
+type benchPublisher struct {
+	latency time.Duration
+
+	mu        sync.Mutex
+	delivered []time.Time
+	// done is closed once want events have been delivered
+	want int
+	done chan struct{}
+}
+
+func newBenchPublisher(latency time.Duration, want int) *benchPublisher {
+	return &benchPublisher{latency: latency, want: want, done: make(chan struct{})}
+}
+
+func (p *benchPublisher) String() string {
+	return fmt.Sprintf("bench (%v per event)", p.latency)
+}
+
+func (p *benchPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	if p.latency > 0 && !sleepContext(ctx, p.latency) {
+		return "", ctx.Err()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delivered = append(p.delivered, time.Now())
+	if len(p.delivered) == p.want {
+		close(p.done)
+	}
+	return "", nil
+}
+
+// benchResult is the outcome of draining the outbox once
+type benchResult struct {
+	Events  int
+	Elapsed time.Duration
+	// P50 and P99 are percentiles of the time from the start of the run
+	// until each event was delivered: the time it waited in the outbox
+	// plus its delivery
+	P50 time.Duration
+	P99 time.Duration
+}
+
+// Rate is the events delivered per second
+func (r benchResult) Rate() float64 {
+	return float64(r.Events) / r.Elapsed.Seconds()
+}
+
+// percentile returns the p-th fraction of durations, which must be sorted
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	i := int(float64(len(durations))*p+0.5) - 1
+	return durations[min(max(i, 0), len(durations)-1)]
+}
+
+// benchDrain runs the worker until publisher has received every pending
+// event, and measures how fast it got there. The worker polls as usual, so
+// its poll interval counts towards the result.
+func benchDrain(ctx context.Context, store Store, publisher *benchPublisher, opts workerOptions) (benchResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- runWorker(ctx, store, publisher, opts) }()
+	select {
+	case <-publisher.done:
+	case err := <-done:
+		if err == nil {
+			err = fmt.Errorf("worker stopped before the queue drained")
+		}
+		return benchResult{}, err
+	}
+	elapsed := time.Since(start)
+	cancel()
+	if err := <-done; err != nil {
+		return benchResult{}, err
+	}
+
+	publisher.mu.Lock()
+	latencies := make([]time.Duration, len(publisher.delivered))
+	for i, at := range publisher.delivered {
+		latencies[i] = at.Sub(start)
+	}
+	publisher.mu.Unlock()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return benchResult{
+		Events:  len(latencies),
+		Elapsed: elapsed,
+		P50:     percentile(latencies, 0.50),
+		P99:     percentile(latencies, 0.99),
+	}, nil
+}
+
+// runBench seeds count invoice events into a fresh temporary SQLite
+// database and drains them with the worker, printing the throughput and
+// latency. The database is removed afterwards.
+func runBench(ctx context.Context, cfg dbConfig, count int, latency time.Duration, opts workerOptions) error {
+	dir, err := os.MkdirTemp("", "outbox-bench")
+	if err != nil {
+		return fmt.Errorf("error creating bench database: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg.Driver = driverSQLite
+	cfg.Path = filepath.Join(dir, "bench.db")
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if _, err := store.conn().ExecContext(ctx, cfg.schema()); err != nil {
+		return fmt.Errorf("error creating bench schema: %v", err)
+	}
+	if err := seedDatabase(ctx, store); err != nil {
+		return err
+	}
+
+	ingestOpts := ingestOptions{Queue: opts.Queue, Mapper: invoiceCreatedEvents, Codec: jsonCodec{}}
+	fmt.Printf("Seeding %d events...\n", count)
+	for i := 0; i < count; i++ {
+		invoice := generateInvoice(getRandomBusinessID(businessIDs))
+		if _, err := createInvoiceWithEvents(ctx, store, invoice, ingestOpts); err != nil {
+			return fmt.Errorf("error seeding event %d: %v", i, err)
+		}
+	}
+
+	fmt.Println("Draining events...")
+	result, err := benchDrain(ctx, store, newBenchPublisher(latency, count), opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Events:             %d\n", result.Events)
+	fmt.Printf("Elapsed:            %v\n", result.Elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput:         %.1f events/s\n", result.Rate())
+	fmt.Printf("Latency p50:        %v\n", result.P50.Round(time.Millisecond))
+	fmt.Printf("Latency p99:        %v\n", result.P99.Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// BenchmarkWorkerDrain measures how fast the worker drains b.N pending
+// events into a publisher taking a millisecond per event, by batch size and
+// concurrency
+func BenchmarkWorkerDrain(b *testing.B) {
+	for _, batchSize := range []int64{10, 100} {
+		for _, concurrency := range []int{1, 8} {
+			b.Run(fmt.Sprintf("batch=%d/concurrency=%d", batchSize, concurrency), func(b *testing.B) {
+				store, _ := newTestStore(b)
+				seedInvoices(b, store, b.N, testIngestOptions(b))
+
+				opts := testWorkerOptions()
+				opts.BatchSize = batchSize
+				opts.Concurrency = concurrency
+				opts.MaxPollInterval = opts.PollInterval
+				b.ResetTimer()
+
+				result, err := benchDrain(context.Background(), store, newBenchPublisher(time.Millisecond, b.N), opts)
+				if err != nil {
+					b.Fatalf("draining: %v", err)
+				}
+				b.ReportMetric(result.Rate(), "events/s")
+				b.ReportMetric(float64(result.P99.Microseconds()), "p99-µs")
+			})
+		}
+	}
+}
+
+func TestBenchDrain(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 25, testIngestOptions(t))
+
+	opts := testWorkerOptions()
+	result, err := benchDrain(context.Background(), store, newBenchPublisher(0, 25), opts)
+	if err != nil {
+		t.Fatalf("draining: %v", err)
+	}
+	if result.Events != 25 || result.Rate() <= 0 {
+		t.Errorf("drained %d events at %.1f/s, want all 25", result.Events, result.Rate())
+	}
+	if result.P50 > result.P99 || result.P99 > result.Elapsed {
+		t.Errorf("latencies p50 %v and p99 %v out of order within %v", result.P50, result.P99, result.Elapsed)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	durations := make([]time.Duration, 100)
+	for i := range durations {
+		durations[i] = time.Duration(i+1) * time.Millisecond
+	}
+	if p50 := percentile(durations, 0.50); p50 != 50*time.Millisecond {
+		t.Errorf("p50 = %v, want 50ms", p50)
+	}
+	if p99 := percentile(durations, 0.99); p99 != 99*time.Millisecond {
+		t.Errorf("p99 = %v, want 99ms", p99)
+	}
+	if p := percentile(nil, 0.5); p != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", p)
+	}
+}
+
+// BenchmarkPreparedQueries compares the worker's hottest queries, fetching a
+// batch and marking an event processed, run as text and as prepared
+// statements
+func BenchmarkPreparedQueries(b *testing.B) {
+	for _, mode := range []string{"unprepared", "prepared"} {
+		b.Run(mode+"/GetPendingEvents", func(b *testing.B) {
+			plain, prepared := openPreparedTestStores(b)
+			store := plain
+			if mode == "prepared" {
+				store = prepared
+			}
+			seedInvoices(b, store, 100, testIngestOptions(b))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := store.GetPendingEvents(context.Background(), db.GetPendingEventsParams{Queue: "default", Limit: 10}); err != nil {
+					b.Fatalf("fetching events: %v", err)
+				}
+			}
+		})
+		b.Run(mode+"/MarkEventAsProcessed", func(b *testing.B) {
+			plain, prepared := openPreparedTestStores(b)
+			store := plain
+			if mode == "prepared" {
+				store = prepared
+			}
+			seedInvoices(b, store, b.N, testIngestOptions(b))
+			events, err := store.ListEvents(context.Background())
+			if err != nil {
+				b.Fatalf("listing events: %v", err)
+			}
+			b.ResetTimer()
+
+			for _, event := range events {
+				if err := store.MarkEventAsProcessed(context.Background(), event.ID); err != nil {
+					b.Fatalf("marking event: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// benchMarkProcessed seeds b.N pending events and times marking them
+// processed with mark, a batch of 100 at a time, reporting the events
+// marked a second
+func benchMarkProcessed(b *testing.B, mark func(ctx context.Context, store Store, ids []string) error) {
+	store, _ := newTestStore(b)
+	seedInvoices(b, store, b.N, testIngestOptions(b))
+	events, err := store.ListEvents(context.Background())
+	if err != nil {
+		b.Fatalf("listing events: %v", err)
+	}
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	b.ResetTimer()
+
+	start := time.Now()
+	for len(ids) > 0 {
+		batch := ids[:min(100, len(ids))]
+		ids = ids[len(batch):]
+		if err := mark(context.Background(), store, batch); err != nil {
+			b.Fatalf("marking events: %v", err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+}
+
+// BenchmarkMarkProcessedPerEvent marks each event of a batch processed with
+// its own UPDATE, as the worker did before marking batches at once
+func BenchmarkMarkProcessedPerEvent(b *testing.B) {
+	benchMarkProcessed(b, func(ctx context.Context, store Store, ids []string) error {
+		for _, id := range ids {
+			if err := store.MarkEventAsProcessed(ctx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BenchmarkMarkProcessedBatched marks a batch processed with the single
+// UPDATE the worker runs
+func BenchmarkMarkProcessedBatched(b *testing.B) {
+	benchMarkProcessed(b, func(ctx context.Context, store Store, ids []string) error {
+		return store.MarkEventsAsProcessed(ctx, ids)
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// breakerState is the state of a circuitBreaker
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// errBreakerOpen is returned instead of calling the publisher while the
+// circuit is open. The event wasn't sent, so it isn't an attempt.
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+var breakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "outbox_circuit_breaker_state",
+	Help: "State of the circuit breaker around the publisher: 0 closed, 1 open, 2 half-open.",
+})
+
+// circuitBreaker wraps a Publisher and stops calling it once threshold
+// deliveries in a row have failed, so a sink that is down isn't sent every
+// event of every batch. After cooldown the circuit is half-open and lets a
+// single delivery through as a probe: if it succeeds the circuit closes, if
+// it fails it opens for another cooldown. Permanent errors and rate limits
+// are the sink answering, so they close the circuit rather than trip it.
+type circuitBreaker struct {
+	next      Publisher
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(next Publisher, threshold int, cooldown time.Duration) *circuitBreaker {
+	breakerStateGauge.Set(float64(breakerClosed))
+	return &circuitBreaker{next: next, threshold: threshold, cooldown: cooldown}
+}
+
+func (b *circuitBreaker) String() string {
+	return fmt.Sprint(b.next)
+}
+
+func (b *circuitBreaker) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	if !b.allow() {
+		return "", errBreakerOpen
+	}
+	deliveryID, err := b.next.Publish(ctx, event)
+	b.record(ctx, err)
+	return deliveryID, err
+}
+
+// allow reports whether a delivery may go to the publisher. An open circuit
+// whose cooldown is over turns half-open, where only one probe is let
+// through at a time.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		slog.Info("Circuit breaker half-open, probing the publisher")
+	}
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record counts the outcome of a delivery allow let through
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+
+	// A delivery cut short by shutdown says nothing about the sink
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	var permanent permanentError
+	var limited rateLimitError
+	var refused sinkAuthError
+	if err == nil || errors.As(err, &permanent) || errors.As(err, &limited) || errors.As(err, &refused) {
+		if b.state != breakerClosed {
+			slog.Info("Circuit breaker closed, the publisher is answering again")
+		}
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+		slog.Warn("Circuit breaker opened, pausing deliveries", "consecutive_failures", b.failures, "cooldown", b.cooldown.String(), "error", err)
+	}
+}
+
+// setState moves the circuit to state and publishes it in the gauge. b.mu
+// must be held.
+func (b *circuitBreaker) setState(state breakerState) {
+	b.state = state
+	breakerStateGauge.Set(float64(state))
+}
+
+// remaining returns how long the circuit stays open, zero when it is closed
+// or its cooldown is over. It is safe to call on a nil breaker.
+func (b *circuitBreaker) remaining() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	return max(b.cooldown-time.Since(b.openedAt), 0)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	publisher := &fakePublisher{err: errors.New("convoy unavailable")}
+	breaker := newCircuitBreaker(publisher, 3, 50*time.Millisecond)
+	publish := func() error {
+		_, err := breaker.Publish(context.Background(), &outboundEvent{Event: db.Event{ID: "evt_1"}})
+		return err
+	}
+
+	// Three failures in a row trip it, after which nothing reaches the
+	// publisher until the cooldown is over
+	for i := 0; i < 3; i++ {
+		if err := publish(); err == nil || errors.Is(err, errBreakerOpen) {
+			t.Fatalf("delivery %d returned %v, want the publisher's error", i+1, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := publish(); !errors.Is(err, errBreakerOpen) {
+			t.Fatalf("delivery while open returned %v, want errBreakerOpen", err)
+		}
+	}
+	if len(publisher.published) != 3 {
+		t.Fatalf("publisher called %d times, want only the 3 before the circuit opened", len(publisher.published))
+	}
+	if breaker.remaining() <= 0 {
+		t.Errorf("open circuit has no cooldown remaining")
+	}
+
+	// Half-open, a failed probe opens it again straight away
+	time.Sleep(60 * time.Millisecond)
+	if err := publish(); err == nil || errors.Is(err, errBreakerOpen) {
+		t.Fatalf("probe returned %v, want the publisher's error", err)
+	}
+	if err := publish(); !errors.Is(err, errBreakerOpen) {
+		t.Fatalf("delivery after a failed probe returned %v, want errBreakerOpen", err)
+	}
+	if len(publisher.published) != 4 {
+		t.Fatalf("publisher called %d times, want one probe after the cooldown", len(publisher.published))
+	}
+
+	// A probe that goes through closes it
+	time.Sleep(60 * time.Millisecond)
+	publisher.err = nil
+	for i := 0; i < 3; i++ {
+		if err := publish(); err != nil {
+			t.Fatalf("delivery %d after the circuit closed returned %v", i+1, err)
+		}
+	}
+	if breaker.state != breakerClosed || len(publisher.published) != 7 {
+		t.Errorf("circuit is %s after %d calls, want closed after 7", breaker.state, len(publisher.published))
+	}
+}
+
+func TestCircuitBreakerIgnoresPermanentErrors(t *testing.T) {
+	// A sink rejecting events is up, so it never trips the breaker
+	publisher := &fakePublisher{err: permanentError{errors.New("payload rejected")}}
+	breaker := newCircuitBreaker(publisher, 2, time.Minute)
+	for i := 0; i < 5; i++ {
+		if _, err := breaker.Publish(context.Background(), &outboundEvent{}); errors.Is(err, errBreakerOpen) {
+			t.Fatalf("delivery %d was short-circuited, want every one sent", i+1)
+		}
+	}
+}
+
+func TestWorkerStopsAtOpenCircuit(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, defaultBatchSize, testIngestOptions(t))
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.Concurrency = 1
+	opts.BreakerThreshold = 2
+	opts.BreakerCooldown = time.Minute
+	publisher := &fakePublisher{err: errors.New("convoy unavailable")}
+	if err := runWorker(context.Background(), store, publisher, opts); err == nil {
+		t.Fatalf("worker with an open circuit succeeded, want an error")
+	}
+
+	// Only the deliveries that tripped the breaker were attempted, the rest
+	// of the batch is pending as it was
+	if len(publisher.published) != 2 {
+		t.Errorf("publisher called %d times, want 2", len(publisher.published))
+	}
+	var untouched int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status = 'pending' AND retry_count = 0").Scan(&untouched); err != nil {
+		t.Fatalf("counting events: %v", err)
+	}
+	if untouched != defaultBatchSize-2 {
+		t.Errorf("%d events left untouched, want %d", untouched, defaultBatchSize-2)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+	"github.com/google/uuid"
+)
+
+// seedBusinesses are the businesses the seed command creates
+var seedBusinesses = []db.CreateBusinessParams{
+	{ID: "550e8400-e29b-41d4-a716-446655440000", Name: "Acme Corp"},
+	{ID: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", Name: "TechStart Inc"},
+	{ID: "7ba7b810-9dad-11d1-80b4-00c04fd430c9", Name: "Global Solutions"},
+	{ID: "8ba7b810-9dad-11d1-80b4-00c04fd430ca", Name: "Innovate Labs"},
+	{ID: "9ba7b810-9dad-11d1-80b4-00c04fd430cb", Name: "Future Systems"},
+}
+
+// seededBusinessIDs returns the IDs of seedBusinesses
+func seededBusinessIDs() []string {
+	ids := make([]string, len(seedBusinesses))
+	for i, business := range seedBusinesses {
+		ids[i] = business.ID
+	}
+	return ids
+}
+
+// seedDatabase creates the seedBusinesses, or renames them back if they
+// exist, so running it again changes nothing
+func seedDatabase(ctx context.Context, queries Querier) error {
+	for _, business := range seedBusinesses {
+		if err := queries.CreateBusiness(ctx, business); err != nil {
+			return fmt.Errorf("error creating business %s: %v", business.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkBusiness rejects an invoice whose business hasn't been created, as
+// ingest does with --validate-business
+func checkBusiness(ctx context.Context, queries Querier, invoice Invoice) error {
+	count, err := queries.BusinessExists(ctx, invoice.BusinessID)
+	if err != nil {
+		return fmt.Errorf("error looking up business: %v", err)
+	}
+	if count == 0 {
+		return &invoiceValidationError{InvoiceID: invoice.ID, Fields: []invoiceFieldError{
+			{"business_id", fmt.Sprintf("%s is not a seeded business", invoice.BusinessID)},
+		}}
+	}
+	return nil
+}
+
+func runSeed(ctx context.Context, store Store) error {
+	tx, err := store.Begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := seedDatabase(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	fmt.Printf("Seeded %d businesses\n", len(seedBusinesses))
+	return nil
+}
+
+// loadBusinessIDs returns the businesses ingest generates invoices for: the
+// IDs given with --business-ids, or read from --businesses-file, falling
+// back to the predefined businessIDs when neither is set
+func loadBusinessIDs(ids []string, path string) ([]string, error) {
+	if len(ids) > 0 && path != "" {
+		return nil, fmt.Errorf("--business-ids and --businesses-file can't be used together")
+	}
+
+	if path != "" {
+		var err error
+		ids, err = readBusinessIDs(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no business IDs found in %s", path)
+		}
+	}
+	if len(ids) == 0 {
+		return businessIDs, nil
+	}
+
+	for _, id := range ids {
+		if err := validateBusinessID(id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// readBusinessIDs reads one business ID per line, skipping blank lines and
+// lines starting with #
+func readBusinessIDs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening businesses file: %v", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading businesses file: %v", err)
+	}
+	return ids, nil
+}
+
+// validateBusinessID checks that id is a UUID in its canonical
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx form
+func validateBusinessID(id string) error {
+	if _, err := uuid.Parse(id); err != nil || len(id) != 36 {
+		return fmt.Errorf("invalid business ID %q: must be a UUID like %s", id, businessIDs[0])
+	}
+	return nil
+}
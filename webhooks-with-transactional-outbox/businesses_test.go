@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// writeBusinessesFile writes contents to a businesses file in a temporary
+// directory, returning its path
+func writeBusinessesFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "businesses.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing businesses file: %v", err)
+	}
+	return path
+}
+
+func TestLoadBusinessIDs(t *testing.T) {
+	const (
+		first  = "3f2504e0-4f89-11d3-9a0c-0305e82c3301"
+		second = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	)
+
+	t.Run("defaults", func(t *testing.T) {
+		ids, err := loadBusinessIDs(nil, "")
+		if err != nil {
+			t.Fatalf("loading business IDs: %v", err)
+		}
+		if !reflect.DeepEqual(ids, businessIDs) {
+			t.Errorf("got %v, want the predefined %v", ids, businessIDs)
+		}
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		var flagIDs []string
+		flags := pflag.NewFlagSet("ingest", pflag.ContinueOnError)
+		flags.StringSliceVar(&flagIDs, "business-ids", nil, "")
+		if err := flags.Parse([]string{"--business-ids", first + "," + second}); err != nil {
+			t.Fatalf("parsing flags: %v", err)
+		}
+
+		ids, err := loadBusinessIDs(flagIDs, "")
+		if err != nil {
+			t.Fatalf("loading business IDs: %v", err)
+		}
+		if want := []string{first, second}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("got %v, want %v", ids, want)
+		}
+		for i := 0; i < 20; i++ {
+			if id := getRandomBusinessID(ids); id != first && id != second {
+				t.Fatalf("getRandomBusinessID returned %q, which isn't one of %v", id, ids)
+			}
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := writeBusinessesFile(t, "# tenants\n"+first+"\n\n  "+second+"  \n")
+		ids, err := loadBusinessIDs(nil, path)
+		if err != nil {
+			t.Fatalf("loading business IDs: %v", err)
+		}
+		if want := []string{first, second}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("got %v, want %v", ids, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name string
+			ids  []string
+			path string
+		}{
+			{name: "not a uuid", ids: []string{first, "acme"}},
+			{name: "uuid without dashes", ids: []string{"3f2504e04f8911d39a0c0305e82c3301"}},
+			{name: "uuid in braces", ids: []string{"{" + first + "}"}},
+			{name: "invalid id in file", path: writeBusinessesFile(t, first+"\nbiz_1\n")},
+			{name: "empty file", path: writeBusinessesFile(t, "# nothing yet\n\n")},
+			{name: "missing file", path: filepath.Join(t.TempDir(), "missing.txt")},
+			{name: "flag and file", ids: []string{first}, path: writeBusinessesFile(t, second+"\n")},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if ids, err := loadBusinessIDs(tt.ids, tt.path); err == nil {
+					t.Errorf("loadBusinessIDs(%q, %q) returned %v, want an error", tt.ids, tt.path, ids)
+				}
+			})
+		}
+	})
+}
+
+func TestSeedDatabase(t *testing.T) {
+	store, dbConn := newTestStore(t)
+
+	// newTestStore has seeded once already; seeding again changes nothing
+	for i := 0; i < 2; i++ {
+		if err := runSeed(context.Background(), store); err != nil {
+			t.Fatalf("seeding: %v", err)
+		}
+	}
+	var count int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM businesses").Scan(&count); err != nil {
+		t.Fatalf("counting businesses: %v", err)
+	}
+	if count != len(seedBusinesses) {
+		t.Errorf("%d businesses after seeding twice, want %d", count, len(seedBusinesses))
+	}
+}
+
+func TestInvoiceNeedsBusiness(t *testing.T) {
+	store, _ := newTestStore(t)
+	const stranger = "3f2504e0-4f89-11d3-9a0c-0305e82c3301"
+
+	// The foreign key rejects the invoice even without validation
+	if _, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(stranger), testIngestOptions(t)); err == nil {
+		t.Fatalf("storing an invoice of an unknown business succeeded, want an error")
+	}
+
+	opts := testIngestOptions(t)
+	opts.ValidateBusiness = true
+	_, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(stranger), opts)
+	var invalid *invoiceValidationError
+	if !errors.As(err, &invalid) || len(invalid.Fields) != 1 || invalid.Fields[0].Field != "business_id" {
+		t.Fatalf("got %v, want an *invoiceValidationError on business_id", err)
+	}
+
+	if _, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(businessIDs[0]), opts); err != nil {
+		t.Errorf("storing an invoice of a seeded business: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// lockedBatch is a batch of events locked by a Postgres transaction with
+// FOR UPDATE SKIP LOCKED. Other workers skip the rows until the transaction
+// commits, by which time they have been marked processed.
+type lockedBatch struct {
+	tx Tx
+
+	// A transaction is a single connection, so the dispatch goroutines take
+	// turns writing to it
+	mu sync.Mutex
+}
+
+// lockPendingEvents starts a transaction and locks the next batch of
+// pending events in it. The transaction outlives ctx so a shutdown doesn't
+// roll back events that were already sent.
+func lockPendingEvents(ctx context.Context, store Store, opts workerOptions) (*lockedBatch, []db.Event, error) {
+	tx, err := store.Begin(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+
+	batch := &lockedBatch{tx: tx}
+	events, err := tx.LockPendingEvents(ctx, db.GetPendingEventsParams{
+		Queue: opts.Queue,
+		Limit: opts.BatchSize,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	return batch, events, nil
+}
+
+// claimPendingEvents marks the next batch of pending events processing and
+// returns them, in one statement so two workers never claim the same event.
+// Each is stamped with the --worker-id of the worker in claimed_by.
+// This is how batches are claimed on SQLite, which has no row locks: the
+// claim lasts until the events are processed or released, or until
+// recoverStuckEvents finds a worker died holding it.
+func claimPendingEvents(ctx context.Context, queries Querier, opts workerOptions) ([]db.Event, error) {
+	return queries.ClaimPendingEvents(ctx, db.ClaimPendingEventsParams{
+		ClaimedBy: sql.NullString{String: opts.WorkerID, Valid: opts.WorkerID != ""},
+		Queue:     opts.Queue,
+		Limit:     opts.BatchSize,
+	})
+}
+
+// releaseClaimedEvents hands the events of a claimed batch that weren't
+// marked processed back to the queue. Failed events were already released
+// when their retry was scheduled, events left unsent by a shutdown or rate
+// limit are released here, so ctx should outlive a shutdown.
+func releaseClaimedEvents(ctx context.Context, queries Querier, events []db.Event) error {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	if err := queries.ReleaseClaimedEvents(ctx, ids); err != nil {
+		return fmt.Errorf("error releasing %d claimed events: %v", len(ids), err)
+	}
+	return nil
+}
+
+// recoverStuckEvents returns events claimed longer than timeout ago to the
+// queue. Their worker most likely crashed, so another one sends them, and
+// Convoy drops the send as a duplicate if the first one got through.
+func recoverStuckEvents(ctx context.Context, queries Querier, timeout time.Duration) (int64, error) {
+	cutoff := sql.NullTime{Time: time.Now().UTC().Add(-timeout), Valid: true}
+	recovered, err := queries.RecoverStuckEvents(ctx, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("error recovering stuck events: %v", err)
+	}
+	return recovered, nil
+}
+
+// releaseWorkerEvents returns the events of queue left processing by
+// workerID to the queue. A worker starting under an ID knows it isn't
+// sending any of them: its last run stopped, most likely crashed, holding
+// them.
+func releaseWorkerEvents(ctx context.Context, queries Querier, workerID, queue string) (int64, error) {
+	released, err := queries.ReleaseWorkerEvents(ctx, db.ReleaseWorkerEventsParams{
+		ClaimedBy: sql.NullString{String: workerID, Valid: true},
+		Queue:     queue,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error releasing the events of worker %s: %v", workerID, err)
+	}
+	return released, nil
+}
+
+// commit releases the locked rows along with the updates made to them
+func (b *lockedBatch) commit() error {
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("error committing claimed batch: %v", err)
+	}
+	return nil
+}
+
+// withBatch returns a processor whose event updates go through the lock
+// transaction
+func (p *eventProcessor) withBatch(batch *lockedBatch) *eventProcessor {
+	claimed := *p
+	claimed.batch = batch
+	return &claimed
+}
+
+// writeQueries returns the queries to update an event with and a func to
+// call once done. Reads always use p.store: Postgres doesn't block reads
+// on locked rows.
+func (p *eventProcessor) writeQueries() (Querier, func()) {
+	if p.batch == nil {
+		return p.store, func() {}
+	}
+	p.batch.mu.Lock()
+	return p.batch.tx, p.batch.mu.Unlock
+}
+
+// deadLetter moves the event to the dead-letter table with status, inside
+// the lock transaction when there is one
+func (p *eventProcessor) deadLetter(ctx context.Context, eventID string, status string, lastError string) error {
+	if p.batch == nil {
+		return moveToDeadLetter(ctx, p.store, eventID, status, lastError)
+	}
+	queries, done := p.writeQueries()
+	defer done()
+	return deadLetterEvent(ctx, queries, eventID, status, lastError)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimPendingEvents(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	events := seedInvoices(t, store, 3*defaultBatchSize, testIngestOptions(t))
+
+	// Several workers claim batches at once until nothing is left
+	var mu sync.Mutex
+	claimed := map[string]int{}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				batch, err := claimPendingEvents(context.Background(), store, testWorkerOptions())
+				if err != nil {
+					t.Errorf("claiming events: %v", err)
+					return
+				}
+				if len(batch) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, event := range batch {
+					claimed[event.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != len(events) {
+		t.Errorf("claimed %d events, want all %d", len(claimed), len(events))
+	}
+	for id, times := range claimed {
+		if times != 1 {
+			t.Errorf("event %s claimed %d times, want once", id, times)
+		}
+	}
+
+	var unclaimed int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status != 'processing' OR claimed_at IS NULL").Scan(&unclaimed); err != nil {
+		t.Fatalf("counting unclaimed events: %v", err)
+	}
+	if unclaimed != 0 {
+		t.Errorf("%d events aren't marked processing with a claim time", unclaimed)
+	}
+}
+
+func TestRecoverStuckEvents(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 2*defaultBatchSize, testIngestOptions(t))
+
+	// One batch was claimed by a worker that crashed an hour ago, the other
+	// by one still working on it
+	stuck, err := claimPendingEvents(context.Background(), store, testWorkerOptions())
+	if err != nil {
+		t.Fatalf("claiming events: %v", err)
+	}
+	for _, event := range stuck {
+		if _, err := dbConn.Exec("UPDATE events SET claimed_at = ? WHERE id = ?", time.Now().UTC().Add(-time.Hour), event.ID); err != nil {
+			t.Fatalf("backdating claim: %v", err)
+		}
+	}
+	if _, err := claimPendingEvents(context.Background(), store, testWorkerOptions()); err != nil {
+		t.Fatalf("claiming events: %v", err)
+	}
+
+	recovered, err := recoverStuckEvents(context.Background(), store, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("recovering events: %v", err)
+	}
+	if recovered != int64(len(stuck)) {
+		t.Errorf("recovered %d events, want the %d stuck ones", recovered, len(stuck))
+	}
+	pending, err := store.CountPendingEvents(context.Background(), defaultQueue)
+	if err != nil {
+		t.Fatalf("counting pending events: %v", err)
+	}
+	processing, err := store.CountProcessingEvents(context.Background(), defaultQueue)
+	if err != nil {
+		t.Fatalf("counting processing events: %v", err)
+	}
+	if pending != int64(len(stuck)) || processing != defaultBatchSize {
+		t.Errorf("%d pending and %d processing, want %d pending and %d still processing", pending, processing, len(stuck), defaultBatchSize)
+	}
+
+	// Once the live claim times out too, a starting worker delivers
+	// everything
+	if _, err := dbConn.Exec("UPDATE events SET claimed_at = ? WHERE status = 'processing'", time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatalf("backdating claims: %v", err)
+	}
+	opts := testWorkerOptions()
+	opts.Once = true
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.published) != 2*defaultBatchSize {
+		t.Errorf("published %d events, want %d", len(publisher.published), 2*defaultBatchSize)
+	}
+}
+
+func TestRecoverOnStart(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 2*defaultBatchSize, testIngestOptions(t))
+
+	// worker-a crashed holding one batch, worker-b is still sending the
+	// other
+	crashed := testWorkerOptions()
+	crashed.WorkerID = "worker-a"
+	held, err := claimPendingEvents(context.Background(), store, crashed)
+	if err != nil {
+		t.Fatalf("claiming events: %v", err)
+	}
+	live := testWorkerOptions()
+	live.WorkerID = "worker-b"
+	if _, err := claimPendingEvents(context.Background(), store, live); err != nil {
+		t.Fatalf("claiming events: %v", err)
+	}
+
+	// Without recovery the restarted worker-a finds nothing to send until
+	// its claims time out
+	opts := crashed
+	opts.Once = true
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.published) != 0 {
+		t.Fatalf("published %d events without recovering, want none", len(publisher.published))
+	}
+
+	// With it, worker-a sends what it held at once, and leaves worker-b's
+	// batch alone
+	opts.RecoverOnStart = true
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.published) != len(held) {
+		t.Errorf("published %d events, want the %d worker-a held", len(publisher.published), len(held))
+	}
+	processing, err := store.CountProcessingEvents(context.Background(), defaultQueue)
+	if err != nil {
+		t.Fatalf("counting processing events: %v", err)
+	}
+	if processing != defaultBatchSize {
+		t.Errorf("%d events processing, want worker-b's %d", processing, defaultBatchSize)
+	}
+
+	// Only the worker's own queue is recovered
+	if released, err := releaseWorkerEvents(context.Background(), store, "worker-b", "other"); err != nil || released != 0 {
+		t.Errorf("recovering worker-b on another queue released %d, %v, want none", released, err)
+	}
+}
+
+func TestWorkerIDTagsClaimsAndLogs(t *testing.T) {
+	// Running a command installs its own logger, writing to os.Stderr
+	logger, stderr := slog.Default(), os.Stderr
+	t.Cleanup(func() { slog.SetDefault(logger); os.Stderr = stderr })
+	logs, err := os.Create(filepath.Join(t.TempDir(), "worker.log"))
+	if err != nil {
+		t.Fatalf("creating log file: %v", err)
+	}
+	defer logs.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "outbox.db")
+	run := func(args ...string) error {
+		cmd := newRootCmd()
+		cmd.SetArgs(append(args, "--db-path", dbPath, "--skip-if-exists", "--log-format", "json"))
+		return cmd.Execute()
+	}
+	if err := run("ingest", "--count", "3", "--rate", "1ms", "--log-level", "error"); err != nil {
+		t.Fatalf("running ingest: %v", err)
+	}
+	os.Stderr = logs
+	if err := run("worker", "--once", "--quiet", "--metrics-addr", "", "--publisher", "noop", "--worker-id", "worker-7"); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	os.Stderr = stderr
+
+	dbConn, err := sql.Open(driverSQLite, dbPath)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer dbConn.Close()
+	var claimedBy []string
+	rows, err := dbConn.Query("SELECT COALESCE(claimed_by, '') FROM events")
+	if err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("reading events: %v", err)
+		}
+		claimedBy = append(claimedBy, id)
+	}
+	if len(claimedBy) != 3 {
+		t.Fatalf("got %d events, want 3", len(claimedBy))
+	}
+	for _, id := range claimedBy {
+		if id != "worker-7" {
+			t.Errorf("event claimed by %q, want worker-7", id)
+		}
+	}
+
+	if _, err := logs.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("rewinding logs: %v", err)
+	}
+	scanner := bufio.NewScanner(logs)
+	lines := 0
+	for ; scanner.Scan(); lines++ {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", scanner.Text(), err)
+		}
+		if line["worker_id"] != "worker-7" {
+			t.Errorf("log line %q has worker_id %v, want worker-7", scanner.Text(), line["worker_id"])
+		}
+	}
+	if lines == 0 {
+		t.Errorf("the worker logged nothing")
+	}
+}
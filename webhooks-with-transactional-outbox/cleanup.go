@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// runCleanup deletes events processed more than olderThan ago, batchSize
+// rows at a time so a large table is never locked for long, each statement
+// cut off after timeout. With dryRun it only reports how many rows would go.
+func runCleanup(ctx context.Context, queries Querier, olderThan time.Duration, batchSize int64, dryRun bool, timeout time.Duration) error {
+	cutoff := sql.NullTime{Time: time.Now().UTC().Add(-olderThan), Valid: true}
+
+	if dryRun {
+		dbCtx, cancel := dbContext(ctx, timeout)
+		defer cancel()
+		count, err := queries.CountProcessedEventsBefore(dbCtx, cutoff)
+		if err != nil {
+			return fmt.Errorf("error counting processed events: %v", err)
+		}
+		fmt.Printf("Would delete %d events processed before %s\n", count, cutoff.Time.Format(time.RFC3339))
+		return nil
+	}
+
+	var total int64
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+		dbCtx, cancel := dbContext(ctx, timeout)
+		deleted, err := queries.DeleteProcessedEventsBefore(dbCtx, db.DeleteProcessedEventsBeforeParams{
+			Cutoff:    cutoff,
+			BatchSize: batchSize,
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error deleting processed events after removing %d: %v", total, err)
+		}
+		total += deleted
+		if deleted < batchSize {
+			break
+		}
+	}
+
+	fmt.Printf("Deleted %d events processed before %s\n", total, cutoff.Time.Format(time.RFC3339))
+	return nil
+}
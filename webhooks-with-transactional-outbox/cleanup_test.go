@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// countingDeleter counts the batches cleanup deletes
+type countingDeleter struct {
+	Querier
+	batches []int64
+}
+
+func (q *countingDeleter) DeleteProcessedEventsBefore(ctx context.Context, arg db.DeleteProcessedEventsBeforeParams) (int64, error) {
+	deleted, err := q.Querier.DeleteProcessedEventsBefore(ctx, arg)
+	q.batches = append(q.batches, deleted)
+	return deleted, err
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 25, testIngestOptions(t))
+	events, err := store.ListEvents(ctx)
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+
+	// Twenty events were processed two days ago and three an hour ago,
+	// while two are still pending
+	now := time.Now().UTC()
+	kept := map[string]bool{}
+	for i, event := range events {
+		processedAt := now.Add(-48 * time.Hour)
+		switch {
+		case i >= 23:
+			kept[event.ID] = true
+			continue
+		case i >= 20:
+			kept[event.ID] = true
+			processedAt = now.Add(-time.Hour)
+		}
+		if _, err := dbConn.Exec("UPDATE events SET status = 'processed', processed_at = ? WHERE id = ?", processedAt, event.ID); err != nil {
+			t.Fatalf("marking event processed: %v", err)
+		}
+	}
+
+	queries := &countingDeleter{Querier: store}
+	if err := runCleanup(ctx, queries, 24*time.Hour, 7, true, time.Second); err != nil {
+		t.Fatalf("running dry run: %v", err)
+	}
+	if len(queries.batches) != 0 {
+		t.Errorf("dry run deleted %v, want nothing", queries.batches)
+	}
+
+	if err := runCleanup(ctx, queries, 24*time.Hour, 7, false, time.Second); err != nil {
+		t.Fatalf("running cleanup: %v", err)
+	}
+	if want := []int64{7, 7, 6}; !reflect.DeepEqual(queries.batches, want) {
+		t.Errorf("deleted batches of %v, want %v", queries.batches, want)
+	}
+	remaining, err := store.ListEvents(ctx)
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if len(remaining) != len(kept) {
+		t.Errorf("%d events left, want the %d newer or pending ones", len(remaining), len(kept))
+	}
+	for _, event := range remaining {
+		if !kept[event.ID] {
+			t.Errorf("event %s processed two days ago survived", event.ID)
+		}
+	}
+}
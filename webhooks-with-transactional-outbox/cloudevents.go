@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// eventFormatRaw stores the event_type and data envelope built by newEvent
+	eventFormatRaw = "raw"
+	// eventFormatCloudEvents wraps the data in a CloudEvents 1.0 envelope
+	eventFormatCloudEvents = "cloudevents"
+
+	cloudEventsSpecVersion = "1.0"
+)
+
+// cloudEvent is a CloudEvents 1.0 event in structured content mode, where
+// the attributes and the data travel together in one JSON document
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// validateEventFormat checks the --event-format of ingest
+func validateEventFormat(format string) error {
+	if format != eventFormatRaw && format != eventFormatCloudEvents && format != eventFormatEnvelope {
+		return fmt.Errorf("invalid event format %q: must be %q, %q or %q", format, eventFormatRaw, eventFormatCloudEvents, eventFormatEnvelope)
+	}
+	return nil
+}
+
+// toCloudEvent rewraps the data of an event built by newEvent in a
+// CloudEvent. The business is its source, the aggregate its subject, and its
+// ID is drawn like an invoice ID so it repeats under --seed.
+func toCloudEvent(event Event) (Event, error) {
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(event.Payload, &envelope); err != nil {
+		return Event{}, fmt.Errorf("error decoding %s payload: %v", event.Type, err)
+	}
+
+	payload, err := json.Marshal(cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              newInvoiceUUID(),
+		Source:          event.BusinessID,
+		Type:            event.Type,
+		Subject:         event.AggregateID,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            envelope.Data,
+	})
+	if err != nil {
+		return Event{}, fmt.Errorf("error encoding %s as a CloudEvent: %v", event.Type, err)
+	}
+	event.Payload = payload
+	return event, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestCloudEventsFormat(t *testing.T) {
+	store, _ := newTestStore(t)
+	opts := testIngestOptions(t)
+	opts.EventFormat = eventFormatCloudEvents
+	seedInvoices(t, store, 2, opts)
+
+	events, err := store.ListEvents(context.Background())
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d events stored, want 2", len(events))
+	}
+
+	ids := map[string]bool{}
+	for _, event := range events {
+		var ce cloudEvent
+		if err := json.Unmarshal([]byte(event.Payload), &ce); err != nil {
+			t.Fatalf("decoding %s as a CloudEvent: %v", event.Payload, err)
+		}
+		if ce.SpecVersion != cloudEventsSpecVersion {
+			t.Errorf("specversion is %q, want %q", ce.SpecVersion, cloudEventsSpecVersion)
+		}
+		if ce.ID == "" || ids[ce.ID] {
+			t.Errorf("id %q is empty or repeated, want a unique ID per event", ce.ID)
+		}
+		ids[ce.ID] = true
+		if ce.Source != event.BusinessID {
+			t.Errorf("source is %q, want the business %q", ce.Source, event.BusinessID)
+		}
+		if ce.Type != event.EventType {
+			t.Errorf("type is %q, want %q", ce.Type, event.EventType)
+		}
+		if ce.Time.IsZero() {
+			t.Errorf("time is missing")
+		}
+
+		var invoice Invoice
+		if err := json.Unmarshal(ce.Data, &invoice); err != nil {
+			t.Fatalf("decoding data: %v", err)
+		}
+		if invoice.ID == "" || invoice.ID != ce.Subject || invoice.ID != event.AggregateID.String {
+			t.Errorf("data holds invoice %q with subject %q, want the invoice %q of the event", invoice.ID, ce.Subject, event.AggregateID.String)
+		}
+	}
+
+	if err := validateEventFormat("xml"); err == nil {
+		t.Errorf("validating an unknown event format succeeded, want an error")
+	}
+}
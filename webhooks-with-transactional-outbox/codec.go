@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Codecs that can be selected with --codec
+const (
+	codecJSON     = "json"
+	codecProtobuf = "protobuf"
+	codecAvro     = "avro"
+)
+
+// payloadCodec encodes the JSON payload produced by an event mapper into the
+// representation that is stored in the outbox and forwarded to Convoy
+type payloadCodec interface {
+	// Name is stored on the event row so the worker can look its format up
+	// again
+	Name() string
+	Encode(payload []byte) ([]byte, error)
+}
+
+// codecFormat is what the worker needs to know about the events stored with
+// a codec, which it sends without the codec's schema
+type codecFormat struct {
+	// contentType is sent along with the event when it is delivered
+	contentType string
+	// binary payloads aren't text, so they are stored base64 encoded, and
+	// sent to Convoy, whose event API carries JSON, as a base64 JSON string.
+	// Other publishers send them as they are.
+	binary bool
+}
+
+// codecFormats are the formats of the codecs that can be selected with
+// --codec
+var codecFormats = map[string]codecFormat{
+	codecJSON:     {contentType: "application/json"},
+	codecProtobuf: {contentType: "application/x-protobuf", binary: true},
+	codecAvro:     {contentType: "avro/binary", binary: true},
+}
+
+// getCodecFormat looks up the format of a codec by name
+func getCodecFormat(name string) (codecFormat, error) {
+	format, ok := codecFormats[name]
+	if !ok {
+		return codecFormat{}, fmt.Errorf("unsupported codec %q (available: %s)", name, codecNames())
+	}
+	return format, nil
+}
+
+// codecNames lists the codecs that can be selected with --codec
+func codecNames() string {
+	names := make([]string, 0, len(codecFormats))
+	for name := range codecFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// getPayloadCodec returns the codec named name. protobuf encodes payloads as
+// the message named message of the descriptor set in schemaFile, and avro as
+// the schema in schemaFile; json takes no schema.
+func getPayloadCodec(name, schemaFile, message string) (payloadCodec, error) {
+	if _, err := getCodecFormat(name); err != nil {
+		return nil, err
+	}
+	if name == codecJSON {
+		if schemaFile != "" || message != "" {
+			return nil, fmt.Errorf("--codec-schema and --codec-message only apply to --codec protobuf or avro")
+		}
+		return jsonCodec{}, nil
+	}
+
+	if schemaFile == "" {
+		return nil, fmt.Errorf("--codec %s needs a --codec-schema", name)
+	}
+	schema, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading codec schema: %v", err)
+	}
+	if name == codecAvro {
+		if message != "" {
+			return nil, fmt.Errorf("--codec-message only applies to --codec protobuf")
+		}
+		return newAvroCodec(schema)
+	}
+	return newProtobufCodec(schema, message)
+}
+
+// jsonCodec stores payloads as they are produced, after checking they are valid JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return codecJSON }
+
+func (jsonCodec) Encode(payload []byte) ([]byte, error) {
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("payload is not valid JSON")
+	}
+	return payload, nil
+}
+
+// protobufCodec stores payloads in the Protobuf wire format of a message
+// type, read from their JSON with the field names of the .proto file or
+// their JSON names
+type protobufCodec struct {
+	message protoreflect.MessageDescriptor
+}
+
+// newProtobufCodec returns a codec encoding payloads as the message named
+// message of descriptorSet, a FileDescriptorSet such as protoc writes with
+// --descriptor_set_out or buf build writes with -o. The set must hold the
+// files the message's file imports too, as --include_imports adds them.
+func newProtobufCodec(descriptorSet []byte, message string) (payloadCodec, error) {
+	if message == "" {
+		return nil, fmt.Errorf("--codec protobuf needs a --codec-message naming the message type, e.g. invoices.v1.Invoice")
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, fmt.Errorf("codec schema is not a Protobuf descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Protobuf descriptor set: %v", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %q not found in the Protobuf descriptor set: %v", message, err)
+	}
+	messageDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q in the Protobuf descriptor set is not a message", message)
+	}
+	return &protobufCodec{message: messageDesc}, nil
+}
+
+func (*protobufCodec) Name() string { return codecProtobuf }
+
+// Encode rejects a payload with fields the message doesn't have, rather than
+// dropping them on the way
+func (c *protobufCodec) Encode(payload []byte) ([]byte, error) {
+	message := dynamicpb.NewMessage(c.message)
+	if err := protojson.Unmarshal(payload, message); err != nil {
+		return nil, fmt.Errorf("payload doesn't match %s: %v", c.message.FullName(), err)
+	}
+	return proto.Marshal(message)
+}
+
+// avroCodec stores payloads in the Avro binary encoding of a schema, read from
+// their Avro JSON encoding
+type avroCodec struct {
+	codec *goavro.Codec
+}
+
+// newAvroCodec returns a codec encoding payloads with schema, the JSON of an
+// Avro schema such as an .avsc file holds
+func newAvroCodec(schema []byte) (payloadCodec, error) {
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Avro schema: %v", err)
+	}
+	return &avroCodec{codec: codec}, nil
+}
+
+func (*avroCodec) Name() string { return codecAvro }
+
+// Encode reads payload in the Avro JSON encoding, where a value of a union
+// other than null is wrapped in an object naming its type, e.g.
+// {"string": "paid"}
+func (c *avroCodec) Encode(payload []byte) ([]byte, error) {
+	native, _, err := c.codec.NativeFromTextual(payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload doesn't match the Avro schema: %v", err)
+	}
+	return c.codec.BinaryFromNative(nil, native)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testProtobufSchema writes a descriptor set holding invoices.v1.InvoiceEvent,
+// the payload of an invoice.created event, and returns its path
+func testProtobufSchema(tb testing.TB) string {
+	tb.Helper()
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   kind.Enum(),
+		}
+	}
+	data := field("data", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	data.TypeName = proto.String(".invoices.v1.Invoice")
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("invoices/v1/invoice.proto"),
+		Package: proto.String("invoices.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Invoice"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("business_id", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("amount_cents", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+				field("currency", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("status", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("created_at", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("description", 7, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			},
+		}, {
+			Name: proto.String("InvoiceEvent"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("event_type", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				data,
+			},
+		}},
+	}}}
+	encoded, err := proto.Marshal(set)
+	if err != nil {
+		tb.Fatalf("encoding descriptor set: %v", err)
+	}
+	path := filepath.Join(tb.TempDir(), "invoice.binpb")
+	if err := os.WriteFile(path, encoded, 0o644); err != nil {
+		tb.Fatalf("writing descriptor set: %v", err)
+	}
+	return path
+}
+
+// testAvroSchema is an Avro record of the payload of an invoice.created
+// event
+const testAvroSchema = `{
+	"type": "record",
+	"name": "InvoiceEvent",
+	"namespace": "invoices.v1",
+	"fields": [
+		{"name": "event_type", "type": "string"},
+		{"name": "data", "type": {
+			"type": "record",
+			"name": "Invoice",
+			"fields": [
+				{"name": "id", "type": "string"},
+				{"name": "business_id", "type": "string"},
+				{"name": "amount_cents", "type": "long"},
+				{"name": "currency", "type": "string"},
+				{"name": "status", "type": "string"},
+				{"name": "created_at", "type": "string"},
+				{"name": "description", "type": "string"}
+			]
+		}}
+	]
+}`
+
+// writeTestAvroSchema writes testAvroSchema and returns its path
+func writeTestAvroSchema(tb testing.TB) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "invoice.avsc")
+	if err := os.WriteFile(path, []byte(testAvroSchema), 0o644); err != nil {
+		tb.Fatalf("writing Avro schema: %v", err)
+	}
+	return path
+}
+
+// decodeProtobuf decodes an encoded invoices.v1.InvoiceEvent back to its
+// invoice
+func decodeProtobuf(tb testing.TB, schemaFile string, encoded []byte) Invoice {
+	tb.Helper()
+	raw, err := os.ReadFile(schemaFile)
+	if err != nil {
+		tb.Fatalf("reading descriptor set: %v", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		tb.Fatalf("decoding descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		tb.Fatalf("loading descriptor set: %v", err)
+	}
+	desc, err := files.FindDescriptorByName("invoices.v1.InvoiceEvent")
+	if err != nil {
+		tb.Fatalf("finding message: %v", err)
+	}
+	message := dynamicpb.NewMessage(desc.(protoreflect.MessageDescriptor))
+	if err := proto.Unmarshal(encoded, message); err != nil {
+		tb.Fatalf("decoding payload: %v", err)
+	}
+	data := message.Get(message.Descriptor().Fields().ByName("data")).Message()
+	field := func(name protoreflect.Name) protoreflect.Value {
+		return data.Get(data.Descriptor().Fields().ByName(name))
+	}
+	return Invoice{ID: field("id").String(), AmountCents: field("amount_cents").Int()}
+}
+
+// decodedInvoice returns the invoice of an invoice.created payload
+func decodedInvoice(tb testing.TB, payload []byte) Invoice {
+	tb.Helper()
+	var event struct {
+		Data Invoice `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		tb.Fatalf("decoding invoice: %v", err)
+	}
+	return event.Data
+}
+
+// decodeAvro decodes a payload encoded with testAvroSchema back to its
+// invoice
+func decodeAvro(tb testing.TB, encoded []byte) Invoice {
+	tb.Helper()
+	codec, err := goavro.NewCodec(testAvroSchema)
+	if err != nil {
+		tb.Fatalf("parsing Avro schema: %v", err)
+	}
+	native, _, err := codec.NativeFromBinary(encoded)
+	if err != nil {
+		tb.Fatalf("decoding payload: %v", err)
+	}
+	decoded, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		tb.Fatalf("encoding payload as JSON: %v", err)
+	}
+	return decodedInvoice(tb, decoded)
+}
+
+func TestPayloadCodecs(t *testing.T) {
+	protobufSchema := testProtobufSchema(t)
+	avroSchema := writeTestAvroSchema(t)
+	payload := []byte(`{"event_type":"invoice.created","data":{"id":"INV-1","business_id":"biz_1","amount_cents":1250,"currency":"USD","status":"pending","created_at":"2024-01-02T03:04:05Z","description":"Consulting"}}`)
+
+	t.Run("protobuf", func(t *testing.T) {
+		codec, err := getPayloadCodec(codecProtobuf, protobufSchema, "invoices.v1.InvoiceEvent")
+		if err != nil {
+			t.Fatalf("getting codec: %v", err)
+		}
+		encoded, err := codec.Encode(payload)
+		if err != nil {
+			t.Fatalf("encoding payload: %v", err)
+		}
+		if invoice := decodeProtobuf(t, protobufSchema, encoded); invoice.ID != "INV-1" || invoice.AmountCents != 1250 {
+			t.Errorf("decoded %+v, want the invoice of %s", invoice, payload)
+		}
+
+		if _, err := codec.Encode([]byte(`{"event_type":"invoice.created","unknown":true}`)); err == nil {
+			t.Errorf("encoding a payload with a field the message doesn't have succeeded, want an error")
+		}
+	})
+
+	t.Run("avro", func(t *testing.T) {
+		codec, err := getPayloadCodec(codecAvro, avroSchema, "")
+		if err != nil {
+			t.Fatalf("getting codec: %v", err)
+		}
+		encoded, err := codec.Encode(payload)
+		if err != nil {
+			t.Fatalf("encoding payload: %v", err)
+		}
+		if invoice := decodeAvro(t, encoded); invoice.ID != "INV-1" || invoice.AmountCents != 1250 {
+			t.Errorf("decoded %+v, want the invoice of %s", invoice, payload)
+		}
+
+		if _, err := codec.Encode([]byte(`{"event_type":"invoice.created"}`)); err == nil {
+			t.Errorf("encoding a payload missing fields of the schema succeeded, want an error")
+		}
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		tests := []struct {
+			name, codec, schema, message string
+		}{
+			{name: "json with schema", codec: codecJSON, schema: avroSchema},
+			{name: "protobuf without schema", codec: codecProtobuf, message: "invoices.v1.InvoiceEvent"},
+			{name: "protobuf without message", codec: codecProtobuf, schema: protobufSchema},
+			{name: "protobuf unknown message", codec: codecProtobuf, schema: protobufSchema, message: "invoices.v1.Refund"},
+			{name: "protobuf schema not a descriptor set", codec: codecProtobuf, schema: avroSchema, message: "invoices.v1.InvoiceEvent"},
+			{name: "avro without schema", codec: codecAvro},
+			{name: "avro with message", codec: codecAvro, schema: avroSchema, message: "invoices.v1.InvoiceEvent"},
+			{name: "avro schema not json", codec: codecAvro, schema: protobufSchema},
+			{name: "unknown codec", codec: "thrift"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := getPayloadCodec(tt.codec, tt.schema, tt.message); err == nil {
+					t.Errorf("getPayloadCodec(%q, %q, %q) succeeded, want an error", tt.codec, tt.schema, tt.message)
+				}
+			})
+		}
+	})
+}
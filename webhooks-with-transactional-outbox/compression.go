@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Encodings of a stored payload, recorded in its payload_encoding column
+const (
+	// payloadIdentity is a payload stored as it was encoded
+	payloadIdentity = "identity"
+	// payloadGzip is a payload compressed with gzip, which like a binary
+	// payload is stored base64 encoded unless it is encrypted
+	payloadGzip = "gzip"
+)
+
+// compressPayload compresses an encoded payload with gzip
+func compressPayload(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, fmt.Errorf("error compressing payload: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing payload: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressPayload returns the payload as it was encoded before it was
+// stored with encoding. Events stored before payload_encoding existed read
+// back as identity.
+func decompressPayload(encoding string, payload []byte) ([]byte, error) {
+	switch encoding {
+	case payloadIdentity, "":
+		return payload, nil
+	case payloadGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing payload: %v", err)
+		}
+		defer r.Close()
+		decompressed, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing payload: %v", err)
+		}
+		return decompressed, nil
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %q", encoding)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+)
+
+// payloadPublisher records the payload of every event it is sent
+type payloadPublisher struct {
+	mu       sync.Mutex
+	payloads [][]byte
+}
+
+func (p *payloadPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.payloads = append(p.payloads, event.Payload)
+	return "", nil
+}
+
+func TestCompressedPayloadRoundTrip(t *testing.T) {
+	cipher, err := newPayloadCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("creating cipher: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		compress bool
+		cipher   *payloadCipher
+		// wantEncoding is the payload_encoding stored with the event
+		wantEncoding string
+	}{
+		{name: "identity", wantEncoding: payloadIdentity},
+		{name: "gzip", compress: true, wantEncoding: payloadGzip},
+		{name: "gzip encrypted", compress: true, cipher: cipher, wantEncoding: payloadGzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newTestStore(t)
+			opts := testIngestOptions(t)
+			opts.CompressPayloads = tt.compress
+			opts.Cipher = tt.cipher
+			created := seedInvoices(t, store, 1, opts)
+
+			stored, err := store.ListEvents(context.Background())
+			if err != nil {
+				t.Fatalf("listing events: %v", err)
+			}
+			if len(stored) != 1 || stored[0].PayloadEncoding != tt.wantEncoding {
+				t.Fatalf("stored %d events, want one with encoding %s", len(stored), tt.wantEncoding)
+			}
+			if tt.compress && stored[0].Payload == string(created[0].Payload) {
+				t.Errorf("payload stored as it was built, want it compressed")
+			}
+
+			publisher := &payloadPublisher{}
+			workerOpts := testWorkerOptions()
+			workerOpts.Once = true
+			workerOpts.Cipher = tt.cipher
+			if err := runWorker(context.Background(), store, publisher, workerOpts); err != nil {
+				t.Fatalf("running worker: %v", err)
+			}
+			if len(publisher.payloads) != 1 || !bytes.Equal(publisher.payloads[0], created[0].Payload) {
+				t.Errorf("sent %q, want the payload as built %q", publisher.payloads, created[0].Payload)
+			}
+		})
+	}
+}
+
+func TestPayloadEncodingDefaultsToIdentity(t *testing.T) {
+	store, dbConn := newTestStore(t)
+
+	// An event written before payload_encoding existed doesn't set it
+	payload := `{"event_type":"invoice.created"}`
+	if _, err := dbConn.Exec("INSERT INTO events (id, business_id, event_type, payload) VALUES (?, ?, ?, ?)", newEventID(), businessIDs[0], "invoice.created", payload); err != nil {
+		t.Fatalf("storing event: %v", err)
+	}
+
+	publisher := &payloadPublisher{}
+	opts := testWorkerOptions()
+	opts.Once = true
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.payloads) != 1 || string(publisher.payloads[0]) != payload {
+		t.Errorf("sent %q, want %q", publisher.payloads, payload)
+	}
+}
+
+func TestDecompressPayloadUnknownEncoding(t *testing.T) {
+	if _, err := decompressPayload("br", []byte("payload")); err == nil {
+		t.Errorf("decompressing a br payload succeeded, want an error")
+	}
+}
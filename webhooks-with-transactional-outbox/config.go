@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix starts the environment variable of every flag without one of
+// its own, see flagEnv
+const envPrefix = "OUTBOX"
+
+// flagEnv returns the environment variable flag name is read from when it
+// isn't given on the command line: OUTBOX_ and the name in upper case with
+// underscores, as in OUTBOX_POLL_INTERVAL, other than for the Convoy flags
+// and --encryption-key, which keep the variables they have always had.
+func flagEnv(name string) string {
+	if env, ok := convoyEnv[name]; ok {
+		return env
+	}
+	if name == "encryption-key" {
+		return encryptionKeyEnv
+	}
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// exclusiveFlags are flags only one of which may be given, so once one is
+// set from a higher source the others aren't filled in from a lower one
+var exclusiveFlags = [][]string{
+	{"encryption-key", "encryption-key-file"},
+}
+
+// applySettings sets the flags of cmd that weren't given on the command
+// line from the environment, see flagEnv, and then from the config file at
+// configFile, when set, with Viper bound to the flags. So flags win over
+// the environment, the environment over the file, and the file over the
+// defaults, and a secret such as CONVOY_API_KEY can be kept out of the
+// file.
+//
+// The file may be in any format Viper reads, such as YAML, keyed by flag
+// name. A section stands for the flags its keys complete, so "convoy:" with
+// "api-key:" under it sets --convoy-api-key. A key that is a flag of
+// another command is skipped, and one that is no flag at all is an error,
+// as it is most likely a typo.
+func applySettings(cmd *cobra.Command, configFile string) error {
+	v := viper.New()
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+	if err := v.BindPFlags(cmd.Flags()); err != nil {
+		return fmt.Errorf("error binding flags: %v", err)
+	}
+	for flag, env := range convoyEnv {
+		v.BindEnv(flag, env)
+	}
+	v.BindEnv("encryption-key", encryptionKeyEnv)
+
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("error reading config file %s: %v", configFile, err)
+		}
+		known := make(map[string]bool)
+		var walk func(c *cobra.Command)
+		walk = func(c *cobra.Command) {
+			for _, flags := range []*pflag.FlagSet{c.Flags(), c.PersistentFlags()} {
+				flags.VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+			}
+			for _, sub := range c.Commands() {
+				walk(sub)
+			}
+		}
+		walk(cmd.Root())
+		for _, key := range v.AllKeys() {
+			if !v.InConfig(key) {
+				continue
+			}
+			name := strings.ReplaceAll(key, ".", "-")
+			if !known[name] {
+				return fmt.Errorf("unknown setting %q in config file: keys are flag names, e.g. poll-interval", key)
+			}
+			// A flag set through a section sits below the environment
+			// like the rest of the file
+			if name != key {
+				v.SetDefault(name, v.Get(key))
+			}
+		}
+	}
+
+	// unset reports whether flag is still to be filled in, neither it nor
+	// a flag it excludes having been set
+	unset := func(flag *pflag.Flag) bool {
+		if flag.Changed {
+			return false
+		}
+		for _, group := range exclusiveFlags {
+			for _, name := range group {
+				if name != flag.Name {
+					continue
+				}
+				for _, other := range group {
+					if f := cmd.Flags().Lookup(other); f != nil && f.Changed {
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}
+
+	// Flags set from the environment go first, so one of them rules out a
+	// flag it excludes that the file sets
+	var fromEnv, fromFile []*pflag.Flag
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed || flag.Name == "config" || flag.Name == "help" || !v.IsSet(flag.Name) {
+			return
+		}
+		if os.Getenv(flagEnv(flag.Name)) != "" {
+			fromEnv = append(fromEnv, flag)
+		} else {
+			fromFile = append(fromFile, flag)
+		}
+	})
+	for _, flag := range append(fromEnv, fromFile...) {
+		if !unset(flag) {
+			continue
+		}
+		if err := cmd.Flags().Set(flag.Name, settingValue(v.Get(flag.Name))); err != nil {
+			source := "config file"
+			if env := flagEnv(flag.Name); os.Getenv(env) != "" {
+				source = env
+			}
+			return fmt.Errorf("invalid %s in %s: %v", flag.Name, source, err)
+		}
+	}
+	return nil
+}
+
+// settingValue returns a value read by Viper as a flag takes it, a list
+// joined with commas
+func settingValue(value any) string {
+	switch v := value.(type) {
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	case []string:
+		return strings.Join(v, ",")
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// writeConfig writes contents to a config file named name in a temporary
+// directory and returns its path
+func writeConfig(tb testing.TB, name, contents string) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		tb.Fatalf("writing config: %v", err)
+	}
+	return path
+}
+
+func TestConfigFileValues(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	cmd := &cobra.Command{Use: "worker"}
+	root.AddCommand(cmd)
+	other := &cobra.Command{Use: "ingest"}
+	root.AddCommand(other)
+	other.Flags().String("rate", "", "")
+	var pollInterval time.Duration
+	var concurrency int
+	var webhookURL, metricsAddr string
+	var derivedEvents, ids []string
+	var cfg convoyConfig
+	cmd.Flags().DurationVar(&pollInterval, "poll-interval", time.Second, "")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 4, "")
+	cmd.Flags().StringVar(&webhookURL, "webhook-url", "", "")
+	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":9090", "")
+	cmd.Flags().StringSliceVar(&derivedEvents, "derived-events", nil, "")
+	cmd.Flags().StringSliceVar(&ids, "business-ids", nil, "")
+	addConvoyFlags(cmd, &cfg)
+	for _, env := range convoyEnv {
+		t.Setenv(env, "")
+	}
+
+	config := writeConfig(t, "config.yaml", `
+# Worker settings
+poll-interval: 2s   # how often to poll
+concurrency: 8
+webhook-url: "http://localhost:8081/hooks#main"
+derived-events: [ledger.entry.added, "other.event"]
+business-ids:
+  - 7d4a3f26-9a56-4d33-9a3f-4a6b8f2b1c01
+  - "0b8e6f2c-3d4a-4e5f-8a9b-1c2d3e4f5a6b"
+metrics-addr: ""
+rate: 1s
+convoy:
+  project-id: 'it''s'
+`)
+	if err := applySettings(cmd, config); err != nil {
+		t.Fatalf("applying config: %v", err)
+	}
+	if pollInterval != 2*time.Second || concurrency != 8 || webhookURL != "http://localhost:8081/hooks#main" || metricsAddr != "" {
+		t.Errorf("got poll interval %v, concurrency %d, webhook URL %q and metrics address %q, want the file's", pollInterval, concurrency, webhookURL, metricsAddr)
+	}
+	if want := []string{"ledger.entry.added", "other.event"}; !reflect.DeepEqual(derivedEvents, want) {
+		t.Errorf("derived events %v, want %v", derivedEvents, want)
+	}
+	if want := []string{"7d4a3f26-9a56-4d33-9a3f-4a6b8f2b1c01", "0b8e6f2c-3d4a-4e5f-8a9b-1c2d3e4f5a6b"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("business IDs %v, want %v", ids, want)
+	}
+	// The section sets the flag its key completes
+	if cfg.ProjectID != "it's" {
+		t.Errorf("Convoy project %q, want the convoy section's", cfg.ProjectID)
+	}
+
+	for name, bad := range map[string]string{
+		"unknown.yaml":   "poll-intervall: 2s\n",
+		"section.yaml":   "worker:\n  poll-interval: 2s\n",
+		"malformed.yaml": "business-ids: [a, b\n",
+		"invalid.yaml":   "concurrency: many\n",
+	} {
+		fresh := &cobra.Command{Use: "worker"}
+		(&cobra.Command{Use: "root"}).AddCommand(fresh)
+		fresh.Flags().Int("concurrency", 4, "")
+		fresh.Flags().StringSlice("business-ids", nil, "")
+		if err := applySettings(fresh, writeConfig(t, name, bad)); err == nil {
+			t.Errorf("applying %q succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	// Running a command installs its own logger
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "outbox.db")
+	configPath := filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf(`db-path: %s
+log-level: error
+skip-if-exists: true
+rate: 1ms
+count: 5
+business-ids:
+  - %s
+publisher: noop
+once: true
+quiet: true
+metrics-addr: ""
+concurrency: 2
+`, dbPath, businessIDs[1])
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+
+	run := func(args ...string) {
+		t.Helper()
+		cmd := newRootCmd()
+		cmd.SetArgs(append(args, "--config", configPath))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("running %v: %v", args, err)
+		}
+	}
+
+	// The flag given on the command line wins over the file
+	run("ingest", "--count", "3")
+	run("worker")
+
+	dbConn, err := sql.Open(driverSQLite, dbPath)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer dbConn.Close()
+	var invoices, otherBusinesses, processed int
+	err = dbConn.QueryRow("SELECT COUNT(*), COUNT(*) FILTER (WHERE business_id != ?) FROM invoices", businessIDs[1]).Scan(&invoices, &otherBusinesses)
+	if err != nil {
+		t.Fatalf("reading invoices: %v", err)
+	}
+	if invoices != 3 || otherBusinesses != 0 {
+		t.Errorf("stored %d invoices, %d for other businesses, want 3 for %s", invoices, otherBusinesses, businessIDs[1])
+	}
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status = 'processed'").Scan(&processed); err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	if processed != 3 {
+		t.Errorf("worker processed %d events, want 3", processed)
+	}
+}
+
+func TestConvoyLoadEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want convoyConfig
+	}{
+		{
+			name: "env fills unset flags",
+			env:  map[string]string{"CONVOY_API_KEY": "key-from-env", "CONVOY_PROJECT_ID": "project-from-env", "CONVOY_BASE_URL": "http://convoy.local"},
+			want: convoyConfig{APIKey: "key-from-env", ProjectID: "project-from-env", BaseURL: "http://convoy.local"},
+		},
+		{
+			name: "flags beat env",
+			args: []string{"--convoy-api-key", "key-from-flag", "--convoy-project-id", "project-from-flag"},
+			env:  map[string]string{"CONVOY_API_KEY": "key-from-env", "CONVOY_PROJECT_ID": "project-from-env"},
+			want: convoyConfig{APIKey: "key-from-flag", ProjectID: "project-from-flag", BaseURL: "https://api.getconvoy.io"},
+		},
+		{
+			name: "flag given its default still beats env",
+			args: []string{"--convoy-base-url", "https://api.getconvoy.io"},
+			env:  map[string]string{"CONVOY_BASE_URL": "http://convoy.local"},
+			want: convoyConfig{BaseURL: "https://api.getconvoy.io"},
+		},
+		{
+			name: "empty env leaves defaults",
+			env:  map[string]string{"CONVOY_BASE_URL": ""},
+			want: convoyConfig{BaseURL: "https://api.getconvoy.io"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range convoyEnv {
+				t.Setenv(env, "")
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cmd := &cobra.Command{Use: "worker"}
+			var cfg convoyConfig
+			addConvoyFlags(cmd, &cfg)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("parsing flags: %v", err)
+			}
+			cfg.loadEnv(cmd)
+			if cfg != tt.want {
+				t.Errorf("got %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFileLeavesSecretsToEnv(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	cmd := &cobra.Command{Use: "worker"}
+	root.AddCommand(cmd)
+	var cfg convoyConfig
+	addConvoyFlags(cmd, &cfg)
+
+	t.Setenv("CONVOY_API_KEY", "from-env")
+	t.Setenv("CONVOY_PROJECT_ID", "")
+	t.Setenv("CONVOY_BASE_URL", "")
+	config := writeConfig(t, "config.yaml", "convoy-api-key: from-file\nconvoy-project-id: project-from-file\n")
+	if err := applySettings(cmd, config); err != nil {
+		t.Fatalf("applying config: %v", err)
+	}
+	cfg.loadEnv(cmd)
+	if cfg.APIKey != "from-env" || cfg.ProjectID != "project-from-file" {
+		t.Errorf("got API key %q and project %q, want the key from the environment and the project from the file", cfg.APIKey, cfg.ProjectID)
+	}
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	cmd := &cobra.Command{Use: "worker"}
+	root.AddCommand(cmd)
+	var pollInterval, maxPollInterval, retryDelay time.Duration
+	var concurrency int
+	var encryptionKey, encryptionKeyFile string
+	cmd.Flags().DurationVar(&pollInterval, "poll-interval", time.Second, "")
+	cmd.Flags().DurationVar(&maxPollInterval, "max-poll-interval", time.Minute, "")
+	cmd.Flags().DurationVar(&retryDelay, "retry-delay", 5*time.Second, "")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 4, "")
+	cmd.Flags().StringVar(&encryptionKey, "encryption-key", "", "")
+	cmd.Flags().StringVar(&encryptionKeyFile, "encryption-key-file", "", "")
+
+	t.Setenv("OUTBOX_POLL_INTERVAL", "2s")
+	t.Setenv("OUTBOX_MAX_POLL_INTERVAL", "2m")
+	t.Setenv("OUTBOX_RETRY_DELAY", "")
+	t.Setenv("OUTBOX_CONCURRENCY", "")
+	t.Setenv(encryptionKeyEnv, testEncryptionKey)
+	config := writeConfig(t, "config.yaml", `
+poll-interval: 3s
+max-poll-interval: 3m
+concurrency: 8
+encryption-key-file: /etc/outbox/key
+`)
+	if err := cmd.ParseFlags([]string{"--max-poll-interval", "1m30s"}); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+	if err := applySettings(cmd, config); err != nil {
+		t.Fatalf("applying settings: %v", err)
+	}
+	if maxPollInterval != 90*time.Second {
+		t.Errorf("--max-poll-interval = %v, want the flag's 1m30s", maxPollInterval)
+	}
+	if pollInterval != 2*time.Second {
+		t.Errorf("--poll-interval = %v, want OUTBOX_POLL_INTERVAL's 2s", pollInterval)
+	}
+	if concurrency != 8 {
+		t.Errorf("--concurrency = %d, want the file's 8", concurrency)
+	}
+	if retryDelay != 5*time.Second {
+		t.Errorf("--retry-delay = %v, want the default 5s", retryDelay)
+	}
+	// The key from the environment rules out the file's key file
+	if encryptionKey != testEncryptionKey || encryptionKeyFile != "" {
+		t.Errorf("got key %q and key file %q, want only %s's key", encryptionKey, encryptionKeyFile, encryptionKeyEnv)
+	}
+
+	t.Setenv("OUTBOX_RETRY_DELAY", "soon")
+	if err := applySettings(cmd, ""); err == nil || !strings.Contains(err.Error(), "OUTBOX_RETRY_DELAY") {
+		t.Errorf("applying an invalid OUTBOX_RETRY_DELAY gave %v, want an error naming it", err)
+	}
+}
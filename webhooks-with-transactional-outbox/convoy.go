@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+	"github.com/spf13/cobra"
+)
+
+// convoyConfig holds the credentials shared by every command talking to Convoy
+type convoyConfig struct {
+	APIKey    string
+	ProjectID string
+	BaseURL   string
+}
+
+// addConvoyFlags registers the Convoy connection flags on cmd
+func addConvoyFlags(cmd *cobra.Command, cfg *convoyConfig) {
+	cmd.Flags().StringVar(&cfg.APIKey, "convoy-api-key", "", "Convoy API key (env CONVOY_API_KEY)")
+	cmd.Flags().StringVar(&cfg.ProjectID, "convoy-project-id", "", "Convoy project ID (env CONVOY_PROJECT_ID)")
+	cmd.Flags().StringVar(&cfg.BaseURL, "convoy-base-url", "https://api.getconvoy.io", "Convoy API base URL (env CONVOY_BASE_URL)")
+}
+
+// convoyEnv maps each Convoy flag to the environment variable it is read
+// from when it isn't given
+var convoyEnv = map[string]string{
+	"convoy-api-key":    "CONVOY_API_KEY",
+	"convoy-project-id": "CONVOY_PROJECT_ID",
+	"convoy-base-url":   "CONVOY_BASE_URL",
+}
+
+// loadEnv fills in the Convoy settings whose flags weren't given from the
+// environment, so the API key needn't appear in shell history or ps
+func (cfg *convoyConfig) loadEnv(cmd *cobra.Command) {
+	settings := []struct {
+		flag  string
+		value *string
+	}{
+		{"convoy-api-key", &cfg.APIKey},
+		{"convoy-project-id", &cfg.ProjectID},
+		{"convoy-base-url", &cfg.BaseURL},
+	}
+	for _, setting := range settings {
+		if cmd.Flags().Changed(setting.flag) {
+			continue
+		}
+		if value := os.Getenv(convoyEnv[setting.flag]); value != "" {
+			*setting.value = value
+		}
+	}
+}
+
+// validate reports the Convoy settings that are required but missing
+func (cfg convoyConfig) validate() error {
+	var missing []string
+	if cfg.APIKey == "" {
+		missing = append(missing, "--convoy-api-key (or CONVOY_API_KEY)")
+	}
+	if cfg.ProjectID == "" {
+		missing = append(missing, "--convoy-project-id (or CONVOY_PROJECT_ID)")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing Convoy settings: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// client returns a Convoy client whose requests note 429 responses for
+// rateLimitTransport. The timeout is convoy-go's own default.
+func (cfg convoyConfig) client() *convoy.Client {
+	httpClient := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: rateLimitTransport{next: http.DefaultTransport},
+	}
+	return convoy.New(cfg.BaseURL, cfg.APIKey, cfg.ProjectID, convoy.OptionHTTPClient(httpClient))
+}
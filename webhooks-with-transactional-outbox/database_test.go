@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestOpenStoreJournalMode(t *testing.T) {
+	for _, wal := range []bool{true, false} {
+		cfg := testDBConfig(t)
+		cfg.SQLiteWAL = wal
+		store, err := openStore(cfg)
+		if err != nil {
+			t.Fatalf("opening database: %v", err)
+		}
+		defer store.Close()
+
+		want := "delete"
+		if wal {
+			want = "wal"
+		}
+		var mode string
+		var timeout int
+		if err := store.conn().QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+			t.Fatalf("reading journal mode: %v", err)
+		}
+		if err := store.conn().QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil {
+			t.Fatalf("reading busy timeout: %v", err)
+		}
+		if mode != want {
+			t.Errorf("--sqlite-wal=%t: journal mode %q, want %q", wal, mode, want)
+		}
+		if timeout != 5000 {
+			t.Errorf("--sqlite-wal=%t: busy timeout %dms, want 5000ms", wal, timeout)
+		}
+	}
+}
+
+func TestDBPathFlag(t *testing.T) {
+	// Running a command installs its own logger
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	// Run from elsewhere, so a database left in the working directory
+	// would show the flag was ignored
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getting working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("changing directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	dbPath := filepath.Join(t.TempDir(), "outbox.db")
+	ingest := func() {
+		t.Helper()
+		cmd := newRootCmd()
+		cmd.SetArgs([]string{"ingest", "--count", "2", "--rate", "1ms", "--db-path", dbPath, "--skip-if-exists", "--log-level", "error"})
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("running ingest: %v", err)
+		}
+	}
+	invoices := func() int {
+		t.Helper()
+		dbConn, err := sql.Open(driverSQLite, dbPath)
+		if err != nil {
+			t.Fatalf("opening database: %v", err)
+		}
+		defer dbConn.Close()
+		var count int
+		if err := dbConn.QueryRow("SELECT COUNT(*) FROM invoices").Scan(&count); err != nil {
+			t.Fatalf("counting invoices: %v", err)
+		}
+		return count
+	}
+
+	// The first run creates the database at the path, the second opens it
+	ingest()
+	if count := invoices(); count != 2 {
+		t.Errorf("created database holds %d invoices, want 2", count)
+	}
+	ingest()
+	if count := invoices(); count != 4 {
+		t.Errorf("reopened database holds %d invoices, want 4", count)
+	}
+	if _, err := os.Stat("events.db"); !os.IsNotExist(err) {
+		t.Errorf("found events.db in the working directory, want only %s used", dbPath)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	calls := 0
+	err := withRetry(context.Background(), 3, func() error {
+		calls++
+		if calls < 3 {
+			return busy
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("got %v after %d calls, want success on the 3rd", err, calls)
+	}
+
+	calls = 0
+	err = withRetry(context.Background(), 2, func() error {
+		calls++
+		return errors.New("error committing transaction: database is locked")
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("got %v after %d calls, want the busy error after 2 retries", err, calls)
+	}
+
+	calls = 0
+	err = withRetry(context.Background(), 5, func() error {
+		calls++
+		return errors.New("invoice is invalid")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("got %v after %d calls, want other errors returned at once", err, calls)
+	}
+}
+
+func TestConcurrentWritersKeepEveryInvoice(t *testing.T) {
+	const writers, perWriter = 8, 15
+
+	// Two stores on one file stand in for separate ingest processes, each
+	// with its own connection pool
+	cfg := testDBConfig(t)
+	var stores []*sqlStore
+	for i := 0; i < 2; i++ {
+		store, err := openStore(cfg)
+		if err != nil {
+			t.Fatalf("opening database: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		stores = append(stores, store)
+	}
+	if _, err := stores[0].conn().Exec(cfg.schema()); err != nil {
+		t.Fatalf("applying schema: %v", err)
+	}
+	if err := seedDatabase(context.Background(), stores[0]); err != nil {
+		t.Fatalf("seeding businesses: %v", err)
+	}
+
+	opts := testIngestOptions(t)
+	opts.BusyRetries = 5
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter)
+	for w := 0; w < writers; w++ {
+		store := stores[w%len(stores)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				invoice := generateInvoice(businessIDs[i%len(businessIDs)])
+				errs <- withRetry(context.Background(), opts.BusyRetries, func() error {
+					_, err := createInvoiceWithEvents(context.Background(), store, invoice, opts)
+					return err
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("storing invoice: %v", err)
+		}
+	}
+	var invoices, events int
+	if err := stores[0].conn().QueryRow("SELECT (SELECT COUNT(*) FROM invoices), (SELECT COUNT(*) FROM events)").Scan(&invoices, &events); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	if invoices != writers*perWriter || events != writers*perWriter {
+		t.Errorf("%d invoices and %d events stored, want %d of each", invoices, events, writers*perWriter)
+	}
+}
+
+func TestDBTimeout(t *testing.T) {
+	store, dbConn := newTestStore(t)
+
+	// Every insert into invoices now counts to a billion first
+	_, err := dbConn.Exec(`CREATE TRIGGER slow_invoice BEFORE INSERT ON invoices BEGIN
+		SELECT count(*) FROM (WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n LIMIT 1000000000) SELECT x FROM n);
+	END`)
+	if err != nil {
+		t.Fatalf("creating slow trigger: %v", err)
+	}
+
+	opts := testIngestOptions(t)
+	opts.DBTimeout = 100 * time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		_, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(businessIDs[0]), opts)
+		done <- err
+	}()
+
+	// Errors are wrapped with %v, so only the message says why it failed
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Fatalf("got %v, want a deadline exceeded error", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("storing an invoice with a slow insert hung past its timeout")
+	}
+
+	// The timed out transaction was rolled back and left the database usable
+	if _, err := dbConn.Exec("DROP TRIGGER slow_invoice"); err != nil {
+		t.Fatalf("dropping slow trigger: %v", err)
+	}
+	if _, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(businessIDs[0]), opts); err != nil {
+		t.Fatalf("storing an invoice after the timeout: %v", err)
+	}
+	var invoices int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM invoices").Scan(&invoices); err != nil {
+		t.Fatalf("counting invoices: %v", err)
+	}
+	if invoices != 1 {
+		t.Errorf("%d invoices stored, want only the one stored after the timeout", invoices)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "closed pool", err: errors.New("error fetching events: sql: database is closed"), want: true},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}, want: true},
+		{name: "postgres restarting", err: &pq.Error{Code: "57P01"}, want: true},
+		{name: "postgres connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "sqlite can't open", err: sqlite3.Error{Code: sqlite3.ErrCantOpen}, want: true},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "busy", err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: false},
+		{name: "no such table", err: errors.New("no such table: events"), want: false},
+		{name: "timeout", err: context.DeadlineExceeded, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "postgres", err: &pq.Error{Code: "23505"}, want: true},
+		{name: "sqlite primary key", err: sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}, want: true},
+		{name: "sqlite wrapped", err: errors.New("error creating invoice: UNIQUE constraint failed: invoices.id"), want: true},
+		{name: "foreign key", err: sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintForeignKey}, want: false},
+		{name: "postgres foreign key", err: &pq.Error{Code: "23503"}, want: false},
+		{name: "busy", err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUniqueViolation(tt.err); got != tt.want {
+				t.Errorf("isUniqueViolation(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsFatalError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "postgres auth", err: &pq.Error{Code: "28P01"}, want: true},
+		{name: "postgres missing table", err: &pq.Error{Code: "42P01"}, want: true},
+		{name: "sqlite not a database", err: sqlite3.Error{Code: sqlite3.ErrNotADB}, want: true},
+		{name: "sqlite wrapped", err: errors.New("error fetching events: no such table: events"), want: true},
+		{name: "postgres missing table wrapped", err: errors.New(`error fetching events: pq: relation "events" does not exist`), want: true},
+		{name: "postgres missing column wrapped", err: errors.New(`error claiming events: pq: column "claimed_by" does not exist`), want: true},
+		{name: "sink refused credentials", err: sinkAuthError{status: http.StatusForbidden, err: errors.New("sink returned 403")}, want: true},
+		{name: "missing prepared statement", err: errors.New(`pq: prepared statement "stmt_1" does not exist`), want: false},
+		{name: "missing convoy endpoint", err: errors.New("convoy error: endpoint does not exist"), want: false},
+		{name: "postgres connection", err: &pq.Error{Code: "08006"}, want: false},
+		{name: "busy", err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: false},
+		{name: "timeout", err: context.DeadlineExceeded, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFatalError(tt.err); got != tt.want {
+				t.Errorf("isFatalError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerReconnects(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 3, testIngestOptions(t))
+
+	publisher := &fakePublisher{}
+	published := func() int {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		return len(publisher.published)
+	}
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runWorker(ctx, store, publisher, testWorkerOptions()) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor("the first events to be published", func() bool { return published() == 3 })
+
+	// The pool goes away under the running worker, which opens a new one
+	dbConn.Close()
+	sqlStore := store.(*sqlStore)
+	waitFor("the worker to reconnect", func() bool { return sqlStore.conn() != dbConn })
+
+	seedInvoices(t, store, 3, testIngestOptions(t))
+	waitFor("the events stored after reconnecting to be published", func() bool { return published() == 6 })
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+)
+
+// lockPendingEvents is GetPendingEvents with the rows locked until the
+// transaction ends. SQLite has no row locks, so unlike the queries in
+// queries.sql this one only runs on Postgres.
+const lockPendingEvents = `-- name: LockPendingEvents :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE status = 'pending'
+  AND queue = ?
+  AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+  AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+ORDER BY created_at ASC, rowid ASC
+LIMIT ?
+FOR UPDATE SKIP LOCKED
+`
+
+// LockPendingEvents locks up to arg.Limit pending events for the current
+// transaction, skipping rows another transaction already holds, so
+// concurrent workers never fetch the same event
+func (q *Queries) LockPendingEvents(ctx context.Context, arg GetPendingEventsParams) ([]Event, error) {
+	rows, err := q.db.QueryContext(ctx, lockPendingEvents, arg.Queue, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Event{}
+	for rows.Next() {
+		var i Event
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+			&i.Status,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ClaimedAt,
+			&i.DeliveryID,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.VisibleAt,
+			&i.ExpiresAt,
+			&i.ClaimedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,21 @@
+package db
+
+import "embed"
+
+// Migrations holds the numbered schema migrations of each driver, in
+// migrations/sqlite and migrations/postgres. Every version has an
+// NNNN_name.up.sql applying it and an NNNN_name.down.sql reverting it.
+//
+//go:embed migrations
+var Migrations embed.FS
+
+// SQLiteSchema is schema.sql, the SQLite schema as of the latest migration
+//
+//go:embed schema.sql
+var SQLiteSchema string
+
+// PostgresSchema is postgres/schema.sql, the Postgres schema as of the
+// latest migration
+//
+//go:embed postgres/schema.sql
+var PostgresSchema string
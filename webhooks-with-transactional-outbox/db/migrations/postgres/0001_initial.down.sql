@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dead_letter_events, worker_cursors, invoices, events;
+DROP FUNCTION IF EXISTS notify_outbox();
@@ -0,0 +1,84 @@
+-- The schema as it stood before migrations were tracked. Its statements
+-- are idempotent, so a database created from db/postgres/schema.sql is
+-- adopted as is.
+
+-- Create events table
+CREATE TABLE IF NOT EXISTS events (
+    id TEXT PRIMARY KEY DEFAULT replace(gen_random_uuid()::text, '-', ''),
+    -- Stands in for SQLite's implicit rowid, which the queries order by
+    rowid BIGSERIAL NOT NULL UNIQUE,
+    business_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMPTZ,
+    status TEXT DEFAULT 'pending',
+    codec TEXT NOT NULL DEFAULT 'json',
+    queue TEXT NOT NULL DEFAULT 'default',
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    aggregate_id TEXT,
+    retry_count BIGINT NOT NULL DEFAULT 0,
+    next_retry_at TIMESTAMPTZ,
+    last_error TEXT,
+    claimed_at TIMESTAMPTZ,
+    delivery_id TEXT
+);
+
+-- Create invoices table
+CREATE TABLE IF NOT EXISTS invoices (
+    id TEXT PRIMARY KEY,
+    business_id TEXT NOT NULL,
+    amount DOUBLE PRECISION NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    description TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create worker cursors table
+CREATE TABLE IF NOT EXISTS worker_cursors (
+    worker_id TEXT PRIMARY KEY,
+    last_event_id TEXT,
+    last_event_created_at TIMESTAMPTZ,
+    events_processed BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create dead-letter table for events that exhausted their retries
+CREATE TABLE IF NOT EXISTS dead_letter_events (
+    id TEXT PRIMARY KEY,
+    business_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ,
+    codec TEXT NOT NULL,
+    queue TEXT NOT NULL,
+    encrypted BOOLEAN NOT NULL,
+    aggregate_id TEXT,
+    retry_count BIGINT NOT NULL,
+    last_error TEXT,
+    dead_lettered_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Announce every new event on the outbox channel, with its queue as the
+-- payload, so workers started with --notify wake up without polling
+CREATE OR REPLACE FUNCTION notify_outbox() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox', NEW.queue);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS events_notify ON events;
+CREATE TRIGGER events_notify
+    AFTER INSERT ON events
+    FOR EACH ROW EXECUTE FUNCTION notify_outbox();
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_events_business_id ON events(business_id);
+CREATE INDEX IF NOT EXISTS idx_events_status ON events(status);
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
+CREATE INDEX IF NOT EXISTS idx_events_queue_status ON events(queue, status, created_at);
+CREATE INDEX IF NOT EXISTS idx_events_aggregate_id ON events(aggregate_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_business_id ON invoices(business_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status, created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_business_id_fkey;
+DROP TABLE IF EXISTS businesses;
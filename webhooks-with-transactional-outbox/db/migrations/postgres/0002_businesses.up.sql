@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS businesses (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Invoices stored before businesses were tracked keep their business,
+-- named by its ID until seed names it
+INSERT INTO businesses (id, name)
+SELECT DISTINCT business_id, business_id FROM invoices
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_business_id_fkey;
+ALTER TABLE invoices ADD CONSTRAINT invoices_business_id_fkey
+    FOREIGN KEY (business_id) REFERENCES businesses(id);
@@ -0,0 +1,12 @@
+-- Every delivery attempt of an event. Rows aren't tied to events by a
+-- foreign key, so the history outlives an event that is dead-lettered or
+-- cleaned up.
+CREATE TABLE event_attempts (
+    event_id TEXT NOT NULL,
+    attempt_no BIGINT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT,
+    latency_ms BIGINT NOT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_id, attempt_no)
+);
@@ -0,0 +1,7 @@
+-- How Convoy delivers an event: fanout to the endpoints of its owner, or
+-- broadcast to every subscriber. A broadcast has no owner, and events
+-- stored before owner_id existed are owned by their business.
+ALTER TABLE events ADD COLUMN owner_id TEXT;
+ALTER TABLE events ADD COLUMN delivery_mode TEXT NOT NULL DEFAULT 'fanout';
+ALTER TABLE dead_letter_events ADD COLUMN owner_id TEXT;
+ALTER TABLE dead_letter_events ADD COLUMN delivery_mode TEXT NOT NULL DEFAULT 'fanout';
@@ -0,0 +1,2 @@
+ALTER TABLE dead_letter_events DROP COLUMN idempotency_key;
+ALTER TABLE events DROP COLUMN idempotency_key;
@@ -0,0 +1,2 @@
+ALTER TABLE dead_letter_events DROP COLUMN headers;
+ALTER TABLE events DROP COLUMN headers;
@@ -0,0 +1,3 @@
+-- When an event may first be delivered, so ingest's --delay can schedule it
+-- for later. The events written before are visible at once.
+ALTER TABLE events ADD COLUMN visible_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP;
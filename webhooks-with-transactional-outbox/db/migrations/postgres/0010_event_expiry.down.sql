@@ -0,0 +1,2 @@
+ALTER TABLE dead_letter_events DROP COLUMN status;
+ALTER TABLE events DROP COLUMN expires_at;
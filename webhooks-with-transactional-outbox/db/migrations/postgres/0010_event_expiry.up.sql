@@ -0,0 +1,5 @@
+-- When an undelivered event goes stale, set by ingest's --event-ttl. The
+-- worker moves an event past it to the dead-letter table instead of
+-- delivering it, where status tells it apart from one that failed.
+ALTER TABLE events ADD COLUMN expires_at TIMESTAMPTZ;
+ALTER TABLE dead_letter_events ADD COLUMN status TEXT NOT NULL DEFAULT 'failed';
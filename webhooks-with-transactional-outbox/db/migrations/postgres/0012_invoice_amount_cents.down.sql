@@ -0,0 +1,4 @@
+ALTER TABLE invoices ADD COLUMN amount DOUBLE PRECISION;
+UPDATE invoices SET amount = amount_cents / CASE currency WHEN 'JPY' THEN 1.0 ELSE 100.0 END;
+ALTER TABLE invoices ALTER COLUMN amount SET NOT NULL;
+ALTER TABLE invoices DROP COLUMN amount_cents;
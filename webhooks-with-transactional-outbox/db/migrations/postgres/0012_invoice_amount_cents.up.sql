@@ -0,0 +1,6 @@
+-- Amounts are stored as integer minor units of their currency, cents for
+-- USD and yen for JPY, so they are exact
+ALTER TABLE invoices ADD COLUMN amount_cents BIGINT;
+UPDATE invoices SET amount_cents = ROUND(amount * CASE currency WHEN 'JPY' THEN 1 ELSE 100 END);
+ALTER TABLE invoices ALTER COLUMN amount_cents SET NOT NULL;
+ALTER TABLE invoices DROP COLUMN amount;
@@ -0,0 +1,3 @@
+-- The worker pages through pending events by (created_at, rowid), which
+-- idx_events_queue_status serves, so no query reads (status, id) anymore
+DROP INDEX IF EXISTS idx_events_status_id;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS dead_letter_events;
+DROP TABLE IF EXISTS worker_cursors;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS events;
@@ -0,0 +1,28 @@
+CREATE TABLE businesses (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Invoices stored before businesses were tracked keep their business,
+-- named by its ID until seed names it
+INSERT INTO businesses (id, name)
+SELECT DISTINCT business_id, business_id FROM invoices;
+
+-- SQLite can't add a foreign key to an existing table, so invoices is
+-- rebuilt with one
+CREATE TABLE invoices_new (
+    id TEXT PRIMARY KEY,
+    business_id TEXT NOT NULL REFERENCES businesses(id),
+    amount REAL NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    description TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO invoices_new (id, business_id, amount, currency, status, description, created_at)
+SELECT id, business_id, amount, currency, status, description, created_at FROM invoices;
+DROP TABLE invoices;
+ALTER TABLE invoices_new RENAME TO invoices;
+CREATE INDEX idx_invoices_business_id ON invoices(business_id);
+CREATE INDEX idx_invoices_status ON invoices(status, created_at);
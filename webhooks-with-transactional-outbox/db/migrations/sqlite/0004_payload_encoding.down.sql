@@ -0,0 +1,2 @@
+ALTER TABLE dead_letter_events DROP COLUMN payload_encoding;
+ALTER TABLE events DROP COLUMN payload_encoding;
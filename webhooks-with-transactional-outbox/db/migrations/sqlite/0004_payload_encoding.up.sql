@@ -0,0 +1,4 @@
+-- How a stored payload is compressed: identity for as it is, or gzip.
+-- Events stored before the column existed are uncompressed.
+ALTER TABLE events ADD COLUMN payload_encoding TEXT NOT NULL DEFAULT 'identity';
+ALTER TABLE dead_letter_events ADD COLUMN payload_encoding TEXT NOT NULL DEFAULT 'identity';
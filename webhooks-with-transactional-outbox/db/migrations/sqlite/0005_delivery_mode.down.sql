@@ -0,0 +1,4 @@
+ALTER TABLE dead_letter_events DROP COLUMN delivery_mode;
+ALTER TABLE dead_letter_events DROP COLUMN owner_id;
+ALTER TABLE events DROP COLUMN delivery_mode;
+ALTER TABLE events DROP COLUMN owner_id;
@@ -0,0 +1,5 @@
+-- The key Convoy deduplicates deliveries of an event by, chosen by
+-- --idempotency-strategy when the event is stored. Events without one are
+-- keyed by their ID.
+ALTER TABLE events ADD COLUMN idempotency_key TEXT;
+ALTER TABLE dead_letter_events ADD COLUMN idempotency_key TEXT;
@@ -0,0 +1,3 @@
+-- Lets the worker page through pending events by ID without scanning the
+-- processed ones kept around
+CREATE INDEX IF NOT EXISTS idx_events_status_id ON events(status, id);
@@ -0,0 +1,4 @@
+-- Custom headers sent with each delivery of an event, as a JSON object,
+-- such as the business and trace ID added at ingest
+ALTER TABLE events ADD COLUMN headers TEXT;
+ALTER TABLE dead_letter_events ADD COLUMN headers TEXT;
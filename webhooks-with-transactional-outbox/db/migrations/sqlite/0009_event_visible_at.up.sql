@@ -0,0 +1,5 @@
+-- When an event may first be delivered, so ingest's --delay can schedule it
+-- for later. SQLite can't add a column defaulting to CURRENT_TIMESTAMP, so
+-- CreateEvent fills it in, and NULL, as on the events written before,
+-- means visible at once.
+ALTER TABLE events ADD COLUMN visible_at DATETIME;
@@ -0,0 +1,3 @@
+-- The --worker-id of the worker holding an event while it is processing,
+-- so the workers of a shared deployment can be told apart
+ALTER TABLE events ADD COLUMN claimed_by TEXT;
@@ -0,0 +1,15 @@
+CREATE TABLE invoices_old (
+    id TEXT PRIMARY KEY,
+    business_id TEXT NOT NULL REFERENCES businesses(id),
+    amount REAL NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    description TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO invoices_old (id, business_id, amount, currency, status, description, created_at)
+SELECT id, business_id, amount_cents / CASE currency WHEN 'JPY' THEN 1.0 ELSE 100.0 END, currency, status, description, created_at FROM invoices;
+DROP TABLE invoices;
+ALTER TABLE invoices_old RENAME TO invoices;
+CREATE INDEX idx_invoices_business_id ON invoices(business_id);
+CREATE INDEX idx_invoices_status ON invoices(status, created_at);
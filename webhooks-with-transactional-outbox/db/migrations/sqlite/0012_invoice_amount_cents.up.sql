@@ -0,0 +1,18 @@
+-- Amounts are stored as integer minor units of their currency, cents for
+-- USD and yen for JPY, so they are exact. SQLite can't change the type of a
+-- column, so invoices is rebuilt with amount_cents in place of amount.
+CREATE TABLE invoices_new (
+    id TEXT PRIMARY KEY,
+    business_id TEXT NOT NULL REFERENCES businesses(id),
+    amount_cents INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    description TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO invoices_new (id, business_id, amount_cents, currency, status, description, created_at)
+SELECT id, business_id, CAST(ROUND(amount * CASE currency WHEN 'JPY' THEN 1 ELSE 100 END) AS INTEGER), currency, status, description, created_at FROM invoices;
+DROP TABLE invoices;
+ALTER TABLE invoices_new RENAME TO invoices;
+CREATE INDEX idx_invoices_business_id ON invoices(business_id);
+CREATE INDEX idx_invoices_status ON invoices(status, created_at);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_events_status_id ON events(status, id);
@@ -6,24 +6,86 @@ package db
 
 import (
 	"database/sql"
+	"time"
 )
 
+type Business struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	CreatedAt sql.NullTime `json:"created_at"`
+}
+
+type DeadLetterEvent struct {
+	ID              string         `json:"id"`
+	BusinessID      string         `json:"business_id"`
+	EventType       string         `json:"event_type"`
+	Payload         string         `json:"payload"`
+	CreatedAt       sql.NullTime   `json:"created_at"`
+	Codec           string         `json:"codec"`
+	Queue           string         `json:"queue"`
+	Encrypted       bool           `json:"encrypted"`
+	AggregateID     sql.NullString `json:"aggregate_id"`
+	RetryCount      int64          `json:"retry_count"`
+	LastError       sql.NullString `json:"last_error"`
+	DeadLetteredAt  time.Time      `json:"dead_lettered_at"`
+	PayloadEncoding string         `json:"payload_encoding"`
+	OwnerID         sql.NullString `json:"owner_id"`
+	DeliveryMode    string         `json:"delivery_mode"`
+	IdempotencyKey  sql.NullString `json:"idempotency_key"`
+	Headers         sql.NullString `json:"headers"`
+	Status          string         `json:"status"`
+}
+
 type Event struct {
-	ID          string         `json:"id"`
-	BusinessID  string         `json:"business_id"`
-	EventType   string         `json:"event_type"`
-	Payload     string         `json:"payload"`
-	CreatedAt   sql.NullTime   `json:"created_at"`
-	ProcessedAt sql.NullTime   `json:"processed_at"`
-	Status      sql.NullString `json:"status"`
+	ID              string         `json:"id"`
+	BusinessID      string         `json:"business_id"`
+	EventType       string         `json:"event_type"`
+	Payload         string         `json:"payload"`
+	CreatedAt       sql.NullTime   `json:"created_at"`
+	ProcessedAt     sql.NullTime   `json:"processed_at"`
+	Status          sql.NullString `json:"status"`
+	Codec           string         `json:"codec"`
+	Queue           string         `json:"queue"`
+	Encrypted       bool           `json:"encrypted"`
+	AggregateID     sql.NullString `json:"aggregate_id"`
+	RetryCount      int64          `json:"retry_count"`
+	NextRetryAt     sql.NullTime   `json:"next_retry_at"`
+	LastError       sql.NullString `json:"last_error"`
+	ClaimedAt       sql.NullTime   `json:"claimed_at"`
+	DeliveryID      sql.NullString `json:"delivery_id"`
+	PayloadEncoding string         `json:"payload_encoding"`
+	OwnerID         sql.NullString `json:"owner_id"`
+	DeliveryMode    string         `json:"delivery_mode"`
+	IdempotencyKey  sql.NullString `json:"idempotency_key"`
+	Headers         sql.NullString `json:"headers"`
+	VisibleAt       sql.NullTime   `json:"visible_at"`
+	ExpiresAt       sql.NullTime   `json:"expires_at"`
+	ClaimedBy       sql.NullString `json:"claimed_by"`
+}
+
+type EventAttempt struct {
+	EventID     string         `json:"event_id"`
+	AttemptNo   int64          `json:"attempt_no"`
+	Status      string         `json:"status"`
+	Error       sql.NullString `json:"error"`
+	LatencyMs   int64          `json:"latency_ms"`
+	AttemptedAt time.Time      `json:"attempted_at"`
 }
 
 type Invoice struct {
 	ID          string         `json:"id"`
 	BusinessID  string         `json:"business_id"`
-	Amount      float64        `json:"amount"`
+	AmountCents int64          `json:"amount_cents"`
 	Currency    string         `json:"currency"`
 	Status      string         `json:"status"`
 	Description sql.NullString `json:"description"`
 	CreatedAt   sql.NullTime   `json:"created_at"`
 }
+
+type WorkerCursor struct {
+	WorkerID           string         `json:"worker_id"`
+	LastEventID        sql.NullString `json:"last_event_id"`
+	LastEventCreatedAt sql.NullTime   `json:"last_event_created_at"`
+	EventsProcessed    int64          `json:"events_processed"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Statements runs queries on a connection pool through prepared statements,
+// so a query run over and over, as the worker's are, is parsed and planned
+// once rather than on every call. It does what sqlc's
+// emit_prepared_queries does, but prepares each query the first time it
+// runs, after NewPostgres and WithTables have rewritten it, so it is used
+// as the DBTX underneath them and queries.sql.go stays as sqlc emits it.
+type Statements struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	stmts  map[string]*sql.Stmt
+	closed bool
+}
+
+// Prepare returns Statements preparing the queries run through them on db.
+// Close closes the statements, but not db.
+func Prepare(db *sql.DB) *Statements {
+	return &Statements{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+// inList matches an IN list of placeholders, as sqlc expands a slice
+// argument into, written with ? or rebound to $n
+var inList = regexp.MustCompile(`IN \(((?:\?|\$\d+)(?:,(?:\?|\$\d+))*)\)`)
+
+// padInList pads the IN list of query with copies of its last argument up
+// to the next power of two, so a query run with lists of every length up to
+// a batch size is prepared a handful of times rather than once per length.
+// Repeating a value in an IN list doesn't change what it matches. Only a
+// list holding the last arguments, as the ids of MarkEventsAsProcessed and
+// ReleaseClaimedEvents do, is padded, and any other query is left as is.
+func padInList(query string, args []interface{}) (string, []interface{}) {
+	loc := inList.FindAllStringSubmatchIndex(query, -1)
+	if len(loc) == 0 {
+		return query, args
+	}
+	m := loc[len(loc)-1]
+	if strings.ContainsAny(query[m[1]:], "?$") {
+		return query, args
+	}
+	list := strings.Split(query[m[2]:m[3]], ",")
+	n := len(list)
+	size := 1
+	for size < n {
+		size *= 2
+	}
+	if size == n || len(args) < n {
+		return query, args
+	}
+
+	var b strings.Builder
+	b.WriteString(query[:m[3]])
+	last := list[n-1]
+	next := 0
+	if strings.HasPrefix(last, "$") {
+		next, _ = strconv.Atoi(last[1:])
+		if next != len(args) {
+			return query, args
+		}
+	}
+	padded := append(make([]interface{}, 0, len(args)+size-n), args...)
+	for i := n; i < size; i++ {
+		if next > 0 {
+			next++
+			b.WriteString(",$" + strconv.Itoa(next))
+		} else {
+			b.WriteString(",?")
+		}
+		padded = append(padded, args[len(args)-1])
+	}
+	b.WriteString(query[m[3]:])
+	return b.String(), padded
+}
+
+// stmt returns the statement of query, preparing it the first time
+func (s *Statements) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("prepared statements are closed")
+	}
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (s *Statements) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query, args = padInList(query, args)
+	stmt, err := s.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+func (s *Statements) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return s.db.PrepareContext(ctx, query)
+}
+
+func (s *Statements) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = padInList(query, args)
+	stmt, err := s.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+// QueryRowContext runs a query that fails to prepare unprepared, as a
+// *sql.Row can only carry the error it fails with by being run
+func (s *Statements) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	query, args = padInList(query, args)
+	stmt, err := s.stmt(ctx, query)
+	if err != nil {
+		return s.db.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// Len returns the number of statements prepared so far
+func (s *Statements) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.stmts)
+}
+
+// Close closes every statement prepared
+func (s *Statements) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var errs []error
+	for _, stmt := range s.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.stmts = nil
+	return errors.Join(errs...)
+}
@@ -6,13 +6,53 @@ package db
 
 import (
 	"context"
+	"database/sql"
 )
 
 type Querier interface {
+	BusinessExists(ctx context.Context, id string) (int64, error)
+	ClaimPendingEvents(ctx context.Context, arg ClaimPendingEventsParams) ([]Event, error)
+	CountDeadLetterEvents(ctx context.Context, queue string) (int64, error)
+	CountPendingEvents(ctx context.Context, queue string) (int64, error)
+	CountProcessedEvents(ctx context.Context, queue string) (int64, error)
+	CountProcessedEventsBefore(ctx context.Context, processedAt sql.NullTime) (int64, error)
+	CountProcessingEvents(ctx context.Context, queue string) (int64, error)
+	CreateBusiness(ctx context.Context, arg CreateBusinessParams) error
 	CreateEvent(ctx context.Context, arg CreateEventParams) (Event, error)
+	CreateEventAttempt(ctx context.Context, arg CreateEventAttemptParams) error
 	CreateInvoice(ctx context.Context, arg CreateInvoiceParams) (Invoice, error)
-	GetPendingEvents(ctx context.Context, limit int64) ([]Event, error)
+	DeleteDeadLetterEvent(ctx context.Context, id string) error
+	DeleteEvent(ctx context.Context, id string) error
+	DeleteProcessedEventsBefore(ctx context.Context, arg DeleteProcessedEventsBeforeParams) (int64, error)
+	ExportEvents(ctx context.Context, arg ExportEventsParams) ([]ExportEventsRow, error)
+	GetEventByID(ctx context.Context, id string) (Event, error)
+	GetInvoice(ctx context.Context, id string) (Invoice, error)
+	GetInvoicesByStatus(ctx context.Context, arg GetInvoicesByStatusParams) ([]Invoice, error)
+	GetLastDelivery(ctx context.Context, queue string) (GetLastDeliveryRow, error)
+	GetOldestPendingEventCreatedAt(ctx context.Context, queue string) (sql.NullTime, error)
+	GetPendingEvents(ctx context.Context, arg GetPendingEventsParams) ([]Event, error)
+	GetPendingEventsAfter(ctx context.Context, arg GetPendingEventsAfterParams) ([]Event, error)
+	GetPendingEventsPerBusiness(ctx context.Context, arg GetPendingEventsPerBusinessParams) ([]Event, error)
+	GetPreviousAggregateEvent(ctx context.Context, arg GetPreviousAggregateEventParams) (Event, error)
+	GetProcessedEventsBetween(ctx context.Context, arg GetProcessedEventsBetweenParams) ([]GetProcessedEventsBetweenRow, error)
+	GetWorkerCursor(ctx context.Context, workerID string) (WorkerCursor, error)
+	ImportEvent(ctx context.Context, arg ImportEventParams) (int64, error)
+	IncrementEventRetry(ctx context.Context, arg IncrementEventRetryParams) error
+	ListDeadLetterEvents(ctx context.Context) ([]DeadLetterEvent, error)
+	ListEventAttempts(ctx context.Context, eventID string) ([]EventAttempt, error)
+	ListEvents(ctx context.Context) ([]Event, error)
+	ListInvoices(ctx context.Context, arg ListInvoicesParams) ([]Invoice, error)
+	ListWorkerCursors(ctx context.Context) ([]WorkerCursor, error)
 	MarkEventAsProcessed(ctx context.Context, id string) error
+	MarkEventAsProcessedWithDeliveryID(ctx context.Context, arg MarkEventAsProcessedWithDeliveryIDParams) error
+	MarkEventsAsProcessed(ctx context.Context, ids []string) error
+	MoveEventToDeadLetter(ctx context.Context, arg MoveEventToDeadLetterParams) error
+	RecoverStuckEvents(ctx context.Context, claimedAt sql.NullTime) (int64, error)
+	ReleaseClaimedEvents(ctx context.Context, ids []string) error
+	ReleaseWorkerEvents(ctx context.Context, arg ReleaseWorkerEventsParams) (int64, error)
+	RequeueDeadLetterEvent(ctx context.Context, id string) (int64, error)
+	UpdateInvoiceStatus(ctx context.Context, arg UpdateInvoiceStatusParams) (int64, error)
+	UpsertWorkerCursor(ctx context.Context, arg UpsertWorkerCursorParams) error
 }
 
 var _ Querier = (*Queries)(nil)
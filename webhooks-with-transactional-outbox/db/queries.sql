@@ -1,22 +1,325 @@
 -- name: CreateEvent :one
-INSERT INTO events (business_id, event_type, payload)
-VALUES (?, ?, ?)
-RETURNING id, business_id, event_type, payload, created_at, processed_at, status;
+INSERT INTO events (id, business_id, event_type, payload, codec, queue, encrypted, aggregate_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?)
+RETURNING id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by;
+
+-- name: ClaimPendingEvents :many
+UPDATE events
+SET status = 'processing',
+    claimed_at = CURRENT_TIMESTAMP,
+    claimed_by = ?
+WHERE status = 'pending'
+  AND id IN (
+    SELECT id
+    FROM events
+    WHERE status = 'pending'
+      AND queue = ?
+      AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+      AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+    ORDER BY created_at ASC, rowid ASC
+    LIMIT ?
+  )
+RETURNING id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by;
 
 -- name: CreateInvoice :one
-INSERT INTO invoices (id, business_id, amount, currency, status, description)
+INSERT INTO invoices (id, business_id, amount_cents, currency, status, description)
 VALUES (?, ?, ?, ?, ?, ?)
-RETURNING id, business_id, amount, currency, status, description, created_at;
+RETURNING id, business_id, amount_cents, currency, status, description, created_at;
+
+-- name: CreateBusiness :exec
+INSERT INTO businesses (id, name)
+VALUES (?, ?)
+ON CONFLICT (id) DO UPDATE SET name = excluded.name;
+
+-- name: BusinessExists :one
+SELECT COUNT(*)
+FROM businesses
+WHERE id = ?;
+
+-- name: GetEventByID :one
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE id = ?;
+
+-- name: GetInvoice :one
+SELECT id, business_id, amount_cents, currency, status, description, created_at
+FROM invoices
+WHERE id = ?;
+
+-- name: ListInvoices :many
+SELECT id, business_id, amount_cents, currency, status, description, created_at
+FROM invoices
+WHERE (sqlc.arg(status) = '' OR status = sqlc.arg(status))
+  AND (sqlc.arg(business_id) = '' OR business_id = sqlc.arg(business_id))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- name: GetInvoicesByStatus :many
+SELECT id, business_id, amount_cents, currency, status, description, created_at
+FROM invoices
+WHERE status = ?
+ORDER BY created_at ASC, id ASC
+LIMIT ?;
+
+-- name: UpdateInvoiceStatus :execrows
+UPDATE invoices
+SET status = sqlc.arg(status)
+WHERE id = sqlc.arg(id)
+  AND status = sqlc.arg(from_status);
 
 -- name: GetPendingEvents :many
-SELECT id, business_id, event_type, payload, created_at, processed_at, status 
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
 FROM events
 WHERE status = 'pending'
-ORDER BY created_at ASC
+  AND queue = ?
+  AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+  AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+ORDER BY created_at ASC, rowid ASC
 LIMIT ?;
 
+-- name: GetPendingEventsAfter :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE status = 'pending'
+  AND queue = sqlc.arg(queue)
+  AND (sqlc.arg(after_id) = ''
+    OR (created_at, rowid) > (SELECT after_event.created_at, after_event.rowid FROM events AS after_event WHERE after_event.id = sqlc.arg(after_id)))
+  AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+  AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+ORDER BY created_at ASC, rowid ASC
+LIMIT sqlc.arg(limit);
+
 -- name: MarkEventAsProcessed :exec
 UPDATE events
 SET status = 'processed',
     processed_at = CURRENT_TIMESTAMP
-WHERE id = ?; 
\ No newline at end of file
+WHERE id = ?;
+
+-- name: MarkEventAsProcessedWithDeliveryID :exec
+UPDATE events
+SET status = 'processed',
+    processed_at = CURRENT_TIMESTAMP,
+    delivery_id = ?
+WHERE id = ?;
+
+-- name: MarkEventsAsProcessed :exec
+UPDATE events
+SET status = 'processed',
+    processed_at = CURRENT_TIMESTAMP
+WHERE id IN (sqlc.slice('ids'));
+
+-- name: IncrementEventRetry :exec
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL,
+    retry_count = retry_count + 1,
+    next_retry_at = ?,
+    last_error = ?
+WHERE id = ?;
+
+-- name: ReleaseClaimedEvents :exec
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL
+WHERE status = 'processing'
+  AND id IN (sqlc.slice('ids'));
+
+-- name: RecoverStuckEvents :execrows
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL
+WHERE status = 'processing'
+  AND claimed_at < ?;
+
+-- name: ReleaseWorkerEvents :execrows
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL
+WHERE status = 'processing'
+  AND claimed_by = ?
+  AND queue = ?;
+
+-- name: MoveEventToDeadLetter :exec
+INSERT INTO dead_letter_events (id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, retry_count, last_error, dead_lettered_at, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, status)
+SELECT id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, retry_count + 1, ?, CURRENT_TIMESTAMP, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, ?
+FROM events
+WHERE id = ?;
+
+-- name: DeleteEvent :exec
+DELETE FROM events
+WHERE id = ?;
+
+-- name: UpsertWorkerCursor :exec
+INSERT INTO worker_cursors (worker_id, last_event_id, last_event_created_at, events_processed, updated_at)
+VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT (worker_id) DO UPDATE
+SET last_event_id = excluded.last_event_id,
+    last_event_created_at = excluded.last_event_created_at,
+    events_processed = worker_cursors.events_processed + excluded.events_processed,
+    updated_at = CURRENT_TIMESTAMP;
+
+-- name: GetWorkerCursor :one
+SELECT worker_id, last_event_id, last_event_created_at, events_processed, updated_at
+FROM worker_cursors
+WHERE worker_id = ?;
+
+-- name: ListWorkerCursors :many
+SELECT worker_id, last_event_id, last_event_created_at, events_processed, updated_at
+FROM worker_cursors
+ORDER BY worker_id ASC;
+
+-- name: GetPendingEventsPerBusiness :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE status = 'pending'
+  AND queue = sqlc.arg(queue)
+  AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+  AND (
+    SELECT COUNT(*)
+    FROM events AS earlier
+    WHERE earlier.business_id = events.business_id
+      AND earlier.queue = events.queue
+      AND earlier.status = 'pending'
+      AND (earlier.visible_at IS NULL OR earlier.visible_at <= CURRENT_TIMESTAMP)
+      AND (earlier.created_at < events.created_at
+        OR (earlier.created_at = events.created_at AND earlier.rowid < events.rowid))
+  ) < sqlc.arg(per_business_limit)
+  AND NOT EXISTS (
+    SELECT 1
+    FROM events AS waiting
+    WHERE waiting.business_id = events.business_id
+      AND waiting.queue = events.queue
+      AND waiting.status = 'pending'
+      AND waiting.next_retry_at > CURRENT_TIMESTAMP
+      AND (waiting.created_at < events.created_at
+        OR (waiting.created_at = events.created_at AND waiting.rowid <= events.rowid))
+  )
+ORDER BY created_at ASC, rowid ASC
+LIMIT sqlc.arg(batch_limit);
+
+-- name: CountPendingEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'pending'
+  AND queue = ?;
+
+-- name: CountProcessingEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'processing'
+  AND queue = ?;
+
+-- name: CountProcessedEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'processed'
+  AND queue = ?;
+
+-- name: CountDeadLetterEvents :one
+SELECT COUNT(*)
+FROM dead_letter_events
+WHERE queue = ?;
+
+-- name: GetLastDelivery :one
+SELECT id, delivery_id, processed_at
+FROM events
+WHERE queue = ?
+  AND delivery_id IS NOT NULL
+ORDER BY processed_at DESC
+LIMIT 1;
+
+-- name: GetOldestPendingEventCreatedAt :one
+SELECT created_at
+FROM events
+WHERE status = 'pending'
+  AND queue = ?
+ORDER BY created_at ASC
+LIMIT 1;
+
+-- name: ListEvents :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+ORDER BY created_at ASC;
+
+-- name: ExportEvents :many
+SELECT rowid, id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE rowid > sqlc.arg(after_rowid)
+  AND (sqlc.arg(status) = '' OR status = sqlc.arg(status))
+  AND (sqlc.arg(business_id) = '' OR business_id = sqlc.arg(business_id))
+  AND created_at >= sqlc.arg(created_from)
+  AND created_at < sqlc.arg(created_until)
+ORDER BY rowid ASC
+LIMIT sqlc.arg(batch_limit);
+
+-- name: ImportEvent :execrows
+INSERT INTO events (id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: GetPreviousAggregateEvent :one
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE aggregate_id = ?
+  AND rowid < (SELECT rowid FROM events AS current WHERE current.id = ?)
+ORDER BY rowid DESC
+LIMIT 1;
+
+-- name: ListDeadLetterEvents :many
+SELECT id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, retry_count, last_error, dead_lettered_at, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, status
+FROM dead_letter_events
+ORDER BY dead_lettered_at ASC;
+
+-- name: RequeueDeadLetterEvent :execrows
+INSERT INTO events (id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers)
+SELECT id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers
+FROM dead_letter_events
+WHERE id = ?;
+
+-- name: DeleteDeadLetterEvent :exec
+DELETE FROM dead_letter_events
+WHERE id = ?;
+
+-- name: DeleteProcessedEventsBefore :execrows
+DELETE FROM events
+WHERE id IN (
+  SELECT id
+  FROM events
+  WHERE status = 'processed'
+    AND processed_at < sqlc.arg(cutoff)
+  LIMIT sqlc.arg(batch_size)
+);
+
+-- name: CountProcessedEventsBefore :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'processed'
+  AND processed_at < ?;
+
+-- name: GetProcessedEventsBetween :many
+SELECT id, business_id, event_type, delivery_id, processed_at
+FROM events
+WHERE status = 'processed'
+  AND processed_at >= sqlc.arg(window_start)
+  AND processed_at < sqlc.arg(window_end)
+ORDER BY processed_at ASC;
+
+-- name: CreateEventAttempt :exec
+INSERT INTO event_attempts (event_id, attempt_no, status, error, latency_ms)
+VALUES (
+  sqlc.arg(event_id),
+  (SELECT COALESCE(MAX(attempt_no), 0) + 1 FROM event_attempts WHERE event_id = sqlc.arg(event_id)),
+  sqlc.arg(status),
+  sqlc.arg(error),
+  sqlc.arg(latency_ms)
+);
+
+-- name: ListEventAttempts :many
+SELECT event_id, attempt_no, status, error, latency_ms, attempted_at
+FROM event_attempts
+WHERE event_id = ?
+ORDER BY attempt_no ASC;
@@ -8,22 +8,222 @@ package db
 import (
 	"context"
 	"database/sql"
+	"strings"
 )
 
+const businessExists = `-- name: BusinessExists :one
+SELECT COUNT(*)
+FROM businesses
+WHERE id = ?
+`
+
+func (q *Queries) BusinessExists(ctx context.Context, id string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, businessExists, id)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const claimPendingEvents = `-- name: ClaimPendingEvents :many
+UPDATE events
+SET status = 'processing',
+    claimed_at = CURRENT_TIMESTAMP,
+    claimed_by = ?
+WHERE status = 'pending'
+  AND id IN (
+    SELECT id
+    FROM events
+    WHERE status = 'pending'
+      AND queue = ?
+      AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+      AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+    ORDER BY created_at ASC, rowid ASC
+    LIMIT ?
+  )
+RETURNING id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+`
+
+type ClaimPendingEventsParams struct {
+	ClaimedBy sql.NullString `json:"claimed_by"`
+	Queue     string         `json:"queue"`
+	Limit     int64          `json:"limit"`
+}
+
+func (q *Queries) ClaimPendingEvents(ctx context.Context, arg ClaimPendingEventsParams) ([]Event, error) {
+	rows, err := q.db.QueryContext(ctx, claimPendingEvents, arg.ClaimedBy, arg.Queue, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Event{}
+	for rows.Next() {
+		var i Event
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+			&i.Status,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ClaimedAt,
+			&i.DeliveryID,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.VisibleAt,
+			&i.ExpiresAt,
+			&i.ClaimedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countDeadLetterEvents = `-- name: CountDeadLetterEvents :one
+SELECT COUNT(*)
+FROM dead_letter_events
+WHERE queue = ?
+`
+
+func (q *Queries) CountDeadLetterEvents(ctx context.Context, queue string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countDeadLetterEvents, queue)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPendingEvents = `-- name: CountPendingEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'pending'
+  AND queue = ?
+`
+
+func (q *Queries) CountPendingEvents(ctx context.Context, queue string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countPendingEvents, queue)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countProcessedEvents = `-- name: CountProcessedEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'processed'
+  AND queue = ?
+`
+
+func (q *Queries) CountProcessedEvents(ctx context.Context, queue string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countProcessedEvents, queue)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countProcessedEventsBefore = `-- name: CountProcessedEventsBefore :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'processed'
+  AND processed_at < ?
+`
+
+func (q *Queries) CountProcessedEventsBefore(ctx context.Context, processedAt sql.NullTime) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countProcessedEventsBefore, processedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countProcessingEvents = `-- name: CountProcessingEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE status = 'processing'
+  AND queue = ?
+`
+
+func (q *Queries) CountProcessingEvents(ctx context.Context, queue string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countProcessingEvents, queue)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createBusiness = `-- name: CreateBusiness :exec
+INSERT INTO businesses (id, name)
+VALUES (?, ?)
+ON CONFLICT (id) DO UPDATE SET name = excluded.name
+`
+
+type CreateBusinessParams struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (q *Queries) CreateBusiness(ctx context.Context, arg CreateBusinessParams) error {
+	_, err := q.db.ExecContext(ctx, createBusiness, arg.ID, arg.Name)
+	return err
+}
+
 const createEvent = `-- name: CreateEvent :one
-INSERT INTO events (business_id, event_type, payload)
-VALUES (?, ?, ?)
-RETURNING id, business_id, event_type, payload, created_at, processed_at, status
+INSERT INTO events (id, business_id, event_type, payload, codec, queue, encrypted, aggregate_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?)
+RETURNING id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
 `
 
 type CreateEventParams struct {
-	BusinessID string `json:"business_id"`
-	EventType  string `json:"event_type"`
-	Payload    string `json:"payload"`
+	ID              string         `json:"id"`
+	BusinessID      string         `json:"business_id"`
+	EventType       string         `json:"event_type"`
+	Payload         string         `json:"payload"`
+	Codec           string         `json:"codec"`
+	Queue           string         `json:"queue"`
+	Encrypted       bool           `json:"encrypted"`
+	AggregateID     sql.NullString `json:"aggregate_id"`
+	PayloadEncoding string         `json:"payload_encoding"`
+	OwnerID         sql.NullString `json:"owner_id"`
+	DeliveryMode    string         `json:"delivery_mode"`
+	IdempotencyKey  sql.NullString `json:"idempotency_key"`
+	Headers         sql.NullString `json:"headers"`
+	VisibleAt       sql.NullTime   `json:"visible_at"`
+	ExpiresAt       sql.NullTime   `json:"expires_at"`
 }
 
 func (q *Queries) CreateEvent(ctx context.Context, arg CreateEventParams) (Event, error) {
-	row := q.db.QueryRowContext(ctx, createEvent, arg.BusinessID, arg.EventType, arg.Payload)
+	row := q.db.QueryRowContext(ctx, createEvent,
+		arg.ID,
+		arg.BusinessID,
+		arg.EventType,
+		arg.Payload,
+		arg.Codec,
+		arg.Queue,
+		arg.Encrypted,
+		arg.AggregateID,
+		arg.PayloadEncoding,
+		arg.OwnerID,
+		arg.DeliveryMode,
+		arg.IdempotencyKey,
+		arg.Headers,
+		arg.VisibleAt,
+		arg.ExpiresAt,
+	)
 	var i Event
 	err := row.Scan(
 		&i.ID,
@@ -33,20 +233,66 @@ func (q *Queries) CreateEvent(ctx context.Context, arg CreateEventParams) (Event
 		&i.CreatedAt,
 		&i.ProcessedAt,
 		&i.Status,
+		&i.Codec,
+		&i.Queue,
+		&i.Encrypted,
+		&i.AggregateID,
+		&i.RetryCount,
+		&i.NextRetryAt,
+		&i.LastError,
+		&i.ClaimedAt,
+		&i.DeliveryID,
+		&i.PayloadEncoding,
+		&i.OwnerID,
+		&i.DeliveryMode,
+		&i.IdempotencyKey,
+		&i.Headers,
+		&i.VisibleAt,
+		&i.ExpiresAt,
+		&i.ClaimedBy,
 	)
 	return i, err
 }
 
+const createEventAttempt = `-- name: CreateEventAttempt :exec
+INSERT INTO event_attempts (event_id, attempt_no, status, error, latency_ms)
+VALUES (
+  ?,
+  (SELECT COALESCE(MAX(attempt_no), 0) + 1 FROM event_attempts WHERE event_id = ?),
+  ?,
+  ?,
+  ?
+)
+`
+
+type CreateEventAttemptParams struct {
+	EventID   string         `json:"event_id"`
+	Status    string         `json:"status"`
+	Error     sql.NullString `json:"error"`
+	LatencyMs int64          `json:"latency_ms"`
+}
+
+func (q *Queries) CreateEventAttempt(ctx context.Context, arg CreateEventAttemptParams) error {
+	_, err := q.db.ExecContext(ctx, createEventAttempt,
+		arg.EventID,
+		arg.EventID,
+		arg.Status,
+		arg.Error,
+		arg.LatencyMs,
+	)
+	return err
+}
+
 const createInvoice = `-- name: CreateInvoice :one
-INSERT INTO invoices (id, business_id, amount, currency, status, description)
+INSERT INTO invoices (id, business_id, amount_cents, currency, status, description)
 VALUES (?, ?, ?, ?, ?, ?)
-RETURNING id, business_id, amount, currency, status, description, created_at
+RETURNING id, business_id, amount_cents, currency, status, description, created_at
 `
 
 type CreateInvoiceParams struct {
 	ID          string         `json:"id"`
 	BusinessID  string         `json:"business_id"`
-	Amount      float64        `json:"amount"`
+	AmountCents int64          `json:"amount_cents"`
 	Currency    string         `json:"currency"`
 	Status      string         `json:"status"`
 	Description sql.NullString `json:"description"`
@@ -56,7 +302,7 @@ func (q *Queries) CreateInvoice(ctx context.Context, arg CreateInvoiceParams) (I
 	row := q.db.QueryRowContext(ctx, createInvoice,
 		arg.ID,
 		arg.BusinessID,
-		arg.Amount,
+		arg.AmountCents,
 		arg.Currency,
 		arg.Status,
 		arg.Description,
@@ -65,7 +311,7 @@ func (q *Queries) CreateInvoice(ctx context.Context, arg CreateInvoiceParams) (I
 	err := row.Scan(
 		&i.ID,
 		&i.BusinessID,
-		&i.Amount,
+		&i.AmountCents,
 		&i.Currency,
 		&i.Status,
 		&i.Description,
@@ -74,16 +320,316 @@ func (q *Queries) CreateInvoice(ctx context.Context, arg CreateInvoiceParams) (I
 	return i, err
 }
 
-const getPendingEvents = `-- name: GetPendingEvents :many
-SELECT id, business_id, event_type, payload, created_at, processed_at, status 
+const deleteDeadLetterEvent = `-- name: DeleteDeadLetterEvent :exec
+DELETE FROM dead_letter_events
+WHERE id = ?
+`
+
+func (q *Queries) DeleteDeadLetterEvent(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, deleteDeadLetterEvent, id)
+	return err
+}
+
+const deleteEvent = `-- name: DeleteEvent :exec
+DELETE FROM events
+WHERE id = ?
+`
+
+func (q *Queries) DeleteEvent(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, deleteEvent, id)
+	return err
+}
+
+const deleteProcessedEventsBefore = `-- name: DeleteProcessedEventsBefore :execrows
+DELETE FROM events
+WHERE id IN (
+  SELECT id
+  FROM events
+  WHERE status = 'processed'
+    AND processed_at < ?
+  LIMIT ?
+)
+`
+
+type DeleteProcessedEventsBeforeParams struct {
+	Cutoff    sql.NullTime `json:"cutoff"`
+	BatchSize int64        `json:"batch_size"`
+}
+
+func (q *Queries) DeleteProcessedEventsBefore(ctx context.Context, arg DeleteProcessedEventsBeforeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteProcessedEventsBefore, arg.Cutoff, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const exportEvents = `-- name: ExportEvents :many
+SELECT rowid, id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE rowid > ?
+  AND (? = '' OR status = ?)
+  AND (? = '' OR business_id = ?)
+  AND created_at >= ?
+  AND created_at < ?
+ORDER BY rowid ASC
+LIMIT ?
+`
+
+type ExportEventsParams struct {
+	AfterRowid   int64        `json:"after_rowid"`
+	Status       string       `json:"status"`
+	BusinessID   string       `json:"business_id"`
+	CreatedFrom  sql.NullTime `json:"created_from"`
+	CreatedUntil sql.NullTime `json:"created_until"`
+	BatchLimit   int64        `json:"batch_limit"`
+}
+
+type ExportEventsRow struct {
+	Rowid           int64          `json:"rowid"`
+	ID              string         `json:"id"`
+	BusinessID      string         `json:"business_id"`
+	EventType       string         `json:"event_type"`
+	Payload         string         `json:"payload"`
+	CreatedAt       sql.NullTime   `json:"created_at"`
+	ProcessedAt     sql.NullTime   `json:"processed_at"`
+	Status          sql.NullString `json:"status"`
+	Codec           string         `json:"codec"`
+	Queue           string         `json:"queue"`
+	Encrypted       bool           `json:"encrypted"`
+	AggregateID     sql.NullString `json:"aggregate_id"`
+	RetryCount      int64          `json:"retry_count"`
+	NextRetryAt     sql.NullTime   `json:"next_retry_at"`
+	LastError       sql.NullString `json:"last_error"`
+	ClaimedAt       sql.NullTime   `json:"claimed_at"`
+	DeliveryID      sql.NullString `json:"delivery_id"`
+	PayloadEncoding string         `json:"payload_encoding"`
+	OwnerID         sql.NullString `json:"owner_id"`
+	DeliveryMode    string         `json:"delivery_mode"`
+	IdempotencyKey  sql.NullString `json:"idempotency_key"`
+	Headers         sql.NullString `json:"headers"`
+	VisibleAt       sql.NullTime   `json:"visible_at"`
+	ExpiresAt       sql.NullTime   `json:"expires_at"`
+	ClaimedBy       sql.NullString `json:"claimed_by"`
+}
+
+func (q *Queries) ExportEvents(ctx context.Context, arg ExportEventsParams) ([]ExportEventsRow, error) {
+	rows, err := q.db.QueryContext(ctx, exportEvents,
+		arg.AfterRowid,
+		arg.Status,
+		arg.Status,
+		arg.BusinessID,
+		arg.BusinessID,
+		arg.CreatedFrom,
+		arg.CreatedUntil,
+		arg.BatchLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ExportEventsRow{}
+	for rows.Next() {
+		var i ExportEventsRow
+		if err := rows.Scan(
+			&i.Rowid,
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+			&i.Status,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ClaimedAt,
+			&i.DeliveryID,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.VisibleAt,
+			&i.ExpiresAt,
+			&i.ClaimedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEventByID = `-- name: GetEventByID :one
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE id = ?
+`
+
+func (q *Queries) GetEventByID(ctx context.Context, id string) (Event, error) {
+	row := q.db.QueryRowContext(ctx, getEventByID, id)
+	var i Event
+	err := row.Scan(
+		&i.ID,
+		&i.BusinessID,
+		&i.EventType,
+		&i.Payload,
+		&i.CreatedAt,
+		&i.ProcessedAt,
+		&i.Status,
+		&i.Codec,
+		&i.Queue,
+		&i.Encrypted,
+		&i.AggregateID,
+		&i.RetryCount,
+		&i.NextRetryAt,
+		&i.LastError,
+		&i.ClaimedAt,
+		&i.DeliveryID,
+		&i.PayloadEncoding,
+		&i.OwnerID,
+		&i.DeliveryMode,
+		&i.IdempotencyKey,
+		&i.Headers,
+		&i.VisibleAt,
+		&i.ExpiresAt,
+		&i.ClaimedBy,
+	)
+	return i, err
+}
+
+const getInvoice = `-- name: GetInvoice :one
+SELECT id, business_id, amount_cents, currency, status, description, created_at
+FROM invoices
+WHERE id = ?
+`
+
+func (q *Queries) GetInvoice(ctx context.Context, id string) (Invoice, error) {
+	row := q.db.QueryRowContext(ctx, getInvoice, id)
+	var i Invoice
+	err := row.Scan(
+		&i.ID,
+		&i.BusinessID,
+		&i.AmountCents,
+		&i.Currency,
+		&i.Status,
+		&i.Description,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getInvoicesByStatus = `-- name: GetInvoicesByStatus :many
+SELECT id, business_id, amount_cents, currency, status, description, created_at
+FROM invoices
+WHERE status = ?
+ORDER BY created_at ASC, id ASC
+LIMIT ?
+`
+
+type GetInvoicesByStatusParams struct {
+	Status string `json:"status"`
+	Limit  int64  `json:"limit"`
+}
+
+func (q *Queries) GetInvoicesByStatus(ctx context.Context, arg GetInvoicesByStatusParams) ([]Invoice, error) {
+	rows, err := q.db.QueryContext(ctx, getInvoicesByStatus, arg.Status, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Invoice{}
+	for rows.Next() {
+		var i Invoice
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.AmountCents,
+			&i.Currency,
+			&i.Status,
+			&i.Description,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLastDelivery = `-- name: GetLastDelivery :one
+SELECT id, delivery_id, processed_at
+FROM events
+WHERE queue = ?
+  AND delivery_id IS NOT NULL
+ORDER BY processed_at DESC
+LIMIT 1
+`
+
+type GetLastDeliveryRow struct {
+	ID          string         `json:"id"`
+	DeliveryID  sql.NullString `json:"delivery_id"`
+	ProcessedAt sql.NullTime   `json:"processed_at"`
+}
+
+func (q *Queries) GetLastDelivery(ctx context.Context, queue string) (GetLastDeliveryRow, error) {
+	row := q.db.QueryRowContext(ctx, getLastDelivery, queue)
+	var i GetLastDeliveryRow
+	err := row.Scan(&i.ID, &i.DeliveryID, &i.ProcessedAt)
+	return i, err
+}
+
+const getOldestPendingEventCreatedAt = `-- name: GetOldestPendingEventCreatedAt :one
+SELECT created_at
 FROM events
 WHERE status = 'pending'
+  AND queue = ?
 ORDER BY created_at ASC
+LIMIT 1
+`
+
+func (q *Queries) GetOldestPendingEventCreatedAt(ctx context.Context, queue string) (sql.NullTime, error) {
+	row := q.db.QueryRowContext(ctx, getOldestPendingEventCreatedAt, queue)
+	var created_at sql.NullTime
+	err := row.Scan(&created_at)
+	return created_at, err
+}
+
+const getPendingEvents = `-- name: GetPendingEvents :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE status = 'pending'
+  AND queue = ?
+  AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+  AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+ORDER BY created_at ASC, rowid ASC
 LIMIT ?
 `
 
-func (q *Queries) GetPendingEvents(ctx context.Context, limit int64) ([]Event, error) {
-	rows, err := q.db.QueryContext(ctx, getPendingEvents, limit)
+type GetPendingEventsParams struct {
+	Queue string `json:"queue"`
+	Limit int64  `json:"limit"`
+}
+
+func (q *Queries) GetPendingEvents(ctx context.Context, arg GetPendingEventsParams) ([]Event, error) {
+	rows, err := q.db.QueryContext(ctx, getPendingEvents, arg.Queue, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -99,6 +645,23 @@ func (q *Queries) GetPendingEvents(ctx context.Context, limit int64) ([]Event, e
 			&i.CreatedAt,
 			&i.ProcessedAt,
 			&i.Status,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ClaimedAt,
+			&i.DeliveryID,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.VisibleAt,
+			&i.ExpiresAt,
+			&i.ClaimedBy,
 		); err != nil {
 			return nil, err
 		}
@@ -113,14 +676,787 @@ func (q *Queries) GetPendingEvents(ctx context.Context, limit int64) ([]Event, e
 	return items, nil
 }
 
-const markEventAsProcessed = `-- name: MarkEventAsProcessed :exec
-UPDATE events
-SET status = 'processed',
-    processed_at = CURRENT_TIMESTAMP
-WHERE id = ?
+const getPendingEventsAfter = `-- name: GetPendingEventsAfter :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE status = 'pending'
+  AND queue = ?
+  AND (? = ''
+    OR (created_at, rowid) > (SELECT after_event.created_at, after_event.rowid FROM events AS after_event WHERE after_event.id = ?))
+  AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+  AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+ORDER BY created_at ASC, rowid ASC
+LIMIT ?
 `
 
-func (q *Queries) MarkEventAsProcessed(ctx context.Context, id string) error {
-	_, err := q.db.ExecContext(ctx, markEventAsProcessed, id)
+type GetPendingEventsAfterParams struct {
+	Queue   string `json:"queue"`
+	AfterID string `json:"after_id"`
+	Limit   int64  `json:"limit"`
+}
+
+func (q *Queries) GetPendingEventsAfter(ctx context.Context, arg GetPendingEventsAfterParams) ([]Event, error) {
+	rows, err := q.db.QueryContext(ctx, getPendingEventsAfter,
+		arg.Queue,
+		arg.AfterID,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Event{}
+	for rows.Next() {
+		var i Event
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+			&i.Status,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ClaimedAt,
+			&i.DeliveryID,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.VisibleAt,
+			&i.ExpiresAt,
+			&i.ClaimedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPendingEventsPerBusiness = `-- name: GetPendingEventsPerBusiness :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE status = 'pending'
+  AND queue = ?
+  AND (visible_at IS NULL OR visible_at <= CURRENT_TIMESTAMP)
+  AND (
+    SELECT COUNT(*)
+    FROM events AS earlier
+    WHERE earlier.business_id = events.business_id
+      AND earlier.queue = events.queue
+      AND earlier.status = 'pending'
+      AND (earlier.visible_at IS NULL OR earlier.visible_at <= CURRENT_TIMESTAMP)
+      AND (earlier.created_at < events.created_at
+        OR (earlier.created_at = events.created_at AND earlier.rowid < events.rowid))
+  ) < ?
+  AND NOT EXISTS (
+    SELECT 1
+    FROM events AS waiting
+    WHERE waiting.business_id = events.business_id
+      AND waiting.queue = events.queue
+      AND waiting.status = 'pending'
+      AND waiting.next_retry_at > CURRENT_TIMESTAMP
+      AND (waiting.created_at < events.created_at
+        OR (waiting.created_at = events.created_at AND waiting.rowid <= events.rowid))
+  )
+ORDER BY created_at ASC, rowid ASC
+LIMIT ?
+`
+
+type GetPendingEventsPerBusinessParams struct {
+	Queue            string `json:"queue"`
+	PerBusinessLimit int64  `json:"per_business_limit"`
+	BatchLimit       int64  `json:"batch_limit"`
+}
+
+func (q *Queries) GetPendingEventsPerBusiness(ctx context.Context, arg GetPendingEventsPerBusinessParams) ([]Event, error) {
+	rows, err := q.db.QueryContext(ctx, getPendingEventsPerBusiness, arg.Queue, arg.PerBusinessLimit, arg.BatchLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Event{}
+	for rows.Next() {
+		var i Event
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+			&i.Status,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ClaimedAt,
+			&i.DeliveryID,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.VisibleAt,
+			&i.ExpiresAt,
+			&i.ClaimedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPreviousAggregateEvent = `-- name: GetPreviousAggregateEvent :one
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+WHERE aggregate_id = ?
+  AND rowid < (SELECT rowid FROM events AS current WHERE current.id = ?)
+ORDER BY rowid DESC
+LIMIT 1
+`
+
+type GetPreviousAggregateEventParams struct {
+	AggregateID sql.NullString `json:"aggregate_id"`
+	ID          string         `json:"id"`
+}
+
+func (q *Queries) GetPreviousAggregateEvent(ctx context.Context, arg GetPreviousAggregateEventParams) (Event, error) {
+	row := q.db.QueryRowContext(ctx, getPreviousAggregateEvent, arg.AggregateID, arg.ID)
+	var i Event
+	err := row.Scan(
+		&i.ID,
+		&i.BusinessID,
+		&i.EventType,
+		&i.Payload,
+		&i.CreatedAt,
+		&i.ProcessedAt,
+		&i.Status,
+		&i.Codec,
+		&i.Queue,
+		&i.Encrypted,
+		&i.AggregateID,
+		&i.RetryCount,
+		&i.NextRetryAt,
+		&i.LastError,
+		&i.ClaimedAt,
+		&i.DeliveryID,
+		&i.PayloadEncoding,
+		&i.OwnerID,
+		&i.DeliveryMode,
+		&i.IdempotencyKey,
+		&i.Headers,
+		&i.VisibleAt,
+		&i.ExpiresAt,
+		&i.ClaimedBy,
+	)
+	return i, err
+}
+
+const getProcessedEventsBetween = `-- name: GetProcessedEventsBetween :many
+SELECT id, business_id, event_type, delivery_id, processed_at
+FROM events
+WHERE status = 'processed'
+  AND processed_at >= ?
+  AND processed_at < ?
+ORDER BY processed_at ASC
+`
+
+type GetProcessedEventsBetweenParams struct {
+	WindowStart sql.NullTime `json:"window_start"`
+	WindowEnd   sql.NullTime `json:"window_end"`
+}
+
+type GetProcessedEventsBetweenRow struct {
+	ID          string         `json:"id"`
+	BusinessID  string         `json:"business_id"`
+	EventType   string         `json:"event_type"`
+	DeliveryID  sql.NullString `json:"delivery_id"`
+	ProcessedAt sql.NullTime   `json:"processed_at"`
+}
+
+func (q *Queries) GetProcessedEventsBetween(ctx context.Context, arg GetProcessedEventsBetweenParams) ([]GetProcessedEventsBetweenRow, error) {
+	rows, err := q.db.QueryContext(ctx, getProcessedEventsBetween, arg.WindowStart, arg.WindowEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetProcessedEventsBetweenRow{}
+	for rows.Next() {
+		var i GetProcessedEventsBetweenRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.DeliveryID,
+			&i.ProcessedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkerCursor = `-- name: GetWorkerCursor :one
+SELECT worker_id, last_event_id, last_event_created_at, events_processed, updated_at
+FROM worker_cursors
+WHERE worker_id = ?
+`
+
+func (q *Queries) GetWorkerCursor(ctx context.Context, workerID string) (WorkerCursor, error) {
+	row := q.db.QueryRowContext(ctx, getWorkerCursor, workerID)
+	var i WorkerCursor
+	err := row.Scan(
+		&i.WorkerID,
+		&i.LastEventID,
+		&i.LastEventCreatedAt,
+		&i.EventsProcessed,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const importEvent = `-- name: ImportEvent :execrows
+INSERT INTO events (id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO NOTHING
+`
+
+type ImportEventParams struct {
+	ID              string         `json:"id"`
+	BusinessID      string         `json:"business_id"`
+	EventType       string         `json:"event_type"`
+	Payload         string         `json:"payload"`
+	CreatedAt       sql.NullTime   `json:"created_at"`
+	ProcessedAt     sql.NullTime   `json:"processed_at"`
+	Status          sql.NullString `json:"status"`
+	Codec           string         `json:"codec"`
+	Queue           string         `json:"queue"`
+	Encrypted       bool           `json:"encrypted"`
+	AggregateID     sql.NullString `json:"aggregate_id"`
+	RetryCount      int64          `json:"retry_count"`
+	NextRetryAt     sql.NullTime   `json:"next_retry_at"`
+	LastError       sql.NullString `json:"last_error"`
+	ClaimedAt       sql.NullTime   `json:"claimed_at"`
+	DeliveryID      sql.NullString `json:"delivery_id"`
+	PayloadEncoding string         `json:"payload_encoding"`
+	OwnerID         sql.NullString `json:"owner_id"`
+	DeliveryMode    string         `json:"delivery_mode"`
+	IdempotencyKey  sql.NullString `json:"idempotency_key"`
+	Headers         sql.NullString `json:"headers"`
+	VisibleAt       sql.NullTime   `json:"visible_at"`
+	ExpiresAt       sql.NullTime   `json:"expires_at"`
+	ClaimedBy       sql.NullString `json:"claimed_by"`
+}
+
+func (q *Queries) ImportEvent(ctx context.Context, arg ImportEventParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, importEvent,
+		arg.ID,
+		arg.BusinessID,
+		arg.EventType,
+		arg.Payload,
+		arg.CreatedAt,
+		arg.ProcessedAt,
+		arg.Status,
+		arg.Codec,
+		arg.Queue,
+		arg.Encrypted,
+		arg.AggregateID,
+		arg.RetryCount,
+		arg.NextRetryAt,
+		arg.LastError,
+		arg.ClaimedAt,
+		arg.DeliveryID,
+		arg.PayloadEncoding,
+		arg.OwnerID,
+		arg.DeliveryMode,
+		arg.IdempotencyKey,
+		arg.Headers,
+		arg.VisibleAt,
+		arg.ExpiresAt,
+		arg.ClaimedBy,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const incrementEventRetry = `-- name: IncrementEventRetry :exec
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL,
+    retry_count = retry_count + 1,
+    next_retry_at = ?,
+    last_error = ?
+WHERE id = ?
+`
+
+type IncrementEventRetryParams struct {
+	NextRetryAt sql.NullTime   `json:"next_retry_at"`
+	LastError   sql.NullString `json:"last_error"`
+	ID          string         `json:"id"`
+}
+
+func (q *Queries) IncrementEventRetry(ctx context.Context, arg IncrementEventRetryParams) error {
+	_, err := q.db.ExecContext(ctx, incrementEventRetry, arg.NextRetryAt, arg.LastError, arg.ID)
+	return err
+}
+
+const listDeadLetterEvents = `-- name: ListDeadLetterEvents :many
+SELECT id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, retry_count, last_error, dead_lettered_at, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, status
+FROM dead_letter_events
+ORDER BY dead_lettered_at ASC
+`
+
+func (q *Queries) ListDeadLetterEvents(ctx context.Context) ([]DeadLetterEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listDeadLetterEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []DeadLetterEvent{}
+	for rows.Next() {
+		var i DeadLetterEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.LastError,
+			&i.DeadLetteredAt,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEventAttempts = `-- name: ListEventAttempts :many
+SELECT event_id, attempt_no, status, error, latency_ms, attempted_at
+FROM event_attempts
+WHERE event_id = ?
+ORDER BY attempt_no ASC
+`
+
+func (q *Queries) ListEventAttempts(ctx context.Context, eventID string) ([]EventAttempt, error) {
+	rows, err := q.db.QueryContext(ctx, listEventAttempts, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []EventAttempt{}
+	for rows.Next() {
+		var i EventAttempt
+		if err := rows.Scan(
+			&i.EventID,
+			&i.AttemptNo,
+			&i.Status,
+			&i.Error,
+			&i.LatencyMs,
+			&i.AttemptedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEvents = `-- name: ListEvents :many
+SELECT id, business_id, event_type, payload, created_at, processed_at, status, codec, queue, encrypted, aggregate_id, retry_count, next_retry_at, last_error, claimed_at, delivery_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, visible_at, expires_at, claimed_by
+FROM events
+ORDER BY created_at ASC
+`
+
+func (q *Queries) ListEvents(ctx context.Context) ([]Event, error) {
+	rows, err := q.db.QueryContext(ctx, listEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Event{}
+	for rows.Next() {
+		var i Event
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+			&i.Status,
+			&i.Codec,
+			&i.Queue,
+			&i.Encrypted,
+			&i.AggregateID,
+			&i.RetryCount,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ClaimedAt,
+			&i.DeliveryID,
+			&i.PayloadEncoding,
+			&i.OwnerID,
+			&i.DeliveryMode,
+			&i.IdempotencyKey,
+			&i.Headers,
+			&i.VisibleAt,
+			&i.ExpiresAt,
+			&i.ClaimedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInvoices = `-- name: ListInvoices :many
+SELECT id, business_id, amount_cents, currency, status, description, created_at
+FROM invoices
+WHERE (? = '' OR status = ?)
+  AND (? = '' OR business_id = ?)
+ORDER BY created_at DESC, id DESC
+LIMIT ? OFFSET ?
+`
+
+type ListInvoicesParams struct {
+	Status     string `json:"status"`
+	BusinessID string `json:"business_id"`
+	RowLimit   int64  `json:"row_limit"`
+	RowOffset  int64  `json:"row_offset"`
+}
+
+func (q *Queries) ListInvoices(ctx context.Context, arg ListInvoicesParams) ([]Invoice, error) {
+	rows, err := q.db.QueryContext(ctx, listInvoices,
+		arg.Status,
+		arg.Status,
+		arg.BusinessID,
+		arg.BusinessID,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Invoice{}
+	for rows.Next() {
+		var i Invoice
+		if err := rows.Scan(
+			&i.ID,
+			&i.BusinessID,
+			&i.AmountCents,
+			&i.Currency,
+			&i.Status,
+			&i.Description,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWorkerCursors = `-- name: ListWorkerCursors :many
+SELECT worker_id, last_event_id, last_event_created_at, events_processed, updated_at
+FROM worker_cursors
+ORDER BY worker_id ASC
+`
+
+func (q *Queries) ListWorkerCursors(ctx context.Context) ([]WorkerCursor, error) {
+	rows, err := q.db.QueryContext(ctx, listWorkerCursors)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []WorkerCursor{}
+	for rows.Next() {
+		var i WorkerCursor
+		if err := rows.Scan(
+			&i.WorkerID,
+			&i.LastEventID,
+			&i.LastEventCreatedAt,
+			&i.EventsProcessed,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markEventAsProcessed = `-- name: MarkEventAsProcessed :exec
+UPDATE events
+SET status = 'processed',
+    processed_at = CURRENT_TIMESTAMP
+WHERE id = ?
+`
+
+func (q *Queries) MarkEventAsProcessed(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, markEventAsProcessed, id)
+	return err
+}
+
+const markEventAsProcessedWithDeliveryID = `-- name: MarkEventAsProcessedWithDeliveryID :exec
+UPDATE events
+SET status = 'processed',
+    processed_at = CURRENT_TIMESTAMP,
+    delivery_id = ?
+WHERE id = ?
+`
+
+type MarkEventAsProcessedWithDeliveryIDParams struct {
+	DeliveryID sql.NullString `json:"delivery_id"`
+	ID         string         `json:"id"`
+}
+
+func (q *Queries) MarkEventAsProcessedWithDeliveryID(ctx context.Context, arg MarkEventAsProcessedWithDeliveryIDParams) error {
+	_, err := q.db.ExecContext(ctx, markEventAsProcessedWithDeliveryID, arg.DeliveryID, arg.ID)
+	return err
+}
+
+const markEventsAsProcessed = `-- name: MarkEventsAsProcessed :exec
+UPDATE events
+SET status = 'processed',
+    processed_at = CURRENT_TIMESTAMP
+WHERE id IN (/*SLICE:ids*/?)
+`
+
+func (q *Queries) MarkEventsAsProcessed(ctx context.Context, ids []string) error {
+	query := markEventsAsProcessed
+	var queryParams []interface{}
+	if len(ids) > 0 {
+		for _, v := range ids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:ids*/?", strings.Repeat(",?", len(ids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:ids*/?", "NULL", 1)
+	}
+	_, err := q.db.ExecContext(ctx, query, queryParams...)
+	return err
+}
+
+const moveEventToDeadLetter = `-- name: MoveEventToDeadLetter :exec
+INSERT INTO dead_letter_events (id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, retry_count, last_error, dead_lettered_at, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, status)
+SELECT id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, retry_count + 1, ?, CURRENT_TIMESTAMP, payload_encoding, owner_id, delivery_mode, idempotency_key, headers, ?
+FROM events
+WHERE id = ?
+`
+
+type MoveEventToDeadLetterParams struct {
+	LastError sql.NullString `json:"last_error"`
+	Status    string         `json:"status"`
+	ID        string         `json:"id"`
+}
+
+func (q *Queries) MoveEventToDeadLetter(ctx context.Context, arg MoveEventToDeadLetterParams) error {
+	_, err := q.db.ExecContext(ctx, moveEventToDeadLetter, arg.LastError, arg.Status, arg.ID)
+	return err
+}
+
+const recoverStuckEvents = `-- name: RecoverStuckEvents :execrows
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL
+WHERE status = 'processing'
+  AND claimed_at < ?
+`
+
+func (q *Queries) RecoverStuckEvents(ctx context.Context, claimedAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, recoverStuckEvents, claimedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const releaseClaimedEvents = `-- name: ReleaseClaimedEvents :exec
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL
+WHERE status = 'processing'
+  AND id IN (/*SLICE:ids*/?)
+`
+
+func (q *Queries) ReleaseClaimedEvents(ctx context.Context, ids []string) error {
+	query := releaseClaimedEvents
+	var queryParams []interface{}
+	if len(ids) > 0 {
+		for _, v := range ids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:ids*/?", strings.Repeat(",?", len(ids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:ids*/?", "NULL", 1)
+	}
+	_, err := q.db.ExecContext(ctx, query, queryParams...)
+	return err
+}
+
+const releaseWorkerEvents = `-- name: ReleaseWorkerEvents :execrows
+UPDATE events
+SET status = 'pending',
+    claimed_at = NULL,
+    claimed_by = NULL
+WHERE status = 'processing'
+  AND claimed_by = ?
+  AND queue = ?
+`
+
+type ReleaseWorkerEventsParams struct {
+	ClaimedBy sql.NullString `json:"claimed_by"`
+	Queue     string         `json:"queue"`
+}
+
+func (q *Queries) ReleaseWorkerEvents(ctx context.Context, arg ReleaseWorkerEventsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, releaseWorkerEvents, arg.ClaimedBy, arg.Queue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const requeueDeadLetterEvent = `-- name: RequeueDeadLetterEvent :execrows
+INSERT INTO events (id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers)
+SELECT id, business_id, event_type, payload, created_at, codec, queue, encrypted, aggregate_id, payload_encoding, owner_id, delivery_mode, idempotency_key, headers
+FROM dead_letter_events
+WHERE id = ?
+`
+
+func (q *Queries) RequeueDeadLetterEvent(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requeueDeadLetterEvent, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateInvoiceStatus = `-- name: UpdateInvoiceStatus :execrows
+UPDATE invoices
+SET status = ?
+WHERE id = ?
+  AND status = ?
+`
+
+type UpdateInvoiceStatusParams struct {
+	Status     string `json:"status"`
+	ID         string `json:"id"`
+	FromStatus string `json:"from_status"`
+}
+
+func (q *Queries) UpdateInvoiceStatus(ctx context.Context, arg UpdateInvoiceStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateInvoiceStatus, arg.Status, arg.ID, arg.FromStatus)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const upsertWorkerCursor = `-- name: UpsertWorkerCursor :exec
+INSERT INTO worker_cursors (worker_id, last_event_id, last_event_created_at, events_processed, updated_at)
+VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT (worker_id) DO UPDATE
+SET last_event_id = excluded.last_event_id,
+    last_event_created_at = excluded.last_event_created_at,
+    events_processed = worker_cursors.events_processed + excluded.events_processed,
+    updated_at = CURRENT_TIMESTAMP
+`
+
+type UpsertWorkerCursorParams struct {
+	WorkerID           string         `json:"worker_id"`
+	LastEventID        sql.NullString `json:"last_event_id"`
+	LastEventCreatedAt sql.NullTime   `json:"last_event_created_at"`
+	EventsProcessed    int64          `json:"events_processed"`
+}
+
+func (q *Queries) UpsertWorkerCursor(ctx context.Context, arg UpsertWorkerCursorParams) error {
+	_, err := q.db.ExecContext(ctx, upsertWorkerCursor,
+		arg.WorkerID,
+		arg.LastEventID,
+		arg.LastEventCreatedAt,
+		arg.EventsProcessed,
+	)
 	return err
 }
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// NewPostgres returns Queries that run on Postgres. The queries are written
+// for SQLite with ? placeholders, so they are rewritten to $1, $2, ... before
+// they reach the driver.
+func NewPostgres(db DBTX) *Queries {
+	return New(rewriteDBTX{db: db, rewrite: rebind})
+}
+
+// InTx is WithTx that keeps the rewriting of NewPostgres and WithTables, so
+// callers can start transactions without knowing the database in use
+func (q *Queries) InTx(tx *sql.Tx) *Queries {
+	if r, ok := q.db.(rewriteDBTX); ok {
+		return New(rewriteDBTX{db: tx, rewrite: r.rewrite})
+	}
+	return q.WithTx(tx)
+}
+
+// rewriteDBTX rewrites every query before it reaches db
+type rewriteDBTX struct {
+	db      DBTX
+	rewrite func(query string) string
+}
+
+func (r rewriteDBTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.db.ExecContext(ctx, r.rewrite(query), args...)
+}
+
+func (r rewriteDBTX) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return r.db.PrepareContext(ctx, r.rewrite(query))
+}
+
+func (r rewriteDBTX) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db.QueryContext(ctx, r.rewrite(query), args...)
+}
+
+func (r rewriteDBTX) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return r.db.QueryRowContext(ctx, r.rewrite(query), args...)
+}
+
+// rebind replaces each ? placeholder outside string literals with $n
+func rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
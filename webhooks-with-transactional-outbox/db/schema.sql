@@ -1,3 +1,6 @@
+-- Keep in step with db/postgres/schema.sql, which runs the same queries,
+-- and with the migrations in db/migrations/sqlite, which create the schema.
+
 -- Create events table
 CREATE TABLE IF NOT EXISTS events (
     id TEXT PRIMARY KEY,
@@ -6,22 +9,91 @@ CREATE TABLE IF NOT EXISTS events (
     payload TEXT NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     processed_at DATETIME,
-    status TEXT DEFAULT 'pending'
+    status TEXT DEFAULT 'pending',
+    codec TEXT NOT NULL DEFAULT 'json',
+    queue TEXT NOT NULL DEFAULT 'default',
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    aggregate_id TEXT,
+    retry_count INTEGER NOT NULL DEFAULT 0,
+    next_retry_at DATETIME,
+    last_error TEXT,
+    claimed_at DATETIME,
+    delivery_id TEXT,
+    payload_encoding TEXT NOT NULL DEFAULT 'identity',
+    owner_id TEXT,
+    delivery_mode TEXT NOT NULL DEFAULT 'fanout',
+    idempotency_key TEXT,
+    headers TEXT,
+    visible_at DATETIME,
+    expires_at DATETIME,
+    claimed_by TEXT
+);
+
+-- Create businesses table, which every invoice must belong to
+CREATE TABLE IF NOT EXISTS businesses (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Create invoices table
 CREATE TABLE IF NOT EXISTS invoices (
     id TEXT PRIMARY KEY,
-    business_id TEXT NOT NULL,
-    amount REAL NOT NULL,
+    business_id TEXT NOT NULL REFERENCES businesses(id),
+    amount_cents INTEGER NOT NULL,
     currency TEXT NOT NULL,
     status TEXT NOT NULL,
     description TEXT,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Create worker cursors table
+CREATE TABLE IF NOT EXISTS worker_cursors (
+    worker_id TEXT PRIMARY KEY,
+    last_event_id TEXT,
+    last_event_created_at DATETIME,
+    events_processed INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create dead-letter table for events that exhausted their retries
+CREATE TABLE IF NOT EXISTS dead_letter_events (
+    id TEXT PRIMARY KEY,
+    business_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at DATETIME,
+    codec TEXT NOT NULL,
+    queue TEXT NOT NULL,
+    encrypted BOOLEAN NOT NULL,
+    aggregate_id TEXT,
+    retry_count INTEGER NOT NULL,
+    last_error TEXT,
+    dead_lettered_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    payload_encoding TEXT NOT NULL DEFAULT 'identity',
+    owner_id TEXT,
+    delivery_mode TEXT NOT NULL DEFAULT 'fanout',
+    idempotency_key TEXT,
+    headers TEXT,
+    status TEXT NOT NULL DEFAULT 'failed'
+);
+
+-- Create attempts table recording every delivery attempt of an event
+CREATE TABLE IF NOT EXISTS event_attempts (
+    event_id TEXT NOT NULL,
+    attempt_no INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT,
+    latency_ms INTEGER NOT NULL,
+    attempted_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_id, attempt_no)
+);
+
 -- Create indexes
 CREATE INDEX IF NOT EXISTS idx_events_business_id ON events(business_id);
 CREATE INDEX IF NOT EXISTS idx_events_status ON events(status);
 CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
-CREATE INDEX IF NOT EXISTS idx_invoices_business_id ON invoices(business_id); 
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_events_queue_status ON events(queue, status, created_at);
+CREATE INDEX IF NOT EXISTS idx_events_aggregate_id ON events(aggregate_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_business_id ON invoices(business_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status, created_at); 
\ No newline at end of file
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Tables names the events and invoices tables, so the outbox can share a
+// database where those names are already taken. The queries are written
+// against the default names and rewritten by Rename before they run.
+type Tables struct {
+	Events   string
+	Invoices string
+}
+
+// DefaultTables are the names the queries, schemas and migrations are
+// written with
+var DefaultTables = Tables{Events: "events", Invoices: "invoices"}
+
+// tableName is what a configured table name may look like. Names are
+// spliced into the SQL text, so nothing that could quote or end an
+// identifier is allowed.
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedTables are the other tables of the outbox, which a configured
+// name must not clash with
+var reservedTables = []string{"businesses", "dead_letter_events", "event_attempts", "worker_cursors", "schema_migrations"}
+
+// derivedSuffixes name the objects named after a table in the schemas and
+// migrations: the Postgres notify trigger and foreign key, and the tables
+// SQLite migrations rebuild a table through. Their names follow the table's,
+// so a renamed table doesn't collide with the default one.
+var derivedSuffixes = []string{"notify", "new", "old", "business_id_fkey"}
+
+// Validate checks that both names are set, made of letters, digits and
+// underscores only, and distinct from each other and the other tables
+func (t Tables) Validate() error {
+	for _, table := range []struct{ flag, name string }{{"--events-table", t.Events}, {"--invoices-table", t.Invoices}} {
+		if !tableName.MatchString(table.name) {
+			return fmt.Errorf("invalid %s %q: must start with a letter or underscore and contain only letters, digits and underscores", table.flag, table.name)
+		}
+		for _, reserved := range reservedTables {
+			if strings.EqualFold(table.name, reserved) {
+				return fmt.Errorf("invalid %s %q: the outbox already has a table of that name", table.flag, table.name)
+			}
+		}
+	}
+	if strings.EqualFold(t.Events, t.Invoices) {
+		return fmt.Errorf("--events-table and --invoices-table must differ, both are %q", t.Events)
+	}
+	return nil
+}
+
+// Rename rewrites query, written against DefaultTables, to run against t.
+// Only whole identifiers outside string literals and comments are replaced: the tables
+// themselves, their idx_ indexes and the objects of derivedSuffixes.
+func (t Tables) Rename(query string) string {
+	if t == DefaultTables {
+		return query
+	}
+	renames := map[string]string{
+		DefaultTables.Events:   t.Events,
+		DefaultTables.Invoices: t.Invoices,
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 32)
+	quoted := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		if c == '\'' {
+			quoted = !quoted
+		}
+		// A comment is copied as is, as an apostrophe in it would
+		// otherwise open a literal
+		if !quoted && strings.HasPrefix(query[i:], "--") {
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			b.WriteString(query[i : i+end])
+			i += end
+			continue
+		}
+		if quoted || !isIdentByte(c) {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(query) && isIdentByte(query[end]) {
+			end++
+		}
+		b.WriteString(renameIdent(query[i:end], renames))
+		i = end
+	}
+	return b.String()
+}
+
+// WithTables returns Queries that run against the tables t instead of
+// DefaultTables, keeping the placeholder rewriting of NewPostgres
+func (q *Queries) WithTables(t Tables) *Queries {
+	if t == DefaultTables {
+		return q
+	}
+	if r, ok := q.db.(rewriteDBTX); ok {
+		return New(rewriteDBTX{db: r.db, rewrite: func(query string) string { return r.rewrite(t.Rename(query)) }})
+	}
+	return New(rewriteDBTX{db: q.db, rewrite: t.Rename})
+}
+
+// renameIdent returns ident with the default table name it is, or is
+// derived from, replaced
+func renameIdent(ident string, renames map[string]string) string {
+	for from, to := range renames {
+		switch {
+		case ident == from:
+			return to
+		case strings.HasPrefix(ident, "idx_"+from+"_"):
+			return "idx_" + to + strings.TrimPrefix(ident, "idx_"+from)
+		}
+		for _, suffix := range derivedSuffixes {
+			if ident == from+"_"+suffix {
+				return to + "_" + suffix
+			}
+		}
+	}
+	return ident
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// decodeJSON decodes a JSON document keeping numbers exactly as written
+func decodeJSON(payload []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// mergePatch builds an RFC 7386 JSON merge patch that turns original into
+// modified: changed and added members are included, removed members are null
+func mergePatch(original, modified interface{}) interface{} {
+	originalObject, ok := original.(map[string]interface{})
+	if !ok {
+		return modified
+	}
+	modifiedObject, ok := modified.(map[string]interface{})
+	if !ok {
+		return modified
+	}
+
+	patch := make(map[string]interface{})
+	for key, modifiedValue := range modifiedObject {
+		originalValue, exists := originalObject[key]
+		if !exists {
+			patch[key] = modifiedValue
+		} else if !reflect.DeepEqual(originalValue, modifiedValue) {
+			patch[key] = mergePatch(originalValue, modifiedValue)
+		}
+	}
+	for key := range originalObject {
+		if _, exists := modifiedObject[key]; !exists {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
+
+// deltaPayload replaces the data of an event with a merge patch against the
+// previous event of the same aggregate. The envelope gains a delta_of member
+// naming that event. Events without a predecessor are sent unchanged.
+func (p *eventProcessor) deltaPayload(ctx context.Context, event db.Event, payload []byte) ([]byte, error) {
+	dbCtx, cancel := dbContext(ctx, p.dbTimeout)
+	previous, err := p.store.GetPreviousAggregateEvent(dbCtx, db.GetPreviousAggregateEventParams{
+		AggregateID: event.AggregateID,
+		ID:          event.ID,
+	})
+	cancel()
+	if err == sql.ErrNoRows {
+		return payload, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching previous event: %v", err)
+	}
+
+	previousPayload, err := p.payload(previous)
+	if err != nil {
+		return nil, fmt.Errorf("error reading previous event %s: %v", previous.ID, err)
+	}
+
+	current, err := decodeJSON(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding payload: %v", err)
+	}
+	before, err := decodeJSON(previousPayload)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding previous payload: %v", err)
+	}
+
+	envelope, ok := current.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("payload is not a JSON object")
+	}
+	var previousData interface{} = before
+	if previousEnvelope, ok := before.(map[string]interface{}); ok {
+		previousData = previousEnvelope["data"]
+	}
+
+	envelope["data"] = mergePatch(previousData, envelope["data"])
+	envelope["delta_of"] = previous.ID
+	return json.Marshal(envelope)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+const (
+	// dispatchPool processes a batch on a bounded pool of goroutines
+	dispatchPool = "pool"
+	// dispatchPerBusiness processes each business's events in order while
+	// running different businesses concurrently. --ordered-by-business
+	// selects it too.
+	dispatchPerBusiness = "per-business"
+)
+
+// drainContext returns the context events of a batch are sent with. It
+// outlives ctx by up to timeout, so deliveries in flight when the worker
+// is shut down get the chance to finish and be marked processed, and is
+// cancelled after that to cut short those that don't. With a timeout of 0
+// it is cancelled along with ctx. The returned func must be called once
+// the batch is done.
+func drainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		select {
+		case <-drainCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		if timeout > 0 {
+			slog.Info("Shutting down, waiting for deliveries in flight", "timeout", timeout.String())
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case <-drainCtx.Done():
+				return
+			case <-timer.C:
+				slog.Warn("Shutdown timeout reached, cancelling deliveries in flight", "timeout", timeout.String())
+			}
+		}
+		cancel()
+	}()
+	return drainCtx, cancel
+}
+
+// dispatchPooled fans a batch out to at most concurrency goroutines, each
+// processing events independently so one failure doesn't affect the others.
+// Events are sent with sendCtx, see drainContext. Events not yet started
+// when ctx is cancelled, the sink starts rate limiting or the circuit
+// breaker opens are left pending. It returns the events that were
+// processed and the number of events that failed.
+//
+// The processor is shared between goroutines: its Store must be safe for
+// concurrent use, as sqlStore over a *sql.DB connection pool is, and writes
+// to a claimed batch's transaction are serialized by the batch.
+func dispatchPooled(ctx, sendCtx context.Context, processor *eventProcessor, events []db.Event, concurrency int) ([]db.Event, int) {
+	jobs := make(chan db.Event, len(events))
+	for _, event := range events {
+		jobs <- event
+	}
+	close(jobs)
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		processed []db.Event
+		failed    int
+	)
+
+	workers := min(concurrency, len(events))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for event := range jobs {
+				if ctx.Err() != nil || processor.paused() {
+					return
+				}
+				deliveryID, err := processor.process(sendCtx, event)
+				if errors.Is(err, errBreakerOpen) {
+					return
+				}
+
+				mu.Lock()
+				if err != nil {
+					slog.Error("Error processing event", "event_id", event.ID, "business_id", event.BusinessID, "event_type", event.EventType, "error", err)
+					failed++
+				} else {
+					processed = append(processed, withDeliveryID(event, deliveryID))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return processed, failed
+}
+
+// businessLane assigns a business to one of n lanes by a hash of its ID, so
+// every event of the business is processed by the same goroutine
+func businessLane(businessID string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(businessID))
+	return int(h.Sum32() % uint32(n))
+}
+
+// partitionByBusiness splits events into at most n lanes by businessLane,
+// keeping the batch order within each lane
+func partitionByBusiness(events []db.Event, n int) [][]db.Event {
+	lanes := make([][]db.Event, n)
+	for _, event := range events {
+		i := businessLane(event.BusinessID, n)
+		lanes[i] = append(lanes[i], event)
+	}
+	return lanes
+}
+
+// dispatchPerBusinessEvents partitions the batch into concurrency lanes on a
+// hash of the business ID and runs one goroutine per lane. A business only
+// ever has one event in flight, and each lane processes its events strictly
+// in order. A business stops at its first failure, so later events are never
+// delivered ahead of an earlier one, while the other businesses of its lane
+// carry on. Held back events are not counted as failed. As with
+// dispatchPooled, events are sent with sendCtx and no new one is started
+// once ctx is cancelled.
+func dispatchPerBusinessEvents(ctx, sendCtx context.Context, processor *eventProcessor, events []db.Event, concurrency int) ([]db.Event, int) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		processed []db.Event
+		failed    int
+	)
+
+	for _, lane := range partitionByBusiness(events, concurrency) {
+		if len(lane) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(lane []db.Event) {
+			defer wg.Done()
+
+			held := make(map[string]bool)
+			for i, event := range lane {
+				if ctx.Err() != nil || processor.paused() {
+					return
+				}
+				if held[event.BusinessID] {
+					continue
+				}
+				deliveryID, err := processor.process(sendCtx, event)
+				if errors.Is(err, errBreakerOpen) {
+					return
+				}
+				if err != nil {
+					slog.Error("Error processing event", "event_id", event.ID, "business_id", event.BusinessID, "event_type", event.EventType, "error", err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					held[event.BusinessID] = true
+					if remaining := countBusinessEvents(lane[i+1:], event.BusinessID); remaining > 0 {
+						slog.Warn("Holding back later events", "business_id", event.BusinessID, "count", remaining)
+					}
+					continue
+				}
+				mu.Lock()
+				processed = append(processed, withDeliveryID(event, deliveryID))
+				mu.Unlock()
+			}
+		}(lane)
+	}
+
+	wg.Wait()
+	return processed, failed
+}
+
+// withDeliveryID records the delivery ID a publisher returned on the event,
+// for markProcessed to store
+func withDeliveryID(event db.Event, deliveryID string) db.Event {
+	event.DeliveryID = sql.NullString{String: deliveryID, Valid: deliveryID != ""}
+	return event
+}
+
+// countBusinessEvents counts the events of a business
+func countBusinessEvents(events []db.Event, businessID string) int {
+	count := 0
+	for _, event := range events {
+		if event.BusinessID == businessID {
+			count++
+		}
+	}
+	return count
+}
+
+// latestEvent returns the most recently created event of a set
+func latestEvent(events []db.Event) (db.Event, bool) {
+	if len(events) == 0 {
+		return db.Event{}, false
+	}
+	latest := events[0]
+	for _, event := range events[1:] {
+		if event.CreatedAt.Time.After(latest.CreatedAt.Time) {
+			latest = event
+		}
+	}
+	return latest, true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jitterPublisher records the events it is given per business after a
+// random delay, so events sent concurrently finish out of order
+type jitterPublisher struct {
+	mu         sync.Mutex
+	byBusiness map[string][]string
+}
+
+func (p *jitterPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.byBusiness[event.Event.BusinessID] = append(p.byBusiness[event.Event.BusinessID], event.Event.ID)
+	return "", nil
+}
+
+func TestOrderedByBusiness(t *testing.T) {
+	store, dbConn := newTestStore(t)
+
+	// Each invoice writes two events in one transaction, which share their
+	// created_at, so the order within the transaction must come from the
+	// insertion order
+	opts := testIngestOptions(t)
+	mapper, err := buildEventMapper([]string{"ledger.entry.added"})
+	if err != nil {
+		t.Fatalf("building event mapper: %v", err)
+	}
+	opts.Mapper = mapper
+	for i := 0; i < 12; i++ {
+		invoice := generateInvoice(businessIDs[i%2])
+		if _, err := createInvoiceWithEvents(context.Background(), store, invoice, opts); err != nil {
+			t.Fatalf("storing invoice %d: %v", i, err)
+		}
+	}
+
+	want := map[string][]string{}
+	rows, err := dbConn.Query("SELECT id, business_id FROM events ORDER BY rowid")
+	if err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	for rows.Next() {
+		var id, businessID string
+		if err := rows.Scan(&id, &businessID); err != nil {
+			t.Fatalf("reading events: %v", err)
+		}
+		want[businessID] = append(want[businessID], id)
+	}
+	rows.Close()
+
+	workerOpts := testWorkerOptions()
+	workerOpts.DispatchMode = dispatchPerBusiness
+	workerOpts.Once = true
+	publisher := &jitterPublisher{byBusiness: map[string][]string{}}
+	if err := runWorker(context.Background(), store, publisher, workerOpts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	for businessID, ids := range want {
+		got := publisher.byBusiness[businessID]
+		if len(got) != len(ids) {
+			t.Fatalf("business %s got %d events, want %d", businessID, len(got), len(ids))
+		}
+		for i := range ids {
+			if got[i] != ids[i] {
+				t.Errorf("business %s got event %d as %s, want %s in the order written", businessID, i, got[i], ids[i])
+			}
+		}
+	}
+}
+
+// slowPublisher announces each delivery on started, then takes delay to
+// finish unless ctx is cancelled first
+type slowPublisher struct {
+	delay   time.Duration
+	started chan struct{}
+}
+
+func (p *slowPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	p.started <- struct{}{}
+	if !sleepContext(ctx, p.delay) {
+		return "", ctx.Err()
+	}
+	return "", nil
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// delay is how long each delivery takes
+		delay         time.Duration
+		wantProcessed int
+	}{
+		{name: "deliveries finish within the timeout", delay: 200 * time.Millisecond, wantProcessed: 4},
+		{name: "deliveries outlast the timeout", delay: time.Minute, wantProcessed: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, dbConn := newTestStore(t)
+			seedInvoices(t, store, 8, testIngestOptions(t))
+
+			opts := testWorkerOptions()
+			opts.BatchSize = 4
+			opts.Concurrency = 4
+			opts.ShutdownTimeout = 500 * time.Millisecond
+			publisher := &slowPublisher{delay: tc.delay, started: make(chan struct{}, 8)}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- runWorker(ctx, store, publisher, opts) }()
+
+			// Shut down once the whole first batch is in flight
+			for i := 0; i < 4; i++ {
+				select {
+				case <-publisher.started:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("only %d deliveries started", i)
+				}
+			}
+			cancel()
+			cancelled := time.Now()
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("running worker: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("worker still running 5s after shutdown with a %v shutdown timeout", opts.ShutdownTimeout)
+			}
+			if elapsed := time.Since(cancelled); elapsed > opts.ShutdownTimeout+time.Second {
+				t.Errorf("worker returned %v after shutdown, want within %v", elapsed, opts.ShutdownTimeout)
+			}
+			if extra := len(publisher.started); extra != 0 {
+				t.Errorf("%d deliveries started after shutdown, want none", extra)
+			}
+
+			var processed, pending int
+			if err := dbConn.QueryRow("SELECT (SELECT COUNT(*) FROM events WHERE status = 'processed'), (SELECT COUNT(*) FROM events WHERE status = 'pending' AND retry_count = 0)").Scan(&processed, &pending); err != nil {
+				t.Fatalf("reading events: %v", err)
+			}
+			if processed != tc.wantProcessed {
+				t.Errorf("%d events processed, want %d", processed, tc.wantProcessed)
+			}
+			if pending != 8-tc.wantProcessed {
+				t.Errorf("%d events left pending without a retry, want %d", pending, 8-tc.wantProcessed)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+const (
+	// deadLetterFailed is the status of a dead-letter event that exhausted
+	// its retries or could never be sent, and deadLetterExpired of one that
+	// outlived its --event-ttl before it was delivered
+	deadLetterFailed  = "failed"
+	deadLetterExpired = "expired"
+)
+
+// moveToDeadLetter moves an event out of the outbox and into
+// dead_letter_events with status, recording the final error
+func moveToDeadLetter(ctx context.Context, store Store, eventID string, status string, lastError string) error {
+	tx, err := store.Begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := deadLetterEvent(ctx, tx, eventID, status, lastError); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// deadLetterEvent copies the event into dead_letter_events and deletes it
+// from events. qtx must be bound to a transaction.
+func deadLetterEvent(ctx context.Context, qtx Querier, eventID string, status string, lastError string) error {
+	err := qtx.MoveEventToDeadLetter(ctx, db.MoveEventToDeadLetterParams{
+		LastError: sql.NullString{String: lastError, Valid: true},
+		Status:    status,
+		ID:        eventID,
+	})
+	if err != nil {
+		return fmt.Errorf("error inserting dead-letter event: %v", err)
+	}
+	if err := qtx.DeleteEvent(ctx, eventID); err != nil {
+		return fmt.Errorf("error deleting event: %v", err)
+	}
+	return nil
+}
+
+func runDLQList(ctx context.Context, queries Querier) error {
+	events, err := queries.ListDeadLetterEvents(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing dead-letter events: %v", err)
+	}
+	if len(events) == 0 {
+		fmt.Println("No dead-letter events.")
+		return nil
+	}
+
+	for i, event := range events {
+		if i > 0 {
+			fmt.Println()
+		}
+		lastError := "-"
+		if event.LastError.Valid {
+			lastError = event.LastError.String
+		}
+
+		fmt.Printf("Event ID:          %s\n", event.ID)
+		fmt.Printf("Business ID:       %s\n", event.BusinessID)
+		fmt.Printf("Event type:        %s\n", event.EventType)
+		fmt.Printf("Queue:             %s\n", event.Queue)
+		fmt.Printf("Status:            %s\n", event.Status)
+		fmt.Printf("Attempts:          %d\n", event.RetryCount)
+		fmt.Printf("Last error:        %s\n", lastError)
+		fmt.Printf("Dead-lettered at:  %s\n", event.DeadLetteredAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// runDLQRequeue moves a dead-letter event back into the outbox with a fresh
+// retry count. It keeps its original ID, so Convoy still sees the same
+// idempotency key, which is checked on the requeued row before committing.
+func runDLQRequeue(ctx context.Context, store Store, eventID string) error {
+	tx, err := store.Begin(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	requeued, err := tx.RequeueDeadLetterEvent(ctx, eventID)
+	if err != nil {
+		return fmt.Errorf("error requeueing event: %v", err)
+	}
+	if requeued == 0 {
+		return fmt.Errorf("no dead-letter event found with ID %s", eventID)
+	}
+	if err := tx.DeleteDeadLetterEvent(ctx, eventID); err != nil {
+		return fmt.Errorf("error deleting dead-letter event: %v", err)
+	}
+
+	requeuedEvent, err := tx.GetEventByID(ctx, eventID)
+	if err != nil {
+		return fmt.Errorf("error reading requeued event: %v", err)
+	}
+	key := idempotencyKey(requeuedEvent)
+	if key != eventID {
+		return fmt.Errorf("requeued event %s would be sent with idempotency key %s instead of its original key", eventID, key)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	fmt.Printf("Requeued event %s with idempotency key %s\n", eventID, key)
+	return nil
+}
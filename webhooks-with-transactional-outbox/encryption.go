@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// encryptionKeyEnv holds the AES key when neither --encryption-key nor
+// --encryption-key-file is given, so it can be kept off the command line
+const encryptionKeyEnv = "OUTBOX_ENC_KEY"
+
+// payloadCipher encrypts event payloads at rest with AES-GCM. Ciphertexts are
+// stored base64 encoded with the nonce prepended, and the event's encrypted
+// column set, so rows stored in plaintext are still read as they are.
+type payloadCipher struct {
+	aead cipher.AEAD
+}
+
+// newPayloadCipher builds a cipher from a 16, 24 or 32 byte AES key
+func newPayloadCipher(key []byte) (*payloadCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadCipher{aead: aead}, nil
+}
+
+// resolvePayloadCipher builds the cipher of a command from --encryption-key,
+// --encryption-key-file or, when neither is given, OUTBOX_ENC_KEY. It
+// returns nil, storing and reading payloads in plaintext, when none is set.
+func resolvePayloadCipher(key, keyFile string) (*payloadCipher, error) {
+	switch {
+	case key != "" && keyFile != "":
+		return nil, fmt.Errorf("--encryption-key and --encryption-key-file can't both be given")
+	case key != "":
+		return parsePayloadCipher(key)
+	case keyFile != "":
+		return loadPayloadCipher(keyFile)
+	}
+	if key := os.Getenv(encryptionKeyEnv); key != "" {
+		return parsePayloadCipher(key)
+	}
+	return nil, nil
+}
+
+// loadPayloadCipher reads a hex or base64 encoded AES key from path
+func loadPayloadCipher(path string) (*payloadCipher, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encryption key file: %v", err)
+	}
+	return parsePayloadCipher(string(bytes.TrimSpace(contents)))
+}
+
+// parsePayloadCipher builds a cipher from a hex or base64 encoded AES key
+func parsePayloadCipher(encoded string) (*payloadCipher, error) {
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key must be hex or base64 encoded")
+		}
+	}
+	return newPayloadCipher(key)
+}
+
+// Encrypt seals plaintext under a fresh random nonce
+func (c *payloadCipher) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a payload produced by Encrypt
+func (c *payloadCipher) Decrypt(ciphertext string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted payload is not valid base64: %v", err)
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("encrypted payload is too short")
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting payload, it was encrypted with a different key: %v", err)
+	}
+	return plaintext, nil
+}
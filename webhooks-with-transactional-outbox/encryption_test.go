@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testEncryptionKey  = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	otherEncryptionKey = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func testPayloadCipher(tb testing.TB, key string) *payloadCipher {
+	tb.Helper()
+	c, err := parsePayloadCipher(key)
+	if err != nil {
+		tb.Fatalf("building cipher: %v", err)
+	}
+	return c
+}
+
+func TestPayloadCipherRoundTrip(t *testing.T) {
+	c := testPayloadCipher(t, testEncryptionKey)
+	plaintext := []byte(`{"id":"INV-1","amount_cents":1999}`)
+
+	first, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+	second, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+	if first == second {
+		t.Errorf("encrypting twice gave the same ciphertext, want a fresh nonce each time")
+	}
+	if strings.Contains(first, "INV-1") {
+		t.Errorf("ciphertext %s holds the plaintext", first)
+	}
+	decrypted, err := c.Decrypt(first)
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted %s, want %s", decrypted, plaintext)
+	}
+
+	_, err = testPayloadCipher(t, otherEncryptionKey).Decrypt(first)
+	if err == nil || !strings.Contains(err.Error(), "encrypted with a different key") {
+		t.Errorf("decrypting with another key gave %v, want an error naming the key", err)
+	}
+}
+
+func TestResolvePayloadCipher(t *testing.T) {
+	plaintext := []byte("invoice")
+	sealed, err := testPayloadCipher(t, testEncryptionKey).Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(otherEncryptionKey+"\n"), 0o600); err != nil {
+		t.Fatalf("writing key file: %v", err)
+	}
+	raw, _ := hex.DecodeString(testEncryptionKey)
+
+	// decrypts reports whether c is the cipher of testEncryptionKey
+	decrypts := func(c *payloadCipher) bool {
+		opened, err := c.Decrypt(sealed)
+		return err == nil && bytes.Equal(opened, plaintext)
+	}
+
+	t.Setenv(encryptionKeyEnv, "")
+	if c, err := resolvePayloadCipher("", ""); err != nil || c != nil {
+		t.Errorf("with no key got %v, %v, want no cipher", c, err)
+	}
+	if c, err := resolvePayloadCipher(testEncryptionKey, ""); err != nil || !decrypts(c) {
+		t.Errorf("--encryption-key gave %v, %v, want its cipher", c, err)
+	}
+
+	t.Setenv(encryptionKeyEnv, hex.EncodeToString(raw))
+	if c, err := resolvePayloadCipher("", ""); err != nil || !decrypts(c) {
+		t.Errorf("%s gave %v, %v, want its cipher", encryptionKeyEnv, c, err)
+	}
+	// A flag wins over the environment
+	if c, err := resolvePayloadCipher("", keyFile); err != nil || c == nil || decrypts(c) {
+		t.Errorf("--encryption-key-file with %s set gave %v, %v, want the file's cipher", encryptionKeyEnv, c, err)
+	}
+	if _, err := resolvePayloadCipher(testEncryptionKey, keyFile); err == nil {
+		t.Errorf("giving both flags succeeded, want an error")
+	}
+	if _, err := resolvePayloadCipher("not a key", ""); err == nil {
+		t.Errorf("an invalid key succeeded, want an error")
+	}
+}
+
+func TestWorkerDecryptsPayloads(t *testing.T) {
+	store, dbConn := newTestStore(t)
+
+	// One event stored before encryption was turned on, one after
+	seedInvoices(t, store, 1, testIngestOptions(t))
+	encrypted := testIngestOptions(t)
+	encrypted.Cipher = testPayloadCipher(t, testEncryptionKey)
+	seedInvoices(t, store, 1, encrypted)
+
+	var stored []string
+	rows, err := dbConn.Query("SELECT payload FROM events WHERE encrypted")
+	if err != nil {
+		t.Fatalf("reading payloads: %v", err)
+	}
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			t.Fatalf("reading payload: %v", err)
+		}
+		stored = append(stored, payload)
+	}
+	rows.Close()
+	if len(stored) != 1 || strings.Contains(stored[0], "INV-") {
+		t.Fatalf("stored encrypted payloads %v, want one without the plaintext", stored)
+	}
+
+	// A worker with the wrong key sends nothing
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.Cipher = testPayloadCipher(t, otherEncryptionKey)
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.payloads) != 1 || !bytes.Contains(publisher.payloads[0], []byte("INV-")) {
+		t.Fatalf("published %d payloads with the wrong key, want only the plaintext one", len(publisher.payloads))
+	}
+	var lastError string
+	if err := dbConn.QueryRow("SELECT last_error FROM events WHERE encrypted").Scan(&lastError); err != nil {
+		t.Fatalf("reading event: %v", err)
+	}
+	if !strings.Contains(lastError, "encrypted with a different key") {
+		t.Errorf("event failed with %q, want the key named", lastError)
+	}
+
+	// The right key delivers it decrypted
+	if _, err := dbConn.Exec("UPDATE events SET next_retry_at = NULL"); err != nil {
+		t.Fatalf("clearing backoff: %v", err)
+	}
+	opts.Cipher = testPayloadCipher(t, testEncryptionKey)
+	publisher = &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.payloads) != 1 || !bytes.Contains(publisher.payloads[0], []byte("INV-")) {
+		t.Errorf("published %q, want the decrypted invoice", publisher.payloads)
+	}
+}
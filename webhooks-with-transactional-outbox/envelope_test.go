@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeFormat(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	opts := testIngestOptions(t)
+	opts.EventFormat = eventFormatEnvelope
+	opts.SchemaVersion = 2
+
+	invoice := generateInvoice(businessIDs[0])
+	before := time.Now().UTC()
+	if _, err := createInvoiceWithEvents(ctx, store, invoice, opts); err != nil {
+		t.Fatalf("storing invoice: %v", err)
+	}
+	events, err := store.ListEvents(ctx)
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("%d events stored, want 1", len(events))
+	}
+	event := events[0]
+
+	var envelope eventEnvelope
+	if err := json.Unmarshal([]byte(event.Payload), &envelope); err != nil {
+		t.Fatalf("decoding %s as an envelope: %v", event.Payload, err)
+	}
+	if envelope.SchemaVersion != 2 {
+		t.Errorf("schema_version is %d, want 2", envelope.SchemaVersion)
+	}
+	if envelope.EventID == "" {
+		t.Errorf("event_id is missing")
+	}
+	if envelope.EventType != "invoice.created" || envelope.BusinessID != invoice.BusinessID {
+		t.Errorf("event_type %q and business_id %q, want invoice.created and %q", envelope.EventType, envelope.BusinessID, invoice.BusinessID)
+	}
+	if envelope.OccurredAt.Before(before.Truncate(time.Second)) || envelope.OccurredAt.After(time.Now()) {
+		t.Errorf("occurred_at is %v, want the time the event was written", envelope.OccurredAt)
+	}
+	want, err := json.Marshal(invoice)
+	if err != nil {
+		t.Fatalf("encoding invoice: %v", err)
+	}
+	if !bytes.Equal(envelope.Data, want) {
+		t.Errorf("data is %s, want the invoice %s", envelope.Data, want)
+	}
+
+	// The worker sends the envelope as it was stored
+	publisher := &fakePublisher{}
+	workerOpts := testWorkerOptions()
+	workerOpts.Once = true
+	if err := runWorker(ctx, store, publisher, workerOpts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.payloads) != 1 || string(publisher.payloads[0]) != event.Payload {
+		t.Errorf("worker sent %q, want the stored envelope %s", publisher.payloads, event.Payload)
+	}
+}
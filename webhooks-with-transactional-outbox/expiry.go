@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// expireEvents moves the events of a batch whose expires_at has passed to
+// the dead-letter table with status expired, rather than deliver them
+// stale after a long outage, and returns the others to be sent. An event
+// that can't be moved is left pending and expired on a later poll.
+func (p *eventProcessor) expireEvents(ctx context.Context, events []db.Event) []db.Event {
+	now := time.Now()
+	live := make([]db.Event, 0, len(events))
+	for _, event := range events {
+		if !event.ExpiresAt.Valid || now.Before(event.ExpiresAt.Time) {
+			live = append(live, event)
+			continue
+		}
+
+		reason := fmt.Sprintf("expired at %s before it was delivered", event.ExpiresAt.Time.UTC().Format(time.RFC3339))
+		dbCtx, cancel := dbContext(context.WithoutCancel(ctx), p.dbTimeout)
+		err := p.deadLetter(dbCtx, event.ID, deadLetterExpired, reason)
+		cancel()
+		if err != nil {
+			slog.Error("Error dead-lettering expired event", "event_id", event.ID, "error", err)
+			continue
+		}
+		eventsExpired.WithLabelValues(event.Queue, p.workerID).Inc()
+		slog.Warn("Event expired, moved to the dead-letter table", "event_id", event.ID, "business_id", event.BusinessID, "event_type", event.EventType, "expires_at", event.ExpiresAt.Time.UTC().Format(time.RFC3339))
+	}
+	return live
+}
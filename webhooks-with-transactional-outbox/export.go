@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// exportBatchSize is how many events export reads per query
+const exportBatchSize = 500
+
+// exportedEvent is a row of the events table as export writes it and
+// import reads it back, one per line. The payload is kept as stored, so an
+// encrypted, binary or compressed payload is exported as it is and only
+// readable with the same key and codec. Timestamps are in UTC.
+type exportedEvent struct {
+	ID              string     `json:"id"`
+	BusinessID      string     `json:"business_id"`
+	EventType       string     `json:"event_type"`
+	Payload         string     `json:"payload"`
+	Status          string     `json:"status"`
+	Queue           string     `json:"queue"`
+	Codec           string     `json:"codec"`
+	Encrypted       bool       `json:"encrypted"`
+	PayloadEncoding string     `json:"payload_encoding"`
+	AggregateID     *string    `json:"aggregate_id,omitempty"`
+	OwnerID         *string    `json:"owner_id,omitempty"`
+	DeliveryMode    string     `json:"delivery_mode"`
+	IdempotencyKey  *string    `json:"idempotency_key,omitempty"`
+	Headers         *string    `json:"headers,omitempty"`
+	RetryCount      int64      `json:"retry_count"`
+	LastError       *string    `json:"last_error,omitempty"`
+	DeliveryID      *string    `json:"delivery_id,omitempty"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`
+	ProcessedAt     *time.Time `json:"processed_at,omitempty"`
+	NextRetryAt     *time.Time `json:"next_retry_at,omitempty"`
+	ClaimedAt       *time.Time `json:"claimed_at,omitempty"`
+	ClaimedBy       *string    `json:"claimed_by,omitempty"`
+	VisibleAt       *time.Time `json:"visible_at,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+}
+
+func exportedFromRow(row db.ExportEventsRow) exportedEvent {
+	return exportedEvent{
+		ID:              row.ID,
+		BusinessID:      row.BusinessID,
+		EventType:       row.EventType,
+		Payload:         row.Payload,
+		Status:          row.Status.String,
+		Queue:           row.Queue,
+		Codec:           row.Codec,
+		Encrypted:       row.Encrypted,
+		PayloadEncoding: row.PayloadEncoding,
+		AggregateID:     stringOrNil(row.AggregateID),
+		OwnerID:         stringOrNil(row.OwnerID),
+		DeliveryMode:    row.DeliveryMode,
+		IdempotencyKey:  stringOrNil(row.IdempotencyKey),
+		Headers:         stringOrNil(row.Headers),
+		RetryCount:      row.RetryCount,
+		LastError:       stringOrNil(row.LastError),
+		DeliveryID:      stringOrNil(row.DeliveryID),
+		CreatedAt:       timeOrNil(row.CreatedAt),
+		ProcessedAt:     timeOrNil(row.ProcessedAt),
+		NextRetryAt:     timeOrNil(row.NextRetryAt),
+		ClaimedAt:       timeOrNil(row.ClaimedAt),
+		ClaimedBy:       stringOrNil(row.ClaimedBy),
+		VisibleAt:       timeOrNil(row.VisibleAt),
+		ExpiresAt:       timeOrNil(row.ExpiresAt),
+	}
+}
+
+// importParams returns the row to insert for an exported event. Columns
+// left out of the line get the defaults of the schema.
+func (e exportedEvent) importParams() db.ImportEventParams {
+	status := e.Status
+	if status == "" {
+		status = "pending"
+	}
+	codec := e.Codec
+	if codec == "" {
+		codec = codecJSON
+	}
+	queue := e.Queue
+	if queue == "" {
+		queue = defaultQueue
+	}
+	encoding := e.PayloadEncoding
+	if encoding == "" {
+		encoding = payloadIdentity
+	}
+	mode := e.DeliveryMode
+	if mode == "" {
+		mode = deliveryFanout
+	}
+	createdAt := e.CreatedAt
+	if createdAt == nil {
+		now := time.Now().UTC()
+		createdAt = &now
+	}
+	return db.ImportEventParams{
+		ID:              e.ID,
+		BusinessID:      e.BusinessID,
+		EventType:       e.EventType,
+		Payload:         e.Payload,
+		CreatedAt:       nullTime(createdAt),
+		ProcessedAt:     nullTime(e.ProcessedAt),
+		Status:          sql.NullString{String: status, Valid: true},
+		Codec:           codec,
+		Queue:           queue,
+		Encrypted:       e.Encrypted,
+		AggregateID:     nullString(e.AggregateID),
+		RetryCount:      e.RetryCount,
+		NextRetryAt:     nullTime(e.NextRetryAt),
+		LastError:       nullString(e.LastError),
+		ClaimedAt:       nullTime(e.ClaimedAt),
+		ClaimedBy:       nullString(e.ClaimedBy),
+		DeliveryID:      nullString(e.DeliveryID),
+		PayloadEncoding: encoding,
+		OwnerID:         nullString(e.OwnerID),
+		DeliveryMode:    mode,
+		IdempotencyKey:  nullString(e.IdempotencyKey),
+		Headers:         nullString(e.Headers),
+		VisibleAt:       nullTime(e.VisibleAt),
+		ExpiresAt:       nullTime(e.ExpiresAt),
+	}
+}
+
+func stringOrNil(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// exportFilter selects the events to export. Empty fields match every
+// event; the time range is on created_at, from Since up to but not
+// including Until.
+type exportFilter struct {
+	Status     string
+	BusinessID string
+	Since      time.Time
+	Until      time.Time
+}
+
+// exportEvents writes the events matching filter to w as JSON lines, in the
+// order they were written. The events are read batchSize at a time after
+// the rowid of the last one written, so a large table is never held in
+// memory and each query stays bounded by dbTimeout. It returns how many
+// events were written.
+func exportEvents(ctx context.Context, queries Querier, w io.Writer, filter exportFilter, batchSize int64, dbTimeout time.Duration) (int, error) {
+	params := db.ExportEventsParams{
+		Status:       filter.Status,
+		BusinessID:   filter.BusinessID,
+		CreatedFrom:  sql.NullTime{Time: filter.Since.UTC(), Valid: true},
+		CreatedUntil: sql.NullTime{Time: time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC), Valid: true},
+		BatchLimit:   batchSize,
+	}
+	if !filter.Until.IsZero() {
+		params.CreatedUntil.Time = filter.Until.UTC()
+	}
+
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	exported := 0
+	for {
+		dbCtx, cancel := dbContext(ctx, dbTimeout)
+		rows, err := queries.ExportEvents(dbCtx, params)
+		cancel()
+		if err != nil {
+			return exported, fmt.Errorf("error reading events: %v", err)
+		}
+		for _, row := range rows {
+			if err := encoder.Encode(exportedFromRow(row)); err != nil {
+				return exported, fmt.Errorf("error writing event %s: %v", row.ID, err)
+			}
+			exported++
+		}
+		if int64(len(rows)) < batchSize {
+			break
+		}
+		params.AfterRowid = rows[len(rows)-1].Rowid
+	}
+	if err := out.Flush(); err != nil {
+		return exported, fmt.Errorf("error writing events: %v", err)
+	}
+	return exported, nil
+}
+
+// importEventsResult counts the events import stored and those it skipped
+// because an event with their ID already exists
+type importEventsResult struct {
+	Imported int
+	Skipped  int
+}
+
+// importEvents reads events written by exportEvents from r and inserts
+// them, batchSize per transaction, keeping their IDs, status and
+// timestamps. An event whose ID is already in the table is skipped, so an
+// import can be run again after it was interrupted. A line that isn't a
+// valid event stops the import; the batches before it stay stored.
+func importEvents(ctx context.Context, store Store, r io.Reader, batchSize int, dbTimeout time.Duration) (importEventsResult, error) {
+	var result importEventsResult
+	decoder := json.NewDecoder(r)
+
+	var pending []db.ImportEventParams
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		imported, err := storeImportedEvents(ctx, store, pending, dbTimeout)
+		if err != nil {
+			return err
+		}
+		result.Imported += imported
+		result.Skipped += len(pending) - imported
+		pending = pending[:0]
+		return nil
+	}
+
+	for line := 1; ctx.Err() == nil; line++ {
+		var event exportedEvent
+		err := decoder.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("error reading event %d: %v", line, err)
+		}
+		if event.ID == "" || event.BusinessID == "" || event.EventType == "" {
+			return result, fmt.Errorf("event %d needs an id, business_id and event_type", line)
+		}
+
+		pending = append(pending, event.importParams())
+		if len(pending) == batchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	return result, flush()
+}
+
+// storeImportedEvents inserts a batch of events in a single transaction and
+// returns how many were new
+func storeImportedEvents(ctx context.Context, store Store, events []db.ImportEventParams, dbTimeout time.Duration) (int, error) {
+	ctx, cancel := dbContext(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := store.Begin(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for _, event := range events {
+		inserted, err := tx.ImportEvent(ctx, event)
+		if err != nil {
+			return 0, fmt.Errorf("error importing event %s: %v", event.ID, err)
+		}
+		imported += int(inserted)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return imported, nil
+}
+
+// runExport exports the events matching filter to the file at path, or to
+// stdout when path is empty or "-". The count goes to stderr so it doesn't
+// end up among the events.
+func runExport(ctx context.Context, queries Querier, path string, filter exportFilter, dbTimeout time.Duration) error {
+	w := io.Writer(os.Stdout)
+	if path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("error creating export file: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	exported, err := exportEvents(ctx, queries, w, filter, exportBatchSize, dbTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d events\n", exported)
+	return nil
+}
+
+// runImport imports the events of the JSON lines file at path, or of stdin
+// when path is "-"
+func runImport(ctx context.Context, store Store, path string, batchSize int, dbTimeout time.Duration) error {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening import file: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	result, err := importEvents(ctx, store, r, batchSize, dbTimeout)
+	fmt.Printf("Imported %d events, skipped %d that already exist\n", result.Imported, result.Skipped)
+	return err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source, _ := newTestStore(t)
+	seedInvoices(t, source, 7, testIngestOptions(t))
+
+	// Give the events a mix of states, so every column is carried over
+	events, err := source.ListEvents(ctx)
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if err := source.MarkEventsAsProcessed(ctx, []string{events[0].ID, events[1].ID}); err != nil {
+		t.Fatalf("marking events processed: %v", err)
+	}
+	err = source.MarkEventAsProcessedWithDeliveryID(ctx, db.MarkEventAsProcessedWithDeliveryIDParams{
+		DeliveryID: sql.NullString{String: "dlv_1", Valid: true},
+		ID:         events[2].ID,
+	})
+	if err != nil {
+		t.Fatalf("marking event processed: %v", err)
+	}
+	err = source.IncrementEventRetry(ctx, db.IncrementEventRetryParams{
+		LastError:   sql.NullString{String: "sink unavailable", Valid: true},
+		NextRetryAt: sql.NullTime{Time: time.Now().Add(time.Hour).UTC(), Valid: true},
+		ID:          events[3].ID,
+	})
+	if err != nil {
+		t.Fatalf("scheduling a retry: %v", err)
+	}
+
+	// A batch smaller than the table makes the export page through it
+	var exported bytes.Buffer
+	count, err := exportEvents(ctx, source, &exported, exportFilter{}, 3, time.Second)
+	if err != nil {
+		t.Fatalf("exporting events: %v", err)
+	}
+	if count != len(events) || strings.Count(exported.String(), "\n") != len(events) {
+		t.Fatalf("exported %d events in %d lines, want %d", count, strings.Count(exported.String(), "\n"), len(events))
+	}
+
+	target, _ := newTestStore(t)
+	result, err := importEvents(ctx, target, bytes.NewReader(exported.Bytes()), 2, time.Second)
+	if err != nil {
+		t.Fatalf("importing events: %v", err)
+	}
+	if result.Imported != len(events) || result.Skipped != 0 {
+		t.Errorf("imported %d and skipped %d events, want %d imported", result.Imported, result.Skipped, len(events))
+	}
+
+	var reexported bytes.Buffer
+	if _, err := exportEvents(ctx, target, &reexported, exportFilter{}, 3, time.Second); err != nil {
+		t.Fatalf("exporting imported events: %v", err)
+	}
+	if reexported.String() != exported.String() {
+		t.Errorf("imported events export as\n%s\nwant\n%s", reexported.String(), exported.String())
+	}
+
+	// Importing again changes nothing
+	result, err = importEvents(ctx, target, bytes.NewReader(exported.Bytes()), 2, time.Second)
+	if err != nil {
+		t.Fatalf("importing events again: %v", err)
+	}
+	if result.Imported != 0 || result.Skipped != len(events) {
+		t.Errorf("imported %d and skipped %d events the second time, want all %d skipped", result.Imported, result.Skipped, len(events))
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 6, testIngestOptions(t))
+	events, err := store.ListEvents(ctx)
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if err := store.MarkEventsAsProcessed(ctx, []string{events[0].ID}); err != nil {
+		t.Fatalf("marking event processed: %v", err)
+	}
+	business := events[0].BusinessID
+
+	for _, tc := range []struct {
+		name   string
+		filter exportFilter
+		want   func(db.Event) bool
+	}{
+		{"status", exportFilter{Status: "processed"}, func(e db.Event) bool { return e.ID == events[0].ID }},
+		{"business", exportFilter{BusinessID: business}, func(e db.Event) bool { return e.BusinessID == business }},
+		{"since", exportFilter{Since: time.Now().Add(time.Hour)}, func(db.Event) bool { return false }},
+		{"until", exportFilter{Until: time.Now().Add(time.Hour)}, func(db.Event) bool { return true }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want := 0
+			for _, event := range events {
+				if tc.want(event) {
+					want++
+				}
+			}
+			var out bytes.Buffer
+			count, err := exportEvents(ctx, store, &out, tc.filter, exportBatchSize, time.Second)
+			if err != nil {
+				t.Fatalf("exporting events: %v", err)
+			}
+			if count != want {
+				t.Errorf("exported %d events, want %d", count, want)
+			}
+		})
+	}
+}
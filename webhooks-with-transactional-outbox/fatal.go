@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// fatalCounter counts the fatal errors in a row of a loop that otherwise
+// logs its errors and tries again, so that it gives up once retrying
+// plainly isn't going to help, letting the process exit non-zero rather
+// than log the same error forever
+type fatalCounter struct {
+	// limit is how many fatal errors in a row are given up on (never when 0)
+	limit int
+	count int
+}
+
+// record notes the outcome of an attempt, err nil for a success, and
+// returns the error to give up with once limit fatal errors have come in a
+// row. A success or a transient error starts the count again.
+func (c *fatalCounter) record(err error) error {
+	if !isFatalError(err) {
+		c.count = 0
+		return nil
+	}
+	c.count++
+	if c.limit > 0 && c.count >= c.limit {
+		return fmt.Errorf("giving up after %d fatal errors in a row: %v", c.count, err)
+	}
+	return nil
+}
+
+// sinkAuthError reports that the sink refused the worker's credentials with
+// a 401 or 403. It isn't the event's fault, so the event is left pending
+// rather than dead-lettered, but nor will retrying help until the
+// credentials are fixed, so it counts as fatal.
+type sinkAuthError struct {
+	status int
+	err    error
+}
+
+func (e sinkAuthError) Error() string {
+	return e.err.Error()
+}
+
+// isSinkAuthStatus reports whether status is the sink refusing credentials
+func isSinkAuthStatus(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// refusal holds the sinkAuthError a batch last failed on, if any, for the
+// worker loop to count once per batch. A nil *refusal records nothing.
+type refusal struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (r *refusal) set(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// refused reports whether an error is held
+func (r *refusal) refused() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err != nil
+}
+
+// take returns the error held and clears it
+func (r *refusal) take() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.err
+	r.err = nil
+	return err
+}
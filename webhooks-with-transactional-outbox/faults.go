@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errInjectedFailure is the error faultyPublisher fails a delivery with. It
+// is an ordinary failure, so the event is retried and eventually
+// dead-lettered like one the publisher really failed.
+var errInjectedFailure = errors.New("injected failure (--fail-rate)")
+
+// faultyPublisher makes the publisher it wraps look like a flaky, slow
+// downstream, for testing only: every call waits latency first and then
+// fails with probability failRate instead of being delivered. It lets the
+// retries, the dead-letter queue and the circuit breaker be seen at work
+// without an endpoint that really fails.
+type faultyPublisher struct {
+	next     Publisher
+	failRate float64
+	latency  time.Duration
+}
+
+func (p *faultyPublisher) String() string {
+	return fmt.Sprint(p.next)
+}
+
+func (p *faultyPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	if p.latency > 0 && !sleepContext(ctx, p.latency) {
+		return "", ctx.Err()
+	}
+	if p.failRate > 0 && random.Float64() < p.failRate {
+		return "", errInjectedFailure
+	}
+	return p.next.Publish(ctx, event)
+}
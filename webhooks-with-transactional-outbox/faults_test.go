@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFaultyPublisherFailRate(t *testing.T) {
+	const calls = 10000
+	for _, rate := range []float64{0, 0.1, 0.5, 1} {
+		next := &fakePublisher{}
+		publisher := &faultyPublisher{next: next, failRate: rate}
+
+		failed := 0
+		for i := 0; i < calls; i++ {
+			_, err := publisher.Publish(context.Background(), &outboundEvent{Payload: []byte("{}")})
+			switch {
+			case errors.Is(err, errInjectedFailure):
+				failed++
+			case err != nil:
+				t.Fatalf("publishing: %v", err)
+			}
+		}
+
+		// Ten thousand draws keep the observed rate well within two
+		// points of the configured one
+		observed := float64(failed) / calls
+		if observed < rate-0.02 || observed > rate+0.02 {
+			t.Errorf("--fail-rate %g failed %.3f of the calls", rate, observed)
+		}
+		if len(next.published)+failed != calls {
+			t.Errorf("--fail-rate %g: %d delivered and %d failed, want %d in all", rate, len(next.published), failed, calls)
+		}
+	}
+}
+
+func TestFaultyPublisherLatency(t *testing.T) {
+	publisher := &faultyPublisher{next: &fakePublisher{}, latency: 50 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := publisher.Publish(context.Background(), &outboundEvent{Payload: []byte("{}")}); err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("delivery took %v, want at least --fail-latency", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := publisher.Publish(ctx, &outboundEvent{Payload: []byte("{}")}); !errors.Is(err, context.Canceled) {
+		t.Errorf("publishing with a cancelled context returned %v, want context.Canceled", err)
+	}
+}
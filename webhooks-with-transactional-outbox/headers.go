@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// headerBusinessID and headerTraceID are added to the headers of every
+	// event at ingest, so consumers can route and trace a delivery without
+	// parsing its payload
+	headerBusinessID = "X-Business-ID"
+	headerTraceID    = "X-Trace-ID"
+)
+
+// eventHeaders returns the headers event is stored and delivered with: its
+// own, plus the business and a trace ID unless it already has them. When
+// ctx is in a span, its traceparent is added too and the trace ID is the
+// span's, otherwise a new one.
+func eventHeaders(ctx context.Context, event Event) map[string]string {
+	headers := make(map[string]string, len(event.Headers)+3)
+	for name, value := range event.Headers {
+		headers[name] = value
+	}
+	if _, ok := headers[headerBusinessID]; !ok {
+		headers[headerBusinessID] = event.BusinessID
+	}
+	span := trace.SpanContextFromContext(ctx)
+	if _, ok := headers[headerTraceparent]; !ok && span.IsValid() {
+		tracePropagator.Inject(ctx, propagation.MapCarrier(headers))
+	}
+	if _, ok := headers[headerTraceID]; !ok {
+		if span.IsValid() {
+			headers[headerTraceID] = span.TraceID().String()
+		} else {
+			headers[headerTraceID] = newTraceID()
+		}
+	}
+	return headers
+}
+
+// newTraceID returns a random trace ID in the 32 hex digit form of W3C
+// Trace Context
+func newTraceID() string {
+	id := make([]byte, 16)
+	// random is a math/rand source, whose reads never fail
+	random.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// encodeHeaders returns headers as stored in the headers column, NULL when
+// there are none
+func encodeHeaders(headers map[string]string) (sql.NullString, error) {
+	if len(headers) == 0 {
+		return sql.NullString{}, nil
+	}
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("error encoding headers: %v", err)
+	}
+	return sql.NullString{String: string(encoded), Valid: true}, nil
+}
+
+// decodeHeaders reads back the headers column of an event
+func decodeHeaders(stored sql.NullString) (map[string]string, error) {
+	if !stored.Valid || stored.String == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(stored.String), &headers); err != nil {
+		return nil, fmt.Errorf("error decoding headers: %v", err)
+	}
+	return headers, nil
+}
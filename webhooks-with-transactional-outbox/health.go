@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// liveness records when the worker loop last went round, so /healthz can
+// tell a loop that is stuck from one that is waiting for events
+type liveness struct {
+	last    atomic.Int64
+	timeout time.Duration
+}
+
+func newLiveness(timeout time.Duration) *liveness {
+	l := &liveness{timeout: timeout}
+	l.beat()
+	return l
+}
+
+// beat records that the loop is alive
+func (l *liveness) beat() {
+	l.last.Store(time.Now().UnixNano())
+}
+
+// since returns how long ago the loop last went round, and whether that is
+// within the timeout
+func (l *liveness) since() (time.Duration, bool) {
+	since := time.Since(time.Unix(0, l.last.Load()))
+	return since, since <= l.timeout
+}
+
+// healthResponse is the body of /healthz and /readyz
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// metricsHandler serves the Prometheus metrics at /metrics, the worker's
+// liveness at /healthz, reporting a paused worker as such, and its readiness
+// at /readyz, which pings the database. With withPprof the runtime profiles
+// are served under /debug/pprof/ as well.
+func metricsHandler(store Store, live *liveness, pause *pauseSwitch, dbTimeout time.Duration, withPprof bool) http.Handler {
+	mux := http.NewServeMux()
+	// OpenMetrics is served to scrapers asking for it, as only it carries
+	// the delivery latency exemplars
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		since, ok := live.since()
+		if !ok {
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{
+				Status: "stuck",
+				Error:  "worker loop last ran " + since.Truncate(time.Second).String() + " ago",
+			})
+			return
+		}
+		// A paused worker is alive, it just isn't sending
+		if pause.isPaused() {
+			writeJSON(w, http.StatusOK, healthResponse{Status: "paused"})
+			return
+		}
+		writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := dbContext(r.Context(), dbTimeout)
+		defer cancel()
+		if err := store.Ping(ctx); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+	})
+
+	if withPprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	handler := metricsHandler(store, newLiveness(time.Minute), nil, time.Second, false)
+
+	get := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Errorf("/readyz returned %d with a working database, want 200", code)
+	}
+	dbConn.Close()
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz returned %d with a closed database, want 503", code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	store, _ := newTestStore(t)
+	live := newLiveness(time.Minute)
+	handler := metricsHandler(store, live, nil, time.Second, false)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz returned %d right after a round of the loop, want 200", code)
+	}
+	live.last.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if code := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz returned %d for a loop stuck for 2m, want 503", code)
+	}
+
+	// The profiles are only served with --pprof
+	if code := get("/debug/pprof/"); code != http.StatusNotFound {
+		t.Errorf("/debug/pprof/ returned %d without --pprof, want 404", code)
+	}
+	handler = metricsHandler(store, live, nil, time.Second, true)
+	if code := get("/debug/pprof/"); code != http.StatusOK {
+		t.Errorf("/debug/pprof/ returned %d with --pprof, want 200", code)
+	}
+}
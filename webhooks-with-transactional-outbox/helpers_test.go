@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The worker and ingest log every event, which would bury the test
+	// output
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testDBConfig returns the settings of a SQLite database in a temporary
+// directory removed when tb finishes
+func testDBConfig(tb testing.TB) dbConfig {
+	tb.Helper()
+	return dbConfig{
+		Driver:            driverSQLite,
+		Path:              filepath.Join(tb.TempDir(), "test.db"),
+		SQLiteWAL:         true,
+		SQLiteBusyTimeout: 5 * time.Second,
+	}
+}
+
+// newTestStore opens a fresh temporary SQLite database with the schema
+// applied and the businesses seeded, returning its store and connection,
+// closed when tb finishes
+func newTestStore(tb testing.TB) (Store, *sql.DB) {
+	tb.Helper()
+	cfg := testDBConfig(tb)
+	store, err := openStore(cfg)
+	if err != nil {
+		tb.Fatalf("opening database: %v", err)
+	}
+	tb.Cleanup(func() { store.Close() })
+	dbConn := store.conn()
+
+	if _, err := dbConn.Exec(cfg.schema()); err != nil {
+		tb.Fatalf("applying schema: %v", err)
+	}
+	if err := seedDatabase(context.Background(), store); err != nil {
+		tb.Fatalf("seeding businesses: %v", err)
+	}
+	return store, dbConn
+}
+
+// testIngestOptions returns the options ingest stores invoices with by
+// default: JSON payloads with just their invoice.created event
+func testIngestOptions(tb testing.TB) ingestOptions {
+	tb.Helper()
+	mapper, err := buildEventMapper(nil)
+	if err != nil {
+		tb.Fatalf("building event mapper: %v", err)
+	}
+	codec, err := getPayloadCodec(codecJSON, "", "")
+	if err != nil {
+		tb.Fatalf("getting codec: %v", err)
+	}
+	return ingestOptions{
+		Queue:  defaultQueue,
+		Mapper: mapper,
+		Codec:  codec,
+	}
+}
+
+// seedInvoices stores count generated invoices with their events, returning
+// the events stored
+func seedInvoices(tb testing.TB, store Store, count int, opts ingestOptions) []Event {
+	tb.Helper()
+	var events []Event
+	for i := 0; i < count; i++ {
+		invoice := generateInvoice(getRandomBusinessID(businessIDs))
+		created, err := createInvoiceWithEvents(context.Background(), store, invoice, opts)
+		if err != nil {
+			tb.Fatalf("storing invoice %d: %v", i, err)
+		}
+		events = append(events, created...)
+	}
+	return events
+}
+
+// testWorkerOptions returns the options of a worker polling the default
+// queue of a test database
+func testWorkerOptions() workerOptions {
+	return workerOptions{
+		Driver:           driverSQLite,
+		WorkerID:         "test",
+		Queue:            defaultQueue,
+		PollInterval:     10 * time.Millisecond,
+		DispatchMode:     dispatchPool,
+		Concurrency:      4,
+		PerBusinessLimit: 5,
+		BatchSize:        defaultBatchSize,
+
+		Retry: retryPolicy{MaxRetries: 10, BaseDelay: 5 * time.Second, MaxDelay: 10 * time.Minute},
+
+		VisibilityTimeout: 5 * time.Minute,
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+)
+
+// runTestIdempotency sends the same fanout event to Convoy twice with one
+// idempotency key and reports how many events Convoy kept for that key
+func runTestIdempotency(ctx context.Context, convoyClient *convoy.Client, businessID, idempotencyKey string, settle time.Duration) error {
+	invoice := generateInvoice(businessID)
+	event, err := newEvent(businessID, "invoice.created", invoice)
+	if err != nil {
+		return fmt.Errorf("error building event: %v", err)
+	}
+
+	if idempotencyKey == "" {
+		idempotencyKey = fmt.Sprintf("idempotency-test-%d", time.Now().UnixNano())
+	}
+
+	fanoutEvent := &convoy.CreateFanoutEventRequest{
+		EventType:      event.Type,
+		OwnerID:        businessID,
+		IdempotencyKey: idempotencyKey,
+		Data:           json.RawMessage(event.Payload),
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := convoyClient.Events.FanoutEvent(ctx, fanoutEvent); err != nil {
+			return fmt.Errorf("error sending attempt %d to Convoy: %v", attempt, err)
+		}
+		fmt.Printf("Sent attempt %d with idempotency key %s\n", attempt, idempotencyKey)
+	}
+
+	// Convoy ingests events asynchronously, give it a moment before looking them up
+	if !sleepContext(ctx, settle) {
+		return ctx.Err()
+	}
+
+	response, err := convoyClient.Events.All(ctx, &convoy.EventParams{
+		IdempotencyKey: idempotencyKey,
+		StartDate:      time.Now().Add(-time.Hour),
+		EndDate:        time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		return fmt.Errorf("error listing events from Convoy: %v", err)
+	}
+
+	found := len(response.Content)
+	for _, e := range response.Content {
+		fmt.Printf("Convoy event %s (%s) created at %s\n", e.UID, e.EventType, e.CreatedAt.Format(time.RFC3339))
+	}
+
+	switch found {
+	case 0:
+		fmt.Printf("Convoy has no events for key %s yet, try again with a longer --settle\n", idempotencyKey)
+	case 1:
+		fmt.Println("Result: deduplicated, Convoy kept a single event for both sends")
+	default:
+		fmt.Printf("Result: not deduplicated, Convoy kept %d events for the same key\n", found)
+	}
+	return nil
+}
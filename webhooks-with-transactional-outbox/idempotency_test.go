@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+)
+
+func TestTestIdempotencyStopsOnCancel(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(convoy.APIResponse{Status: true, Message: "Event created"})
+	}))
+	defer server.Close()
+	client := convoyConfig{BaseURL: server.URL, APIKey: "key", ProjectID: "project"}.client()
+
+	// Cancelled while waiting for Convoy to settle, the events aren't
+	// looked up
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := runTestIdempotency(ctx, client, businessIDs[0], "key-1", time.Minute)
+	if err == nil || time.Since(start) > 10*time.Second {
+		t.Errorf("got %v after %v, want the cancelled context to stop the wait", err, time.Since(start))
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Convoy got %d requests, want only the two sends", got)
+	}
+
+	// Already cancelled, nothing is sent
+	requests.Store(0)
+	if err := runTestIdempotency(ctx, client, businessIDs[0], "key-2", time.Minute); err == nil {
+		t.Error("running with a cancelled context succeeded, want an error")
+	}
+	if got := requests.Load(); got != 0 {
+		t.Errorf("Convoy got %d requests with a cancelled context, want none", got)
+	}
+}
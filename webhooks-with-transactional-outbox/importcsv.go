@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// csvColumns are the columns import-csv expects in the header row, in any
+// order. description may be left empty.
+var csvColumns = []string{"business_id", "amount", "currency", "status", "description"}
+
+// importFailure is a CSV row that couldn't be imported, by its line number
+type importFailure struct {
+	Line int
+	Err  error
+}
+
+// importResult counts the rows import-csv stored and lists those it didn't
+type importResult struct {
+	Imported int
+	Failures []importFailure
+}
+
+// pendingRow is a parsed invoice waiting for its batch to be stored
+type pendingRow struct {
+	line    int
+	invoice Invoice
+}
+
+// importInvoices reads invoices from a CSV and stores each with its events,
+// batch rows per transaction. A row that doesn't parse or validate is
+// recorded as a failure and skipped. A batch the database rejects is rolled
+// back and all its rows fail, since none of them were written.
+func importInvoices(ctx context.Context, store Store, r io.Reader, batch int, opts ingestOptions) (importResult, error) {
+	var result importResult
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return result, fmt.Errorf("error reading CSV header: %v", err)
+	}
+	columns, err := csvColumnIndexes(header)
+	if err != nil {
+		return result, err
+	}
+
+	var pending []pendingRow
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := storeInvoiceBatch(ctx, store, pending, opts); err != nil {
+			for _, row := range pending {
+				result.Failures = append(result.Failures, importFailure{row.line, err})
+			}
+		} else {
+			result.Imported += len(pending)
+		}
+		pending = pending[:0]
+	}
+
+	for ctx.Err() == nil {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			result.Failures = append(result.Failures, importFailure{parseErr.Line, err})
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("error reading CSV: %v", err)
+		}
+
+		invoice, err := invoiceFromCSV(record, columns)
+		if err == nil && opts.ValidateSchema {
+			err = validateInvoiceSchema(invoice)
+		}
+		if err == nil {
+			err = validateInvoice(invoice)
+		}
+		if err != nil {
+			result.Failures = append(result.Failures, importFailure{line, err})
+			continue
+		}
+
+		pending = append(pending, pendingRow{line, invoice})
+		if len(pending) == batch {
+			flush()
+		}
+	}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	flush()
+	return result, nil
+}
+
+// csvColumnIndexes finds each of csvColumns in the header row
+func csvColumnIndexes(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	var missing []string
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("CSV header is missing columns: %s", strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+// invoiceFromCSV builds an invoice from a CSV record, giving it a new ID
+func invoiceFromCSV(record []string, columns map[string]int) (Invoice, error) {
+	field := func(name string) string {
+		return strings.TrimSpace(record[columns[name]])
+	}
+
+	amount, err := parseAmount(field("amount"), field("currency"))
+	if err != nil {
+		return Invoice{}, err
+	}
+	return Invoice{
+		ID:          "INV-" + newInvoiceUUID(),
+		BusinessID:  field("business_id"),
+		AmountCents: amount,
+		Currency:    field("currency"),
+		Status:      field("status"),
+		CreatedAt:   time.Now(),
+		Description: field("description"),
+	}, nil
+}
+
+// storeInvoiceBatch stores a batch of invoices with their events in a single
+// transaction
+func storeInvoiceBatch(ctx context.Context, store Store, rows []pendingRow, opts ingestOptions) error {
+	ctx, cancel := dbContext(ctx, opts.DBTimeout)
+	defer cancel()
+
+	tx, err := store.Begin(ctx, opts.txOptions())
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, row := range rows {
+		if _, err := insertInvoiceWithEvents(ctx, tx, row.invoice, opts); err != nil {
+			return fmt.Errorf("error storing line %d: %v", row.line, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// runImportCSV imports the invoices of the CSV at path and reports how many
+// rows were stored and why the others weren't
+func runImportCSV(ctx context.Context, store Store, path string, batch int, opts ingestOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening CSV: %v", err)
+	}
+	defer f.Close()
+
+	result, err := importInvoices(ctx, store, f, batch, opts)
+	for _, failure := range result.Failures {
+		fmt.Printf("Line %d failed: %v\n", failure.Line, failure.Err)
+	}
+	fmt.Printf("Imported %d invoices, %d rows failed\n", result.Imported, len(result.Failures))
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImportInvoices(t *testing.T) {
+	sample := "business_id,amount,currency,status,description\n" +
+		businessIDs[0] + ",120.50,USD,sent,Consulting\n" +
+		businessIDs[1] + ",-5,XYZ,sent,Refund\n"
+
+	for _, batch := range []int{1, 10} {
+		store, dbConn := newTestStore(t)
+		result, err := importInvoices(context.Background(), store, strings.NewReader(sample), batch, testIngestOptions(t))
+		if err != nil {
+			t.Fatalf("batch %d: importing: %v", batch, err)
+		}
+
+		if result.Imported != 1 {
+			t.Errorf("batch %d: imported %d rows, want the valid one", batch, result.Imported)
+		}
+		if len(result.Failures) != 1 {
+			t.Fatalf("batch %d: %d rows failed, want the invalid one", batch, len(result.Failures))
+		}
+		failure := result.Failures[0]
+		var invalid *invoiceValidationError
+		if failure.Line != 3 || !errors.As(failure.Err, &invalid) || len(invalid.Fields) != 2 {
+			t.Errorf("batch %d: line %d failed with %v, want line 3 with an invalid amount and currency", batch, failure.Line, failure.Err)
+		}
+
+		events, err := store.ListEvents(context.Background())
+		if err != nil {
+			t.Fatalf("listing events: %v", err)
+		}
+		if len(events) != 1 || events[0].EventType != "invoice.created" || events[0].BusinessID != businessIDs[0] {
+			t.Fatalf("batch %d: stored %d events, want the invoice.created of the valid row", batch, len(events))
+		}
+		var description string
+		if err := dbConn.QueryRow("SELECT description FROM invoices WHERE id = ?", events[0].AggregateID.String).Scan(&description); err != nil {
+			t.Fatalf("reading invoice: %v", err)
+		}
+		if description != "Consulting" {
+			t.Errorf("batch %d: stored invoice %q, want the Consulting one", batch, description)
+		}
+	}
+}
+
+func TestImportInvoicesMissingColumn(t *testing.T) {
+	store, _ := newTestStore(t)
+	sample := "business_id,amount,status\n" + businessIDs[0] + ",10,paid\n"
+	if _, err := importInvoices(context.Background(), store, strings.NewReader(sample), 1, testIngestOptions(t)); err == nil {
+		t.Errorf("importing a CSV without currency and description columns succeeded, want an error")
+	}
+}
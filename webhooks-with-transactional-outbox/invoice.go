@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// invoiceStatuses are the statuses an invoice can have, in lifecycle order
+var invoiceStatuses = []string{"draft", "sent", "paid", "overdue"}
+
+// invoiceCurrencies are the ISO 4217 codes an invoice may be raised in,
+// with the decimal places of their minor unit, which amounts are counted in
+var invoiceCurrencies = map[string]int{
+	"AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2,
+	"EUR": 2, "GBP": 2, "GHS": 2, "INR": 2, "JPY": 0,
+	"KES": 2, "NGN": 2, "NZD": 2, "SEK": 2, "USD": 2,
+	"ZAR": 2,
+}
+
+// invoiceFieldError is a single field of an invoice that failed validation
+type invoiceFieldError struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+}
+
+// invoiceValidationError lists every field that makes an invoice invalid,
+// rather than just the first
+type invoiceValidationError struct {
+	InvoiceID string
+	Fields    []invoiceFieldError
+}
+
+func (e *invoiceValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = field.Field + ": " + field.Problem
+	}
+	return fmt.Sprintf("invalid invoice %s: %s", e.InvoiceID, strings.Join(problems, "; "))
+}
+
+// duplicateInvoiceError is an invoice whose ID is already taken. Nothing
+// was written for it.
+type duplicateInvoiceError struct {
+	InvoiceID string
+}
+
+func (e *duplicateInvoiceError) Error() string {
+	return fmt.Sprintf("duplicate invoice %s: an invoice with this ID already exists", e.InvoiceID)
+}
+
+// validateInvoice checks an invoice before it is stored, returning an
+// *invoiceValidationError naming each offending field
+func validateInvoice(invoice Invoice) error {
+	var fields []invoiceFieldError
+	if invoice.AmountCents <= 0 {
+		fields = append(fields, invoiceFieldError{"amount_cents", fmt.Sprintf("%d is not positive", invoice.AmountCents)})
+	}
+	if _, ok := invoiceCurrencies[invoice.Currency]; !ok {
+		fields = append(fields, invoiceFieldError{"currency", fmt.Sprintf("%q is not a supported ISO 4217 code", invoice.Currency)})
+	}
+	if !isInvoiceStatus(invoice.Status) {
+		fields = append(fields, invoiceFieldError{"status", fmt.Sprintf("%q must be one of %s", invoice.Status, strings.Join(invoiceStatuses, ", "))})
+	}
+	if _, err := uuid.Parse(invoice.BusinessID); err != nil || len(invoice.BusinessID) != 36 {
+		fields = append(fields, invoiceFieldError{"business_id", fmt.Sprintf("%q is not a UUID", invoice.BusinessID)})
+	}
+
+	if len(fields) > 0 {
+		return &invoiceValidationError{InvoiceID: invoice.ID, Fields: fields}
+	}
+	return nil
+}
+
+// isInvoiceStatus reports whether status is one of invoiceStatuses
+func isInvoiceStatus(status string) bool {
+	for _, known := range invoiceStatuses {
+		if status == known {
+			return true
+		}
+	}
+	return false
+}
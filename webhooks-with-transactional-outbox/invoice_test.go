@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateInvoice(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Invoice)
+		fields []string
+	}{
+		{"valid", func(*Invoice) {}, nil},
+		{"zero amount", func(i *Invoice) { i.AmountCents = 0 }, []string{"amount_cents"}},
+		{"negative amount", func(i *Invoice) { i.AmountCents = -10 }, []string{"amount_cents"}},
+		{"unknown currency", func(i *Invoice) { i.Currency = "XYZ" }, []string{"currency"}},
+		{"lowercase currency", func(i *Invoice) { i.Currency = "usd" }, []string{"currency"}},
+		{"unknown status", func(i *Invoice) { i.Status = "void" }, []string{"status"}},
+		{"business not a UUID", func(i *Invoice) { i.BusinessID = "acme" }, []string{"business_id"}},
+		{"empty business", func(i *Invoice) { i.BusinessID = "" }, []string{"business_id"}},
+		{"every field", func(i *Invoice) {
+			i.AmountCents = 0
+			i.Currency = ""
+			i.Status = ""
+			i.BusinessID = "acme"
+		}, []string{"amount_cents", "currency", "status", "business_id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := generateInvoice(businessIDs[0])
+			tt.change(&invoice)
+
+			err := validateInvoice(invoice)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("validating a valid invoice: %v", err)
+				}
+				return
+			}
+
+			var invalid *invoiceValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got %v, want an *invoiceValidationError", err)
+			}
+			var fields []string
+			for _, field := range invalid.Fields {
+				fields = append(fields, field.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("offending fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestInvalidInvoiceNotStored(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	invoice := generateInvoice(businessIDs[0])
+	invoice.Currency = "XYZ"
+
+	if _, err := createInvoiceWithEvents(context.Background(), store, invoice, testIngestOptions(t)); err == nil {
+		t.Fatalf("storing an invalid invoice succeeded, want an error")
+	}
+
+	var invoices, events int
+	if err := dbConn.QueryRow("SELECT (SELECT COUNT(*) FROM invoices), (SELECT COUNT(*) FROM events)").Scan(&invoices, &events); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	if invoices != 0 || events != 0 {
+		t.Errorf("%d invoices and %d events stored, want none", invoices, events)
+	}
+}
+
+func TestDuplicateInvoiceRetried(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	opts := testIngestOptions(t)
+	invoice := generateInvoice(businessIDs[0])
+	if _, err := createInvoiceWithEvents(context.Background(), store, invoice, opts); err != nil {
+		t.Fatalf("storing the first invoice: %v", err)
+	}
+
+	// Storing the same ID again fails the primary key and nothing else
+	_, err := createInvoiceWithEvents(context.Background(), store, invoice, opts)
+	var duplicate *duplicateInvoiceError
+	if !errors.As(err, &duplicate) || duplicate.InvoiceID != invoice.ID {
+		t.Fatalf("got %v, want a *duplicateInvoiceError for %s", err, invoice.ID)
+	}
+
+	retried := invoice
+	events, err := storeGeneratedInvoice(context.Background(), store, &retried, opts)
+	if err != nil {
+		t.Fatalf("storing a generated invoice with a taken ID: %v", err)
+	}
+	if retried.ID == invoice.ID {
+		t.Errorf("invoice kept the taken ID %s, want a new one", invoice.ID)
+	}
+	if len(events) == 0 || !strings.Contains(string(events[0].Payload), retried.ID) {
+		t.Errorf("events weren't mapped from the new ID %s", retried.ID)
+	}
+
+	var invoices, stored int
+	if err := dbConn.QueryRow("SELECT (SELECT COUNT(*) FROM invoices), (SELECT COUNT(*) FROM events)").Scan(&invoices, &stored); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	if invoices != 2 || stored != 2*len(events) {
+		t.Errorf("%d invoices and %d events stored, want 2 and %d", invoices, stored, 2*len(events))
+	}
+}
+
+func TestInvoiceIDsDontCollide(t *testing.T) {
+	const producers, perProducer = 8, 100000 / 8
+
+	// Ingest producers draw IDs from the shared source at once
+	var wg sync.WaitGroup
+	ids := make([][]string, producers)
+	for p := range ids {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				ids[p] = append(ids[p], generateInvoice(businessIDs[0]).ID)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, producers*perProducer)
+	for _, produced := range ids {
+		for _, id := range produced {
+			if seen[id] {
+				t.Fatalf("invoice ID %s generated twice", id)
+			}
+			seen[id] = true
+			parsed, err := uuid.Parse(strings.TrimPrefix(id, "INV-"))
+			if !strings.HasPrefix(id, "INV-") || err != nil || parsed.Version() != 4 {
+				t.Fatalf("invoice ID %s isn't INV- and a random UUID", id)
+			}
+		}
+	}
+	if len(seen) != 100000 {
+		t.Errorf("generated %d IDs, want 100000", len(seen))
+	}
+}
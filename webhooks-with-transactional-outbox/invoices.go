@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// invoiceFilter is what the invoices list command narrows the invoices to.
+// An empty Status or BusinessID matches every invoice.
+type invoiceFilter struct {
+	Status     string
+	BusinessID string
+	Limit      int64
+	Offset     int64
+}
+
+// validate checks the filter before it is run, so a mistyped status is an
+// error rather than an empty list
+func (f invoiceFilter) validate() error {
+	if f.Status != "" && !isInvoiceStatus(f.Status) {
+		return fmt.Errorf("invalid --status %q: must be one of %s", f.Status, strings.Join(invoiceStatuses, ", "))
+	}
+	if f.Limit <= 0 {
+		return fmt.Errorf("--limit must be positive, got %d", f.Limit)
+	}
+	if f.Offset < 0 {
+		return fmt.Errorf("--offset must not be negative, got %d", f.Offset)
+	}
+	return nil
+}
+
+// listInvoices returns the invoices matching filter, newest first
+func listInvoices(ctx context.Context, queries Querier, filter invoiceFilter) ([]db.Invoice, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	invoices, err := queries.ListInvoices(ctx, db.ListInvoicesParams{
+		Status:     filter.Status,
+		BusinessID: filter.BusinessID,
+		RowLimit:   filter.Limit,
+		RowOffset:  filter.Offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing invoices: %v", err)
+	}
+	return invoices, nil
+}
+
+func runInvoicesList(ctx context.Context, queries Querier, filter invoiceFilter) error {
+	invoices, err := listInvoices(ctx, queries, filter)
+	if err != nil {
+		return err
+	}
+	if len(invoices) == 0 {
+		fmt.Println("No invoices found.")
+		return nil
+	}
+
+	fmt.Printf("%-40s  %-36s  %12s  %-8s  %-8s  %s\n", "ID", "BUSINESS ID", "AMOUNT", "CURRENCY", "STATUS", "CREATED AT")
+	for _, invoice := range invoices {
+		fmt.Printf("%-40s  %-36s  %12s  %-8s  %-8s  %s\n", invoice.ID, invoice.BusinessID, formatAmount(invoice.AmountCents, invoice.Currency), invoice.Currency, invoice.Status, invoiceCreatedAt(invoice))
+	}
+	return nil
+}
+
+func runInvoicesGet(ctx context.Context, queries Querier, invoiceID string) error {
+	invoice, err := queries.GetInvoice(ctx, invoiceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no invoice found with ID %s", invoiceID)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading invoice: %v", err)
+	}
+
+	description := "-"
+	if invoice.Description.Valid && invoice.Description.String != "" {
+		description = invoice.Description.String
+	}
+	fmt.Printf("Invoice ID:   %s\n", invoice.ID)
+	fmt.Printf("Business ID:  %s\n", invoice.BusinessID)
+	fmt.Printf("Amount:       %s %s\n", formatAmount(invoice.AmountCents, invoice.Currency), invoice.Currency)
+	fmt.Printf("Status:       %s\n", invoice.Status)
+	fmt.Printf("Description:  %s\n", description)
+	fmt.Printf("Created at:   %s\n", invoiceCreatedAt(invoice))
+	return nil
+}
+
+func invoiceCreatedAt(invoice db.Invoice) string {
+	if !invoice.CreatedAt.Valid {
+		return "-"
+	}
+	return invoice.CreatedAt.Time.UTC().Format(time.RFC3339)
+}
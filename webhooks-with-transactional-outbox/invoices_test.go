@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestListInvoices(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+
+	// Stored in the same second, so the newest first order falls back to
+	// the IDs
+	seeded := []struct{ id, businessID, status string }{
+		{"INV-1", businessIDs[0], "draft"},
+		{"INV-2", businessIDs[0], "paid"},
+		{"INV-3", businessIDs[1], "paid"},
+		{"INV-4", businessIDs[1], "sent"},
+		{"INV-5", businessIDs[0], "paid"},
+	}
+	for _, invoice := range seeded {
+		_, err := store.CreateInvoice(ctx, db.CreateInvoiceParams{
+			ID:          invoice.id,
+			BusinessID:  invoice.businessID,
+			AmountCents: 1999,
+			Currency:    "USD",
+			Status:      invoice.status,
+		})
+		if err != nil {
+			t.Fatalf("storing %s: %v", invoice.id, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter invoiceFilter
+		want   []string
+	}{
+		{"all", invoiceFilter{Limit: 10}, []string{"INV-5", "INV-4", "INV-3", "INV-2", "INV-1"}},
+		{"status", invoiceFilter{Status: "paid", Limit: 10}, []string{"INV-5", "INV-3", "INV-2"}},
+		{"business", invoiceFilter{BusinessID: businessIDs[1], Limit: 10}, []string{"INV-4", "INV-3"}},
+		{"status and business", invoiceFilter{Status: "paid", BusinessID: businessIDs[0], Limit: 10}, []string{"INV-5", "INV-2"}},
+		{"limit", invoiceFilter{Limit: 2}, []string{"INV-5", "INV-4"}},
+		{"offset", invoiceFilter{Status: "paid", Limit: 2, Offset: 2}, []string{"INV-2"}},
+		{"no match", invoiceFilter{Status: "overdue", Limit: 10}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoices, err := listInvoices(ctx, store, tt.filter)
+			if err != nil {
+				t.Fatalf("listing invoices: %v", err)
+			}
+			var ids []string
+			for _, invoice := range invoices {
+				ids = append(ids, invoice.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("listed %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	for _, filter := range []invoiceFilter{{Status: "void", Limit: 10}, {Limit: 0}, {Limit: 10, Offset: -1}} {
+		if _, err := listInvoices(ctx, store, filter); err == nil {
+			t.Errorf("listing with %+v succeeded, want an error", filter)
+		}
+	}
+
+	invoice, err := store.GetInvoice(ctx, "INV-3")
+	if err != nil {
+		t.Fatalf("getting invoice: %v", err)
+	}
+	if invoice.BusinessID != businessIDs[1] || invoice.Status != "paid" || invoice.AmountCents != 1999 {
+		t.Errorf("got %+v, want INV-3 as stored", invoice)
+	}
+	if err := runInvoicesGet(ctx, store, "INV-404"); err == nil {
+		t.Errorf("getting a missing invoice succeeded, want an error")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// invoiceSchemaJSON is the JSON Schema an invoice must conform to with
+// --validate-schema, published alongside the code as the contract of the
+// invoice payload
+//
+//go:embed invoice.schema.json
+var invoiceSchemaJSON []byte
+
+// invoiceSchema is invoiceSchemaJSON compiled once at startup
+var invoiceSchema = mustCompileSchema(invoiceSchemaJSON)
+
+// jsonSchema is the subset of JSON Schema the invoice schema is written in.
+// A keyword outside it fails compileSchema, so the schema can't silently
+// ask for a check that isn't made.
+type jsonSchema struct {
+	Schema      string `json:"$schema"`
+	ID          string `json:"$id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	Type                 string                 `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Enum                 []any                  `json:"enum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	Maximum              *float64               `json:"maximum"`
+	Format               string                 `json:"format"`
+
+	pattern *regexp.Regexp
+}
+
+// compileSchema parses a schema and compiles its patterns
+func compileSchema(data []byte) (*jsonSchema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var schema jsonSchema
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("error reading JSON schema: %v", err)
+	}
+	if err := schema.compile(""); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func mustCompileSchema(data []byte) *jsonSchema {
+	schema, err := compileSchema(data)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func (s *jsonSchema) compile(path string) error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("unsupported type %q in JSON schema at %s", s.Type, schemaPath(path))
+	}
+	switch s.Format {
+	case "", "date-time":
+	default:
+		return fmt.Errorf("unsupported format %q in JSON schema at %s", s.Format, schemaPath(path))
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern in JSON schema at %s: %v", schemaPath(path), err)
+		}
+		s.pattern = pattern
+	}
+	for name, property := range s.Properties {
+		if err := property.compile(joinSchemaPath(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks value, as decoded by encoding/json, against the schema and
+// returns a field error for each violation, the field named by its path
+// from the root
+func (s *jsonSchema) validate(path string, value any) []invoiceFieldError {
+	fail := func(format string, args ...any) []invoiceFieldError {
+		return []invoiceFieldError{{schemaPath(path), fmt.Sprintf(format, args...)}}
+	}
+
+	if s.Type != "" && !isSchemaType(value, s.Type) {
+		return fail("must be of type %s, got %s", s.Type, schemaTypeOf(value))
+	}
+	if len(s.Enum) > 0 && !schemaEnumHas(s.Enum, value) {
+		return fail("%s must be one of %s", schemaValue(value), schemaEnumString(s.Enum))
+	}
+
+	var fields []invoiceFieldError
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fields = append(fields, fail("must be at least %d characters long, got %d", *s.MinLength, length)...)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fields = append(fields, fail("must be at most %d characters long, got %d", *s.MaxLength, length)...)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fields = append(fields, fail("%q doesn't match %s", v, s.Pattern)...)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				fields = append(fields, fail("%q is not an RFC 3339 date-time", v)...)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fields = append(fields, fail("%v is less than %v", v, *s.Minimum)...)
+		}
+		if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+			fields = append(fields, fail("%v must be greater than %v", v, *s.ExclusiveMinimum)...)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fields = append(fields, fail("%v is greater than %v", v, *s.Maximum)...)
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fields = append(fields, invoiceFieldError{joinSchemaPath(path, name), "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			switch {
+			case ok:
+				fields = append(fields, property.validate(joinSchemaPath(path, name), v[name])...)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				fields = append(fields, invoiceFieldError{joinSchemaPath(path, name), "is not allowed"})
+			}
+		}
+	}
+	return fields
+}
+
+// validateInvoiceSchema checks an invoice, as encoded in its payload,
+// against invoiceSchema, returning an *invoiceValidationError naming each
+// offending field
+func validateInvoiceSchema(invoice Invoice) error {
+	encoded, err := json.Marshal(invoice)
+	if err != nil {
+		return fmt.Errorf("error encoding invoice %s: %v", invoice.ID, err)
+	}
+	var document any
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return fmt.Errorf("error decoding invoice %s: %v", invoice.ID, err)
+	}
+
+	if fields := invoiceSchema.validate("", document); len(fields) > 0 {
+		return &invoiceValidationError{InvoiceID: invoice.ID, Fields: fields}
+	}
+	return nil
+}
+
+func isSchemaType(value any, typ string) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		return typ == "object"
+	case []any:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || typ == "integer" && v == math.Trunc(v)
+	case bool:
+		return typ == "boolean"
+	case nil:
+		return typ == "null"
+	}
+	return false
+}
+
+func schemaTypeOf(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+func schemaEnumHas(enum []any, value any) bool {
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaEnumString(enum []any) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprint(value)
+	}
+	return strings.Join(values, ", ")
+}
+
+func schemaValue(value any) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(value)
+}
+
+// schemaPath names the field at path, the invoice itself at the root
+func schemaPath(path string) string {
+	if path == "" {
+		return "invoice"
+	}
+	return path
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateInvoiceSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Invoice)
+		fields []string
+	}{
+		{"conforming", func(*Invoice) {}, nil},
+		{"empty id", func(i *Invoice) { i.ID = "" }, []string{"id"}},
+		{"zero amount", func(i *Invoice) { i.AmountCents = 0 }, []string{"amount_cents"}},
+		{"unknown currency", func(i *Invoice) { i.Currency = "XYZ" }, []string{"currency"}},
+		{"unknown status", func(i *Invoice) { i.Status = "void" }, []string{"status"}},
+		{"business not a UUID", func(i *Invoice) { i.BusinessID = "acme" }, []string{"business_id"}},
+		{"description too long", func(i *Invoice) { i.Description = strings.Repeat("x", 1001) }, []string{"description"}},
+		{"every field", func(i *Invoice) {
+			i.ID = ""
+			i.AmountCents = -1
+			i.Currency = ""
+			i.Status = ""
+			i.BusinessID = "acme"
+		}, []string{"amount_cents", "business_id", "currency", "id", "status"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := generateInvoice(businessIDs[0])
+			tt.change(&invoice)
+
+			err := validateInvoiceSchema(invoice)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("validating a conforming invoice: %v", err)
+				}
+				return
+			}
+
+			var invalid *invoiceValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got %v, want an *invoiceValidationError", err)
+			}
+			var fields []string
+			for _, field := range invalid.Fields {
+				fields = append(fields, field.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("offending fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestInvoiceSchemaDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		document map[string]any
+		want     []invoiceFieldError
+	}{
+		{
+			"missing and unknown fields",
+			map[string]any{
+				"id": "INV-1", "business_id": businessIDs[0], "amount_cents": 1000.0,
+				"currency": "USD", "status": "paid", "discount": 5.0,
+			},
+			[]invoiceFieldError{{"created_at", "is required"}, {"discount", "is not allowed"}},
+		},
+		{
+			"wrong types",
+			map[string]any{
+				"id": 7.0, "business_id": businessIDs[0], "amount_cents": "10",
+				"currency": "USD", "status": "paid", "created_at": "yesterday",
+			},
+			[]invoiceFieldError{
+				{"amount_cents", "must be of type integer, got string"},
+				{"created_at", `"yesterday" is not an RFC 3339 date-time`},
+				{"id", "must be of type string, got number"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := invoiceSchema.validate("", tt.document); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := compileSchema([]byte(`{"type": "object", "oneOf": []}`)); err == nil {
+		t.Errorf("compiling a schema with an unsupported keyword succeeded, want an error")
+	}
+}
+
+func TestSchemaRejectRollsBack(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	opts := testIngestOptions(t)
+	opts.ValidateSchema = true
+
+	// The conforming invoice is written first, so only a rollback keeps it
+	// out of the table once the second is rejected
+	conforming := generateInvoice(businessIDs[0])
+	nonconforming := generateInvoice(businessIDs[1])
+	nonconforming.Description = strings.Repeat("x", 1001)
+
+	err := storeInvoiceBatch(context.Background(), store, []pendingRow{{1, conforming}, {2, nonconforming}}, opts)
+	if err == nil || !strings.Contains(err.Error(), "description: must be at most 1000 characters long") {
+		t.Fatalf("got %v, want the description rejected", err)
+	}
+
+	var invoices, events int
+	if err := dbConn.QueryRow("SELECT (SELECT COUNT(*) FROM invoices), (SELECT COUNT(*) FROM events)").Scan(&invoices, &events); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	if invoices != 0 || events != 0 {
+		t.Errorf("%d invoices and %d events stored, want none", invoices, events)
+	}
+
+	// Without the flag the same description is accepted
+	opts.ValidateSchema = false
+	if _, err := createInvoiceWithEvents(context.Background(), store, nonconforming, opts); err != nil {
+		t.Fatalf("storing without --validate-schema: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// txIsolationLevels are the values of --tx-isolation
+var txIsolationLevels = map[string]sql.IsolationLevel{
+	"default":          sql.LevelDefault,
+	"read-uncommitted": sql.LevelReadUncommitted,
+	"read-committed":   sql.LevelReadCommitted,
+	"repeatable-read":  sql.LevelRepeatableRead,
+	"serializable":     sql.LevelSerializable,
+}
+
+// parseTxIsolation returns the isolation level named by --tx-isolation,
+// checking that driver can run the ingest transaction at it. The outbox
+// needs at least read-committed, so an invoice's events are never seen
+// before the invoice they belong to is committed. SQLite only has
+// serializable transactions, which is also what it runs by default, so any
+// other level there is refused rather than silently ignored.
+func parseTxIsolation(name, driver string) (sql.IsolationLevel, error) {
+	level, ok := txIsolationLevels[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid --tx-isolation %q: must be default, read-committed, repeatable-read or serializable", name)
+	}
+	switch {
+	case level == sql.LevelReadUncommitted:
+		return 0, fmt.Errorf("invalid --tx-isolation %q: the outbox needs at least read-committed, so events aren't read before their invoice commits", name)
+	case driver == driverSQLite && level != sql.LevelDefault && level != sql.LevelSerializable:
+		return 0, fmt.Errorf("invalid --tx-isolation %q: SQLite transactions are always serializable, use default or serializable", name)
+	}
+	return level, nil
+}
+
+// txOptions returns the options ingest begins each transaction with, nil
+// for the driver's default isolation
+func (o ingestOptions) txOptions() *sql.TxOptions {
+	if o.TxIsolation == sql.LevelDefault {
+		return nil
+	}
+	return &sql.TxOptions{Isolation: o.TxIsolation}
+}
+
+// isSerializationFailure reports whether err is Postgres aborting a
+// transaction that conflicted with a concurrent one, which succeeds when run
+// again: a repeatable-read or serializable transaction failing to serialize,
+// 40001, or any transaction picked to break a deadlock, 40P01
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	// Errors wrapped with %v keep only the message
+	msg := err.Error()
+	return strings.Contains(msg, "could not serialize access") || strings.Contains(msg, "deadlock detected")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestParseTxIsolation(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver string
+		want   sql.IsolationLevel
+		ok     bool
+	}{
+		{"default", driverSQLite, sql.LevelDefault, true},
+		{"serializable", driverSQLite, sql.LevelSerializable, true},
+		{"read-committed", driverSQLite, 0, false},
+		{"repeatable-read", driverSQLite, 0, false},
+		{"default", driverPostgres, sql.LevelDefault, true},
+		{"read-committed", driverPostgres, sql.LevelReadCommitted, true},
+		{"repeatable-read", driverPostgres, sql.LevelRepeatableRead, true},
+		{"serializable", driverPostgres, sql.LevelSerializable, true},
+		{"read-uncommitted", driverPostgres, 0, false},
+		{"snapshot", driverPostgres, 0, false},
+	}
+	for _, tt := range tests {
+		level, err := parseTxIsolation(tt.name, tt.driver)
+		if tt.ok && (err != nil || level != tt.want) {
+			t.Errorf("%s on %s: got %v, %v, want %v", tt.name, tt.driver, level, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s on %s: got %v, want an error", tt.name, tt.driver, level)
+		}
+	}
+}
+
+func TestIngestTxIsolation(t *testing.T) {
+	store := &memStore{}
+	opts := testIngestOptions(t)
+	if _, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(businessIDs[0]), opts); err != nil {
+		t.Fatalf("storing invoice: %v", err)
+	}
+	opts.TxIsolation = sql.LevelSerializable
+	if _, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(businessIDs[0]), opts); err != nil {
+		t.Fatalf("storing invoice: %v", err)
+	}
+
+	if len(store.txOptions) != 2 {
+		t.Fatalf("began %d transactions, want 2", len(store.txOptions))
+	}
+	if store.txOptions[0] != nil {
+		t.Errorf("began the default transaction with %+v, want the driver's default", store.txOptions[0])
+	}
+	if got := store.txOptions[1]; got == nil || got.Isolation != sql.LevelSerializable {
+		t.Errorf("began the serializable transaction with %+v, want serializable", got)
+	}
+
+	// SQLite takes serializable, the level it always runs at
+	sqlite, _ := newTestStore(t)
+	if _, err := createInvoiceWithEvents(context.Background(), sqlite, generateInvoice(businessIDs[0]), opts); err != nil {
+		t.Fatalf("storing invoice on SQLite at serializable: %v", err)
+	}
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}, true},
+		{&pq.Error{Code: "40P01", Message: "deadlock detected"}, true},
+		{&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}, false},
+		{fmt.Errorf("error creating invoice: %v", &pq.Error{Code: "40001", Message: "could not serialize access due to read/write dependencies among transactions"}), true},
+		{fmt.Errorf("error creating event: %v", &pq.Error{Code: "40P01", Message: "deadlock detected"}), true},
+		{errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := isSerializationFailure(tt.err); got != tt.want {
+			t.Errorf("isSerializationFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
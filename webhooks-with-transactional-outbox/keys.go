@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// Strategies of --idempotency-strategy for the key stored with each event
+const (
+	// idempotencyEventID keys an event by its ID
+	idempotencyEventID = "event-id"
+	// idempotencyContentHash keys an event by its content, so an event
+	// written twice, such as by a retried ingest, is delivered once
+	idempotencyContentHash = "content-hash"
+)
+
+// validateIdempotencyStrategy checks the --idempotency-strategy of ingest
+func validateIdempotencyStrategy(strategy string) error {
+	if strategy != idempotencyEventID && strategy != idempotencyContentHash {
+		return fmt.Errorf("invalid idempotency strategy %q: must be %q or %q", strategy, idempotencyEventID, idempotencyContentHash)
+	}
+	return nil
+}
+
+// idempotencyKey is the key Convoy uses to deduplicate deliveries of event.
+// It is the key stored with the event when it was written, or the event ID
+// when none was, and both are kept when the event is retried, dead-lettered
+// or requeued, so every resend of an event carries the same key. Nothing
+// else about the row may go into it: a key that changed between sends would
+// defeat deduplication.
+func idempotencyKey(event db.Event) string {
+	if event.IdempotencyKey.Valid {
+		return event.IdempotencyKey.String
+	}
+	return event.ID
+}
+
+// contentHashKey is the hex SHA-256 of an event's business, type and
+// encoded payload, each prefixed with its length so no two different
+// events hash the same fields
+func contentHashKey(businessID, eventType string, payload []byte) string {
+	hash := sha256.New()
+	for _, field := range [][]byte{[]byte(businessID), []byte(eventType), payload} {
+		fmt.Fprintf(hash, "%d:", len(field))
+		hash.Write(field)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// keyCollision is an idempotency key shared by more than one event row
+type keyCollision struct {
+	key      string
+	eventIDs []string
+}
+
+// findKeyCollisions groups events by idempotency key and returns the keys
+// shared by several rows, most rows first
+func findKeyCollisions(events []db.Event) (int, []keyCollision) {
+	rowsByKey := make(map[string][]string)
+	for _, event := range events {
+		key := idempotencyKey(event)
+		rowsByKey[key] = append(rowsByKey[key], event.ID)
+	}
+
+	var collisions []keyCollision
+	for key, ids := range rowsByKey {
+		if len(ids) > 1 {
+			collisions = append(collisions, keyCollision{key: key, eventIDs: ids})
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		if len(collisions[i].eventIDs) != len(collisions[j].eventIDs) {
+			return len(collisions[i].eventIDs) > len(collisions[j].eventIDs)
+		}
+		return collisions[i].key < collisions[j].key
+	})
+	return len(rowsByKey), collisions
+}
+
+// runKeysAudit reports events whose idempotency keys collide, which Convoy
+// would treat as duplicates and silently drop
+func runKeysAudit(ctx context.Context, queries Querier, top int) error {
+	events, err := queries.ListEvents(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing events: %v", err)
+	}
+
+	distinct, collisions := findKeyCollisions(events)
+	affected := 0
+	for _, collision := range collisions {
+		affected += len(collision.eventIDs)
+	}
+
+	fmt.Printf("Events scanned:     %d\n", len(events))
+	fmt.Printf("Distinct keys:      %d\n", distinct)
+	fmt.Printf("Colliding keys:     %d\n", len(collisions))
+	fmt.Printf("Rows affected:      %d\n", affected)
+
+	if len(collisions) == 0 {
+		return nil
+	}
+
+	if top > 0 && len(collisions) > top {
+		collisions = collisions[:top]
+	}
+	fmt.Println()
+	fmt.Println("Top colliding keys:")
+	for _, collision := range collisions {
+		fmt.Printf("  %s (%d rows): %s\n", collision.key, len(collision.eventIDs), strings.Join(collision.eventIDs, ", "))
+	}
+	return nil
+}
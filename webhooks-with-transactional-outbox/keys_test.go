@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContentHashKey(t *testing.T) {
+	key := contentHashKey("biz_1", "invoice.created", []byte(`{"id":"inv_1"}`))
+	if again := contentHashKey("biz_1", "invoice.created", []byte(`{"id":"inv_1"}`)); again != key {
+		t.Errorf("identical content hashed to %s and %s, want the same key", key, again)
+	}
+
+	differing := []struct {
+		name       string
+		businessID string
+		eventType  string
+		payload    string
+	}{
+		{name: "business", businessID: "biz_2", eventType: "invoice.created", payload: `{"id":"inv_1"}`},
+		{name: "event type", businessID: "biz_1", eventType: "invoice.sent", payload: `{"id":"inv_1"}`},
+		{name: "payload", businessID: "biz_1", eventType: "invoice.created", payload: `{"id":"inv_2"}`},
+		{name: "field boundary", businessID: "biz_1invoice.", eventType: "created", payload: `{"id":"inv_1"}`},
+	}
+	for _, tt := range differing {
+		if other := contentHashKey(tt.businessID, tt.eventType, []byte(tt.payload)); other == key {
+			t.Errorf("content with a different %s hashed to the same key %s", tt.name, key)
+		}
+	}
+}
+
+func TestIdempotencyStrategy(t *testing.T) {
+	invoice := generateInvoice(businessIDs[0])
+	event, err := newEvent(invoice.BusinessID, "invoice.created", invoice)
+	if err != nil {
+		t.Fatalf("building event: %v", err)
+	}
+
+	tests := []struct {
+		strategy string
+		// wantSameKey is whether the event written twice is delivered
+		// with one key
+		wantSameKey bool
+	}{
+		{strategy: idempotencyEventID},
+		{strategy: idempotencyContentHash, wantSameKey: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			store, _ := newTestStore(t)
+			opts := testIngestOptions(t)
+			opts.IdempotencyStrategy = tt.strategy
+
+			// The same event written twice, as a retried ingest would
+			if err := createEvents(context.Background(), store, []Event{event, event}, opts); err != nil {
+				t.Fatalf("storing events: %v", err)
+			}
+
+			publisher := &fakePublisher{}
+			workerOpts := testWorkerOptions()
+			workerOpts.Once = true
+			if err := runWorker(context.Background(), store, publisher, workerOpts); err != nil {
+				t.Fatalf("running worker: %v", err)
+			}
+			if len(publisher.keys) != 2 {
+				t.Fatalf("published %d events, want 2", len(publisher.keys))
+			}
+			if same := publisher.keys[0] == publisher.keys[1]; same != tt.wantSameKey {
+				t.Errorf("sent keys %q, want the same key %v", publisher.keys, tt.wantSameKey)
+			}
+
+			stored, err := store.ListEvents(context.Background())
+			if err != nil {
+				t.Fatalf("listing events: %v", err)
+			}
+			for _, e := range stored {
+				if e.IdempotencyKey.Valid != (tt.strategy == idempotencyContentHash) {
+					t.Errorf("event %s stored key %v with the %s strategy", e.ID, e.IdempotencyKey, tt.strategy)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// jsonLogs is set when logs are written as JSON, for output that is
+// otherwise laid out for people to read
+var jsonLogs bool
+
+// configureLogging installs the slog handler chosen with --log-format and
+// --log-level as the default logger
+func configureLogging(format, level string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: must be debug, info, warn or error", level)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	switch format {
+	case logFormatText:
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+		jsonLogs = true
+	default:
+		return fmt.Errorf("invalid log format %q: must be %q or %q", format, logFormatText, logFormatJSON)
+	}
+	slog.SetDefault(slog.New(handler))
+
+	// SetDefault also reroutes the log package; keep it printing plainly for
+	// the banner and fatal errors
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+	return nil
+}
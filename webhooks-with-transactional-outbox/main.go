@@ -1,41 +1,81 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	cryptorand "crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	convoy "github.com/frain-dev/convoy-go/v2"
 	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/term"
 )
 
-// Predefined business IDs with UUIDs
-var businessIDs = []string{
-	"550e8400-e29b-41d4-a716-446655440000", // Acme Corp
-	"6ba7b810-9dad-11d1-80b4-00c04fd430c8", // TechStart Inc
-	"7ba7b810-9dad-11d1-80b4-00c04fd430c9", // Global Solutions
-	"8ba7b810-9dad-11d1-80b4-00c04fd430ca", // Innovate Labs
-	"9ba7b810-9dad-11d1-80b4-00c04fd430cb", // Future Systems
+// Predefined business IDs, those of the seeded businesses, used unless
+// ingest is given its own
+var businessIDs = seededBusinessIDs()
+
+// random generates the sample data. It is seeded from the clock unless
+// ingest is given --seed, which makes the generated invoices reproducible.
+var random = newLockedRand(time.Now().UnixNano())
+
+// lockedRand is a math/rand generator that is safe to share between the
+// ingest producers and serve's requests
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
 }
 
-// getRandomBusinessID returns a random business ID from the predefined list
-func getRandomBusinessID() string {
-	return businessIDs[rand.Intn(len(businessIDs))]
+func (l *lockedRand) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// getRandomBusinessID returns a random business ID from ids
+func getRandomBusinessID(ids []string) string {
+	return ids[random.Intn(len(ids))]
 }
 
 type Invoice struct {
 	ID          string    `json:"id"`
 	BusinessID  string    `json:"business_id"`
-	Amount      float64   `json:"amount"`
+	AmountCents int64     `json:"amount_cents"`
 	Currency    string    `json:"currency"`
 	Status      string    `json:"status"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -46,276 +86,2571 @@ type Event struct {
 	BusinessID string          `json:"business_id"`
 	Type       string          `json:"type"`
 	Payload    json.RawMessage `json:"payload"`
+	// AggregateID names the entity the event describes, if any, so later
+	// events for it can be sent as deltas
+	AggregateID string `json:"aggregate_id,omitempty"`
+	// Headers are sent with every delivery of the event, see eventHeaders
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 const (
-	batchSize = 10
+	// defaultBatchSize is how many events the worker fetches per poll when
+	// no --batch-size is given
+	defaultBatchSize = 10
+
+	// annotationNoDB marks commands that run without opening the database
+	annotationNoDB = "no-db"
+
+	// annotationNoInit marks commands that open the database as it is,
+	// neither creating nor migrating it
+	annotationNoInit = "no-init"
+
+	// defaultQueue is used by ingest and worker when no --queue is given
+	defaultQueue = "default"
 )
 
+// newInvoiceUUID returns a random UUID drawn from random, so IDs never
+// collide in practice and still repeat under --seed
+func newInvoiceUUID() string {
+	id, err := uuid.NewRandomFromReader(random)
+	if err != nil {
+		// random is a math/rand source, whose reads never fail
+		panic(err)
+	}
+	return id.String()
+}
+
+// newEventID returns a random event ID of 32 hex digits. Unlike invoice
+// IDs it is drawn from crypto/rand, so two runs under the same --seed don't
+// collide on the events' primary key.
+func newEventID() string {
+	id := make([]byte, 16)
+	if _, err := cryptorand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
 func generateInvoice(businessID string) Invoice {
 	currencies := []string{"USD", "EUR", "GBP"}
-	statuses := []string{"draft", "sent", "paid", "overdue"}
 
 	return Invoice{
-		ID:          fmt.Sprintf("INV-%d", rand.Intn(1000000)),
+		ID:          "INV-" + newInvoiceUUID(),
 		BusinessID:  businessID,
-		Amount:      float64(rand.Intn(10000)) + 99.99,
-		Currency:    currencies[rand.Intn(len(currencies))],
-		Status:      statuses[rand.Intn(len(statuses))],
+		AmountCents: int64(random.Intn(10000)+99)*100 + 99,
+		Currency:    currencies[random.Intn(len(currencies))],
+		Status:      invoiceStatuses[random.Intn(len(invoiceStatuses))],
 		CreatedAt:   time.Now(),
 		Description: "Sample invoice for demonstration",
 	}
 }
 
-func runIngest(queries *db.Queries, dbConn *sql.DB, rate time.Duration) error {
-	ticker := time.NewTicker(rate)
-	defer ticker.Stop()
+// ingestOptions controls how runIngest generates and stores events
+type ingestOptions struct {
+	Rate        time.Duration
+	Queue       string
+	BusinessIDs []string
+	Mapper      eventMapper
 
-	for range ticker.C {
-		// Get a random business ID from our predefined list
-		businessID := getRandomBusinessID()
+	// Count stops ingest after this many invoices (unlimited when 0)
+	Count int
 
-		// Generate an invoice
-		invoice := generateInvoice(businessID)
+	// Delay holds each event back from the worker for this long after it
+	// is written, by setting its visible_at
+	Delay time.Duration
 
-		// Start a transaction
-		tx, err := dbConn.BeginTx(context.Background(), nil)
-		if err != nil {
-			log.Printf("Error starting transaction: %v", err)
-			continue
-		}
+	// EventTTL is how long an event may wait to be delivered before the
+	// worker expires it instead (forever when 0)
+	EventTTL time.Duration
 
-		// Create a new queries instance that uses the transaction
-		txQueries := queries.WithTx(tx)
+	// Workers is how many producers store invoices at once, each on its
+	// own Rate ticker, and ReportInterval how often their combined
+	// throughput is logged (never when 0)
+	Workers        int
+	ReportInterval time.Duration
 
-		// Create the invoice within the transaction
-		_, err = txQueries.CreateInvoice(context.Background(), db.CreateInvoiceParams{
-			ID:          invoice.ID,
-			BusinessID:  invoice.BusinessID,
-			Amount:      invoice.Amount,
-			Currency:    invoice.Currency,
-			Status:      invoice.Status,
-			Description: sql.NullString{String: invoice.Description, Valid: true},
-		})
-		if err != nil {
-			tx.Rollback()
-			log.Printf("Error creating invoice: %v", err)
-			continue
-		}
+	// EventFormat is how the payload of each event is built, raw by
+	// default, and SchemaVersion the version an eventEnvelope is stamped with
+	EventFormat   string
+	SchemaVersion int
+	Codec         payloadCodec
+	Cipher        *payloadCipher
+	NormalizeJSON bool
+	SortJSONKeys  bool
 
-		// Marshal the invoice for the event payload
-		eventPayload := struct {
-			EventType string      `json:"event_type"`
-			Data      interface{} `json:"data"`
-		}{
-			EventType: "invoice.created",
-			Data:      invoice,
-		}
-		payload, err := json.Marshal(eventPayload)
-		if err != nil {
-			tx.Rollback()
-			log.Printf("Error marshaling invoice: %v", err)
-			continue
+	// CompressPayloads stores payloads compressed with gzip
+	CompressPayloads bool
+
+	// DeliveryMode is how Convoy delivers the events, deliveryFanout to
+	// the business's endpoints by default or deliveryBroadcast
+	DeliveryMode string
+
+	// IdempotencyStrategy is how the key stored with each event is chosen,
+	// idempotencyEventID by default or idempotencyContentHash
+	IdempotencyStrategy string
+
+	// BusyRetries is how many times a transaction that fails on a busy
+	// SQLite database, or on a serialization failure or deadlock on Postgres,
+	// is run again
+	BusyRetries int
+
+	// LogPayloads logs the amount and payload of each stored invoice, with
+	// the JSON fields named in RedactFields masked
+	LogPayloads  bool
+	RedactFields []string
+
+	// TxIsolation is the isolation level each invoice's transaction runs
+	// at, the driver's default when sql.LevelDefault
+	TxIsolation sql.IsolationLevel
+
+	// DBTimeout bounds the transaction each invoice is stored in
+	DBTimeout time.Duration
+
+	// MaxFatalErrors is how many fatal errors in a row a producer may hit,
+	// such as a missing table or refused credentials, before runIngest
+	// stops and returns the error (never when 0)
+	MaxFatalErrors int
+
+	// ValidateBusiness rejects an invoice whose business isn't in the
+	// businesses table before anything is written
+	ValidateBusiness bool
+
+	// ValidateSchema rejects an invoice that doesn't conform to
+	// invoice.schema.json before anything is written
+	ValidateSchema bool
+
+	// MaxPayloadBytes caps the size of each encoded event payload
+	// (unlimited when 0), and OnOversize is the policy for an invoice over
+	// it: oversizeReject or oversizeTruncate
+	MaxPayloadBytes int
+	OnOversize      string
+
+	// Tracer starts a span for each invoice, whose context is stored with
+	// its events (disabled when nil)
+	Tracer trace.Tracer
+}
+
+// normalizeJSON compacts a JSON document, optionally rewriting it with
+// object keys in sorted order so equal documents produce equal bytes
+func normalizeJSON(payload []byte, sortKeys bool) ([]byte, error) {
+	if !sortKeys {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, payload); err != nil {
+			return nil, err
 		}
+		return buf.Bytes(), nil
+	}
 
-		// Create the event within the same transaction
-		_, err = txQueries.CreateEvent(context.Background(), db.CreateEventParams{
-			BusinessID: businessID,
-			EventType:  "invoice.created",
-			Payload:    string(payload),
-		})
-		if err != nil {
-			tx.Rollback()
-			log.Printf("Error creating event: %v", err)
-			continue
+	// Decode numbers as json.Number so they are written back untouched
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after top-level JSON value")
+	}
+
+	// encoding/json writes map keys in sorted order
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// eventMapper derives the outbox events that are written in the same
+// transaction as an invoice
+type eventMapper func(invoice Invoice) ([]Event, error)
+
+// newEvent wraps data in the standard payload envelope for eventType
+func newEvent(businessID, eventType string, data interface{}) (Event, error) {
+	eventPayload := struct {
+		EventType string      `json:"event_type"`
+		Data      interface{} `json:"data"`
+	}{
+		EventType: eventType,
+		Data:      data,
+	}
+	payload, err := json.Marshal(eventPayload)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{BusinessID: businessID, Type: eventType, Payload: payload}, nil
+}
+
+// invoiceCreatedEvents is the default 1:1 mapping of an invoice to an
+// invoice.created event
+func invoiceCreatedEvents(invoice Invoice) ([]Event, error) {
+	event, err := newEvent(invoice.BusinessID, "invoice.created", invoice)
+	if err != nil {
+		return nil, err
+	}
+	event.AggregateID = invoice.ID
+	return []Event{event}, nil
+}
+
+// ledgerEntryEvents derives a ledger.entry.added event booking the invoice
+// amount against the business
+func ledgerEntryEvents(invoice Invoice) ([]Event, error) {
+	entry := struct {
+		InvoiceID   string `json:"invoice_id"`
+		BusinessID  string `json:"business_id"`
+		EntryType   string `json:"entry_type"`
+		AmountCents int64  `json:"amount_cents"`
+		Currency    string `json:"currency"`
+	}{
+		InvoiceID:   invoice.ID,
+		BusinessID:  invoice.BusinessID,
+		EntryType:   "receivable",
+		AmountCents: invoice.AmountCents,
+		Currency:    invoice.Currency,
+	}
+	event, err := newEvent(invoice.BusinessID, "ledger.entry.added", entry)
+	if err != nil {
+		return nil, err
+	}
+	return []Event{event}, nil
+}
+
+// derivedEventMappers are the additional mappings that can be enabled with
+// --derived-events, keyed by the event type they produce
+var derivedEventMappers = map[string]eventMapper{
+	"ledger.entry.added": ledgerEntryEvents,
+}
+
+// combineMappers returns a mapper emitting the events of all mappers in order
+func combineMappers(mappers ...eventMapper) eventMapper {
+	return func(invoice Invoice) ([]Event, error) {
+		var events []Event
+		for _, mapper := range mappers {
+			mapped, err := mapper(invoice)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, mapped...)
 		}
+		return events, nil
+	}
+}
 
-		// Commit the transaction
-		if err := tx.Commit(); err != nil {
-			log.Printf("Error committing transaction: %v", err)
-			continue
+// buildEventMapper returns the default mapper extended with the named
+// derived mappers
+func buildEventMapper(derived []string) (eventMapper, error) {
+	mappers := []eventMapper{invoiceCreatedEvents}
+	for _, name := range derived {
+		mapper, ok := derivedEventMappers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown derived event %q", name)
 		}
+		mappers = append(mappers, mapper)
+	}
+	return combineMappers(mappers...), nil
+}
 
-		log.Printf("Created invoice and event for business %s: %s", businessID, string(payload))
+// createInvoiceWithEvents stores the invoice and every event mapped from it
+// in a single transaction, so either all of them are written or none are
+func createInvoiceWithEvents(ctx context.Context, store Store, invoice Invoice, opts ingestOptions) ([]Event, error) {
+	ctx, cancel := dbContext(ctx, opts.DBTimeout)
+	defer cancel()
+
+	// Start a transaction
+	tx, err := store.Begin(ctx, opts.txOptions())
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	return nil
+	// Create the invoice and its events within the transaction
+	events, err := insertInvoiceWithEvents(ctx, tx, invoice, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return events, nil
 }
 
-func runWorker(queries *db.Queries, dbConn *sql.DB, pollInterval time.Duration, convoyClient *convoy.Client) error {
-	for {
-		events, err := queries.GetPendingEvents(context.Background(), batchSize)
-		if err != nil {
-			log.Printf("Error fetching events: %v", err)
-			time.Sleep(pollInterval)
-			continue
+// insertInvoiceWithEvents validates an invoice and stores it with its events
+// using txQueries, leaving the transaction to the caller so several invoices
+// can share one
+func insertInvoiceWithEvents(ctx context.Context, txQueries Querier, invoice Invoice, opts ingestOptions) ([]Event, error) {
+	// Reject a bad invoice before anything is written, the caller's
+	// rollback ends the transaction
+	if opts.ValidateSchema {
+		if err := validateInvoiceSchema(invoice); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateInvoice(invoice); err != nil {
+		return nil, err
+	}
+	if opts.ValidateBusiness {
+		if err := checkBusiness(ctx, txQueries, invoice); err != nil {
+			return nil, err
 		}
+	}
 
-		if len(events) == 0 {
-			log.Printf("No pending events found. Polling again in %v", pollInterval)
-			time.Sleep(pollInterval)
-			continue
+	events, err := opts.Mapper(invoice)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping invoice to events: %v", err)
+	}
+	if opts.MaxPayloadBytes > 0 && opts.OnOversize == oversizeTruncate {
+		invoice, events, err = fitPayloads(invoice, events, opts)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		log.Printf("Found %d pending events to process", len(events))
+	_, err = txQueries.CreateInvoice(ctx, db.CreateInvoiceParams{
+		ID:          invoice.ID,
+		BusinessID:  invoice.BusinessID,
+		AmountCents: invoice.AmountCents,
+		Currency:    invoice.Currency,
+		Status:      invoice.Status,
+		Description: sql.NullString{String: invoice.Description, Valid: true},
+	})
+	if err != nil && isUniqueViolation(err) {
+		return nil, &duplicateInvoiceError{InvoiceID: invoice.ID}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating invoice: %v", err)
+	}
 
-		for _, event := range events {
+	// Create the events within the same transaction
+	if err := createEvents(ctx, txQueries, events, opts); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
 
-			// Ensure payload is not empty
-			if event.Payload == "" {
-				log.Printf("Warning: Empty payload for event %d, skipping", event.ID)
-				continue
-			}
+// createEvents encodes and stores events with txQueries, which runs in the
+// transaction of the change they describe. Events are written back as they
+// were encoded, normalized or wrapped in a CloudEvent. A payload over
+// opts.MaxPayloadBytes fails with a *payloadTooLargeError, leaving the
+// caller to roll back.
+func createEvents(ctx context.Context, txQueries Querier, events []Event, opts ingestOptions) error {
+	for i := range events {
+		event, encoded, err := encodeEvent(events[i], opts)
+		if err != nil {
+			return err
+		}
+		event.Headers = eventHeaders(ctx, event)
+		events[i] = event
+		if err := checkPayloadSize(event.Type, encoded, opts.MaxPayloadBytes); err != nil {
+			return err
+		}
 
-			// Create a fanout event using Convoy
-			fanoutEvent := &convoy.CreateFanoutEventRequest{
-				EventType:      event.EventType,
-				OwnerID:        event.BusinessID, // Using business_id as owner_id
-				IdempotencyKey: event.ID,
-				Data:           []byte(event.Payload),
-			}
+		// Events keyed by their ID store no key, as the ID isn't known
+		// until the row is written
+		var key sql.NullString
+		if opts.IdempotencyStrategy == idempotencyContentHash {
+			key = sql.NullString{String: contentHashKey(event.BusinessID, event.Type, encoded), Valid: true}
+		}
 
-			// Send the event to Convoy
-			err = convoyClient.Events.FanoutEvent(context.Background(), fanoutEvent)
+		// Compress before encrypting, as ciphertext doesn't compress
+		encoding := payloadIdentity
+		if opts.CompressPayloads {
+			encoding = payloadGzip
+			encoded, err = compressPayload(encoded)
 			if err != nil {
-				log.Printf("Error sending event %d to Convoy: %v", event.ID, err)
-				continue
+				return err
 			}
+		}
 
-			// Mark event as processed
-			if err := queries.MarkEventAsProcessed(context.Background(), event.ID); err != nil {
-				log.Printf("Error marking event %d as processed: %v", event.ID, err)
-				continue
+		// Binary and compressed payloads aren't text, so they are stored
+		// base64 encoded, as encrypted payloads are already
+		stored := string(encoded)
+		if codecFormats[opts.Codec.Name()].binary || encoding == payloadGzip {
+			stored = base64.StdEncoding.EncodeToString(encoded)
+		}
+		if opts.Cipher != nil {
+			stored, err = opts.Cipher.Encrypt(encoded)
+			if err != nil {
+				return fmt.Errorf("error encrypting payload: %v", err)
 			}
 		}
 
-		time.Sleep(pollInterval)
+		headers, err := encodeHeaders(event.Headers)
+		if err != nil {
+			return err
+		}
+
+		// Without a delay the event is visible from when it is written
+		var visibleAt sql.NullTime
+		if opts.Delay > 0 {
+			visibleAt = sql.NullTime{Time: time.Now().UTC().Add(opts.Delay), Valid: true}
+		}
+		var expiresAt sql.NullTime
+		if opts.EventTTL > 0 {
+			expiresAt = sql.NullTime{Time: time.Now().UTC().Add(opts.Delay + opts.EventTTL), Valid: true}
+		}
+
+		// A broadcast goes to every subscriber, so it has no owner
+		owner := sql.NullString{String: event.BusinessID, Valid: true}
+		mode := deliveryFanout
+		if opts.DeliveryMode == deliveryBroadcast {
+			owner = sql.NullString{}
+			mode = deliveryBroadcast
+		}
+
+		_, err = txQueries.CreateEvent(ctx, db.CreateEventParams{
+			ID:         newEventID(),
+			BusinessID: event.BusinessID,
+			EventType:  event.Type,
+			Payload:    stored,
+			Codec:      opts.Codec.Name(),
+			Queue:      opts.Queue,
+			Encrypted:  opts.Cipher != nil,
+			AggregateID: sql.NullString{
+				String: event.AggregateID,
+				Valid:  event.AggregateID != "",
+			},
+			PayloadEncoding: encoding,
+			OwnerID:         owner,
+			DeliveryMode:    mode,
+			IdempotencyKey:  key,
+			Headers:         headers,
+			VisibleAt:       visibleAt,
+			ExpiresAt:       expiresAt,
+		})
+		if err != nil {
+			return fmt.Errorf("error creating %s event: %v", event.Type, err)
+		}
 	}
+	return nil
 }
 
-func getDB() (*db.Queries, *sql.DB, error) {
-	dbConn, err := sql.Open("sqlite3", "events.db")
+// encodeEvent builds the payload of event as it is sent: wrapped in a
+// CloudEvent and normalized when opts ask for it, then encoded with the
+// codec. The event is returned with its payload as built.
+func encodeEvent(event Event, opts ingestOptions) (Event, []byte, error) {
+	var err error
+	switch opts.EventFormat {
+	case eventFormatCloudEvents:
+		event, err = toCloudEvent(event)
+	case eventFormatEnvelope:
+		event, err = toEnvelope(event, opts.SchemaVersion)
+	}
 	if err != nil {
-		return nil, nil, err
+		return event, nil, err
 	}
-	return db.New(dbConn), dbConn, nil
-}
 
-// initDB initializes the database with the schema if it doesn't exist
-func initDB(dbPath string) error {
-	// Check if database file exists
-	if _, err := os.Stat(dbPath); err == nil {
-		fmt.Printf("Database file %s already exists. Do you want to recreate it? (y/n): ", dbPath)
-		var response string
-		fmt.Scanln(&response)
-		if strings.ToLower(response) != "y" {
-			fmt.Println("Using existing database.")
-			return nil
-		}
-		// Remove existing database file
-		if err := os.Remove(dbPath); err != nil {
-			return fmt.Errorf("error removing existing database: %v", err)
+	// Normalize the payload so formatting differences don't reach storage
+	if opts.NormalizeJSON || opts.SortJSONKeys {
+		event.Payload, err = normalizeJSON(event.Payload, opts.SortJSONKeys)
+		if err != nil {
+			return event, nil, fmt.Errorf("error normalizing payload: %v", err)
 		}
 	}
 
-	// Open database connection
-	dbConn, err := sql.Open("sqlite3", dbPath)
+	encoded, err := opts.Codec.Encode(event.Payload)
 	if err != nil {
-		return fmt.Errorf("error opening database: %v", err)
+		return event, nil, fmt.Errorf("error encoding payload as %s: %v", opts.Codec.Name(), err)
 	}
-	defer dbConn.Close()
+	return event, encoded, nil
+}
 
-	// Read schema.sql file
-	schemaPath := filepath.Join("db", "schema.sql")
-	schemaSQL, err := os.ReadFile(schemaPath)
-	if err != nil {
-		return fmt.Errorf("error reading schema file: %v", err)
+// runIngest runs opts.Workers producers, each generating an invoice on
+// every tick of its own ticker, until ctx is cancelled, or until opts.Count
+// invoices have been stored between them when it is set. A producer that
+// gives up on fatal errors stops the others, and its error is returned.
+func runIngest(ctx context.Context, store Store, opts ingestOptions) error {
+	start := time.Now()
+	counter := &ingestCounter{limit: int64(opts.Count)}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if opts.ReportInterval > 0 {
+		go reportThroughput(ctx, counter, opts.ReportInterval)
 	}
 
-	// Execute schema SQL
-	_, err = dbConn.Exec(string(schemaSQL))
-	if err != nil {
-		return fmt.Errorf("error executing schema: %v", err)
+	var wg sync.WaitGroup
+	var fatalOnce sync.Once
+	var fatalErr error
+	for i := 0; i < max(opts.Workers, 1); i++ {
+		wg.Add(1)
+		go func(producer int) {
+			defer wg.Done()
+			if err := ingestProducer(ctx, store, opts, counter, producer); err != nil {
+				fatalOnce.Do(func() {
+					fatalErr = err
+					cancel()
+				})
+			}
+		}(i)
 	}
+	wg.Wait()
 
-	fmt.Println("Database initialized successfully!")
+	stored := counter.stored.Load()
+	if fatalErr != nil {
+		slog.Error("Stopping ingest", "count", stored, "error", fatalErr)
+		return fatalErr
+	}
+	if ctx.Err() != nil {
+		slog.Info("Shutting down ingest", "count", stored)
+		return nil
+	}
+	elapsed := time.Since(start)
+	slog.Info("Ingested requested invoices", "count", stored, "invoices_per_sec", fmt.Sprintf("%.1f", float64(stored)/elapsed.Seconds()))
 	return nil
 }
 
-func main() {
-	var rootCmd = &cobra.Command{
-		Use:   "transactional-outbox",
-		Short: "Transactional outbox pattern implementation for webhook delivery",
-		Long: `A demonstration of the transactional outbox pattern for reliable webhook delivery.
-This application can run in either ingest mode to generate events or worker mode to process them.`,
+// ingestCounter shares the count of invoices stored between the producers.
+// Each producer reserves an invoice before generating it, so together they
+// never store more than limit, and gives the reservation back if the
+// invoice fails.
+type ingestCounter struct {
+	limit    int64
+	reserved atomic.Int64
+	stored   atomic.Int64
+}
+
+// reserve reports whether another invoice may be stored. A refused
+// reservation leaves the count as it was, so a slot given back by release
+// can always be taken again.
+func (c *ingestCounter) reserve() bool {
+	if c.limit == 0 {
+		return true
+	}
+	for {
+		reserved := c.reserved.Load()
+		if reserved >= c.limit {
+			return false
+		}
+		if c.reserved.CompareAndSwap(reserved, reserved+1) {
+			return true
+		}
 	}
+}
 
-	// Initialize database on startup
-	dbPath := "events.db"
-	if err := initDB(dbPath); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+// release gives back the reservation of an invoice that wasn't stored
+func (c *ingestCounter) release() {
+	if c.limit > 0 {
+		c.reserved.Add(-1)
 	}
+}
 
-	var rate string
-	var ingestCmd = &cobra.Command{
-		Use:   "ingest",
-		Short: "Run in ingest mode to generate invoice events",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			rateDuration, err := time.ParseDuration(rate)
-			if err != nil {
-				return fmt.Errorf("invalid rate format: %v", err)
-			}
+// reportThroughput logs how many invoices were stored per second over each
+// interval until ctx is cancelled
+func reportThroughput(ctx context.Context, counter *ingestCounter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-			queries, dbConn, err := getDB()
-			if err != nil {
-				return err
-			}
-			defer dbConn.Close()
-			return runIngest(queries, dbConn, rateDuration)
-		},
+	last, lastAt := int64(0), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stored := counter.stored.Load()
+			rate := float64(stored-last) / now.Sub(lastAt).Seconds()
+			slog.Info("Ingest throughput", "invoices_per_sec", fmt.Sprintf("%.1f", rate), "total", stored)
+			last, lastAt = stored, now
+		}
 	}
-	ingestCmd.Flags().StringVar(&rate, "rate", "30s", "Rate at which to generate events (e.g. 30s, 1m)")
+}
 
-	var pollInterval string
-	var convoyAPIKey string
-	var convoyProjectID string
-	var convoyBaseURL string
+// ingestProducer stores an invoice with its events on every tick until ctx
+// is cancelled or counter has no invoices left, or returns an error once
+// opts.MaxFatalErrors invoices in a row fail on a fatal error
+func ingestProducer(ctx context.Context, store Store, opts ingestOptions, counter *ingestCounter, producer int) error {
+	ticker := time.NewTicker(opts.Rate)
+	defer ticker.Stop()
 
-	var workerCmd = &cobra.Command{
-		Use:   "worker",
-		Short: "Run in worker mode to process events",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			pollIntervalDuration, err := time.ParseDuration(pollInterval)
-			if err != nil {
-				return fmt.Errorf("invalid poll interval format: %v", err)
-			}
+	fatal := &fatalCounter{limit: opts.MaxFatalErrors}
+	for counter.reserve() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
 
-			// Initialize Convoy client with custom HTTP client
-			convoyClient := convoy.New(
-				convoyBaseURL,
-				convoyAPIKey,
-				convoyProjectID,
-			)
+		// Get a random business ID from the configured list
+		businessID := getRandomBusinessID(opts.BusinessIDs)
 
-			queries, dbConn, err := getDB()
-			if err != nil {
+		// Generate an invoice
+		invoice := generateInvoice(businessID)
+
+		spanCtx, span := startSpan(ctx, opts.Tracer, "ingest invoice", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attribute.String("business.id", businessID)))
+
+		events, err := storeGeneratedInvoice(spanCtx, store, &invoice, opts)
+		span.SetAttributes(attribute.String("invoice.id", invoice.ID))
+		endSpan(span, err)
+		var duplicate *duplicateInvoiceError
+		if errors.As(err, &duplicate) {
+			counter.release()
+			slog.Warn("Duplicate invoice, skipping", "producer", producer, "invoice_id", invoice.ID, "business_id", businessID)
+			continue
+		}
+		if err != nil {
+			counter.release()
+			slog.Error("Error ingesting invoice", "producer", producer, "invoice_id", invoice.ID, "business_id", businessID, "error", err)
+			if err := fatal.record(err); err != nil {
 				return err
 			}
-			defer dbConn.Close()
-			return runWorker(queries, dbConn, pollIntervalDuration, convoyClient)
-		},
+			continue
+		}
+		fatal.record(nil)
+		counter.stored.Add(1)
+
+		for _, event := range events {
+			attrs := []any{"producer", producer, "invoice_id", invoice.ID, "business_id", businessID, "event_type", event.Type, "payload_bytes", len(event.Payload)}
+			slog.Info("Created invoice and event", append(attrs, invoiceLogAttrs(invoice, event, opts)...)...)
+		}
 	}
+	return nil
+}
 
-	workerCmd.Flags().StringVar(&pollInterval, "poll-interval", "5s", "Interval at which to poll for events (e.g. 5s, 1m)")
-	workerCmd.Flags().StringVar(&convoyAPIKey, "convoy-api-key", "", "Convoy API key")
-	workerCmd.Flags().StringVar(&convoyProjectID, "convoy-project-id", "", "Convoy project ID")
-	workerCmd.Flags().StringVar(&convoyBaseURL, "convoy-base-url", "https://api.getconvoy.io", "Convoy API base URL")
-	workerCmd.MarkFlagRequired("convoy-api-key")
-	workerCmd.MarkFlagRequired("convoy-project-id")
+// storeGeneratedInvoice stores a generated invoice with its events,
+// retrying the transaction while the database is busy. When its ID is
+// already taken, as by another ingest process started with the same
+// --seed, the invoice is given a new ID and stored once more; a second
+// duplicate is returned as a *duplicateInvoiceError.
+func storeGeneratedInvoice(ctx context.Context, store Store, invoice *Invoice, opts ingestOptions) ([]Event, error) {
+	create := func() ([]Event, error) {
+		var events []Event
+		err := withRetry(ctx, opts.BusyRetries, func() error {
+			var err error
+			events, err = createInvoiceWithEvents(ctx, store, *invoice, opts)
+			return err
+		})
+		return events, err
+	}
 
-	rootCmd.AddCommand(ingestCmd, workerCmd)
+	events, err := create()
+	var duplicate *duplicateInvoiceError
+	if errors.As(err, &duplicate) {
+		slog.Warn("Duplicate invoice ID, retrying with a new one", "invoice_id", invoice.ID)
+		invoice.ID = "INV-" + newInvoiceUUID()
+		events, err = create()
+	}
+	return events, err
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+// workerOptions controls how runWorker fetches and dispatches events
+type workerOptions struct {
+	Driver           string
+	WorkerID         string
+	Queue            string
+	PollInterval     time.Duration
+	DispatchMode     string
+	Concurrency      int
+	PerBusinessLimit int64
+
+	// BatchSize is how many events are fetched per poll
+	BatchSize int64
+
+	// DeliverySemantics is semanticsSendThenMark or semanticsClaimFirst,
+	// see resolveDeliverySemantics when empty
+	DeliverySemantics string
+
+	// MaxPollInterval caps the poll interval as it doubles while the queue
+	// is idle, and PollJitter is the fraction of it each wait varies by
+	MaxPollInterval time.Duration
+	PollJitter      float64
+
+	MetricsFile        string
+	MetricsInterval    time.Duration
+	MetricsRotateBytes int64
+
+	// MetricsAddr serves Prometheus metrics at /metrics, with /healthz and
+	// /readyz next to them (disabled when empty)
+	MetricsAddr string
+
+	// Pprof serves the runtime profiles under /debug/pprof/ on MetricsAddr
+	Pprof bool
+
+	// LivenessTimeout is how long the loop may go without a round before
+	// /healthz reports it stuck
+	LivenessTimeout time.Duration
+
+	Cipher *payloadCipher
+	Delta  bool
+	Retry  retryPolicy
+
+	// BreakerThreshold is how many deliveries in a row may fail before the
+	// circuit breaker stops calling the publisher for BreakerCooldown
+	// (disabled when 0)
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxRPS caps the calls to the publisher per second across every
+	// goroutine (unlimited when 0)
+	MaxRPS float64
+
+	// FailRate is the fraction of deliveries failed on purpose, and
+	// FailLatency how long each is held up first, to simulate a flaky,
+	// slow downstream for testing (both off when 0)
+	FailRate    float64
+	FailLatency time.Duration
+
+	// MaxPayloadBytes dead-letters events whose payload is larger instead
+	// of sending them (unlimited when 0)
+	MaxPayloadBytes int
+
+	// Listener wakes the worker on new events with --notify
+	Listener       *eventListener
+	NotifyFallback time.Duration
+
+	// Once drains the pending events and returns instead of polling
+	Once bool
+
+	// Pause pauses and resumes the worker; runWorker creates one when nil.
+	// Either way it is flipped by SIGUSR1 and SIGUSR2.
+	Pause *pauseSwitch
+
+	// VisibilityTimeout is how long a claimed event may stay processing
+	// before it is handed to another worker
+	VisibilityTimeout time.Duration
+
+	// RecoverOnStart hands back the events WorkerID left processing when it
+	// last stopped before the first poll, rather than after
+	// VisibilityTimeout
+	RecoverOnStart bool
+
+	// ShutdownTimeout is how long deliveries in flight may take to finish
+	// once the worker is shut down before they are cancelled
+	ShutdownTimeout time.Duration
+
+	// DBTimeout bounds each query of the worker
+	DBTimeout time.Duration
+
+	// MaxFatalErrors is how many fatal errors in a row, such as a missing
+	// table or refused credentials, runWorker returns after (never when 0)
+	MaxFatalErrors int
+
+	// Tracer traces each delivery as a child of the span its event was
+	// ingested in (disabled when nil), exporting to OTLPEndpoint
+	Tracer       trace.Tracer
+	OTLPEndpoint string
+}
+
+// eventProcessor holds what's needed to deliver a single event
+type eventProcessor struct {
+	store     Store
+	publisher Publisher
+	cipher    *payloadCipher
+	delta     bool
+	retry     retryPolicy
+	dbTimeout time.Duration
+
+	// maxPayloadBytes is the largest payload sent, unlimited when 0
+	maxPayloadBytes int
+
+	// throttle pauses sending while the sink is rate limiting the worker
+	throttle *throttle
+
+	// breaker is the circuit breaker wrapping publisher, if enabled
+	breaker *circuitBreaker
+
+	// refusal holds the sink refusing the worker's credentials, which
+	// stops the batch from sending more
+	refusal *refusal
+
+	// batch is set while processing events locked on Postgres
+	batch *lockedBatch
+
+	// tracer traces each delivery, disabled when nil
+	tracer trace.Tracer
+
+	// traces holds the sampled delivery spans for the delivery latency
+	// exemplars, nil when tracing is disabled
+	traces *deliveryTraces
+
+	// workerID labels the metrics the processor records
+	workerID string
+}
+
+// payload returns the event payload as it should be sent, decrypting it
+// when it was stored encrypted, decoding the base64 of an unencrypted
+// binary or compressed payload and decompressing it by its encoding
+func (p *eventProcessor) payload(event db.Event) ([]byte, error) {
+	stored, err := p.storedPayload(event)
+	if err != nil {
+		return nil, err
 	}
+	return decompressPayload(event.PayloadEncoding, stored)
+}
+
+// storedPayload returns the bytes of the event payload as they were before
+// it was encrypted or base64 encoded for storage
+func (p *eventProcessor) storedPayload(event db.Event) ([]byte, error) {
+	if !event.Encrypted {
+		if codecFormats[event.Codec].binary || event.PayloadEncoding == payloadGzip {
+			return base64.StdEncoding.DecodeString(event.Payload)
+		}
+		return []byte(event.Payload), nil
+	}
+	if p.cipher == nil {
+		return nil, fmt.Errorf("payload is encrypted but no key was given with --encryption-key, --encryption-key-file or %s", encryptionKeyEnv)
+	}
+	return p.cipher.Decrypt(event.Payload)
+}
+
+// process delivers a single event to the publisher, returning the ID the
+// publisher gave the delivery, if any. Every attempt is recorded in
+// event_attempts. A failed delivery is scheduled for a retry with backoff,
+// unless it can never succeed. Delivered events are marked processed for
+// the whole batch by markProcessed.
+func (p *eventProcessor) process(ctx context.Context, event db.Event) (string, error) {
+	start := time.Now()
+	deliveryID, err := p.send(ctx, event)
+	latency := time.Since(start)
+	if err != nil {
+		// A send cut short by shutdown, or held back by an open circuit,
+		// doesn't count as an attempt
+		if ctx.Err() == nil && !errors.Is(err, errBreakerOpen) {
+			p.recordAttempt(ctx, event, latency, err)
+			var refused sinkAuthError
+			if errors.As(err, &refused) {
+				p.refusal.set(err)
+			} else {
+				p.recordFailure(ctx, event, err)
+			}
+		}
+
+		// Pause for as long as the sink asked, or the event's backoff when
+		// it didn't say
+		var limited rateLimitError
+		if errors.As(err, &limited) {
+			pause := limited.retryAfter
+			if pause <= 0 {
+				pause = p.retry.delay(event.RetryCount)
+			}
+			p.throttle.pause(pause)
+		}
+		return "", err
+	}
+	p.recordAttempt(ctx, event, latency, nil)
+	slog.Info("Delivered event", "event_id", event.ID, "business_id", event.BusinessID, "event_type", event.EventType, "delivery_id", deliveryID)
+	return deliveryID, nil
+}
+
+// paused reports whether the sink is rate limiting the worker or refused
+// its credentials, or the circuit breaker is open, in which case no new
+// event should be sent
+func (p *eventProcessor) paused() bool {
+	return p.throttle.remaining() > 0 || p.breaker.remaining() > 0 || p.refusal.refused()
+}
+
+// markProcessed marks the delivered events of a batch processed in one
+// statement, apart from those with a delivery ID, which is stored with each
+// of them. The events were accepted by the sink, so this happens even if
+// ctx has been cancelled in the meantime and a shutdown can't cause a resend.
+func (p *eventProcessor) markProcessed(ctx context.Context, events []db.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	ctx, cancel := dbContext(context.WithoutCancel(ctx), p.dbTimeout)
+	defer cancel()
+
+	queries, done := p.writeQueries()
+	defer done()
+
+	var ids []string
+	for _, event := range events {
+		if !event.DeliveryID.Valid {
+			ids = append(ids, event.ID)
+			continue
+		}
+		err := queries.MarkEventAsProcessedWithDeliveryID(ctx, db.MarkEventAsProcessedWithDeliveryIDParams{
+			DeliveryID: event.DeliveryID,
+			ID:         event.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("error marking event %s as processed: %v", event.ID, err)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := queries.MarkEventsAsProcessed(ctx, ids); err != nil {
+		return fmt.Errorf("error marking %d events as processed: %v", len(ids), err)
+	}
+	return nil
+}
+
+// send prepares the event payload and publishes it, returning the delivery
+// ID of the publisher
+func (p *eventProcessor) send(ctx context.Context, event db.Event) (string, error) {
+	payload, err := p.payload(event)
+	if err != nil {
+		return "", err
+	}
+
+	// An empty or malformed payload will never send, so don't retry it
+	if len(payload) == 0 {
+		return "", permanentError{fmt.Errorf("empty payload")}
+	}
+
+	format, err := getCodecFormat(event.Codec)
+	if err != nil {
+		return "", fmt.Errorf("error resolving codec: %v", err)
+	}
+	if event.Codec == codecJSON && !json.Valid(payload) {
+		return "", permanentError{fmt.Errorf("payload is not valid JSON")}
+	}
+
+	// Deltas are computed on JSON, so other codecs are always sent in full
+	if p.delta && event.AggregateID.Valid && event.Codec == codecJSON {
+		payload, err = p.deltaPayload(ctx, event, payload)
+		if err != nil {
+			return "", fmt.Errorf("error computing delta: %v", err)
+		}
+	}
+
+	// A payload over the limit will never be accepted, so move it to the
+	// dead-letter table rather than retry it
+	if err := checkPayloadSize(event.EventType, payload, p.maxPayloadBytes); err != nil {
+		slog.Warn("Event payload over the size limit", "event_id", event.ID, "event_type", event.EventType, "payload_bytes", len(payload), "max_payload_bytes", p.maxPayloadBytes)
+		return "", permanentError{err}
+	}
+
+	// Headers that don't decode can't be fixed by sending again either
+	headers, err := decodeHeaders(event.Headers)
+	if err != nil {
+		return "", permanentError{err}
+	}
+
+	// The delivery is a child of the span the event was ingested in, so the
+	// trace runs from the invoice to its webhook, and links to it as
+	// consumers of a message do. The consumer gets the delivery's
+	// traceparent in turn.
+	ctx = tracePropagator.Extract(ctx, propagation.MapCarrier(headers))
+	spanOpts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("event.id", event.ID),
+			attribute.String("event.type", event.EventType),
+			attribute.String("business.id", event.BusinessID),
+		),
+	}
+	if ingested := trace.SpanContextFromContext(ctx); ingested.IsValid() {
+		spanOpts = append(spanOpts, trace.WithLinks(trace.Link{SpanContext: ingested}))
+	}
+	ctx, span := startSpan(ctx, p.tracer, "deliver event", spanOpts...)
+	if p.tracer != nil {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		tracePropagator.Inject(ctx, propagation.MapCarrier(headers))
+	}
+
+	// Send the event
+	start := time.Now()
+	deliveryID, err := p.publisher.Publish(ctx, &outboundEvent{Event: event, Payload: payload, ContentType: format.contentType, Binary: format.binary, Headers: headers})
+	sinkDuration.WithLabelValues(event.Queue, p.workerID).Observe(time.Since(start).Seconds())
+	endSpan(span, err)
+	if err == nil {
+		p.traces.set(event.ID, span.SpanContext())
+	}
+	return deliveryID, err
+}
+
+// sleepContext waits for d and reports false if ctx was cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// waitForEvents waits before the next poll: until a new event is announced
+// with --notify, otherwise for pollInterval
+func waitForEvents(ctx context.Context, opts workerOptions, pollInterval time.Duration) bool {
+	if opts.Listener != nil {
+		return opts.Listener.wait(ctx, opts.NotifyFallback)
+	}
+	return sleepContext(ctx, pollInterval)
+}
+
+// runWorker polls for pending events and dispatches them until ctx is
+// cancelled, or with opts.Once until no pending events are left. A batch in
+// progress stops taking new events on cancellation but events already sent
+// are still marked processed. SIGUSR1 and SIGUSR2 pause and resume it, see
+// pauseSwitch.
+func runWorker(ctx context.Context, store Store, publisher Publisher, opts workerOptions) error {
+	if opts.FailRate > 0 || opts.FailLatency > 0 {
+		publisher = &faultyPublisher{next: publisher, failRate: opts.FailRate, latency: opts.FailLatency}
+	}
+	if opts.MaxRPS > 0 {
+		publisher = &rateLimitedPublisher{next: publisher, limiter: newRPSLimiter(opts.MaxRPS)}
+	}
+	var breaker *circuitBreaker
+	if opts.BreakerThreshold > 0 {
+		breaker = newCircuitBreaker(publisher, opts.BreakerThreshold, opts.BreakerCooldown)
+		publisher = breaker
+	}
+	processor := &eventProcessor{
+		store:     store,
+		publisher: publisher,
+		cipher:    opts.Cipher,
+		delta:     opts.Delta,
+		retry:     opts.Retry,
+		dbTimeout: opts.DBTimeout,
+
+		maxPayloadBytes: opts.MaxPayloadBytes,
+
+		throttle: &throttle{},
+		breaker:  breaker,
+		refusal:  &refusal{},
+		tracer:   opts.Tracer,
+		workerID: opts.WorkerID,
+	}
+	if opts.Tracer != nil {
+		processor.traces = &deliveryTraces{}
+	}
+
+	// The metrics file and server stop with the worker, even when it
+	// returns on its own with opts.Once
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pause := opts.Pause
+	if pause == nil {
+		pause = newPauseSwitch()
+	}
+	go watchPauseSignals(ctx, pause)
+
+	stats := &deliveryStats{}
+	if opts.MetricsFile != "" {
+		go runMetricsFile(ctx, store, stats, opts)
+	}
+	live := newLiveness(opts.LivenessTimeout)
+	if opts.MetricsAddr != "" {
+		stopped, err := serveMetrics(ctx, opts.MetricsAddr, metricsHandler(store, live, pause, opts.DBTimeout, opts.Pprof))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			cancel()
+			<-stopped
+		}()
+	}
+
+	// Pooled batches are claimed so several workers can share a queue:
+	// sending then marking, on Postgres with row locks held until the batch
+	// is marked, or claiming first by marking the events processing
+	semantics := resolveDeliverySemantics(opts)
+	slog.Info("Delivery semantics", "semantics", semantics, "tradeoff", deliveryTradeoffs[semantics])
+	lock := semantics == semanticsSendThenMark && opts.Driver == driverPostgres && opts.DispatchMode == dispatchPool
+	claim := semantics == semanticsClaimFirst
+
+	// Batches that are neither locked nor claimed, on SQLite with
+	// --delivery-semantics send-then-mark, are paged: after a full batch the
+	// next one starts after its last event, in the same oldest-first order,
+	// so draining a large backlog, with --once in particular, seeks on
+	// idx_events_queue_status instead of going over the events already
+	// handled in this poll cycle
+	paged := !lock && !claim && opts.DispatchMode != dispatchPerBusiness
+	var after string
+
+	// Events this worker ID left processing when it last stopped aren't
+	// being sent by anyone, so they needn't wait out the visibility timeout
+	if opts.RecoverOnStart && opts.WorkerID != "" {
+		dbCtx, cancel := dbContext(ctx, opts.DBTimeout)
+		released, err := releaseWorkerEvents(dbCtx, store, opts.WorkerID, opts.Queue)
+		cancel()
+		switch {
+		case err != nil:
+			slog.Error("Error recovering events on start, leaving them to the visibility timeout", "queue", opts.Queue, "error", err)
+		case released > 0:
+			slog.Warn("Recovered events left processing by this worker's last run", "count", released, "queue", opts.Queue)
+		default:
+			slog.Info("No events left processing by this worker's last run", "queue", opts.Queue)
+		}
+	}
+
+	backoff := newPollBackoff(opts)
+	fatal := &fatalCounter{limit: opts.MaxFatalErrors}
+	// Refused sink credentials are counted apart, as every poll between two
+	// batches succeeds
+	refusals := &fatalCounter{limit: opts.MaxFatalErrors}
+	var lastRecovery time.Time
+	for {
+		if ctx.Err() != nil {
+			slog.Info("Shutting down worker")
+			return nil
+		}
+		live.beat()
+
+		// A paused worker keeps going round, so it stays live, but leaves the
+		// database and the publisher alone until it is resumed
+		if pause.isPaused() {
+			pause.wait(ctx, opts.PollInterval)
+			continue
+		}
+
+		// Events claimed by a worker that died are stuck processing, so on
+		// start and every visibility timeout they are handed back
+		if time.Since(lastRecovery) >= opts.VisibilityTimeout {
+			lastRecovery = time.Now()
+			dbCtx, cancel := dbContext(ctx, opts.DBTimeout)
+			recovered, err := recoverStuckEvents(dbCtx, store, opts.VisibilityTimeout)
+			cancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Error recovering stuck events", "queue", opts.Queue, "error", err)
+				}
+			} else if recovered > 0 {
+				slog.Warn("Recovered events stuck processing", "count", recovered, "visibility_timeout", opts.VisibilityTimeout.String())
+			}
+		}
+
+		// The backlog is logged with every poll and kept in the gauges
+		dbCtx, cancel := dbContext(ctx, opts.DBTimeout)
+		depth, err := readQueueDepth(dbCtx, store, opts.Queue)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("Error reading queue depth", "queue", opts.Queue, "error", err)
+			}
+		} else {
+			recordQueueDepth(opts.Queue, opts.WorkerID, depth)
+		}
+		pollLog := slog.With("queue", opts.Queue, "pending", depth.Pending, "oldest_pending_age", depth.OldestPendingAge.Truncate(time.Second).String())
+
+		var events []db.Event
+		var batch *lockedBatch
+		dbCtx, cancel = dbContext(ctx, opts.DBTimeout)
+		if lock {
+			batch, events, err = lockPendingEvents(dbCtx, store, opts)
+		} else if claim {
+			events, err = claimPendingEvents(dbCtx, store, opts)
+		} else if opts.DispatchMode == dispatchPerBusiness {
+			events, err = store.GetPendingEventsPerBusiness(dbCtx, db.GetPendingEventsPerBusinessParams{
+				Queue:            opts.Queue,
+				PerBusinessLimit: opts.PerBusinessLimit,
+				BatchLimit:       opts.BatchSize,
+			})
+		} else {
+			events, err = store.GetPendingEventsAfter(dbCtx, db.GetPendingEventsAfterParams{
+				Queue:   opts.Queue,
+				AfterID: after,
+				Limit:   opts.BatchSize,
+			})
+		}
+		cancel()
+		if err != nil {
+			if opts.Once && ctx.Err() == nil {
+				return fmt.Errorf("error fetching events: %v", err)
+			}
+			if ctx.Err() == nil {
+				slog.Error("Error fetching events", "queue", opts.Queue, "error", err)
+				if err := fatal.record(err); err != nil {
+					return err
+				}
+			}
+
+			// A lost connection doesn't come back by retrying the query, so
+			// the pool is reopened first
+			if reopen, ok := store.(reopener); ok && isConnectionError(err) && ctx.Err() == nil {
+				slog.Warn("Lost the connection to the database, reconnecting", "error", err)
+				reconnect(ctx, reopen, opts.DBTimeout)
+				continue
+			}
+			sleepContext(ctx, backoff.next())
+			continue
+		}
+
+		fatal.record(nil)
+		if len(events) == 0 {
+			if batch != nil {
+				batch.tx.Rollback()
+			}
+			// The end of a backlog paged through isn't the end of the queue:
+			// events skipped on the way, e.g. left unsent by a rate limit, are
+			// before the cursor, so the next page starts over
+			if after != "" {
+				after = ""
+				continue
+			}
+			if opts.Once {
+				slog.Info("No pending events left, exiting", "queue", opts.Queue)
+				return nil
+			}
+			// An idle queue is polled less and less often
+			wait := backoff.idlePoll()
+			if opts.Listener != nil {
+				pollLog.Debug("No pending events found, waiting for new events")
+			} else {
+				pollLog.Debug("No pending events found, polling again", "poll_interval", wait.Truncate(time.Millisecond).String())
+			}
+			waitForEvents(ctx, opts, wait)
+			continue
+		}
+
+		pollLog.Info("Found pending events to process", "count", len(events))
+		backoff.reset()
+
+		batchProcessor := processor
+		if batch != nil {
+			batchProcessor = processor.withBatch(batch)
+		}
+		live := batchProcessor.expireEvents(ctx, events)
+
+		// A shutdown stops new events from being sent, while those in flight
+		// get up to the shutdown timeout to finish
+		sendCtx, cancelSends := drainContext(ctx, opts.ShutdownTimeout)
+		var processed []db.Event
+		var failed int
+		if opts.DispatchMode == dispatchPerBusiness {
+			processed, failed = dispatchPerBusinessEvents(ctx, sendCtx, batchProcessor, live, opts.Concurrency)
+		} else {
+			processed, failed = dispatchPooled(ctx, sendCtx, batchProcessor, live, opts.Concurrency)
+		}
+		cancelSends()
+
+		err = batchProcessor.markProcessed(ctx, processed)
+		if batch != nil {
+			if err == nil {
+				err = batch.commit()
+			} else {
+				batch.tx.Rollback()
+			}
+		}
+		if err != nil {
+			// The events stay pending and are sent again, deduplicated by Convoy
+			slog.Error("Error marking events as processed", "queue", opts.Queue, "error", err)
+			processed = nil
+		}
+		if claim {
+			// Whatever is still processing wasn't delivered
+			dbCtx, cancel := dbContext(context.WithoutCancel(ctx), opts.DBTimeout)
+			if err := releaseClaimedEvents(dbCtx, store, events); err != nil {
+				slog.Error("Error releasing claimed events", "queue", opts.Queue, "error", err)
+			}
+			cancel()
+		}
+		after = ""
+		if paged && int64(len(events)) == opts.BatchSize {
+			after = events[len(events)-1].ID
+		}
+		stats.delivered.Add(int64(len(processed)))
+		stats.failed.Add(int64(failed))
+		eventsDispatched.WithLabelValues(opts.Queue, opts.WorkerID).Add(float64(len(processed)))
+		eventsFailed.WithLabelValues(opts.Queue, opts.WorkerID).Add(float64(failed))
+		recordDeliveryLatency(opts.Queue, opts.WorkerID, processed, processor.traces.take(events))
+
+		// Persist how far this worker got so progress survives restarts
+		if lastProcessed, ok := latestEvent(processed); ok {
+			dbCtx, cancel := dbContext(context.WithoutCancel(ctx), opts.DBTimeout)
+			err := store.UpsertWorkerCursor(dbCtx, db.UpsertWorkerCursorParams{
+				WorkerID:           opts.WorkerID,
+				LastEventID:        sql.NullString{String: lastProcessed.ID, Valid: true},
+				LastEventCreatedAt: lastProcessed.CreatedAt,
+				EventsProcessed:    int64(len(processed)),
+			})
+			cancel()
+			if err != nil {
+				slog.Error("Error updating cursor", "error", err)
+			}
+		}
+
+		// Refused credentials fail every event alike, so the batch counts as
+		// one fatal error, and the worker backs off as after a failed poll
+		refused := processor.refusal.take()
+		if err := refusals.record(refused); err != nil {
+			return err
+		}
+		if refused != nil {
+			slog.Error("Sink refused the worker's credentials, leaving the events pending", "queue", opts.Queue, "error", refused)
+			sleepContext(ctx, backoff.next())
+			continue
+		}
+
+		// A rate limited sink is left alone until it said to come back
+		if pause := processor.throttle.remaining(); pause > 0 {
+			slog.Warn("Sink is rate limiting, pausing before the next batch", "queue", opts.Queue, "pause", pause.Truncate(time.Millisecond).String())
+			sleepContext(ctx, pause)
+			continue
+		}
+
+		// A sink that keeps failing isn't polled for until the circuit
+		// breaker lets a probe through
+		if pause := breaker.remaining(); pause > 0 {
+			if opts.Once {
+				return fmt.Errorf("circuit breaker opened after %d deliveries in a row failed", opts.BreakerThreshold)
+			}
+			slog.Warn("Circuit breaker is open, pausing before the next batch", "queue", opts.Queue, "pause", pause.Truncate(time.Millisecond).String())
+			sleepContext(ctx, pause)
+			continue
+		}
+
+		// A full batch means more may be waiting, which no notification
+		// will announce, so drain them first. With --once the next batch is
+		// fetched straight away until none are left
+		if opts.Once || (opts.Listener != nil && int64(len(events)) == opts.BatchSize) {
+			continue
+		}
+		waitForEvents(ctx, opts, backoff.next())
+	}
+}
+
+// printCursor writes a single worker cursor in a human readable form
+func printCursor(cursor db.WorkerCursor) {
+	lastEventID := "-"
+	if cursor.LastEventID.Valid {
+		lastEventID = cursor.LastEventID.String
+	}
+	lastEventCreatedAt := "-"
+	if cursor.LastEventCreatedAt.Valid {
+		lastEventCreatedAt = cursor.LastEventCreatedAt.Time.Format(time.RFC3339)
+	}
+
+	fmt.Printf("Worker:            %s\n", cursor.WorkerID)
+	fmt.Printf("Last event ID:     %s\n", lastEventID)
+	fmt.Printf("Last event time:   %s\n", lastEventCreatedAt)
+	fmt.Printf("Events processed:  %d\n", cursor.EventsProcessed)
+	fmt.Printf("Updated at:        %s\n", cursor.UpdatedAt.Format(time.RFC3339))
+}
+
+func runCursor(ctx context.Context, queries Querier, workerID string) error {
+	if workerID != "" {
+		cursor, err := queries.GetWorkerCursor(ctx, workerID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no cursor found for worker %s", workerID)
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching cursor: %v", err)
+		}
+		printCursor(cursor)
+		return nil
+	}
+
+	cursors, err := queries.ListWorkerCursors(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing cursors: %v", err)
+	}
+	if len(cursors) == 0 {
+		fmt.Println("No worker cursors recorded yet.")
+		return nil
+	}
+	for i, cursor := range cursors {
+		if i > 0 {
+			fmt.Println()
+		}
+		printCursor(cursor)
+	}
+	return nil
+}
+
+// defaultWorkerID identifies this worker by hostname when no ID is given,
+// so a restarted worker keeps its cursor, metric labels and claims. Several
+// workers on one host need an ID each.
+func defaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "worker"
+	}
+	return hostname
+}
+
+// initDB initializes the database with the migrations and the predefined
+// businesses if it doesn't exist.
+// An existing database is recreated with force, kept with skipIfExists, and
+// otherwise the user is asked. Without a terminal to ask on it is kept.
+func initDB(cfg dbConfig, force, skipIfExists bool) error {
+	if cfg.Driver == driverPostgres {
+		return initPostgres(cfg, force)
+	}
+	dbPath := cfg.Path
+
+	// Check if database file exists
+	if _, err := os.Stat(dbPath); err == nil {
+		recreate := force
+		if !force && !skipIfExists && term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Printf("Database file %s already exists. Do you want to recreate it? (y/n): ", dbPath)
+			var response string
+			fmt.Scanln(&response)
+			recreate = strings.ToLower(response) == "y"
+		}
+		if !recreate {
+			fmt.Println("Using existing database.")
+			return nil
+		}
+		// Remove existing database file
+		if err := os.Remove(dbPath); err != nil {
+			return fmt.Errorf("error removing existing database: %v", err)
+		}
+		// A WAL left behind would be replayed into the new database
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing existing database: %v", err)
+			}
+		}
+	}
+
+	// Open database connection
+	dbConn, err := sql.Open(driverSQLite, cfg.dataSource())
+	if err != nil {
+		return fmt.Errorf("error opening database: %v", err)
+	}
+	defer dbConn.Close()
+
+	// Create the schema by migrating up, so its version is tracked from the
+	// start
+	migrations, err := cfg.migrations()
+	if err != nil {
+		return err
+	}
+	if _, err := newMigrator(dbConn, migrations).up(context.Background()); err != nil {
+		return err
+	}
+	if err := seedDatabase(context.Background(), db.New(dbConn).WithTables(cfg.tables())); err != nil {
+		return err
+	}
+
+	fmt.Println("Database initialized successfully!")
+	return nil
+}
+
+func main() {
+	rootCmd := newRootCmd()
+
+	// Cancel the running command on Ctrl-C or when asked to stop by systemd or Kubernetes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		stop()
+		log.Fatal(err)
+	}
+}
+
+// newRootCmd builds the command tree with every command and its flags
+func newRootCmd() *cobra.Command {
+	var configFile string
+	var database dbConfig
+	var logFormat string
+	var logLevel string
+	var forceInit bool
+	var skipInitIfExists bool
+	var rootCmd = &cobra.Command{
+		Use:   "transactional-outbox",
+		Short: "Transactional outbox pattern implementation for webhook delivery",
+		Long: `A demonstration of the transactional outbox pattern for reliable webhook delivery.
+This application can run in either ingest mode to generate events or worker mode to process them.`,
+		// Initialize database on startup
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// The environment and config file fill in the flags first, as
+			// every other setting, including logging, may come from them
+			if err := applySettings(cmd, configFile); err != nil {
+				return err
+			}
+			if err := configureLogging(logFormat, logLevel); err != nil {
+				return err
+			}
+			if forceInit && skipInitIfExists {
+				return fmt.Errorf("--force and --skip-if-exists can't be used together")
+			}
+			// Commands that never touch the database don't create one
+			if cmd.Annotations[annotationNoDB] != "" {
+				return nil
+			}
+			if err := database.validate(); err != nil {
+				return err
+			}
+			if cmd.Annotations[annotationNoInit] != "" {
+				return nil
+			}
+			if err := initDB(database, forceInit, skipInitIfExists); err != nil {
+				return fmt.Errorf("failed to initialize database: %v", err)
+			}
+			return nil
+		},
+	}
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (YAML, JSON or TOML) of flag names and values, e.g. poll-interval: 2s, used for the flags not given on the command line or in the environment")
+	addDBFlags(rootCmd, &database)
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText, "Log format: text or json")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Minimum level logged: debug, info, warn or error")
+	rootCmd.PersistentFlags().BoolVar(&forceInit, "force", false, "Recreate an existing database without asking")
+	rootCmd.PersistentFlags().BoolVar(&skipInitIfExists, "skip-if-exists", false, "Use an existing database without asking")
+
+	var rate string
+	var seed int64
+	var ingestCount int
+	var ingestDelay time.Duration
+	var eventTTL time.Duration
+	var ingestWorkers int
+	var reportInterval time.Duration
+	var busyRetries int
+	var txIsolation string
+	var logPayloads bool
+	var redactFields []string
+	var ingestMaxFatalErrors int
+	var validateBusiness bool
+	var validateSchema bool
+	var ingestMaxPayloadBytes int
+	var compressPayloads bool
+	var deliveryMode string
+	var idempotencyStrategy string
+	var onOversize string
+	var ingestBusinessIDs []string
+	var businessesFile string
+	var normalizeJSONPayloads bool
+	var sortJSONKeys bool
+	var derivedEvents []string
+	var eventFormat string
+	var schemaVersion int
+	var codecName string
+	var codecSchema string
+	var codecMessage string
+	var ingestQueue string
+	var ingestKey string
+	var ingestKeyFile string
+	var ingestOTLPEndpoint string
+	var ingestCmd = &cobra.Command{
+		Use:   "ingest",
+		Short: "Run in ingest mode to generate invoice events",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rateDuration, err := time.ParseDuration(rate)
+			if err != nil {
+				return fmt.Errorf("invalid rate format: %v", err)
+			}
+			if ingestCount < 0 {
+				return fmt.Errorf("--count must not be negative, got %d", ingestCount)
+			}
+			if ingestDelay < 0 {
+				return fmt.Errorf("--delay must not be negative, got %v", ingestDelay)
+			}
+			if eventTTL < 0 {
+				return fmt.Errorf("--event-ttl must not be negative, got %v", eventTTL)
+			}
+			if ingestWorkers < 1 {
+				return fmt.Errorf("--workers must be at least 1, got %d", ingestWorkers)
+			}
+			if reportInterval < 0 {
+				return fmt.Errorf("--report-interval must not be negative, got %v", reportInterval)
+			}
+			if ingestMaxFatalErrors < 0 {
+				return fmt.Errorf("--max-fatal-errors must not be negative, got %d", ingestMaxFatalErrors)
+			}
+			if ingestMaxPayloadBytes < 0 {
+				return fmt.Errorf("--max-payload-bytes must not be negative, got %d", ingestMaxPayloadBytes)
+			}
+			if onOversize != oversizeReject && onOversize != oversizeTruncate {
+				return fmt.Errorf("invalid --on-oversize %q: must be %q or %q", onOversize, oversizeReject, oversizeTruncate)
+			}
+			if len(redactFields) > 0 && !logPayloads {
+				return fmt.Errorf("--redact-fields only applies with --log-payloads")
+			}
+			isolation, err := parseTxIsolation(txIsolation, database.Driver)
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("seed") {
+				random = newLockedRand(seed)
+			}
+
+			ids, err := loadBusinessIDs(ingestBusinessIDs, businessesFile)
+			if err != nil {
+				return err
+			}
+
+			mapper, err := buildEventMapper(derivedEvents)
+			if err != nil {
+				return err
+			}
+			if err := validateEventFormat(eventFormat); err != nil {
+				return err
+			}
+			if schemaVersion < 1 {
+				return fmt.Errorf("--schema-version must be at least 1, got %d", schemaVersion)
+			}
+			if err := validateDeliveryMode(deliveryMode); err != nil {
+				return err
+			}
+			if err := validateIdempotencyStrategy(idempotencyStrategy); err != nil {
+				return err
+			}
+
+			codec, err := getPayloadCodec(codecName, codecSchema, codecMessage)
+			if err != nil {
+				return err
+			}
+
+			payloadCipher, err := resolvePayloadCipher(ingestKey, ingestKeyFile)
+			if err != nil {
+				return err
+			}
+
+			tracerProvider, err := newOTLPTracerProvider(ingestOTLPEndpoint, "outbox-ingest")
+			if err != nil {
+				return err
+			}
+			defer flushTracer(tracerProvider)
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runIngest(cmd.Context(), store, ingestOptions{
+				Rate:          rateDuration,
+				Queue:         ingestQueue,
+				BusinessIDs:   ids,
+				Count:         ingestCount,
+				Delay:         ingestDelay,
+				EventTTL:      eventTTL,
+				Mapper:        mapper,
+				EventFormat:   eventFormat,
+				SchemaVersion: schemaVersion,
+				Codec:         codec,
+				Cipher:        payloadCipher,
+				NormalizeJSON: normalizeJSONPayloads,
+				SortJSONKeys:  sortJSONKeys,
+				BusyRetries:   busyRetries,
+				TxIsolation:   isolation,
+				LogPayloads:   logPayloads,
+				RedactFields:  redactFields,
+				DBTimeout:     database.Timeout,
+
+				MaxFatalErrors: ingestMaxFatalErrors,
+
+				ValidateBusiness: validateBusiness,
+				ValidateSchema:   validateSchema,
+
+				MaxPayloadBytes: ingestMaxPayloadBytes,
+				OnOversize:      onOversize,
+
+				CompressPayloads:    compressPayloads,
+				DeliveryMode:        deliveryMode,
+				IdempotencyStrategy: idempotencyStrategy,
+
+				Workers:        ingestWorkers,
+				ReportInterval: reportInterval,
+
+				Tracer: providerTracer(tracerProvider),
+			})
+		},
+	}
+	ingestCmd.Flags().StringVar(&rate, "rate", "30s", "Rate at which to generate events (e.g. 30s, 1m)")
+	ingestCmd.Flags().IntVar(&ingestCount, "count", 0, "Number of invoices to generate before exiting (0 runs until interrupted)")
+	ingestCmd.Flags().DurationVar(&ingestDelay, "delay", 0, "How long after it is written each event becomes visible to the worker, to schedule deliveries such as reminders for later")
+	ingestCmd.Flags().DurationVar(&eventTTL, "event-ttl", 0, "How long each event may wait to be delivered, from when it becomes visible, before the worker expires it to the dead-letter table instead (0 never expires)")
+	ingestCmd.Flags().IntVar(&ingestWorkers, "workers", 1, "Number of producers storing invoices concurrently, each at --rate")
+	ingestCmd.Flags().DurationVar(&reportInterval, "report-interval", 10*time.Second, "How often the invoices stored per second across all producers are logged (0 disables)")
+	ingestCmd.Flags().IntVar(&busyRetries, "busy-retries", 5, "Times an invoice's transaction is retried when the SQLite database is locked or Postgres fails to serialize it or breaks a deadlock with it")
+	ingestCmd.Flags().StringVar(&txIsolation, "tx-isolation", "default", "Isolation level of each invoice's transaction: default, read-committed, repeatable-read or serializable (SQLite: default or serializable)")
+	ingestCmd.Flags().IntVar(&ingestMaxFatalErrors, "max-fatal-errors", 5, "Invoices in a row a producer may fail to store on an error retrying can't fix, such as a missing table or refused credentials, before ingest exits with it (0 never exits)")
+	ingestCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for the generated invoices, so a run can be repeated exactly (seeded from the clock when not given)")
+	ingestCmd.Flags().BoolVar(&normalizeJSONPayloads, "normalize-json", false, "Compact event payloads before storing them")
+	ingestCmd.Flags().StringVar(&ingestQueue, "queue", defaultQueue, "Name of the outbox queue events are written to")
+	ingestCmd.Flags().StringSliceVar(&ingestBusinessIDs, "business-ids", nil, "Comma-separated UUIDs of the businesses to generate invoices for (default: the predefined businesses)")
+	ingestCmd.Flags().BoolVar(&validateBusiness, "validate-business", false, "Reject invoices whose business hasn't been seeded before writing them, instead of failing on the foreign key")
+	ingestCmd.Flags().BoolVar(&validateSchema, "validate-schema", false, "Reject invoices that don't conform to invoice.schema.json before writing them")
+	ingestCmd.Flags().IntVar(&ingestMaxPayloadBytes, "max-payload-bytes", 0, "Largest encoded event payload stored, in bytes (0 is unlimited)")
+	ingestCmd.Flags().StringVar(&onOversize, "on-oversize", oversizeReject, "What happens to an invoice whose payload is over --max-payload-bytes: reject it, or truncate its description until it fits")
+	ingestCmd.Flags().StringVar(&businessesFile, "businesses-file", "", "File listing one business UUID per line to generate invoices for")
+	ingestCmd.Flags().StringVar(&eventFormat, "event-format", eventFormatRaw, "How event payloads are built: raw, cloudevents for a CloudEvents 1.0 envelope, or envelope for one carrying --schema-version")
+	ingestCmd.Flags().IntVar(&schemaVersion, "schema-version", defaultSchemaVersion, "Version of the invoice shape stamped on each payload with --event-format envelope, for consumers to branch on")
+	ingestCmd.Flags().StringVar(&codecName, "codec", codecJSON, "Encoding used for stored event payloads: json, protobuf or avro")
+	ingestCmd.Flags().StringVar(&codecSchema, "codec-schema", "", "Schema the payloads are encoded with: a Protobuf descriptor set with --codec protobuf, or an Avro schema (.avsc) with --codec avro")
+	ingestCmd.Flags().StringVar(&codecMessage, "codec-message", "", "Full name of the Protobuf message the payloads are encoded as, e.g. invoices.v1.Invoice")
+	ingestCmd.Flags().StringVar(&ingestKeyFile, "encryption-key-file", "", "File holding a hex or base64 AES key used to encrypt stored payloads")
+	ingestCmd.Flags().StringVar(&ingestKey, "encryption-key", "", "Hex or base64 AES key, given instead of --encryption-key-file (OUTBOX_ENC_KEY is read when neither is)")
+	ingestCmd.Flags().BoolVar(&logPayloads, "log-payloads", false, "Log the amount and payload of each stored invoice, which may hold personal or financial data")
+	ingestCmd.Flags().StringSliceVar(&redactFields, "redact-fields", nil, "JSON fields masked in logged payloads, at any depth, with --log-payloads (e.g. amount_cents,description)")
+	ingestCmd.Flags().StringSliceVar(&derivedEvents, "derived-events", nil, "Additional events to write with each invoice (available: ledger.entry.added)")
+	ingestCmd.Flags().StringVar(&idempotencyStrategy, "idempotency-strategy", idempotencyEventID, "How each event's idempotency key is chosen: event-id, or content-hash for a SHA-256 of its business, type and payload")
+	ingestCmd.Flags().StringVar(&ingestOTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector to send a trace span per invoice to, e.g. http://localhost:4318 (disabled when empty)")
+	ingestCmd.Flags().StringVar(&deliveryMode, "delivery-mode", deliveryFanout, "How Convoy delivers the events: fanout to the business's endpoints, or broadcast to every subscriber")
+	ingestCmd.Flags().BoolVar(&compressPayloads, "compress-payloads", false, "Store event payloads compressed with gzip; the worker decompresses them before sending")
+	ingestCmd.Flags().BoolVar(&sortJSONKeys, "sort-json-keys", false, "Sort object keys in event payloads before storing them (implies --normalize-json)")
+
+	var advanceLimit int64
+	var advanceQueue string
+	var advanceKey string
+	var advanceKeyFile string
+	var advanceCmd = &cobra.Command{
+		Use:   "advance",
+		Short: "Move draft and sent invoices along their lifecycle, writing an event for each change",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if advanceLimit <= 0 {
+				return fmt.Errorf("limit must be positive")
+			}
+
+			codec, err := getPayloadCodec(codecJSON, "", "")
+			if err != nil {
+				return err
+			}
+
+			payloadCipher, err := resolvePayloadCipher(advanceKey, advanceKeyFile)
+			if err != nil {
+				return err
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runAdvance(cmd.Context(), store, advanceLimit, ingestOptions{
+				Queue:     advanceQueue,
+				Codec:     codec,
+				Cipher:    payloadCipher,
+				DBTimeout: database.Timeout,
+			})
+		},
+	}
+	advanceCmd.Flags().Int64Var(&advanceLimit, "limit", 10, "Maximum number of invoices of each status advanced per run")
+	advanceCmd.Flags().StringVar(&advanceQueue, "queue", defaultQueue, "Name of the outbox queue events are written to")
+	advanceCmd.Flags().StringVar(&advanceKeyFile, "encryption-key-file", "", "File holding a hex or base64 AES key used to encrypt stored payloads")
+	advanceCmd.Flags().StringVar(&advanceKey, "encryption-key", "", "Hex or base64 AES key, given instead of --encryption-key-file (OUTBOX_ENC_KEY is read when neither is)")
+
+	var importBatch int
+	var importQueue string
+	var importKey string
+	var importKeyFile string
+	var importValidateSchema bool
+	var importCSVCmd = &cobra.Command{
+		Use:   "import-csv <file>",
+		Short: "Store the invoices of a CSV file, each with its invoice.created event",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if importBatch <= 0 {
+				return fmt.Errorf("batch size must be positive")
+			}
+
+			mapper, err := buildEventMapper(nil)
+			if err != nil {
+				return err
+			}
+			codec, err := getPayloadCodec(codecJSON, "", "")
+			if err != nil {
+				return err
+			}
+
+			payloadCipher, err := resolvePayloadCipher(importKey, importKeyFile)
+			if err != nil {
+				return err
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runImportCSV(cmd.Context(), store, args[0], importBatch, ingestOptions{
+				Queue:     importQueue,
+				Mapper:    mapper,
+				Codec:     codec,
+				Cipher:    payloadCipher,
+				DBTimeout: database.Timeout,
+
+				ValidateBusiness: true,
+				ValidateSchema:   importValidateSchema,
+			})
+		},
+	}
+	importCSVCmd.Flags().IntVar(&importBatch, "batch", 1, "Number of rows stored per transaction")
+	importCSVCmd.Flags().StringVar(&importQueue, "queue", defaultQueue, "Name of the outbox queue events are written to")
+	importCSVCmd.Flags().StringVar(&importKeyFile, "encryption-key-file", "", "File holding a hex or base64 AES key used to encrypt stored payloads")
+	importCSVCmd.Flags().StringVar(&importKey, "encryption-key", "", "Hex or base64 AES key, given instead of --encryption-key-file (OUTBOX_ENC_KEY is read when neither is)")
+	importCSVCmd.Flags().BoolVar(&importValidateSchema, "validate-schema", false, "Reject rows whose invoice doesn't conform to invoice.schema.json")
+
+	var serveAddr string
+	var serveQueue string
+	var serveKey string
+	var serveKeyFile string
+	var serveValidateSchema bool
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Run an HTTP API storing the invoices POSTed to /invoices with their events",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapper, err := buildEventMapper(nil)
+			if err != nil {
+				return err
+			}
+			codec, err := getPayloadCodec(codecJSON, "", "")
+			if err != nil {
+				return err
+			}
+
+			payloadCipher, err := resolvePayloadCipher(serveKey, serveKeyFile)
+			if err != nil {
+				return err
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runServe(cmd.Context(), store, serveAddr, ingestOptions{
+				Queue:     serveQueue,
+				Mapper:    mapper,
+				Codec:     codec,
+				Cipher:    payloadCipher,
+				DBTimeout: database.Timeout,
+
+				ValidateBusiness: true,
+				ValidateSchema:   serveValidateSchema,
+			})
+		},
+	}
+	serveCmd.Flags().StringVar(&serveAddr, "addr", ":8081", "Address to serve the ingest API on")
+	serveCmd.Flags().StringVar(&serveQueue, "queue", defaultQueue, "Name of the outbox queue events are written to")
+	serveCmd.Flags().StringVar(&serveKeyFile, "encryption-key-file", "", "File holding a hex or base64 AES key used to encrypt stored payloads")
+	serveCmd.Flags().StringVar(&serveKey, "encryption-key", "", "Hex or base64 AES key, given instead of --encryption-key-file (OUTBOX_ENC_KEY is read when neither is)")
+	serveCmd.Flags().BoolVar(&serveValidateSchema, "validate-schema", false, "Answer 400 to invoices that don't conform to invoice.schema.json")
+
+	var pollInterval string
+	var maxPollInterval time.Duration
+	var pollJitter float64
+	var workerConvoy convoyConfig
+	var workerID string
+	var workerQueue string
+	var dispatchMode string
+	var orderedByBusiness bool
+	var deliverySemantics string
+	var concurrency int
+	var perBusinessLimit int64
+	var workerBatchSize int64
+	var metricsFile string
+	var metricsInterval time.Duration
+	var metricsRotateBytes int64
+	var metricsAddr string
+	var pprofEnabled bool
+	var livenessTimeout time.Duration
+	var notify bool
+	var notifyFallback time.Duration
+	var once bool
+	var visibilityTimeout time.Duration
+	var recoverOnStart bool
+	var shutdownTimeout time.Duration
+	var workerMaxFatalErrors int
+	var workerKey string
+	var workerKeyFile string
+	var workerOTLPEndpoint string
+	var skipPreflight bool
+	var publisherName string
+	var convoyDeliveryIDs bool
+	var routingFile string
+	var webhookURL string
+	var webhookSecret string
+	var webhookRetries int
+	var quiet bool
+	var maxRPS float64
+	var failRate float64
+	var failLatency time.Duration
+	var workerMaxPayloadBytes int
+	var breakerThreshold int
+	var breakerCooldown time.Duration
+	var delta bool
+	var retry retryPolicy
+
+	var workerCmd = &cobra.Command{
+		Use:   "worker",
+		Short: "Run in worker mode to process events",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pollIntervalDuration, err := time.ParseDuration(pollInterval)
+			if err != nil {
+				return fmt.Errorf("invalid poll interval format: %v", err)
+			}
+			if pollJitter < 0 || pollJitter >= 1 {
+				return fmt.Errorf("poll jitter must be at least 0 and less than 1")
+			}
+
+			if orderedByBusiness {
+				if cmd.Flags().Changed("dispatch-mode") && dispatchMode != dispatchPerBusiness {
+					return fmt.Errorf("--ordered-by-business can't be combined with --dispatch-mode %s", dispatchMode)
+				}
+				dispatchMode = dispatchPerBusiness
+			}
+			if dispatchMode != dispatchPool && dispatchMode != dispatchPerBusiness {
+				return fmt.Errorf("invalid dispatch mode %q: must be %q or %q", dispatchMode, dispatchPool, dispatchPerBusiness)
+			}
+			if err := validateDeliverySemantics(deliverySemantics, dispatchMode); err != nil {
+				return err
+			}
+			if concurrency <= 0 {
+				return fmt.Errorf("concurrency must be positive")
+			}
+			if perBusinessLimit <= 0 {
+				return fmt.Errorf("per-business limit must be positive")
+			}
+			if workerBatchSize <= 0 {
+				return fmt.Errorf("batch size must be positive")
+			}
+			if err := retry.validate(); err != nil {
+				return err
+			}
+			if maxRPS < 0 {
+				return fmt.Errorf("max rps must not be negative")
+			}
+			if failRate < 0 || failRate > 1 {
+				return fmt.Errorf("--fail-rate must be between 0 and 1, got %g", failRate)
+			}
+			if failLatency < 0 {
+				return fmt.Errorf("--fail-latency must not be negative")
+			}
+			if workerMaxPayloadBytes < 0 {
+				return fmt.Errorf("max payload bytes must not be negative")
+			}
+			if breakerThreshold < 0 {
+				return fmt.Errorf("breaker threshold must not be negative")
+			}
+			if breakerThreshold > 0 && breakerCooldown <= 0 {
+				return fmt.Errorf("breaker cooldown must be positive")
+			}
+			if metricsFile != "" && metricsInterval <= 0 {
+				return fmt.Errorf("metrics interval must be positive")
+			}
+			if pprofEnabled && metricsAddr == "" {
+				return fmt.Errorf("--pprof requires --metrics-addr")
+			}
+			if livenessTimeout <= maxPollInterval || (notify && livenessTimeout <= notifyFallback) {
+				return fmt.Errorf("liveness timeout must be longer than the longest wait between polls")
+			}
+			if notify && database.Driver != driverPostgres {
+				return fmt.Errorf("--notify requires --db-driver postgres")
+			}
+			if notify && notifyFallback <= 0 {
+				return fmt.Errorf("notify fallback interval must be positive")
+			}
+			if visibilityTimeout <= 0 {
+				return fmt.Errorf("visibility timeout must be positive")
+			}
+			if shutdownTimeout < 0 {
+				return fmt.Errorf("shutdown timeout must not be negative")
+			}
+			if workerMaxFatalErrors < 0 {
+				return fmt.Errorf("--max-fatal-errors must not be negative, got %d", workerMaxFatalErrors)
+			}
+			if once && notify {
+				return fmt.Errorf("--once can't be combined with --notify")
+			}
+			if workerID == "" {
+				return fmt.Errorf("--worker-id must not be empty")
+			}
+
+			// Every line the worker logs names it, so the logs of the
+			// workers of a shared deployment can be told apart
+			slog.SetDefault(slog.Default().With("worker_id", workerID))
+
+			payloadCipher, err := resolvePayloadCipher(workerKey, workerKeyFile)
+			if err != nil {
+				return err
+			}
+
+			if routingFile != "" && publisherName != publisherConvoy {
+				return fmt.Errorf("--routing-file can only be used with --publisher convoy")
+			}
+
+			var publisher Publisher
+			switch publisherName {
+			case publisherConvoy:
+				workerConvoy.loadEnv(cmd)
+				if err := workerConvoy.validate(); err != nil {
+					return err
+				}
+				convoyPub := &convoyPublisher{client: workerConvoy.client(), lookupDeliveryIDs: convoyDeliveryIDs}
+				var routes []convoyRoute
+				if routingFile != "" {
+					routes, err = loadRoutes(routingFile)
+					if err != nil {
+						return err
+					}
+					convoyPub.router = newConvoyRouter(workerConvoy, routes)
+				}
+				if !skipPreflight {
+					if err := checkConvoy(cmd.Context(), workerConvoy, routes); err != nil {
+						return err
+					}
+				}
+				publisher = convoyPub
+			case publisherHTTP:
+				if webhookURL == "" {
+					return fmt.Errorf("--webhook-url is required with --publisher http")
+				}
+				if webhookRetries < 0 {
+					return fmt.Errorf("webhook retries must not be negative")
+				}
+				publisher = newHTTPPublisher(webhookURL, webhookSecret, webhookRetries)
+			case publisherNoop:
+				publisher = noopPublisher{}
+			default:
+				return fmt.Errorf("invalid publisher %q: must be %q, %q or %q", publisherName, publisherConvoy, publisherHTTP, publisherNoop)
+			}
+
+			opts := workerOptions{
+				Driver:           database.Driver,
+				WorkerID:         workerID,
+				Queue:            workerQueue,
+				PollInterval:     pollIntervalDuration,
+				DispatchMode:     dispatchMode,
+				Concurrency:      concurrency,
+				PerBusinessLimit: perBusinessLimit,
+				BatchSize:        workerBatchSize,
+
+				DeliverySemantics: deliverySemantics,
+
+				MaxPollInterval: maxPollInterval,
+				PollJitter:      pollJitter,
+
+				MetricsFile:        metricsFile,
+				MetricsInterval:    metricsInterval,
+				MetricsRotateBytes: metricsRotateBytes,
+				MetricsAddr:        metricsAddr,
+
+				Pprof:           pprofEnabled,
+				LivenessTimeout: livenessTimeout,
+
+				Cipher: payloadCipher,
+				Delta:  delta,
+				Retry:  retry,
+
+				BreakerThreshold: breakerThreshold,
+				BreakerCooldown:  breakerCooldown,
+				MaxRPS:           maxRPS,
+				MaxPayloadBytes:  workerMaxPayloadBytes,
+
+				FailRate:    failRate,
+				FailLatency: failLatency,
+
+				NotifyFallback: notifyFallback,
+
+				Once:              once,
+				VisibilityTimeout: visibilityTimeout,
+				RecoverOnStart:    recoverOnStart,
+				ShutdownTimeout:   shutdownTimeout,
+				DBTimeout:         database.Timeout,
+				MaxFatalErrors:    workerMaxFatalErrors,
+			}
+			tracerProvider, err := newOTLPTracerProvider(workerOTLPEndpoint, "outbox-worker")
+			if err != nil {
+				return err
+			}
+			defer flushTracer(tracerProvider)
+			opts.Tracer = providerTracer(tracerProvider)
+			opts.OTLPEndpoint = workerOTLPEndpoint
+			if notify {
+				opts.Listener, err = newEventListener(database.DSN, workerQueue)
+				if err != nil {
+					return err
+				}
+				defer opts.Listener.Close()
+			}
+			if !quiet {
+				printBanner("Starting worker", workerBanner(publisher, opts))
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runWorker(cmd.Context(), store, publisher, opts)
+		},
+	}
+
+	workerCmd.Flags().StringVar(&pollInterval, "poll-interval", "5s", "Interval at which to poll for events (e.g. 5s, 1m)")
+	workerCmd.Flags().DurationVar(&maxPollInterval, "max-poll-interval", time.Minute, "Longest the poll interval grows to, doubling after each poll of an idle queue")
+	workerCmd.Flags().Float64Var(&pollJitter, "poll-jitter", 0.1, "Fraction of the poll interval each wait is randomly lengthened or shortened by, so workers don't poll in lockstep")
+	workerCmd.Flags().DurationVar(&visibilityTimeout, "visibility-timeout", 5*time.Minute, "How long a claimed event may stay processing before another worker takes it over")
+	workerCmd.Flags().BoolVar(&recoverOnStart, "recover-on-start", true, "Hand back the events this --worker-id left processing when it last stopped before the first poll, rather than after --visibility-timeout")
+	workerCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "How long deliveries in flight may take to finish on shutdown before they are cancelled and their events left pending")
+	workerCmd.Flags().IntVar(&workerMaxFatalErrors, "max-fatal-errors", 5, "Polls in a row that may fail on an error retrying can't fix, such as a missing table or the database or sink refusing credentials, before the worker exits with it (0 never exits)")
+	workerCmd.Flags().BoolVar(&once, "once", false, "Process the pending events batch by batch, then exit instead of polling")
+	addConvoyFlags(workerCmd, &workerConvoy)
+	workerCmd.Flags().StringVar(&publisherName, "publisher", publisherConvoy, "Where events are delivered: convoy, http to POST them straight to --webhook-url, or noop to discard them")
+	workerCmd.Flags().StringVar(&webhookURL, "webhook-url", "", "Webhook URL events are POSTed to with --publisher http")
+	workerCmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "Start without first fetching the Convoy project to check the base URL and credentials")
+	workerCmd.Flags().StringVar(&routingFile, "routing-file", "", "JSON file mapping event type prefixes to the Convoy project_id and api_key their events are sent with")
+	workerCmd.Flags().BoolVar(&convoyDeliveryIDs, "convoy-delivery-ids", false, "Look up the IDs Convoy gave each fanned out event and store them with the processed event")
+	workerCmd.Flags().StringVar(&webhookSecret, "webhook-secret", "", "Secret used to sign --publisher http requests with HMAC-SHA256")
+	workerCmd.Flags().IntVar(&webhookRetries, "webhook-retries", 3, "How many times --publisher http retries a failed delivery before leaving the event pending")
+	workerCmd.Flags().Int64Var(&retry.MaxRetries, "max-retries", 10, "How many times a failed event is retried before it is moved to the dead-letter table")
+	workerCmd.Flags().DurationVar(&retry.BaseDelay, "retry-base-delay", 5*time.Second, "Delay before the first retry, doubled on every further retry")
+	workerCmd.Flags().DurationVar(&retry.MaxDelay, "retry-max-delay", 10*time.Minute, "Upper bound on the delay between retries")
+	workerCmd.Flags().Float64Var(&maxRPS, "max-rps", 0, "Most calls per second made to the publisher across all goroutines, waiting for a turn rather than dropping events (0 is unlimited)")
+	workerCmd.Flags().Float64Var(&failRate, "fail-rate", 0, "TESTING ONLY: fraction of deliveries, from 0 to 1, failed on purpose to see retries, backoff and the dead-letter queue at work")
+	workerCmd.Flags().DurationVar(&failLatency, "fail-latency", 0, "TESTING ONLY: delay added before every delivery to simulate a slow downstream")
+	workerCmd.Flags().IntVar(&workerMaxPayloadBytes, "max-payload-bytes", 0, "Largest payload sent, in bytes; larger events are moved to the dead-letter table (0 is unlimited)")
+	workerCmd.Flags().IntVar(&breakerThreshold, "breaker-threshold", 5, "Deliveries in a row that may fail before the circuit breaker stops calling the publisher (0 disables it)")
+	workerCmd.Flags().DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Second, "How long the circuit breaker stays open before it lets a probe delivery through")
+	workerCmd.Flags().StringVar(&workerID, "worker-id", defaultWorkerID(), "Unique ID of this worker, logged with every line, stamped on the events it claims, set as the worker_id label of its metrics and used to key its cursor; defaults to the hostname, so give each of several workers on one host its own")
+	workerCmd.Flags().StringVar(&workerQueue, "queue", defaultQueue, "Name of the outbox queue to process")
+	workerCmd.Flags().StringVar(&dispatchMode, "dispatch-mode", dispatchPool, "How a batch is dispatched: pool, or per-business to keep each business in order while running businesses in parallel")
+	workerCmd.Flags().StringVar(&deliverySemantics, "delivery-semantics", "", "When events are marked: send-then-mark, or claim-first to commit them as processing before sending (default claim-first for a pool on SQLite, send-then-mark otherwise)")
+	workerCmd.Flags().BoolVar(&orderedByBusiness, "ordered-by-business", false, "Deliver each business's events in order, one at a time (same as --dispatch-mode per-business)")
+	workerCmd.Flags().IntVar(&concurrency, "concurrency", 4, "Maximum number of events (or lanes of businesses in per-business mode) processed at once")
+	workerCmd.Flags().Int64Var(&workerBatchSize, "batch-size", defaultBatchSize, "Maximum events fetched and dispatched per poll")
+	workerCmd.Flags().Int64Var(&perBusinessLimit, "per-business-limit", 5, "Maximum events fetched per business in each batch in per-business mode")
+	workerCmd.Flags().BoolVar(&delta, "delta", false, "Send events as a JSON merge patch against the previous event for the same invoice")
+	workerCmd.Flags().BoolVar(&quiet, "quiet", false, "Don't print the startup banner listing the effective configuration")
+	workerCmd.Flags().StringVar(&workerKeyFile, "encryption-key-file", "", "File holding the AES key used to decrypt encrypted payloads")
+	workerCmd.Flags().StringVar(&workerKey, "encryption-key", "", "Hex or base64 AES key, given instead of --encryption-key-file (OUTBOX_ENC_KEY is read when neither is)")
+	workerCmd.Flags().BoolVar(&notify, "notify", false, "Wake up as soon as Postgres announces a new event instead of polling (requires --db-driver postgres)")
+	workerCmd.Flags().DurationVar(&notifyFallback, "notify-fallback", time.Minute, "With --notify, poll this often anyway in case a notification was missed")
+	workerCmd.Flags().StringVar(&metricsFile, "metrics-file", "", "File to append periodic JSON snapshots of queue metrics to (disabled when empty)")
+	workerCmd.Flags().DurationVar(&metricsInterval, "metrics-interval", time.Minute, "Interval between metrics snapshots")
+	workerCmd.Flags().Int64Var(&metricsRotateBytes, "metrics-rotate-bytes", 0, "Rotate the metrics file to <file>.1 once it reaches this size (0 always appends)")
+	workerCmd.Flags().StringVar(&workerOTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector to send a trace span per delivery to, e.g. http://localhost:4318 (disabled when empty)")
+	workerCmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":9090", "Address to serve Prometheus metrics on at /metrics, and /healthz and /readyz (disabled when empty)")
+	workerCmd.Flags().BoolVar(&pprofEnabled, "pprof", false, "Serve the runtime profiles of net/http/pprof under /debug/pprof/ on --metrics-addr")
+	workerCmd.Flags().DurationVar(&livenessTimeout, "liveness-timeout", 5*time.Minute, "How long the worker loop may go without a poll before /healthz reports it stuck")
+	workerCmd.Flags().SetNormalizeFunc(normalizePublisherFlags)
+
+	var cursorWorkerID string
+	var cursorCmd = &cobra.Command{
+		Use:   "cursor",
+		Short: "Show the last processed position of each worker",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runCursor(ctx, store, cursorWorkerID)
+		},
+	}
+	cursorCmd.Flags().StringVar(&cursorWorkerID, "worker-id", "", "Only show the cursor of this worker")
+
+	var idempotencyConvoy convoyConfig
+	var idempotencyBusinessID string
+	var idempotencyKey string
+	var idempotencySettle time.Duration
+	var testIdempotencyCmd = &cobra.Command{
+		Use:   "test-idempotency",
+		Short: "Send the same event to Convoy twice and check it is deduplicated",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			idempotencyConvoy.loadEnv(cmd)
+			if err := idempotencyConvoy.validate(); err != nil {
+				return err
+			}
+			return runTestIdempotency(cmd.Context(), idempotencyConvoy.client(), idempotencyBusinessID, idempotencyKey, idempotencySettle)
+		},
+	}
+	addConvoyFlags(testIdempotencyCmd, &idempotencyConvoy)
+	testIdempotencyCmd.Flags().StringVar(&idempotencyBusinessID, "business-id", businessIDs[0], "Business ID used as the fanout owner")
+	testIdempotencyCmd.Flags().StringVar(&idempotencyKey, "idempotency-key", "", "Idempotency key to send (default: a unique generated key)")
+	testIdempotencyCmd.Flags().DurationVar(&idempotencySettle, "settle", 2*time.Second, "How long to wait before looking the events up in Convoy")
+
+	var keysCmd = &cobra.Command{
+		Use:   "keys",
+		Short: "Inspect the idempotency keys sent to Convoy",
+	}
+	var auditTop int
+	var keysAuditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "Report events whose idempotency keys collide",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runKeysAudit(ctx, store, auditTop)
+		},
+	}
+	keysAuditCmd.Flags().IntVar(&auditTop, "top", 10, "Number of colliding keys to list (0 lists all)")
+	keysCmd.AddCommand(keysAuditCmd)
+
+	var dlqCmd = &cobra.Command{
+		Use:   "dlq",
+		Short: "Inspect and recover events that exhausted their retries",
+	}
+	var dlqListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List dead-letter events",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runDLQList(ctx, store)
+		},
+	}
+	var dlqRequeueCmd = &cobra.Command{
+		Use:   "requeue <id>",
+		Short: "Move a dead-letter event back into the outbox",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runDLQRequeue(ctx, store, args[0])
+		},
+	}
+	dlqCmd.AddCommand(dlqListCmd, dlqRequeueCmd)
+
+	var invoicesCmd = &cobra.Command{
+		Use:   "invoices",
+		Short: "Inspect the invoices ingest stored",
+	}
+	var invoicesGetCmd = &cobra.Command{
+		Use:   "get <id>",
+		Short: "Show an invoice",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runInvoicesGet(ctx, store, args[0])
+		},
+	}
+	var filter invoiceFilter
+	var invoicesListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List invoices, newest first",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := filter.validate(); err != nil {
+				return err
+			}
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runInvoicesList(ctx, store, filter)
+		},
+	}
+	invoicesListCmd.Flags().StringVar(&filter.Status, "status", "", "Only list invoices with this status")
+	invoicesListCmd.Flags().StringVar(&filter.BusinessID, "business-id", "", "Only list invoices of this business")
+	invoicesListCmd.Flags().Int64Var(&filter.Limit, "limit", 20, "Maximum number of invoices to list")
+	invoicesListCmd.Flags().Int64Var(&filter.Offset, "offset", 0, "Number of invoices to skip, to page through the list")
+	invoicesCmd.AddCommand(invoicesGetCmd, invoicesListCmd)
+
+	var attemptsCmd = &cobra.Command{
+		Use:   "attempts <event-id>",
+		Short: "Show every delivery attempt of an event",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runAttempts(ctx, store, args[0])
+		},
+	}
+
+	var cleanupOlderThan time.Duration
+	var cleanupBatchSize int64
+	var cleanupDryRun bool
+	var cleanupCmd = &cobra.Command{
+		Use:   "cleanup",
+		Short: "Delete old processed events from the outbox",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cleanupOlderThan <= 0 {
+				return fmt.Errorf("older-than must be positive")
+			}
+			if cleanupBatchSize <= 0 {
+				return fmt.Errorf("batch size must be positive")
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runCleanup(cmd.Context(), store, cleanupOlderThan, cleanupBatchSize, cleanupDryRun, database.Timeout)
+		},
+	}
+	cleanupCmd.Flags().DurationVar(&cleanupOlderThan, "older-than", 168*time.Hour, "Delete events processed longer ago than this")
+	cleanupCmd.Flags().Int64Var(&cleanupBatchSize, "batch-size", 1000, "Number of rows deleted per statement")
+	cleanupCmd.Flags().BoolVar(&cleanupDryRun, "dry-run", false, "Only report how many events would be deleted")
+
+	var exportOutput string
+	var exportFilters exportFilter
+	var exportSince string
+	var exportUntil string
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Write the events of the outbox as JSON lines, for backups, inspection or moving databases",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := exportFilters
+			var err error
+			if exportSince != "" {
+				if filter.Since, err = time.Parse(time.RFC3339, exportSince); err != nil {
+					return fmt.Errorf("invalid --since time %q: %v", exportSince, err)
+				}
+			}
+			if exportUntil != "" {
+				if filter.Until, err = time.Parse(time.RFC3339, exportUntil); err != nil {
+					return fmt.Errorf("invalid --until time %q: %v", exportUntil, err)
+				}
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runExport(cmd.Context(), store, exportOutput, filter, database.Timeout)
+		},
+	}
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File the events are written to (default: stdout)")
+	exportCmd.Flags().StringVar(&exportFilters.Status, "status", "", "Only export events with this status, e.g. pending or processed")
+	exportCmd.Flags().StringVar(&exportFilters.BusinessID, "business-id", "", "Only export the events of this business")
+	exportCmd.Flags().StringVar(&exportSince, "since", "", "Only export events created at or after this RFC 3339 time")
+	exportCmd.Flags().StringVar(&exportUntil, "until", "", "Only export events created before this RFC 3339 time")
+
+	var importEventsBatch int
+	var importEventsCmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "Store the events of a JSON lines file written by export, skipping IDs that already exist",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if importEventsBatch <= 0 {
+				return fmt.Errorf("batch size must be positive")
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runImport(cmd.Context(), store, args[0], importEventsBatch, database.Timeout)
+		},
+	}
+	importEventsCmd.Flags().IntVar(&importEventsBatch, "batch", 500, "Number of events stored per transaction")
+
+	var statusQueue string
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the pending count, oldest pending age, processed and dead-lettered counts of a queue",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runStatus(ctx, cmd.OutOrStdout(), store, statusQueue)
+		},
+	}
+	statusCmd.Flags().StringVar(&statusQueue, "queue", defaultQueue, "Name of the outbox queue to inspect")
+
+	var reconcileConvoy convoyConfig
+	var reconcileFrom string
+	var reconcileTo string
+	var reconcileCmd = &cobra.Command{
+		Use:   "reconcile",
+		Short: "Check that the events processed in a window exist in Convoy",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			start, end, err := parseReconcileWindow(reconcileFrom, reconcileTo, time.Now())
+			if err != nil {
+				return err
+			}
+			reconcileConvoy.loadEnv(cmd)
+			if err := reconcileConvoy.validate(); err != nil {
+				return err
+			}
+
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return runReconcile(cmd.Context(), store, reconcileConvoy.client(), start, end)
+		},
+	}
+	addConvoyFlags(reconcileCmd, &reconcileConvoy)
+	reconcileCmd.Flags().StringVar(&reconcileFrom, "from", "", "Start of the window, as an RFC 3339 time (default: 24h before --to)")
+	reconcileCmd.Flags().StringVar(&reconcileTo, "to", "", "End of the window, as an RFC 3339 time (default: now)")
+
+	var seedCmd = &cobra.Command{
+		Use:   "seed",
+		Short: "Create the predefined businesses invoices are generated for",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(database)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := dbContext(cmd.Context(), database.Timeout)
+			defer cancel()
+			return runSeed(ctx, store)
+		},
+	}
+
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply, revert and inspect the versioned schema migrations",
+	}
+	// withMigrator runs fn with a migrator for the database's driver
+	withMigrator := func(fn func(m *migrator) error) error {
+		migrations, err := database.migrations()
+		if err != nil {
+			return err
+		}
+		store, err := openStore(database)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		return fn(newMigrator(store.conn(), migrations))
+	}
+	var migrateUpCmd = &cobra.Command{
+		Use:   "up",
+		Short: "Apply every migration newer than the database's version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(m *migrator) error {
+				return runMigrateUp(cmd.Context(), m)
+			})
+		},
+	}
+	var migrateSteps int
+	var migrateDownCmd = &cobra.Command{
+		Use:   "down",
+		Short: "Revert the latest migrations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if migrateSteps <= 0 {
+				return fmt.Errorf("steps must be positive")
+			}
+			return withMigrator(func(m *migrator) error {
+				return runMigrateDown(cmd.Context(), m, migrateSteps)
+			})
+		},
+	}
+	migrateDownCmd.Flags().IntVar(&migrateSteps, "steps", 1, "Number of migrations to revert")
+	var migrateVersionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "Show the schema version of the database",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(m *migrator) error {
+				return runMigrateVersion(cmd.Context(), m)
+			})
+		},
+	}
+	var migratePlanCmd = &cobra.Command{
+		Use:   "plan",
+		Short: "Print the SQL of the pending migrations and the objects they change, without applying them",
+		// A new database is left as it is rather than created, and pending
+		// migrations aren't applied on Postgres
+		Annotations: map[string]string{annotationNoInit: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Opening a SQLite file that doesn't exist would create it, which
+			// the next command would take for an existing database
+			if database.Driver == driverSQLite {
+				if _, err := os.Stat(database.Path); os.IsNotExist(err) {
+					migrations, err := database.migrations()
+					if err != nil {
+						return err
+					}
+					writeMigrationPlan(cmd.OutOrStdout(), 0, migrations)
+					return nil
+				}
+			}
+			return withMigrator(func(m *migrator) error {
+				return runMigratePlan(cmd.Context(), cmd.OutOrStdout(), m)
+			})
+		},
+	}
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migratePlanCmd, migrateVersionCmd)
+
+	var benchEvents int
+	var benchLatency time.Duration
+	var benchPollInterval time.Duration
+	var benchDispatchMode string
+	var benchConcurrency int
+	var benchBatchSize int64
+	var benchPerBusinessLimit int64
+	var benchCmd = &cobra.Command{
+		Use:         "bench",
+		Short:       "Measure how fast the worker drains seeded events into a simulated publisher",
+		Annotations: map[string]string{annotationNoDB: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if benchEvents <= 0 {
+				return fmt.Errorf("--events must be positive")
+			}
+			if benchLatency < 0 {
+				return fmt.Errorf("--publisher-latency must not be negative")
+			}
+			if benchPollInterval <= 0 {
+				return fmt.Errorf("--poll-interval must be positive")
+			}
+			if benchConcurrency <= 0 || benchBatchSize <= 0 || benchPerBusinessLimit <= 0 {
+				return fmt.Errorf("concurrency, batch size and per-business limit must be positive")
+			}
+			if benchDispatchMode != dispatchPool && benchDispatchMode != dispatchPerBusiness {
+				return fmt.Errorf("invalid dispatch mode %q: must be %q or %q", benchDispatchMode, dispatchPool, dispatchPerBusiness)
+			}
+
+			return runBench(cmd.Context(), database, benchEvents, benchLatency, workerOptions{
+				Driver:           driverSQLite,
+				WorkerID:         "bench",
+				Queue:            defaultQueue,
+				PollInterval:     benchPollInterval,
+				MaxPollInterval:  benchPollInterval,
+				DispatchMode:     benchDispatchMode,
+				Concurrency:      benchConcurrency,
+				PerBusinessLimit: benchPerBusinessLimit,
+				BatchSize:        benchBatchSize,
+
+				Retry: retryPolicy{MaxRetries: 10, BaseDelay: 5 * time.Second, MaxDelay: 10 * time.Minute},
+
+				VisibilityTimeout: 5 * time.Minute,
+				DBTimeout:         database.Timeout,
+			})
+		},
+	}
+	benchCmd.Flags().IntVar(&benchEvents, "events", 1000, "Number of events seeded before the worker starts")
+	benchCmd.Flags().DurationVar(&benchLatency, "publisher-latency", 0, "How long the simulated publisher takes to accept each event")
+	benchCmd.Flags().DurationVar(&benchPollInterval, "poll-interval", 100*time.Millisecond, "Interval at which the worker polls for events")
+	benchCmd.Flags().StringVar(&benchDispatchMode, "dispatch-mode", dispatchPool, "How a batch is dispatched: pool or per-business")
+	benchCmd.Flags().IntVar(&benchConcurrency, "concurrency", 4, "Maximum number of events (or lanes of businesses in per-business mode) processed at once")
+	benchCmd.Flags().Int64Var(&benchBatchSize, "batch-size", defaultBatchSize, "Maximum events fetched and dispatched per poll")
+	benchCmd.Flags().Int64Var(&benchPerBusinessLimit, "per-business-limit", 5, "Maximum events fetched per business in each batch in per-business mode")
+
+	var receiverAddr string
+	var receiverSecret string
+	var receiverCmd = &cobra.Command{
+		Use:         "receiver",
+		Short:       "Run a local webhook endpoint that logs and verifies every delivery",
+		Annotations: map[string]string{annotationNoDB: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReceiver(cmd.Context(), receiverAddr, receiverSecret)
+		},
+	}
+	receiverCmd.Flags().StringVar(&receiverAddr, "addr", ":8080", "Address to listen for webhooks on")
+	receiverCmd.Flags().StringVar(&receiverSecret, "secret", "", "Shared secret to verify X-Convoy-Signature or X-Signature with (unchecked when empty)")
+
+	rootCmd.AddCommand(ingestCmd, advanceCmd, importCSVCmd, serveCmd, workerCmd, cursorCmd, testIdempotencyCmd, keysCmd, dlqCmd, invoicesCmd, attemptsCmd, cleanupCmd, exportCmd, importEventsCmd, statusCmd, reconcileCmd, migrateCmd, seedCmd, receiverCmd, benchCmd)
+	return rootCmd
 }
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestIngestCount(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	opts := testIngestOptions(t)
+	opts.Rate = time.Millisecond
+	opts.BusinessIDs = businessIDs
+	opts.Count = 5
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := runIngest(ctx, store, opts); err != nil {
+		t.Fatalf("running ingest: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("ingest only returned once it timed out, want it to stop after %d invoices", opts.Count)
+	}
+
+	for _, table := range []string{"invoices", "events"} {
+		var count int
+		if err := dbConn.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatalf("counting %s: %v", table, err)
+		}
+		if count != opts.Count {
+			t.Errorf("%d rows in %s, want %d", count, table, opts.Count)
+		}
+	}
+
+	// Each event gets an ID of its own, the schema having no default
+	var ids, distinct int
+	if err := dbConn.QueryRow("SELECT COUNT(*), COUNT(DISTINCT id) FROM events WHERE length(id) = 32").Scan(&ids, &distinct); err != nil {
+		t.Fatalf("counting event IDs: %v", err)
+	}
+	if ids != opts.Count || distinct != opts.Count {
+		t.Errorf("%d events have a 32 digit ID, %d of them distinct, want all %d", ids, distinct, opts.Count)
+	}
+}
+
+func TestDefaultWorkerIDIsHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	// A restarted worker keeps its ID, and with it its cursor and claims
+	if got := defaultWorkerID(); got != hostname {
+		t.Errorf("default worker ID %q, want the hostname %q", got, hostname)
+	}
+}
+
+func TestIngestWorkers(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	opts := testIngestOptions(t)
+	opts.Rate = time.Millisecond
+	opts.BusinessIDs = businessIDs
+	opts.Count = 40
+	opts.Workers = 4
+	opts.BusyRetries = 5
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := runIngest(ctx, store, opts); err != nil {
+		t.Fatalf("running ingest: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("ingest only returned once it timed out, want it to stop after %d invoices", opts.Count)
+	}
+
+	// Every invoice is stored once, with exactly its own event
+	var invoices, distinct, events, orphans int
+	err := dbConn.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM invoices),
+		(SELECT COUNT(DISTINCT id) FROM invoices),
+		(SELECT COUNT(*) FROM events),
+		(SELECT COUNT(*) FROM invoices WHERE (SELECT COUNT(*) FROM events WHERE aggregate_id = invoices.id) != 1)`).Scan(&invoices, &distinct, &events, &orphans)
+	if err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	if invoices != opts.Count || distinct != opts.Count || events != opts.Count || orphans != 0 {
+		t.Errorf("stored %d invoices (%d distinct) and %d events, %d invoices without exactly one event, want %d of each and none", invoices, distinct, events, orphans, opts.Count)
+	}
+}
+
+func TestIngestCounterRefusalsDontLeak(t *testing.T) {
+	counter := &ingestCounter{limit: 2}
+	for i := 0; i < 2; i++ {
+		if !counter.reserve() {
+			t.Fatalf("reservation %d refused, want the first 2 taken", i+1)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if counter.reserve() {
+			t.Fatalf("reservation over the limit taken")
+		}
+	}
+	// The refusals above don't count against the slot given back
+	counter.release()
+	if !counter.reserve() {
+		t.Error("reservation after a release refused, want the slot taken again")
+	}
+}
+
+// failingStore fails every third transaction begun through it
+type failingStore struct {
+	Store
+	begun atomic.Int64
+}
+
+func (s *failingStore) Begin(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	if s.begun.Add(1)%3 == 0 {
+		return nil, errors.New("injected failure")
+	}
+	return s.Store.Begin(ctx, opts)
+}
+
+func TestIngestWorkersStoreCountDespiteFailures(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	failing := &failingStore{Store: store}
+	opts := testIngestOptions(t)
+	opts.Rate = time.Millisecond
+	opts.BusinessIDs = businessIDs
+	opts.Count = 30
+	opts.Workers = 4
+	opts.BusyRetries = 5
+	opts.MaxFatalErrors = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := runIngest(ctx, failing, opts); err != nil {
+		t.Fatalf("running ingest: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("ingest only returned once it timed out, want it to stop after %d invoices", opts.Count)
+	}
+	if failing.begun.Load() <= int64(opts.Count) {
+		t.Fatalf("began %d transactions, want failures injected along the way", failing.begun.Load())
+	}
+	var invoices int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM invoices").Scan(&invoices); err != nil {
+		t.Fatalf("counting invoices: %v", err)
+	}
+	if invoices != opts.Count {
+		t.Errorf("stored %d invoices, want exactly %d", invoices, opts.Count)
+	}
+}
+
+func TestWorkerOnceDrainsAndExits(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	events := seedInvoices(t, store, 3*defaultBatchSize, testIngestOptions(t))
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	// Never reached when --once skips polling between batches
+	opts.PollInterval = time.Hour
+
+	publisher := &fakePublisher{}
+	done := make(chan error, 1)
+	go func() { done <- runWorker(context.Background(), store, publisher, opts) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("running worker: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("worker didn't return once the events were drained")
+	}
+
+	if len(publisher.published) != len(events) {
+		t.Errorf("published %d events, want %d", len(publisher.published), len(events))
+	}
+	want := eventState{status: "processed", processed: true}
+	for _, state := range eventStates(t, dbConn) {
+		if state != want {
+			t.Errorf("event is %+v, want %+v", state, want)
+		}
+	}
+}
+
+// claimCountingStore records the size of every batch claimed through it
+type claimCountingStore struct {
+	Store
+	claims []int
+}
+
+func (s *claimCountingStore) ClaimPendingEvents(ctx context.Context, arg db.ClaimPendingEventsParams) ([]db.Event, error) {
+	events, err := s.Store.ClaimPendingEvents(ctx, arg)
+	s.claims = append(s.claims, len(events))
+	return events, err
+}
+
+func TestWorkerBatchSize(t *testing.T) {
+	store, _ := newTestStore(t)
+	events := seedInvoices(t, store, 50, testIngestOptions(t))
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.BatchSize = 50
+	counting := &claimCountingStore{Store: store}
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), counting, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	// One poll takes every event, the next finds none left and ends the run
+	if want := []int{len(events), 0}; !reflect.DeepEqual(counting.claims, want) {
+		t.Errorf("claimed batches of %v, want %v", counting.claims, want)
+	}
+	if len(publisher.published) != len(events) {
+		t.Errorf("published %d events, want %d", len(publisher.published), len(events))
+	}
+}
+
+func TestDelayedEventsWaitUntilVisible(t *testing.T) {
+	for _, mode := range []string{dispatchPool, dispatchPerBusiness} {
+		t.Run(mode, func(t *testing.T) {
+			store, dbConn := newTestStore(t)
+			seedInvoices(t, store, 1, testIngestOptions(t))
+			delayedOpts := testIngestOptions(t)
+			delayedOpts.Delay = time.Hour
+			seedInvoices(t, store, 1, delayedOpts)
+
+			opts := testWorkerOptions()
+			opts.Once = true
+			opts.DispatchMode = mode
+			publisher := &fakePublisher{}
+			if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+				t.Fatalf("running worker: %v", err)
+			}
+			var pending int
+			if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status = 'pending' AND visible_at > ?", time.Now().UTC()).Scan(&pending); err != nil {
+				t.Fatalf("counting delayed events: %v", err)
+			}
+			if len(publisher.published) != 1 || pending != 1 {
+				t.Fatalf("published %d events with %d delayed left, want only the event without a delay published", len(publisher.published), pending)
+			}
+
+			// Once its time has passed the delayed event is delivered too
+			past := time.Now().UTC().Add(-time.Minute)
+			if _, err := dbConn.Exec("UPDATE events SET visible_at = ? WHERE status = 'pending'", past); err != nil {
+				t.Fatalf("moving visible_at back: %v", err)
+			}
+			if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+				t.Fatalf("running worker: %v", err)
+			}
+			if len(publisher.published) != 2 {
+				t.Errorf("published %d events, want both", len(publisher.published))
+			}
+		})
+	}
+}
+
+func TestExpiredEventsDeadLettered(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 1, testIngestOptions(t))
+	expiringOpts := testIngestOptions(t)
+	expiringOpts.EventTTL = time.Millisecond
+	seedInvoices(t, store, 1, expiringOpts)
+	var expiring int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE expires_at IS NOT NULL").Scan(&expiring); err != nil {
+		t.Fatalf("counting expiring events: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	var pending, expired int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status = 'pending'").Scan(&pending); err != nil {
+		t.Fatalf("counting pending events: %v", err)
+	}
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM dead_letter_events WHERE status = 'expired' AND last_error LIKE 'expired at %'").Scan(&expired); err != nil {
+		t.Fatalf("counting expired events: %v", err)
+	}
+	if expiring == 0 || expired != expiring {
+		t.Errorf("%d events dead-lettered as expired, want the %d past their TTL", expired, expiring)
+	}
+	if pending != 0 {
+		t.Errorf("%d events left pending, want none", pending)
+	}
+	if len(publisher.published) != expiring {
+		t.Errorf("published %d events, want only the %d without a TTL", len(publisher.published), expiring)
+	}
+	for _, id := range publisher.published {
+		var deadLettered bool
+		if err := dbConn.QueryRow("SELECT EXISTS (SELECT 1 FROM dead_letter_events WHERE id = ?)", id).Scan(&deadLettered); err != nil {
+			t.Fatalf("looking up event %s: %v", id, err)
+		}
+		if deadLettered {
+			t.Errorf("expired event %s was published", id)
+		}
+	}
+}
+
+func TestWorkerGivesUpOnFatalErrors(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	if _, err := dbConn.Exec("DROP TABLE events"); err != nil {
+		t.Fatalf("dropping events: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := testWorkerOptions()
+	opts.MaxFatalErrors = 3
+	err := runWorker(ctx, store, &fakePublisher{}, opts)
+	if ctx.Err() != nil {
+		t.Fatalf("worker still running after %d fatal errors", opts.MaxFatalErrors)
+	}
+	if err == nil || !strings.Contains(err.Error(), "giving up after 3 fatal errors in a row") || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("got %v, want the worker to give up on the missing table", err)
+	}
+}
+
+func TestWorkerGivesUpOnRefusedCredentials(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 3, testIngestOptions(t))
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := testWorkerOptions()
+	opts.Concurrency = 1
+	opts.MaxFatalErrors = 2
+	err := runWorker(ctx, store, newHTTPPublisher(server.URL, "", 0), opts)
+	if ctx.Err() != nil {
+		t.Fatalf("worker still running after %d refusals", opts.MaxFatalErrors)
+	}
+	if err == nil || !strings.Contains(err.Error(), "giving up after 2 fatal errors in a row") || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got %v, want the worker to give up on the refused credentials", err)
+	}
+	// Each batch stops at the first refusal rather than trying every event
+	if got := requests.Load(); got != 2 {
+		t.Errorf("sink got %d requests, want one per batch", got)
+	}
+
+	// The events were never delivered, so they wait for the right
+	// credentials rather than using up retries or being dead-lettered
+	var pending, retried, deadLettered int
+	if err := dbConn.QueryRow("SELECT COUNT(*), COALESCE(SUM(retry_count), 0) FROM events WHERE status = 'pending'").Scan(&pending, &retried); err != nil {
+		t.Fatalf("counting events: %v", err)
+	}
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM dead_letter_events").Scan(&deadLettered); err != nil {
+		t.Fatalf("counting dead letters: %v", err)
+	}
+	if pending != 3 || retried != 0 || deadLettered != 0 {
+		t.Errorf("%d events pending with %d retries and %d dead-lettered, want 3 pending, untouched", pending, retried, deadLettered)
+	}
+}
+
+// spreadCreatedAt gives the events seconds apart creation times, two to a
+// second, in the order they were written, so their IDs, which are random,
+// sort differently from the order they are due in
+func spreadCreatedAt(tb testing.TB, dbConn *sql.DB) []string {
+	tb.Helper()
+	base := time.Now().UTC().Add(-time.Hour)
+	if _, err := dbConn.Exec("UPDATE events SET created_at = datetime(?, '+' || (rowid / 2) || ' seconds')", base.Format("2006-01-02 15:04:05")); err != nil {
+		tb.Fatalf("spreading created_at: %v", err)
+	}
+	rows, err := dbConn.Query("SELECT id FROM events ORDER BY created_at, rowid")
+	if err != nil {
+		tb.Fatalf("reading events: %v", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			tb.Fatalf("reading event: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestGetPendingEventsAfterDrains(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 1000, testIngestOptions(t))
+	want := spreadCreatedAt(t, dbConn)
+
+	// Nothing is marked, so only the cursor moves the pages along
+	var got []string
+	var after string
+	pages := 0
+	for {
+		events, err := store.GetPendingEventsAfter(context.Background(), db.GetPendingEventsAfterParams{
+			Queue:   defaultQueue,
+			AfterID: after,
+			Limit:   100,
+		})
+		if err != nil {
+			t.Fatalf("fetching page %d: %v", pages+1, err)
+		}
+		if len(events) == 0 {
+			break
+		}
+		pages++
+		for _, event := range events {
+			got = append(got, event.ID)
+		}
+		after = events[len(events)-1].ID
+	}
+	if pages != 10 || !reflect.DeepEqual(got, want) {
+		t.Errorf("fetched %d events in %d pages, want %d oldest first in 10", len(got), pages, len(want))
+	}
+}
+
+func TestWorkerPagesOldestFirst(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 50, testIngestOptions(t))
+	want := spreadCreatedAt(t, dbConn)
+
+	// SQLite sending then marking is the combination that pages
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.DeliverySemantics = semanticsSendThenMark
+	opts.Concurrency = 1
+	opts.BatchSize = 7
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if !reflect.DeepEqual(publisher.published, want) {
+		t.Errorf("published %v, want oldest first %v", publisher.published, want)
+	}
+}
+
+func TestInitDBFromAnyDirectory(t *testing.T) {
+	// Nothing the binary needs is read relative to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getting working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("changing directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	cfg := testDBConfig(t)
+	cfg.Path = "events.db"
+	if err := initDB(cfg, false, true); err != nil {
+		t.Fatalf("initializing database outside the repository: %v", err)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer store.Close()
+	if _, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(businessIDs[0]), testIngestOptions(t)); err != nil {
+		t.Errorf("storing an invoice in the initialized database: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// memStore is an in-memory Store holding just enough of the outbox for
+// ingest and a pooled worker. It embeds a nil Querier, so any other query
+// panics, which shows at once what a test needs that the fake lacks.
+type memStore struct {
+	Querier
+
+	mu       sync.Mutex
+	invoices []db.Invoice
+	events   []db.Event
+
+	// txOptions are the options each transaction was begun with
+	txOptions []*sql.TxOptions
+}
+
+func (s *memStore) Begin(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	s.mu.Lock()
+	s.txOptions = append(s.txOptions, opts)
+	s.mu.Unlock()
+	return &memTx{memStore: s}, nil
+}
+
+func (s *memStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *memStore) CreateInvoice(ctx context.Context, arg db.CreateInvoiceParams) (db.Invoice, error) {
+	invoice := newMemInvoice(arg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invoices = append(s.invoices, invoice)
+	return invoice, nil
+}
+
+func (s *memStore) CreateEvent(ctx context.Context, arg db.CreateEventParams) (db.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event := s.newEvent(arg)
+	s.events = append(s.events, event)
+	return event, nil
+}
+
+func (s *memStore) CountPendingEvents(ctx context.Context, queue string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int64
+	for _, event := range s.events {
+		if event.Queue == queue && event.Status.String == "pending" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memStore) GetOldestPendingEventCreatedAt(ctx context.Context, queue string) (sql.NullTime, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range s.events {
+		if event.Queue == queue && event.Status.String == "pending" {
+			return event.CreatedAt, nil
+		}
+	}
+	return sql.NullTime{}, sql.ErrNoRows
+}
+
+func (s *memStore) ClaimPendingEvents(ctx context.Context, arg db.ClaimPendingEventsParams) ([]db.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claimed := []db.Event{}
+	for i := range s.events {
+		event := &s.events[i]
+		if int64(len(claimed)) == arg.Limit {
+			break
+		}
+		if event.Queue != arg.Queue || event.Status.String != "pending" {
+			continue
+		}
+		if event.VisibleAt.Valid && event.VisibleAt.Time.After(time.Now()) {
+			continue
+		}
+		event.Status = sql.NullString{String: "processing", Valid: true}
+		event.ClaimedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		event.ClaimedBy = arg.ClaimedBy
+		claimed = append(claimed, *event)
+	}
+	return claimed, nil
+}
+
+func (s *memStore) RecoverStuckEvents(ctx context.Context, claimedAt sql.NullTime) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var recovered int64
+	for i := range s.events {
+		event := &s.events[i]
+		if event.Status.String == "processing" && event.ClaimedAt.Time.Before(claimedAt.Time) {
+			event.Status = sql.NullString{String: "pending", Valid: true}
+			event.ClaimedAt = sql.NullTime{}
+			event.ClaimedBy = sql.NullString{}
+			recovered++
+		}
+	}
+	return recovered, nil
+}
+
+func (s *memStore) ReleaseWorkerEvents(ctx context.Context, arg db.ReleaseWorkerEventsParams) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var released int64
+	for i := range s.events {
+		event := &s.events[i]
+		if event.Status.String == "processing" && event.ClaimedBy == arg.ClaimedBy && event.Queue == arg.Queue {
+			event.Status = sql.NullString{String: "pending", Valid: true}
+			event.ClaimedAt = sql.NullTime{}
+			event.ClaimedBy = sql.NullString{}
+			released++
+		}
+	}
+	return released, nil
+}
+
+func (s *memStore) ReleaseClaimedEvents(ctx context.Context, ids []string) error {
+	return s.setStatus(ids, "processing", "pending")
+}
+
+func (s *memStore) MarkEventsAsProcessed(ctx context.Context, ids []string) error {
+	return s.setStatus(ids, "", "processed")
+}
+
+func (s *memStore) CreateEventAttempt(ctx context.Context, arg db.CreateEventAttemptParams) error {
+	return nil
+}
+
+func (s *memStore) UpsertWorkerCursor(ctx context.Context, arg db.UpsertWorkerCursorParams) error {
+	return nil
+}
+
+// setStatus moves the events with ids to status, when they are in from or
+// from is empty
+func (s *memStore) setStatus(ids []string, from, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	for i := range s.events {
+		event := &s.events[i]
+		if !wanted[event.ID] || (from != "" && event.Status.String != from) {
+			continue
+		}
+		event.Status = sql.NullString{String: status, Valid: true}
+		event.ClaimedAt = sql.NullTime{}
+		event.ClaimedBy = sql.NullString{}
+		if status == "processed" {
+			event.ProcessedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		}
+	}
+	return nil
+}
+
+// newEvent builds a pending event row as the schema's defaults would.
+// s.mu must be held.
+func (s *memStore) newEvent(arg db.CreateEventParams) db.Event {
+	visibleAt := arg.VisibleAt
+	if !visibleAt.Valid {
+		visibleAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	return db.Event{
+		ID:          arg.ID,
+		BusinessID:  arg.BusinessID,
+		EventType:   arg.EventType,
+		Payload:     arg.Payload,
+		CreatedAt:   sql.NullTime{Time: time.Now().UTC(), Valid: true},
+		Status:      sql.NullString{String: "pending", Valid: true},
+		Codec:       arg.Codec,
+		Queue:       arg.Queue,
+		Encrypted:   arg.Encrypted,
+		AggregateID: arg.AggregateID,
+
+		PayloadEncoding: arg.PayloadEncoding,
+		OwnerID:         arg.OwnerID,
+		DeliveryMode:    arg.DeliveryMode,
+		IdempotencyKey:  arg.IdempotencyKey,
+		Headers:         arg.Headers,
+		VisibleAt:       visibleAt,
+		ExpiresAt:       arg.ExpiresAt,
+	}
+}
+
+func newMemInvoice(arg db.CreateInvoiceParams) db.Invoice {
+	return db.Invoice{
+		ID:          arg.ID,
+		BusinessID:  arg.BusinessID,
+		AmountCents: arg.AmountCents,
+		Currency:    arg.Currency,
+		Status:      arg.Status,
+		Description: arg.Description,
+		CreatedAt:   sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+}
+
+// memTx buffers the invoices and events written in it until Commit, and
+// drops them on Rollback
+type memTx struct {
+	*memStore
+
+	invoices []db.Invoice
+	events   []db.Event
+	done     bool
+}
+
+func (t *memTx) CreateInvoice(ctx context.Context, arg db.CreateInvoiceParams) (db.Invoice, error) {
+	invoice := newMemInvoice(arg)
+	t.invoices = append(t.invoices, invoice)
+	return invoice, nil
+}
+
+func (t *memTx) CreateEvent(ctx context.Context, arg db.CreateEventParams) (db.Event, error) {
+	t.mu.Lock()
+	event := t.newEvent(arg)
+	t.mu.Unlock()
+	t.events = append(t.events, event)
+	return event, nil
+}
+
+func (t *memTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.memStore.invoices = append(t.memStore.invoices, t.invoices...)
+	t.memStore.events = append(t.memStore.events, t.events...)
+	return nil
+}
+
+func (t *memTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	return nil
+}
+
+func TestMemStoreIngestAndDeliver(t *testing.T) {
+	store := &memStore{}
+
+	opts := testIngestOptions(t)
+	opts.Rate = time.Millisecond
+	opts.Count = 3
+	opts.BusinessIDs = businessIDs
+	if err := runIngest(context.Background(), store, opts); err != nil {
+		t.Fatalf("ingesting: %v", err)
+	}
+
+	// An invoice whose event can't be written leaves nothing behind
+	failing := testIngestOptions(t)
+	failing.Codec = failingCodec{}
+	if _, err := createInvoiceWithEvents(context.Background(), store, generateInvoice(businessIDs[0]), failing); err == nil {
+		t.Fatalf("storing an invoice with a failing codec succeeded, want an error")
+	}
+	if len(store.invoices) != 3 || len(store.events) != 3 {
+		t.Fatalf("%d invoices and %d events stored, want the 3 ingested of each", len(store.invoices), len(store.events))
+	}
+
+	workerOpts := testWorkerOptions()
+	workerOpts.Once = true
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, workerOpts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	if len(publisher.published) != 3 {
+		t.Errorf("published %d events, want 3", len(publisher.published))
+	}
+	for _, event := range store.events {
+		if event.Status.String != "processed" || !event.ProcessedAt.Valid {
+			t.Errorf("event %s is %s, want processed", event.ID, event.Status.String)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// deliveryStats counts deliveries between two metrics snapshots
+type deliveryStats struct {
+	delivered atomic.Int64
+	failed    atomic.Int64
+}
+
+// metricsSnapshot is a single line of the metrics file
+type metricsSnapshot struct {
+	Time                    time.Time `json:"time"`
+	WorkerID                string    `json:"worker_id"`
+	Queue                   string    `json:"queue"`
+	Pending                 int64     `json:"pending"`
+	DeliveredSinceLast      int64     `json:"delivered_since_last"`
+	FailedSinceLast         int64     `json:"failed_since_last"`
+	OldestPendingAgeSeconds float64   `json:"oldest_pending_age_seconds"`
+}
+
+// takeSnapshot reads the queue state and resets the delivery counters
+func takeSnapshot(ctx context.Context, queries Querier, stats *deliveryStats, workerID, queue string) (metricsSnapshot, error) {
+	snapshot := metricsSnapshot{
+		Time:     time.Now().UTC(),
+		WorkerID: workerID,
+		Queue:    queue,
+	}
+
+	depth, err := readQueueDepth(ctx, queries, queue)
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Pending = depth.Pending
+	snapshot.OldestPendingAgeSeconds = depth.OldestPendingAge.Seconds()
+
+	snapshot.DeliveredSinceLast = stats.delivered.Swap(0)
+	snapshot.FailedSinceLast = stats.failed.Swap(0)
+	return snapshot, nil
+}
+
+// appendSnapshot writes the snapshot as a JSON line, first moving the file
+// to path.1 once it has grown past rotateBytes (0 never rotates)
+func appendSnapshot(path string, rotateBytes int64, snapshot metricsSnapshot) error {
+	if rotateBytes > 0 {
+		if info, err := os.Stat(path); err == nil && info.Size() >= rotateBytes {
+			if err := os.Rename(path, path+".1"); err != nil {
+				return fmt.Errorf("error rotating metrics file: %v", err)
+			}
+		}
+	}
+
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening metrics file: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// runMetricsFile appends a snapshot of the queue to path every interval
+// until ctx is cancelled
+func runMetricsFile(ctx context.Context, queries Querier, stats *deliveryStats, opts workerOptions) {
+	ticker := time.NewTicker(opts.MetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		dbCtx, cancel := dbContext(ctx, opts.DBTimeout)
+		snapshot, err := takeSnapshot(dbCtx, queries, stats, opts.WorkerID, opts.Queue)
+		cancel()
+		if err != nil {
+			slog.Error("Error collecting metrics snapshot", "error", err)
+			continue
+		}
+		if err := appendSnapshot(opts.MetricsFile, opts.MetricsRotateBytes, snapshot); err != nil {
+			slog.Error("Error writing metrics snapshot", "file", opts.MetricsFile, "error", err)
+		}
+	}
+}
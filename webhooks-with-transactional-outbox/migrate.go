@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// migrationFile matches the file names of migrations, e.g.
+// 0002_add_invoice_notes.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migration is one numbered step of the schema with the SQL applying and
+// reverting it
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// loadMigrations reads the migrations in the top directory of fsys, sorted
+// by version. Each version needs both its up and down file.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %v", err)
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %v", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// driverMigrations returns the migrations embedded for driver
+func driverMigrations(driver string) ([]migration, error) {
+	dir := "sqlite"
+	if driver == driverPostgres {
+		dir = "postgres"
+	}
+	sub, err := fs.Sub(db.Migrations, path.Join("migrations", dir))
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(sub)
+}
+
+// migrator applies and reverts migrations, recording the versions applied
+// in schema_migrations. Each migration runs in a transaction with its
+// version row, so a failed one leaves neither behind.
+type migrator struct {
+	conn       *sql.DB
+	migrations []migration
+}
+
+func newMigrator(conn *sql.DB, migrations []migration) *migrator {
+	return &migrator{conn: conn, migrations: migrations}
+}
+
+const (
+	createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+	selectSchemaVersion = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+)
+
+// ensureTable creates schema_migrations if it doesn't exist yet
+func (m *migrator) ensureTable(ctx context.Context) error {
+	if _, err := m.conn.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+	return nil
+}
+
+// version returns the latest migration applied, 0 when there is none
+func (m *migrator) version(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	var version int
+	if err := m.conn.QueryRowContext(ctx, selectSchemaVersion).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return version, nil
+}
+
+// pending returns the database's version and the migrations newer than
+// it, without changing the database: the version is read in a transaction
+// that is rolled back, so schema_migrations isn't even created when it is
+// missing
+func (m *migrator) pending(ctx context.Context) (int, []migration, error) {
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return 0, nil, fmt.Errorf("error reading schema_migrations: %v", err)
+	}
+	var current int
+	if err := tx.QueryRowContext(ctx, selectSchemaVersion).Scan(&current); err != nil {
+		return 0, nil, fmt.Errorf("error reading schema version: %v", err)
+	}
+	var steps []migration
+	for _, step := range m.migrations {
+		if step.Version > current {
+			steps = append(steps, step)
+		}
+	}
+	return current, steps, nil
+}
+
+// latest returns the version of the newest migration known
+func (m *migrator) latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// up applies every migration newer than the current version, in order,
+// and returns those it applied
+func (m *migrator) up(ctx context.Context) ([]migration, error) {
+	current, err := m.version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []migration
+	for _, step := range m.migrations {
+		if step.Version <= current {
+			continue
+		}
+		err := m.run(ctx, step.Up, "INSERT INTO schema_migrations (version) VALUES ($1)", step.Version)
+		if err != nil {
+			return applied, fmt.Errorf("error applying migration %s: %v", step, err)
+		}
+		applied = append(applied, step)
+	}
+	return applied, nil
+}
+
+// down reverts the latest steps migrations, newest first, and returns
+// those it reverted
+func (m *migrator) down(ctx context.Context, steps int) ([]migration, error) {
+	var reverted []migration
+	for len(reverted) < steps {
+		current, err := m.version(ctx)
+		if err != nil {
+			return reverted, err
+		}
+		if current == 0 {
+			break
+		}
+
+		step, ok := m.find(current)
+		if !ok {
+			return reverted, fmt.Errorf("database is at version %d, which has no migration to revert", current)
+		}
+		err = m.run(ctx, step.Down, "DELETE FROM schema_migrations WHERE version = $1", step.Version)
+		if err != nil {
+			return reverted, fmt.Errorf("error reverting migration %s: %v", step, err)
+		}
+		reverted = append(reverted, step)
+	}
+	return reverted, nil
+}
+
+// find returns the migration of version
+func (m *migrator) find(version int) (migration, bool) {
+	for _, step := range m.migrations {
+		if step.Version == version {
+			return step, true
+		}
+	}
+	return migration{}, false
+}
+
+// run executes the SQL of a migration and records it with record, in one
+// transaction
+func (m *migrator) run(ctx context.Context, statements, record string, version int) error {
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, version); err != nil {
+		return fmt.Errorf("error recording schema version: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+func runMigrateUp(ctx context.Context, m *migrator) error {
+	applied, err := m.up(ctx)
+	for _, step := range applied {
+		fmt.Printf("Applied migration %s\n", step)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("Schema is up to date.")
+	}
+	return nil
+}
+
+func runMigrateDown(ctx context.Context, m *migrator, steps int) error {
+	reverted, err := m.down(ctx, steps)
+	for _, step := range reverted {
+		fmt.Printf("Reverted migration %s\n", step)
+	}
+	if err != nil {
+		return err
+	}
+	if len(reverted) == 0 {
+		fmt.Println("No migrations to revert.")
+	}
+	return nil
+}
+
+// schemaChanges match the statements of a migration that change a schema
+// object, and describe the change from the submatches
+var schemaChanges = []struct {
+	pattern  *regexp.Regexp
+	describe func(match []string) string
+}{
+	{
+		regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:IF\s+NOT\s+EXISTS\s+)?(\w+)\s+ON\s+(\w+)`),
+		func(match []string) string { return fmt.Sprintf("create index %s on %s", match[1], match[2]) },
+	},
+	{
+		regexp.MustCompile(`(?i)^CREATE\s+(?:OR\s+REPLACE\s+)?(TABLE|FUNCTION|TRIGGER)\s+(?:IF\s+NOT\s+EXISTS\s+)?(\w+)`),
+		func(match []string) string { return fmt.Sprintf("create %s %s", strings.ToLower(match[1]), match[2]) },
+	},
+	{
+		regexp.MustCompile(`(?i)^DROP\s+(TABLE|INDEX|TRIGGER|FUNCTION)\s+(?:IF\s+EXISTS\s+)?(\w+)`),
+		func(match []string) string { return fmt.Sprintf("drop %s %s", strings.ToLower(match[1]), match[2]) },
+	},
+	{
+		regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(\w+)\s+RENAME\s+TO\s+(\w+)`),
+		func(match []string) string { return fmt.Sprintf("rename table %s to %s", match[1], match[2]) },
+	},
+	{
+		regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(\w+)\s+(ADD|DROP|ALTER|RENAME)\s+(?:(COLUMN|CONSTRAINT)\s+)?(?:IF\s+(?:NOT\s+)?EXISTS\s+)?(\w+)`),
+		func(match []string) string {
+			kind := strings.ToLower(match[3])
+			if kind == "" {
+				kind = "column"
+			}
+			return fmt.Sprintf("%s %s %s.%s", strings.ToLower(match[2]), kind, match[1], match[4])
+		},
+	},
+	{
+		regexp.MustCompile(`(?i)^(INSERT\s+INTO|UPDATE|DELETE\s+FROM)\s+(\w+)`),
+		func(match []string) string { return fmt.Sprintf("write rows of %s", match[2]) },
+	},
+}
+
+// summarizeMigration lists the schema objects the SQL of a migration
+// changes, in order, from the statements starting a line. It is a summary
+// for reading before applying, not a parse: a statement it doesn't know,
+// such as one inside a function body, is left out.
+func summarizeMigration(statements string) []string {
+	var changes []string
+	for _, line := range strings.Split(statements, "\n") {
+		line = strings.TrimSpace(line)
+		for _, change := range schemaChanges {
+			if match := change.pattern.FindStringSubmatch(line); match != nil {
+				changes = append(changes, change.describe(match))
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// runMigratePlan writes the plan of the migrations pending on the database
+// of m to w, without applying any of them
+func runMigratePlan(ctx context.Context, w io.Writer, m *migrator) error {
+	current, steps, err := m.pending(ctx)
+	if err != nil {
+		return err
+	}
+	writeMigrationPlan(w, current, steps)
+	return nil
+}
+
+// writeMigrationPlan writes the SQL of each of steps, pending on a
+// database at version current, to w, headed by a summary of the objects it
+// changes. The summaries are SQL comments, so the output can be reviewed as
+// the script migrate up would run.
+func writeMigrationPlan(w io.Writer, current int, steps []migration) {
+	if len(steps) == 0 {
+		fmt.Fprintf(w, "-- Schema is up to date at version %d.\n", current)
+		return
+	}
+
+	fmt.Fprintf(w, "-- %d pending migrations take the schema from version %d to %d. Nothing has been applied.\n", len(steps), current, steps[len(steps)-1].Version)
+	for _, step := range steps {
+		fmt.Fprintf(w, "\n-- Migration %s\n", step)
+		for _, change := range summarizeMigration(step.Up) {
+			fmt.Fprintf(w, "--   %s\n", change)
+		}
+		fmt.Fprintln(w, strings.TrimSpace(step.Up))
+	}
+}
+
+func runMigrateVersion(ctx context.Context, m *migrator) error {
+	current, err := m.version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version:  %d\n", current)
+	fmt.Printf("Latest version:  %d\n", m.latest())
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// openTestDB opens an empty temporary SQLite database, closed when tb
+// finishes
+func openTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	store, err := openStore(testDBConfig(tb))
+	if err != nil {
+		tb.Fatalf("opening database: %v", err)
+	}
+	tb.Cleanup(func() { store.Close() })
+	return store.conn()
+}
+
+// sqliteSchema describes each table of a SQLite database by its columns
+// and foreign keys, and each index by its columns, leaving out
+// schema_migrations. The SQL a table was created with isn't compared, as
+// SQLite rewrites it when a migration renames a table.
+func sqliteSchema(tb testing.TB, conn *sql.DB) map[string]string {
+	tb.Helper()
+	describe := func(query string, args ...interface{}) string {
+		rows, err := conn.Query(query, args...)
+		if err != nil {
+			tb.Fatalf("reading schema: %v", err)
+		}
+		defer rows.Close()
+		var description []string
+		for rows.Next() {
+			var row string
+			if err := rows.Scan(&row); err != nil {
+				tb.Fatalf("reading schema: %v", err)
+			}
+			description = append(description, row)
+		}
+		return strings.Join(description, "; ")
+	}
+
+	rows, err := conn.Query("SELECT type, name FROM sqlite_master WHERE sql IS NOT NULL AND name != 'schema_migrations'")
+	if err != nil {
+		tb.Fatalf("reading schema: %v", err)
+	}
+	objects := map[string]string{}
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			tb.Fatalf("reading schema: %v", err)
+		}
+		objects[name] = kind
+	}
+	rows.Close()
+
+	schema := map[string]string{}
+	for name, kind := range objects {
+		if kind == "index" {
+			schema[name] = describe("SELECT name FROM pragma_index_info(?) ORDER BY seqno", name)
+			continue
+		}
+		schema[name] = describe("SELECT name || ' ' || type || ' ' || \"notnull\" || ' ' || IFNULL(dflt_value, '') || ' ' || pk FROM pragma_table_info(?) ORDER BY cid", name) +
+			" | " + describe("SELECT \"from\" || ' -> ' || \"table\" || '(' || \"to\" || ')' FROM pragma_foreign_key_list(?)", name)
+	}
+	return schema
+}
+
+func hasColumn(tb testing.TB, conn *sql.DB, table, column string) bool {
+	tb.Helper()
+	var count int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count); err != nil {
+		tb.Fatalf("reading columns of %s: %v", table, err)
+	}
+	return count > 0
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	conn := openTestDB(t)
+
+	// The shipped migrations plus one more adding a column
+	shipped, err := driverMigrations(driverSQLite)
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	latest := shipped[len(shipped)-1].Version
+	notes := migration{
+		Version: latest + 1,
+		Name:    "add_invoice_notes",
+		Up:      "ALTER TABLE invoices ADD COLUMN notes TEXT;",
+		Down:    "ALTER TABLE invoices DROP COLUMN notes;",
+	}
+	migrations := append(shipped, notes)
+	m := newMigrator(conn, migrations)
+
+	checkVersion := func(want int) {
+		t.Helper()
+		version, err := m.version(ctx)
+		if err != nil {
+			t.Fatalf("reading version: %v", err)
+		}
+		if version != want {
+			t.Fatalf("schema version %d, want %d", version, want)
+		}
+	}
+
+	checkVersion(0)
+	applied, err := m.up(ctx)
+	if err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+	if len(applied) != len(migrations) || applied[len(applied)-1] != notes {
+		t.Fatalf("applied %v, want all of %v", applied, migrations)
+	}
+	checkVersion(notes.Version)
+	if !hasColumn(t, conn, "invoices", "notes") {
+		t.Errorf("invoices has no notes column after migrating up")
+	}
+
+	// Nothing is left to apply
+	if applied, err := m.up(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("migrating up again applied %v, %v, want nothing", applied, err)
+	}
+
+	reverted, err := m.down(ctx, 1)
+	if err != nil {
+		t.Fatalf("migrating down: %v", err)
+	}
+	if len(reverted) != 1 || reverted[0] != notes {
+		t.Fatalf("reverted %v, want %s", reverted, notes)
+	}
+	checkVersion(latest)
+	if hasColumn(t, conn, "invoices", "notes") {
+		t.Errorf("invoices still has a notes column after reverting %s", notes)
+	}
+
+	if _, err := m.down(ctx, len(migrations)); err != nil {
+		t.Fatalf("migrating down to the start: %v", err)
+	}
+	checkVersion(0)
+	if schema := sqliteSchema(t, conn); len(schema) != 0 {
+		t.Errorf("tables left after reverting every migration: %v", schema)
+	}
+}
+
+func TestMigrationsMatchSchema(t *testing.T) {
+	migrations, err := driverMigrations(driverSQLite)
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	migrated := openTestDB(t)
+	if _, err := newMigrator(migrated, migrations).up(context.Background()); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+
+	// db/schema.sql, which sqlc and the other tests use, must describe the
+	// same database as the migrations
+	fromSchema := openTestDB(t)
+	if _, err := fromSchema.Exec(testDBConfig(t).schema()); err != nil {
+		t.Fatalf("applying schema: %v", err)
+	}
+	if got, want := sqliteSchema(t, migrated), sqliteSchema(t, fromSchema); !reflect.DeepEqual(got, want) {
+		t.Errorf("the migrations create\n%v\nwant db/schema.sql's\n%v", got, want)
+	}
+
+	// A database created from db/schema.sql is adopted at version 1
+	m := newMigrator(fromSchema, migrations[:1])
+	if _, err := m.up(context.Background()); err != nil {
+		t.Fatalf("migrating a database created from db/schema.sql: %v", err)
+	}
+	if version, err := m.version(context.Background()); err != nil || version != 1 {
+		t.Errorf("version %d, %v after adopting the database, want 1", version, err)
+	}
+}
+
+func TestMigratePlan(t *testing.T) {
+	ctx := context.Background()
+	conn := openTestDB(t)
+	shipped, err := driverMigrations(driverSQLite)
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	m := newMigrator(conn, shipped)
+	if _, err := m.up(ctx); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+	before := sqliteSchema(t, conn)
+
+	// Only one more migration is left to plan
+	latest := shipped[len(shipped)-1].Version
+	notes := migration{
+		Version: latest + 1,
+		Name:    "add_invoice_notes",
+		Up: `-- Notes on an invoice
+ALTER TABLE invoices ADD COLUMN notes TEXT;
+CREATE INDEX IF NOT EXISTS idx_invoices_notes ON invoices(notes);
+UPDATE invoices SET notes = description;
+DROP INDEX idx_invoices_status;`,
+		Down: "ALTER TABLE invoices DROP COLUMN notes;",
+	}
+	m = newMigrator(conn, append(shipped, notes))
+	var out bytes.Buffer
+	if err := runMigratePlan(ctx, &out, m); err != nil {
+		t.Fatalf("planning: %v", err)
+	}
+	want := fmt.Sprintf(`-- 1 pending migrations take the schema from version %d to %d. Nothing has been applied.
+
+-- Migration %s
+--   add column invoices.notes
+--   create index idx_invoices_notes on invoices
+--   write rows of invoices
+--   drop index idx_invoices_status
+%s
+`, latest, notes.Version, notes, notes.Up)
+	if out.String() != want {
+		t.Errorf("plan is\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// Nothing was applied
+	if version, err := m.version(ctx); err != nil || version != latest {
+		t.Errorf("version %d, %v after planning, want %d", version, err, latest)
+	}
+	if after := sqliteSchema(t, conn); !reflect.DeepEqual(after, before) {
+		t.Errorf("planning changed the schema from\n%v\nto\n%v", before, after)
+	}
+
+	if _, err := m.up(ctx); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+	out.Reset()
+	if err := runMigratePlan(ctx, &out, m); err != nil {
+		t.Fatalf("planning: %v", err)
+	}
+	if want := fmt.Sprintf("-- Schema is up to date at version %d.\n", notes.Version); out.String() != want {
+		t.Errorf("plan of a migrated database is %q, want %q", out.String(), want)
+	}
+}
+
+func TestMigratePlanLeavesNewDatabaseEmpty(t *testing.T) {
+	conn := openTestDB(t)
+	migrations, err := driverMigrations(driverSQLite)
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	var out bytes.Buffer
+	if err := runMigratePlan(context.Background(), &out, newMigrator(conn, migrations)); err != nil {
+		t.Fatalf("planning: %v", err)
+	}
+	if !strings.Contains(out.String(), "-- Migration 0001_initial\n--   create table ") {
+		t.Errorf("plan of a new database doesn't start from 0001_initial:\n%s", out.String())
+	}
+	var tables int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&tables); err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+	if tables != 0 {
+		t.Errorf("planning created %d objects in a new database, want none, not even schema_migrations", tables)
+	}
+}
+
+func TestMigratePlanCommand(t *testing.T) {
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := newRootCmd()
+		cmd.SetOut(&out)
+		cmd.SetArgs(append(args, "--db-path", dbPath, "--skip-if-exists", "--log-level", "error"))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("running %v: %v", args, err)
+		}
+		return out.String()
+	}
+
+	// Planning neither creates the database nor leaves an empty file behind
+	if plan := run("migrate", "plan"); !strings.Contains(plan, " from version 0 to ") || !strings.Contains(plan, "-- Migration 0001_initial\n") {
+		t.Errorf("plan of a new database is\n%s\nwant every migration", plan)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatalf("planning created %s", dbPath)
+	}
+
+	run("migrate", "up")
+	if plan := run("migrate", "plan"); !strings.HasPrefix(plan, "-- Schema is up to date") {
+		t.Errorf("plan of a migrated database is %q, want it up to date", plan)
+	}
+}
+
+func TestLoadMigrationsNeedsDown(t *testing.T) {
+	_, err := loadMigrations(fstest.MapFS{
+		"0001_initial.up.sql": {Data: []byte("CREATE TABLE t (id INTEGER);")},
+	})
+	if err == nil {
+		t.Errorf("loading a migration without a down file succeeded, want an error")
+	}
+}
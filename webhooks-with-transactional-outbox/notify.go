@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// notifyChannel is the channel the Postgres events trigger notifies with
+// the queue of every inserted event
+const notifyChannel = "outbox"
+
+// eventListener wakes the worker when Postgres announces a new event on its
+// queue, instead of waiting for the next poll
+type eventListener struct {
+	listener *pq.Listener
+	queue    string
+}
+
+func newEventListener(dsn string, queue string) (*eventListener, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Notification listener error", "error", err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error listening on %s: %v", notifyChannel, err)
+	}
+	return &eventListener{listener: listener, queue: queue}, nil
+}
+
+// wait blocks until an event is inserted on the queue or fallback passes,
+// and reports false if ctx was cancelled first. The fallback poll catches
+// anything inserted while the listener was reconnecting.
+func (l *eventListener) wait(ctx context.Context, fallback time.Duration) bool {
+	timer := time.NewTimer(fallback)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case n := <-l.listener.Notify:
+			// nil follows a reconnect, when notifications may have been lost
+			if n == nil || n.Extra == l.queue {
+				return true
+			}
+		}
+	}
+}
+
+func (l *eventListener) Close() error {
+	return l.listener.Close()
+}
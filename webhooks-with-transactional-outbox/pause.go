@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var workerPausedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "outbox_worker_paused",
+	Help: "Whether the worker is paused: 1 while it doesn't fetch or dispatch events, 0 otherwise.",
+})
+
+// pauseSwitch holds whether the worker is paused, for maintenance windows
+// where nothing should be sent but the process, its metrics and its state
+// should stay up. SIGUSR1 toggles it and SIGUSR2 resumes the worker.
+type pauseSwitch struct {
+	mu     sync.Mutex
+	paused bool
+	// changed is closed and replaced whenever paused flips, waking wait
+	changed chan struct{}
+}
+
+func newPauseSwitch() *pauseSwitch {
+	workerPausedGauge.Set(0)
+	return &pauseSwitch{changed: make(chan struct{})}
+}
+
+// set pauses or resumes the worker
+func (s *pauseSwitch) set(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == paused {
+		return
+	}
+	s.paused = paused
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	if paused {
+		workerPausedGauge.Set(1)
+		slog.Info("Worker paused, send SIGUSR1 or SIGUSR2 to resume")
+	} else {
+		workerPausedGauge.Set(0)
+		slog.Info("Worker resumed")
+	}
+}
+
+// toggle pauses a running worker and resumes a paused one
+func (s *pauseSwitch) toggle() {
+	s.mu.Lock()
+	paused := s.paused
+	s.mu.Unlock()
+	s.set(!paused)
+}
+
+// isPaused reports whether the worker is paused. It is safe to call on a
+// nil switch, which is never paused.
+func (s *pauseSwitch) isPaused() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// wait waits for d, returning early if the worker is paused or resumed in
+// the meantime or ctx is cancelled
+func (s *pauseSwitch) wait(ctx context.Context, d time.Duration) {
+	s.mu.Lock()
+	changed := s.changed
+	s.mu.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-changed:
+	case <-timer.C:
+	}
+}
+
+// watchPauseSignals flips s on SIGUSR1 and SIGUSR2 until ctx is cancelled
+func watchPauseSignals(ctx context.Context, s *pauseSwitch) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			if sig == syscall.SIGUSR1 {
+				s.toggle()
+			} else {
+				s.set(false)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPauseWorker(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 4, testIngestOptions(t))
+
+	pause := newPauseSwitch()
+	pause.set(true)
+	opts := testWorkerOptions()
+	opts.Pause = pause
+	publisher := &fakePublisher{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- runWorker(ctx, store, publisher, opts) }()
+
+	published := func() int {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		return len(publisher.published)
+	}
+
+	// Many poll intervals go by without a delivery
+	time.Sleep(20 * opts.PollInterval)
+	if n := published(); n != 0 {
+		t.Fatalf("%d events published while paused, want none", n)
+	}
+	var pending int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status = 'pending'").Scan(&pending); err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	if pending != 4 {
+		t.Errorf("%d events pending while paused, want 4", pending)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(store, newLiveness(time.Minute), pause, time.Second, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var health healthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatalf("decoding /healthz: %v", err)
+	}
+	if rec.Code != http.StatusOK || health.Status != "paused" {
+		t.Errorf("/healthz returned %d %q while paused, want 200 \"paused\"", rec.Code, health.Status)
+	}
+
+	pause.toggle()
+	deadline := time.Now().Add(5 * time.Second)
+	for published() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events published 5s after resuming, want 4", published())
+		}
+		time.Sleep(opts.PollInterval)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+}
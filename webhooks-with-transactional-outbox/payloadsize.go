@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+)
+
+// Policies for an invoice whose event payloads are over --max-payload-bytes
+const (
+	// oversizeReject fails the invoice without storing anything
+	oversizeReject = "reject"
+	// oversizeTruncate shortens the invoice's description until its
+	// payloads fit, and rejects it when they still don't without one
+	oversizeTruncate = "truncate"
+)
+
+// payloadTooLargeError reports an event payload over --max-payload-bytes
+type payloadTooLargeError struct {
+	EventType string
+	Size      int
+	Limit     int
+}
+
+func (e *payloadTooLargeError) Error() string {
+	return fmt.Sprintf("%s payload is %d bytes, over the limit of %d bytes", e.EventType, e.Size, e.Limit)
+}
+
+// checkPayloadSize returns a *payloadTooLargeError when payload is over
+// limit, which is unlimited when 0
+func checkPayloadSize(eventType string, payload []byte, limit int) error {
+	if limit > 0 && len(payload) > limit {
+		return &payloadTooLargeError{EventType: eventType, Size: len(payload), Limit: limit}
+	}
+	return nil
+}
+
+// fitPayloads shortens the description of an invoice whose events are over
+// opts.MaxPayloadBytes by as many bytes as the largest is over, and maps it
+// again, until they fit or the description is empty. The events are
+// returned for the invoice as it should be stored, and createEvents rejects
+// any still too large.
+func fitPayloads(invoice Invoice, events []Event, opts ingestOptions) (Invoice, []Event, error) {
+	original := len(invoice.Description)
+	for {
+		largest := 0
+		for _, event := range events {
+			_, encoded, err := encodeEvent(event, opts)
+			if err != nil {
+				return invoice, nil, err
+			}
+			largest = max(largest, len(encoded))
+		}
+		excess := largest - opts.MaxPayloadBytes
+		if excess <= 0 || invoice.Description == "" {
+			if len(invoice.Description) < original {
+				slog.Warn("Truncated invoice description to fit the payload limit", "invoice_id", invoice.ID, "description_bytes", original, "truncated_bytes", len(invoice.Description), "payload_bytes", largest, "max_payload_bytes", opts.MaxPayloadBytes)
+			}
+			return invoice, events, nil
+		}
+
+		invoice.Description = truncateUTF8(invoice.Description, len(invoice.Description)-excess)
+		var err error
+		events, err = opts.Mapper(invoice)
+		if err != nil {
+			return invoice, nil, fmt.Errorf("error mapping invoice to events: %v", err)
+		}
+	}
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMaxPayloadBytes(t *testing.T) {
+	invoice := generateInvoice(businessIDs[0])
+	invoice.Description = strings.Repeat("long description ", 20)
+
+	// The size of the invoice's payload as ingest encodes it
+	events, err := testIngestOptions(t).Mapper(invoice)
+	if err != nil {
+		t.Fatalf("mapping invoice: %v", err)
+	}
+	_, encoded, err := encodeEvent(events[0], testIngestOptions(t))
+	if err != nil {
+		t.Fatalf("encoding event: %v", err)
+	}
+	size := len(encoded)
+
+	tests := []struct {
+		name   string
+		policy string
+		limit  int
+		// wantErr is whether the invoice is rejected, and wantTruncated
+		// whether its description is stored shortened
+		wantErr       bool
+		wantTruncated bool
+	}{
+		{name: "reject just under", policy: oversizeReject, limit: size},
+		{name: "reject just over", policy: oversizeReject, limit: size - 1, wantErr: true},
+		{name: "truncate just under", policy: oversizeTruncate, limit: size},
+		{name: "truncate just over", policy: oversizeTruncate, limit: size - 1, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, dbConn := newTestStore(t)
+			opts := testIngestOptions(t)
+			opts.MaxPayloadBytes = tt.limit
+			opts.OnOversize = tt.policy
+
+			_, err := createInvoiceWithEvents(context.Background(), store, invoice, opts)
+			var tooLarge *payloadTooLargeError
+			if tt.wantErr {
+				if !errors.As(err, &tooLarge) || tooLarge.Size != size || tooLarge.Limit != tt.limit {
+					t.Fatalf("storing the invoice returned %v, want a %d byte payload over the limit of %d", err, size, tt.limit)
+				}
+				var invoices int
+				if err := dbConn.QueryRow("SELECT COUNT(*) FROM invoices").Scan(&invoices); err != nil {
+					t.Fatalf("counting invoices: %v", err)
+				}
+				if invoices != 0 {
+					t.Errorf("%d invoices stored after rejecting the invoice, want none", invoices)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("storing the invoice: %v", err)
+			}
+
+			var description string
+			if err := dbConn.QueryRow("SELECT description FROM invoices WHERE id = ?", invoice.ID).Scan(&description); err != nil {
+				t.Fatalf("reading invoice: %v", err)
+			}
+			if truncated := description != invoice.Description; truncated != tt.wantTruncated {
+				t.Errorf("stored description %q, want truncated %v", description, tt.wantTruncated)
+			}
+			if !strings.HasPrefix(invoice.Description, description) {
+				t.Errorf("stored description %q isn't a prefix of %q", description, invoice.Description)
+			}
+
+			stored, err := store.ListEvents(context.Background())
+			if err != nil {
+				t.Fatalf("listing events: %v", err)
+			}
+			if len(stored) != 1 || len(stored[0].Payload) > tt.limit {
+				t.Errorf("stored %d events, want one with a payload within %d bytes", len(stored), tt.limit)
+			}
+		})
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{s: "hello", n: 10, want: "hello"},
+		{s: "hello", n: 3, want: "hel"},
+		{s: "héllo", n: 2, want: "h"},
+		{s: "héllo", n: 3, want: "hé"},
+		{s: "hello", n: 0, want: ""},
+	}
+	for _, tt := range tests {
+		if got := truncateUTF8(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestWorkerDeadLettersOversizedPayloads(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 3, testIngestOptions(t))
+	stored, err := store.ListEvents(context.Background())
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+
+	// Only the largest payload is over the limit
+	largest := 0
+	for _, event := range stored {
+		largest = max(largest, len(event.Payload))
+	}
+	publisher := &fakePublisher{}
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.MaxPayloadBytes = largest - 1
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	var deadLettered int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM dead_letter_events").Scan(&deadLettered); err != nil {
+		t.Fatalf("counting dead letters: %v", err)
+	}
+	oversized := 0
+	for _, event := range stored {
+		if len(event.Payload) > opts.MaxPayloadBytes {
+			oversized++
+		}
+	}
+	if deadLettered != oversized {
+		t.Errorf("%d events dead-lettered, want the %d over the limit", deadLettered, oversized)
+	}
+	if len(publisher.published) != len(stored)-oversized {
+		t.Errorf("published %d events, want the %d within the limit", len(publisher.published), len(stored)-oversized)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// pollBackoff spaces out the polls of a worker. The interval doubles with
+// every consecutive poll that finds no events, up to max, and drops back to
+// base once one does. Each wait is jittered so workers started together
+// drift apart instead of querying in lockstep.
+type pollBackoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter float64
+
+	// idle counts the polls in a row that found no events
+	idle int
+}
+
+func newPollBackoff(opts workerOptions) *pollBackoff {
+	return &pollBackoff{
+		base:   opts.PollInterval,
+		max:    max(opts.MaxPollInterval, opts.PollInterval),
+		jitter: opts.PollJitter,
+	}
+}
+
+// interval returns the current poll interval, before jitter
+func (b *pollBackoff) interval() time.Duration {
+	if b.idle >= 62 {
+		return b.max
+	}
+	d := b.base << b.idle
+	if d <= 0 || d > b.max {
+		return b.max
+	}
+	return d
+}
+
+// idlePoll returns how long to wait after a poll that found no events, and
+// backs off the next one
+func (b *pollBackoff) idlePoll() time.Duration {
+	d := b.next()
+	b.idle++
+	return d
+}
+
+// reset goes back to the base interval after a poll that found events
+func (b *pollBackoff) reset() {
+	b.idle = 0
+}
+
+// next returns the current interval moved by up to jitter of itself either
+// way
+func (b *pollBackoff) next() time.Duration {
+	d := b.interval()
+	if b.jitter <= 0 {
+		return d
+	}
+	offset := (rand.Float64()*2 - 1) * b.jitter * float64(d)
+	return d + time.Duration(offset)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollBackoff(t *testing.T) {
+	opts := testWorkerOptions()
+	opts.PollInterval = time.Second
+	opts.MaxPollInterval = 5 * time.Second
+	backoff := newPollBackoff(opts)
+
+	// Each idle poll waits the current interval and doubles the next, up
+	// to the cap
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := backoff.idlePoll(); got != want {
+			t.Fatalf("idle poll waited %v, want %v", got, want)
+		}
+	}
+
+	backoff.reset()
+	if got := backoff.interval(); got != time.Second {
+		t.Errorf("interval %v after finding events, want the base 1s", got)
+	}
+	if got := backoff.idlePoll(); got != time.Second {
+		t.Errorf("first idle poll after a reset waited %v, want 1s", got)
+	}
+}
+
+func TestPollBackoffJitter(t *testing.T) {
+	opts := testWorkerOptions()
+	opts.PollInterval = time.Second
+	opts.PollJitter = 0.1
+	backoff := newPollBackoff(opts)
+
+	varied := false
+	for i := 0; i < 100; i++ {
+		d := backoff.next()
+		if d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Fatalf("jittered wait %v, want within 10%% of 1s", d)
+		}
+		varied = varied || d != time.Second
+	}
+	if !varied {
+		t.Errorf("every jittered wait was exactly 1s")
+	}
+}
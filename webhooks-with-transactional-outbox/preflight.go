@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// preflightTimeout bounds each call of the Convoy check at worker startup
+const preflightTimeout = 10 * time.Second
+
+// checkConvoy fetches the project of cfg, and of each route, from Convoy,
+// so a wrong base URL, API key or project ID stops the worker at startup
+// instead of failing every delivery once events have been claimed
+func checkConvoy(ctx context.Context, cfg convoyConfig, routes []convoyRoute) error {
+	configs := []convoyConfig{cfg}
+	for _, route := range routes {
+		routed := cfg
+		routed.ProjectID, routed.APIKey = route.ProjectID, route.APIKey
+		configs = append(configs, routed)
+	}
+
+	for _, c := range configs {
+		callCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+		_, err := c.client().Projects.Find(callCtx, c.ProjectID)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("can't reach Convoy project %s at %s, check the base URL, API key and project ID (or pass --skip-preflight): %v", c.ProjectID, c.BaseURL, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+)
+
+func TestWorkerPreflight(t *testing.T) {
+	// Running a command installs its own logger
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(convoy.APIResponse{Status: false, Message: "invalid api key"})
+	}))
+	defer server.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "outbox.db")
+	run := func(args ...string) error {
+		cmd := newRootCmd()
+		cmd.SetArgs(append(args, "--db-path", dbPath, "--skip-if-exists", "--log-level", "error"))
+		return cmd.Execute()
+	}
+	if err := run("ingest", "--count", "2", "--rate", "1ms"); err != nil {
+		t.Fatalf("running ingest: %v", err)
+	}
+
+	worker := []string{"worker", "--once", "--quiet", "--metrics-addr", "", "--convoy-base-url", server.URL, "--convoy-api-key", "wrong", "--convoy-project-id", "project"}
+	err := run(worker...)
+	if err == nil || !strings.Contains(err.Error(), "invalid api key") {
+		t.Fatalf("worker returned %v, want the preflight to fail with Convoy's error", err)
+	}
+	if len(requests) != 1 || requests[0] != "GET /projects/project" {
+		t.Errorf("Convoy got %v, want only the project lookup", requests)
+	}
+
+	dbConn, err := sql.Open(driverSQLite, dbPath)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer dbConn.Close()
+	var untouched int
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status = 'pending' AND retry_count = 0").Scan(&untouched); err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	if untouched != 2 {
+		t.Errorf("%d events left untouched, want both", untouched)
+	}
+
+	// Skipping the check goes straight to delivering, where the refused key
+	// is fatal rather than failing each event
+	requests = nil
+	err = run(append(worker, "--skip-preflight", "--max-fatal-errors", "1")...)
+	if err == nil || !strings.Contains(err.Error(), "giving up") || !strings.Contains(err.Error(), "invalid api key") {
+		t.Fatalf("worker with --skip-preflight returned %v, want it to give up on the refused key", err)
+	}
+	for _, request := range requests {
+		if strings.HasPrefix(request, "GET /projects/") {
+			t.Errorf("Convoy got %s with --skip-preflight", request)
+		}
+	}
+	if len(requests) == 0 {
+		t.Errorf("Convoy got no deliveries with --skip-preflight")
+	}
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM events WHERE status = 'pending' AND retry_count = 0").Scan(&untouched); err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	if untouched != 2 {
+		t.Errorf("%d events left untouched with --skip-preflight, want both", untouched)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Every metric is labelled with the queue and the --worker-id of the worker
+// recording it, so the workers of a shared deployment can be told apart
+var (
+	eventsDispatched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_dispatched_total",
+		Help: "Events delivered to the sink and marked processed.",
+	}, []string{"queue", "worker_id"})
+
+	eventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_fanout_failures_total",
+		Help: "Event deliveries that failed and were scheduled for a retry or dead-lettered.",
+	}, []string{"queue", "worker_id"})
+
+	eventsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_expired_total",
+		Help: "Events dead-lettered undelivered because they outlived their TTL.",
+	}, []string{"queue", "worker_id"})
+
+	sinkDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "outbox_sink_request_duration_seconds",
+		Help:    "Time taken to hand a single event to the sink.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"queue", "worker_id"})
+
+	deliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "outbox_event_delivery_latency_seconds",
+		Help:    "End-to-end latency of each event, from when it was written to when it was marked processed.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 16),
+	}, []string{"queue", "worker_id"})
+
+	pendingEvents = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "outbox_pending_events",
+		Help: "Pending events on the queue, refreshed on every poll.",
+	}, []string{"queue", "worker_id"})
+
+	oldestPendingAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "outbox_oldest_pending_seconds",
+		Help: "Age of the oldest pending event on the queue, 0 when none are pending, refreshed on every poll.",
+	}, []string{"queue", "worker_id"})
+)
+
+// serveMetrics serves handler on addr until ctx is cancelled, returning a
+// channel closed once the server has shut down. The address is bound before
+// returning so a port already in use fails the worker on start.
+func serveMetrics(ctx context.Context, addr string, handler http.Handler) (<-chan struct{}, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for metrics on %s: %v", addr, err)
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		close(stopped)
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", "addr", addr, "error", err)
+		}
+	}()
+	return stopped, nil
+}
+
+// recordQueueDepth sets the queue gauges of a worker from the depth read on
+// a poll
+func recordQueueDepth(queue, workerID string, depth queueDepth) {
+	pendingEvents.WithLabelValues(queue, workerID).Set(float64(depth.Pending))
+	oldestPendingAge.WithLabelValues(queue, workerID).Set(depth.OldestPendingAge.Seconds())
+}
+
+// deliveryTraces holds the trace of each event delivered in a sampled
+// span, by event ID, until the event's delivery latency is recorded with it
+type deliveryTraces struct {
+	mu     sync.Mutex
+	traces map[string]trace.TraceID
+}
+
+// set holds the trace of span for the event eventID, when span is sampled.
+// It is a no-op on a nil deliveryTraces.
+func (d *deliveryTraces) set(eventID string, span trace.SpanContext) {
+	if d == nil || !span.IsSampled() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.traces == nil {
+		d.traces = make(map[string]trace.TraceID)
+	}
+	d.traces[eventID] = span.TraceID()
+}
+
+// take returns the traces held for events and forgets them, whether or not
+// the events were marked processed
+func (d *deliveryTraces) take(events []db.Event) map[string]trace.TraceID {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	taken := make(map[string]trace.TraceID)
+	for _, event := range events {
+		if id, ok := d.traces[event.ID]; ok {
+			taken[event.ID] = id
+			delete(d.traces, event.ID)
+		}
+	}
+	return taken
+}
+
+// recordDeliveryLatency observes how long each event marked processed
+// waited between being written and being delivered, the lag users alert on.
+// An event delivered in one of traces is observed with the trace ID as an
+// exemplar, linking a slow bucket to a trace of it.
+func recordDeliveryLatency(queue, workerID string, processed []db.Event, traces map[string]trace.TraceID) {
+	now := time.Now()
+	observer := deliveryLatency.WithLabelValues(queue, workerID)
+	for _, event := range processed {
+		if !event.CreatedAt.Valid {
+			continue
+		}
+		latency := now.Sub(event.CreatedAt.Time).Seconds()
+		if id, ok := traces[event.ID]; ok {
+			observer.(prometheus.ExemplarObserver).ObserveWithExemplar(latency, prometheus.Labels{"trace_id": id.String()})
+		} else {
+			observer.Observe(latency)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOldestPendingAgeGauge(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	if events := seedInvoices(t, store, 3, testIngestOptions(t)); len(events) != 3 {
+		t.Fatalf("seeded %d events, want 3", len(events))
+	}
+
+	// The events are an hour, two hours and five minutes old
+	now := time.Now().UTC()
+	for i, age := range []time.Duration{time.Hour, 2 * time.Hour, 5 * time.Minute} {
+		if _, err := dbConn.Exec("UPDATE events SET created_at = ? WHERE rowid = ?", now.Add(-age), i+1); err != nil {
+			t.Fatalf("backdating event: %v", err)
+		}
+	}
+
+	depth, err := readQueueDepth(context.Background(), store, defaultQueue)
+	if err != nil {
+		t.Fatalf("reading queue depth: %v", err)
+	}
+	recordQueueDepth(defaultQueue, "gauge-test", depth)
+	got := testutil.ToFloat64(oldestPendingAge.WithLabelValues(defaultQueue, "gauge-test"))
+	if want := (2 * time.Hour).Seconds(); math.Abs(got-want) > 5 {
+		t.Errorf("oldest pending age %.0fs, want about %.0fs", got, want)
+	}
+	server := httptest.NewServer(metricsHandler(store, newLiveness(time.Minute), nil, time.Second, false))
+	defer server.Close()
+	if scraped, ok := scrapeMetric(t, server.URL, `outbox_oldest_pending_seconds{queue="default",worker_id="gauge-test"}`); !ok || scraped != got {
+		t.Errorf("scraped outbox_oldest_pending_seconds %v (found %v), want %.0fs", scraped, ok, got)
+	}
+
+	// Once they are delivered nothing is pending and each latency is seen.
+	// The histogram is shared by every run of the test, so only what this
+	// run adds to it is checked.
+	latencyBefore := deliveryLatencyHistogram(t, "gauge-test")
+	opts := testWorkerOptions()
+	opts.WorkerID = "gauge-test"
+	opts.Once = true
+	if err := runWorker(context.Background(), store, &fakePublisher{}, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if got := testutil.ToFloat64(oldestPendingAge.WithLabelValues(defaultQueue, "gauge-test")); got != 0 {
+		t.Errorf("oldest pending age %.0fs with nothing pending, want 0", got)
+	}
+
+	latency := deliveryLatencyHistogram(t, "gauge-test")
+	if count := latency.GetSampleCount() - latencyBefore.GetSampleCount(); count != 3 {
+		t.Errorf("%d delivery latencies observed, want 3", count)
+	}
+	sum := latency.GetSampleSum() - latencyBefore.GetSampleSum()
+	if want := (3*time.Hour + 5*time.Minute).Seconds(); math.Abs(sum-want) > 15 {
+		t.Errorf("delivery latencies sum to %.0fs, want about %.0fs", sum, want)
+	}
+}
+
+// deliveryLatencyHistogram returns the delivery latencies observed so far
+// by workerID on the default queue
+func deliveryLatencyHistogram(t *testing.T, workerID string) *dto.Histogram {
+	t.Helper()
+	var metric dto.Metric
+	if err := deliveryLatency.WithLabelValues(defaultQueue, workerID).(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatalf("reading delivery latency: %v", err)
+	}
+	return metric.GetHistogram()
+}
+
+// scrapeMetric fetches /metrics from url and returns the value of the
+// series written as series, such as name{label="value"}
+func scrapeMetric(t *testing.T, url, series string) (float64, bool) {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatalf("scraping metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/metrics returned %s", resp.Status)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), series+" ")
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", scanner.Text(), err)
+		}
+		return f, true
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading metrics: %v", err)
+	}
+	return 0, false
+}
+
+func TestMetricsEndpointCountsDispatched(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 4, testIngestOptions(t))
+	server := httptest.NewServer(metricsHandler(store, newLiveness(time.Minute), nil, time.Second, false))
+	defer server.Close()
+
+	const series = `outbox_events_dispatched_total{queue="default",worker_id="scrape-test"}`
+	before, _ := scrapeMetric(t, server.URL, series)
+
+	opts := testWorkerOptions()
+	opts.WorkerID = "scrape-test"
+	opts.Once = true
+	if err := runWorker(context.Background(), store, &fakePublisher{}, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	after, ok := scrapeMetric(t, server.URL, series)
+	if !ok {
+		t.Fatalf("/metrics has no %s", series)
+	}
+	if after-before != 4 {
+		t.Errorf("%s went from %v to %v, want 4 more", series, before, after)
+	}
+	if counted := testutil.ToFloat64(eventsDispatched.WithLabelValues(defaultQueue, "scrape-test")); counted != after {
+		t.Errorf("scraped %v dispatched events, the counter holds %v", after, counted)
+	}
+}
+
+func TestDeliveryLatencyExemplar(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 1, testIngestOptions(t))
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	opts := testWorkerOptions()
+	opts.WorkerID = "exemplar-test"
+	opts.Once = true
+	opts.Tracer = providerTracer(provider)
+	if err := runWorker(context.Background(), store, &fakePublisher{}, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	delivered := namedSpans(exporter, "deliver event")
+	if len(delivered) != 1 {
+		t.Fatalf("got %d delivery spans, want 1", len(delivered))
+	}
+	traceID := delivered[0].SpanContext.TraceID().String()
+
+	// Each bucket keeps its latest exemplar, which for this run's delivery
+	// is its trace
+	var exemplars []string
+	found := false
+	for _, bucket := range deliveryLatencyHistogram(t, "exemplar-test").GetBucket() {
+		for _, label := range bucket.GetExemplar().GetLabel() {
+			if label.GetName() == "trace_id" {
+				exemplars = append(exemplars, label.GetValue())
+				found = found || label.GetValue() == traceID
+			}
+		}
+	}
+	if !found {
+		t.Errorf("delivery latency has exemplars %v, want the delivery trace %s", exemplars, traceID)
+	}
+
+	// Scrapers asking for OpenMetrics get the exemplar too
+	server := httptest.NewServer(metricsHandler(store, newLiveness(time.Minute), nil, time.Second, false))
+	defer server.Close()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("scraping metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `# {trace_id="`+traceID+`"}`) {
+		t.Errorf("OpenMetrics scrape has no exemplar for trace %s", traceID)
+	}
+}
+
+func TestDeliveryLatencyWithoutTracing(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 1, testIngestOptions(t))
+	before := deliveryLatencyHistogram(t, "no-exemplar-test")
+	opts := testWorkerOptions()
+	opts.WorkerID = "no-exemplar-test"
+	opts.Once = true
+	if err := runWorker(context.Background(), store, &fakePublisher{}, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	latency := deliveryLatencyHistogram(t, "no-exemplar-test")
+	if count := latency.GetSampleCount() - before.GetSampleCount(); count != 1 {
+		t.Errorf("%d delivery latencies observed, want 1", count)
+	}
+	for _, bucket := range latency.GetBucket() {
+		if bucket.Exemplar != nil {
+			t.Errorf("untraced delivery has exemplar %v", bucket.Exemplar)
+		}
+	}
+}
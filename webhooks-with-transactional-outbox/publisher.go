@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+	"github.com/spf13/pflag"
+)
+
+const (
+	publisherConvoy = "convoy"
+	publisherHTTP   = "http"
+	publisherNoop   = "noop"
+
+	// deliveryFanout sends an event to every endpoint of its owner, and
+	// deliveryBroadcast to every subscriber of the project whatever their
+	// owner
+	deliveryFanout    = "fanout"
+	deliveryBroadcast = "broadcast"
+
+	// signatureHeader carries the hex HMAC-SHA256 of the body sent by httpPublisher
+	signatureHeader = "X-Signature"
+
+	// idempotencyHeader carries the idempotency key of the event sent by
+	// httpPublisher, so the webhook can drop a resend as Convoy would
+	idempotencyHeader = "Idempotency-Key"
+)
+
+// Publisher is where the worker delivers events. runWorker only depends on
+// this interface, so another backend, or a fake in a test, needs nothing but
+// a Publish method. The event is marked processed only when Publish returns
+// a nil error, along with the ID the backend gave the delivery, if it is
+// known, which is stored as the event's delivery_id.
+type Publisher interface {
+	Publish(ctx context.Context, event *outboundEvent) (deliveryID string, err error)
+}
+
+// outboundEvent is an event handed to a Publisher: the stored row, and its
+// payload as it is sent, decrypted, with its content type. Binary is set
+// when the payload isn't text but the encoding of a binary codec. Headers
+// are the custom headers stored with the event, to be sent along with it.
+type outboundEvent struct {
+	Event       db.Event
+	Payload     []byte
+	ContentType string
+	Binary      bool
+	Headers     map[string]string
+}
+
+// deprecatedFlagNames maps the names the publisher flags had before they
+// were named after the publisher and webhook, which are still accepted
+var deprecatedFlagNames = map[string]string{
+	"sink":         "publisher",
+	"sink-url":     "webhook-url",
+	"sink-secret":  "webhook-secret",
+	"sink-retries": "webhook-retries",
+}
+
+// normalizePublisherFlags resolves deprecatedFlagNames when the worker flags
+// are parsed
+func normalizePublisherFlags(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if current, ok := deprecatedFlagNames[name]; ok {
+		name = current
+	}
+	return pflag.NormalizedName(name)
+}
+
+// convoyPublisher fans events out through Convoy to every endpoint of the
+// owner, or broadcasts those stored with the broadcast delivery mode to
+// every subscriber
+type convoyPublisher struct {
+	client *convoy.Client
+
+	// router, when set, picks the client of each event by its type
+	// instead of client
+	router *convoyRouter
+
+	// lookupDeliveryIDs asks Convoy for the event it created after every
+	// fanout, since FanoutEvent doesn't return it
+	lookupDeliveryIDs bool
+}
+
+func (s *convoyPublisher) String() string {
+	if s.router != nil {
+		return "convoy, " + s.router.String()
+	}
+	return "convoy"
+}
+
+// clientFor returns the client event is sent with
+func (s *convoyPublisher) clientFor(event db.Event) *convoy.Client {
+	if s.router != nil {
+		return s.router.client(event.EventType)
+	}
+	return s.client
+}
+
+func (s *convoyPublisher) Publish(ctx context.Context, out *outboundEvent) (string, error) {
+	event := out.Event
+	// Convoy takes the data of an event as JSON, so a binary payload goes
+	// as a base64 string
+	data := json.RawMessage(out.Payload)
+	if out.Binary {
+		var err error
+		data, err = json.Marshal(out.Payload)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	ctx, note := withRateLimitNote(ctx)
+	if err := s.send(ctx, event, out.Headers, out.ContentType, data); err != nil {
+		err = fmt.Errorf("error sending to Convoy: %v", err)
+		if note.limited {
+			return "", rateLimitError{retryAfter: note.retryAfter, err: err}
+		}
+		if isSinkAuthStatus(note.status) {
+			return "", sinkAuthError{status: note.status, err: err}
+		}
+		return "", err
+	}
+
+	if !s.lookupDeliveryIDs {
+		return "", nil
+	}
+	// The event was delivered, so failing to find its ID mustn't send it
+	// again
+	deliveryID, err := s.lookupDeliveryID(ctx, event)
+	if err != nil {
+		slog.Warn("Error looking up Convoy event", "event_id", event.ID, "error", err)
+	}
+	return deliveryID, nil
+}
+
+// send creates the event in Convoy as a broadcast or a fanout, by its
+// delivery mode, with the custom headers of the event
+func (s *convoyPublisher) send(ctx context.Context, event db.Event, custom map[string]string, contentType string, data json.RawMessage) error {
+	headers := make(map[string]string, len(custom)+1)
+	for name, value := range custom {
+		headers[name] = value
+	}
+	headers["Content-Type"] = contentType
+	if event.DeliveryMode == deliveryBroadcast {
+		return s.clientFor(event).Events.BroadcastEvent(ctx, &convoy.CreateBroadcastEventRequest{
+			EventType:      event.EventType,
+			IdempotencyKey: idempotencyKey(event),
+			CustomHeaders:  headers,
+			Data:           data,
+		})
+	}
+
+	// Events stored before owner_id existed are owned by their business
+	owner := event.BusinessID
+	if event.OwnerID.Valid {
+		owner = event.OwnerID.String
+	}
+	return s.clientFor(event).Events.FanoutEvent(ctx, &convoy.CreateFanoutEventRequest{
+		EventType:      event.EventType,
+		OwnerID:        owner,
+		IdempotencyKey: idempotencyKey(event),
+		CustomHeaders:  headers,
+		Data:           data,
+	})
+}
+
+// validateDeliveryMode checks the --delivery-mode of ingest
+func validateDeliveryMode(mode string) error {
+	if mode != deliveryFanout && mode != deliveryBroadcast {
+		return fmt.Errorf("invalid delivery mode %q: must be %q or %q", mode, deliveryFanout, deliveryBroadcast)
+	}
+	return nil
+}
+
+// lookupDeliveryID finds the Convoy event created for event by its
+// idempotency key. Convoy ingests fanouts asynchronously, so the event may
+// not be listed yet, in which case the ID is empty. A fanout to an owner
+// with several endpoints can create several events, whose IDs are joined
+// with commas.
+func (s *convoyPublisher) lookupDeliveryID(ctx context.Context, event db.Event) (string, error) {
+	// Convoy can't have created its event before the outbox row existed
+	start := event.CreatedAt.Time.Add(-time.Minute)
+	if !event.CreatedAt.Valid {
+		start = time.Now().Add(-time.Hour)
+	}
+	response, err := s.clientFor(event).Events.All(ctx, &convoy.EventParams{
+		IdempotencyKey: idempotencyKey(event),
+		StartDate:      start,
+		EndDate:        time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error listing events from Convoy: %v", err)
+	}
+	uids := make([]string, len(response.Content))
+	for i, e := range response.Content {
+		uids[i] = e.UID
+	}
+	return strings.Join(uids, ","), nil
+}
+
+// noopPublisher accepts every event without sending it anywhere, for trying the
+// worker out or measuring it without a webhook backend
+type noopPublisher struct{}
+
+func (noopPublisher) String() string {
+	return "noop (events are discarded)"
+}
+
+func (noopPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	return "", nil
+}
+
+// httpPublisher POSTs each payload straight to a webhook URL, retrying failed
+// attempts itself since there is no Convoy to do it
+type httpPublisher struct {
+	url     string
+	secret  string
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+func newHTTPPublisher(url, secret string, retries int) *httpPublisher {
+	return &httpPublisher{
+		url:     url,
+		secret:  secret,
+		retries: retries,
+		backoff: 500 * time.Millisecond,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *httpPublisher) String() string {
+	signed := "unsigned"
+	if s.secret != "" {
+		signed = "signed"
+	}
+	return fmt.Sprintf("http (%s, %s)", s.url, signed)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of body under secret
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish delivers the event to the webhook URL. A webhook assigns no ID
+// to a delivery, so none is returned.
+func (s *httpPublisher) Publish(ctx context.Context, out *outboundEvent) (string, error) {
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(s.backoff << (attempt - 1)):
+			}
+		}
+
+		var retryable bool
+		retryable, err = s.post(ctx, out)
+		if err == nil || !retryable {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("giving up after %d attempts: %v", s.retries+1, err)
+}
+
+// post makes a single delivery attempt and reports whether a failure is
+// worth retrying. The custom headers of the event are set first, so they
+// can't replace the headers the publisher sets itself.
+func (s *httpPublisher) post(ctx context.Context, out *outboundEvent) (bool, error) {
+	event, payload := out.Event, out.Payload
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	for name, value := range out.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", out.ContentType)
+	req.Header.Set("X-Event-ID", event.ID)
+	req.Header.Set("X-Event-Type", event.EventType)
+	req.Header.Set("X-Business-ID", event.BusinessID)
+	req.Header.Set(idempotencyHeader, idempotencyKey(event))
+	if s.secret != "" {
+		req.Header.Set(signatureHeader, sign(s.secret, payload))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("error posting to %s: %v", s.url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	// Retrying a rate limited request straight away only adds to the load,
+	// so hand it back to the worker to wait as long as it was told
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return false, rateLimitError{
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			err:        fmt.Errorf("webhook returned %s", resp.Status),
+		}
+	}
+
+	// Refused credentials aren't the event's fault, see sinkAuthError
+	if isSinkAuthStatus(resp.StatusCode) {
+		return false, sinkAuthError{status: resp.StatusCode, err: fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+
+	// Any other client error is the event's fault and won't change on
+	// retry, so it goes straight to the dead-letter table
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return false, permanentError{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+	return resp.StatusCode >= 500, fmt.Errorf("webhook returned %s", resp.Status)
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// fakePublisher is a Publisher recording the events it is given, with the
+// idempotency keys they would be sent with, and answering every one with err
+type fakePublisher struct {
+	err error
+	// deliveryPrefix, when set, makes each delivery return the ID of the
+	// event behind this prefix as its delivery ID
+	deliveryPrefix string
+
+	mu        sync.Mutex
+	published []string
+	keys      []string
+	headers   []map[string]string
+	payloads  [][]byte
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, event.Event.ID)
+	p.keys = append(p.keys, idempotencyKey(event.Event))
+	p.headers = append(p.headers, event.Headers)
+	p.payloads = append(p.payloads, event.Payload)
+	if p.err != nil || p.deliveryPrefix == "" {
+		return "", p.err
+	}
+	return p.deliveryPrefix + event.Event.ID, nil
+}
+
+// eventState is what the worker recorded about an event
+type eventState struct {
+	status    string
+	processed bool
+	retries   int64
+}
+
+// eventStates reads back every row of the events table
+func eventStates(t *testing.T, dbConn *sql.DB) []eventState {
+	t.Helper()
+	rows, err := dbConn.Query("SELECT status, processed_at IS NOT NULL, retry_count FROM events")
+	if err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	defer rows.Close()
+	var states []eventState
+	for rows.Next() {
+		var state eventState
+		if err := rows.Scan(&state.status, &state.processed, &state.retries); err != nil {
+			t.Fatalf("reading events: %v", err)
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+func TestWorkerMarksProcessedOnlyOnPublish(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// want is the state of every event left in the outbox, and
+		// wantDeadLettered how many were moved to the dead letter table
+		want             eventState
+		wantDeadLettered int
+	}{
+		{name: "published", err: nil, want: eventState{status: "processed", processed: true}},
+		{name: "failed", err: errors.New("connection refused"), want: eventState{status: "pending", retries: 1}},
+		{name: "permanent", err: permanentError{errors.New("payload rejected")}, wantDeadLettered: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, dbConn := newTestStore(t)
+			events := seedInvoices(t, store, 3, testIngestOptions(t))
+
+			// The worker polls until it is stopped, so stop it once every
+			// event has been handled
+			settled := func() bool {
+				var deadLettered int
+				if err := dbConn.QueryRow("SELECT COUNT(*) FROM dead_letter_events").Scan(&deadLettered); err != nil {
+					t.Fatalf("counting dead letters: %v", err)
+				}
+				if deadLettered != tt.wantDeadLettered {
+					return false
+				}
+				for _, state := range eventStates(t, dbConn) {
+					if state != tt.want {
+						return false
+					}
+				}
+				return true
+			}
+
+			publisher := &fakePublisher{err: tt.err}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- runWorker(ctx, store, publisher, testWorkerOptions()) }()
+			for deadline := time.Now().Add(5 * time.Second); !settled() && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("running worker: %v", err)
+			}
+
+			if len(publisher.published) != len(events) {
+				t.Fatalf("published %d events, want %d", len(publisher.published), len(events))
+			}
+			states := eventStates(t, dbConn)
+			if got := len(events) - len(states); got != tt.wantDeadLettered {
+				t.Errorf("%d events left the outbox, want %d dead-lettered", got, tt.wantDeadLettered)
+			}
+			for _, state := range states {
+				if state != tt.want {
+					t.Errorf("event is %+v, want %+v", state, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerDeadLettersEmptyPayload(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 5, testIngestOptions(t))
+
+	// The oldest event lost its payload, so it heads the first batch
+	var emptyID string
+	if err := dbConn.QueryRow("SELECT id FROM events ORDER BY rowid LIMIT 1").Scan(&emptyID); err != nil {
+		t.Fatalf("reading event: %v", err)
+	}
+	if _, err := dbConn.Exec("UPDATE events SET payload = '' WHERE id = ?", emptyID); err != nil {
+		t.Fatalf("emptying payload: %v", err)
+	}
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.BatchSize = 2
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	// It is dead-lettered on its first attempt without being sent, and
+	// the events behind it are all delivered
+	if len(publisher.published) != 4 {
+		t.Errorf("published %d events, want the 4 with a payload", len(publisher.published))
+	}
+	for _, id := range publisher.published {
+		if id == emptyID {
+			t.Errorf("event %s with an empty payload was sent", emptyID)
+		}
+	}
+	var deadID, lastError string
+	var retries int64
+	if err := dbConn.QueryRow("SELECT id, last_error, retry_count FROM dead_letter_events").Scan(&deadID, &lastError, &retries); err != nil {
+		t.Fatalf("reading dead letter: %v", err)
+	}
+	if deadID != emptyID || lastError != "empty payload" || retries != 1 {
+		t.Errorf("dead-lettered %s after %d attempts with %q, want %s after 1 with \"empty payload\"", deadID, retries, lastError, emptyID)
+	}
+	for _, state := range eventStates(t, dbConn) {
+		if state != (eventState{status: "processed", processed: true}) {
+			t.Errorf("event left %+v, want every other event processed", state)
+		}
+	}
+}
+
+func TestHTTPPublisherSignsBody(t *testing.T) {
+	const secret = "demo"
+	payload := []byte(`{"id":"inv_1","amount":12.5}`)
+
+	var gotSignature, gotEventID string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(signatureHeader)
+		gotEventID = r.Header.Get("X-Event-ID")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	publisher := newHTTPPublisher(server.URL, secret, 0)
+	event := &outboundEvent{
+		Event:       db.Event{ID: "evt_1", EventType: "invoice.created", BusinessID: "biz_1"},
+		Payload:     payload,
+		ContentType: "application/json",
+	}
+	if _, err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+	if string(gotBody) != string(payload) {
+		t.Errorf("body = %s, want %s", gotBody, payload)
+	}
+	if want := sign(secret, payload); gotSignature != want {
+		t.Errorf("%s = %q, want %q", signatureHeader, gotSignature, want)
+	}
+	if gotEventID != "evt_1" {
+		t.Errorf("X-Event-ID = %q, want %q", gotEventID, "evt_1")
+	}
+}
+
+func TestHTTPPublisherIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(idempotencyHeader))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	publisher := newHTTPPublisher(server.URL, "", 0)
+	event := &outboundEvent{Event: db.Event{ID: "evt_1"}, Payload: []byte("{}"), ContentType: "application/json"}
+	for i := 0; i < 2; i++ {
+		if _, err := publisher.Publish(context.Background(), event); err != nil {
+			t.Fatalf("publishing: %v", err)
+		}
+	}
+	if len(keys) != 2 || keys[0] != "evt_1" || keys[1] != keys[0] {
+		t.Errorf("%s headers = %q, want evt_1 on both sends", idempotencyHeader, keys)
+	}
+}
+
+func TestHTTPPublisherStatus(t *testing.T) {
+	const retries = 2
+	tests := []struct {
+		name          string
+		status        int
+		wantRequests  int64
+		wantPermanent bool
+		wantRefused   bool
+		wantErr       bool
+	}{
+		{name: "ok", status: http.StatusOK, wantRequests: 1},
+		{name: "server error", status: http.StatusBadGateway, wantRequests: retries + 1, wantErr: true},
+		{name: "not found", status: http.StatusNotFound, wantRequests: 1, wantErr: true, wantPermanent: true},
+		{name: "unprocessable", status: http.StatusUnprocessableEntity, wantRequests: 1, wantErr: true, wantPermanent: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantRequests: 1, wantErr: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, wantRequests: 1, wantErr: true, wantRefused: true},
+		{name: "forbidden", status: http.StatusForbidden, wantRequests: 1, wantErr: true, wantRefused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			publisher := newHTTPPublisher(server.URL, "", retries)
+			publisher.backoff = time.Millisecond
+			_, err := publisher.Publish(context.Background(), &outboundEvent{Event: db.Event{ID: "evt_1"}, Payload: []byte("{}")})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			var permanent permanentError
+			if errors.As(err, &permanent) != tt.wantPermanent {
+				t.Errorf("Publish() error = %v, want permanent %v", err, tt.wantPermanent)
+			}
+			var refused sinkAuthError
+			if errors.As(err, &refused) != tt.wantRefused {
+				t.Errorf("Publish() error = %v, want refused %v", err, tt.wantRefused)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeySurvivesResend(t *testing.T) {
+	store, dbConn := newTestStore(t)
+	events := seedInvoices(t, store, 1, testIngestOptions(t))
+
+	stored, err := store.ListEvents(context.Background())
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	eventID := stored[0].ID
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	publisher := &fakePublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	// The event was published but marking it processed failed, so the next
+	// run sends it again
+	if _, err := dbConn.Exec("UPDATE events SET status = 'pending', processed_at = NULL WHERE id = ?", eventID); err != nil {
+		t.Fatalf("resetting event: %v", err)
+	}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker again: %v", err)
+	}
+
+	// A dead-lettered event that is requeued is sent a third time
+	if err := moveToDeadLetter(context.Background(), store, eventID, deadLetterFailed, "gave up"); err != nil {
+		t.Fatalf("dead-lettering event: %v", err)
+	}
+	if err := runDLQRequeue(context.Background(), store, eventID); err != nil {
+		t.Fatalf("requeueing event: %v", err)
+	}
+	requeued, err := store.GetEventByID(context.Background(), eventID)
+	if err != nil {
+		t.Fatalf("reading requeued event: %v", err)
+	}
+	if key := idempotencyKey(requeued); key != eventID {
+		t.Errorf("requeued event has key %q, want its original %q", key, eventID)
+	}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker after requeue: %v", err)
+	}
+
+	if len(publisher.keys) != 3*len(events) {
+		t.Fatalf("published %d times, want 3", len(publisher.keys))
+	}
+	for _, key := range publisher.keys {
+		if key != eventID {
+			t.Errorf("sent with key %q, want %q on every send", key, eventID)
+		}
+	}
+}
+
+func TestDeliveryIDStored(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 3, testIngestOptions(t))
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	publisher := &fakePublisher{deliveryPrefix: "msg_"}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	events, err := store.ListEvents(context.Background())
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	for _, event := range events {
+		if !event.ProcessedAt.Valid {
+			t.Errorf("event %s wasn't marked processed", event.ID)
+		}
+		if want := "msg_" + event.ID; event.DeliveryID.String != want {
+			t.Errorf("event %s has delivery ID %q, want %q", event.ID, event.DeliveryID.String, want)
+		}
+	}
+
+	last, err := store.GetLastDelivery(context.Background(), defaultQueue)
+	if err != nil {
+		t.Fatalf("reading last delivery: %v", err)
+	}
+	if last.DeliveryID.String != "msg_"+last.ID {
+		t.Errorf("last delivery is %q for event %s, want its own delivery ID", last.DeliveryID.String, last.ID)
+	}
+}
+
+func TestConvoyPublisherDeliveryMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		// wantPath is the Convoy endpoint the event is created with, and
+		// wantOwner the owner it is sent to
+		wantPath  string
+		wantOwner bool
+	}{
+		{mode: deliveryFanout, wantPath: "/projects/project/events/fanout", wantOwner: true},
+		{mode: deliveryBroadcast, wantPath: "/projects/project/events/broadcast"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var paths []string
+			var owners []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					OwnerID string `json:"owner_id"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				paths = append(paths, r.URL.Path)
+				owners = append(owners, body.OwnerID)
+				json.NewEncoder(w).Encode(convoy.APIResponse{Status: true, Message: "Event created"})
+			}))
+			defer server.Close()
+
+			store, _ := newTestStore(t)
+			ingestOpts := testIngestOptions(t)
+			ingestOpts.DeliveryMode = tt.mode
+			seedInvoices(t, store, 1, ingestOpts)
+			stored, err := store.ListEvents(context.Background())
+			if err != nil {
+				t.Fatalf("listing events: %v", err)
+			}
+			if stored[0].DeliveryMode != tt.mode || stored[0].OwnerID.Valid != tt.wantOwner {
+				t.Errorf("stored a %s event owned by %v, want %s", stored[0].DeliveryMode, stored[0].OwnerID, tt.mode)
+			}
+
+			publisher := &convoyPublisher{client: convoy.New(server.URL, "key", "project")}
+			opts := testWorkerOptions()
+			opts.Once = true
+			if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+				t.Fatalf("running worker: %v", err)
+			}
+			if len(paths) != 1 || paths[0] != tt.wantPath {
+				t.Fatalf("Convoy got %q, want one request to %s", paths, tt.wantPath)
+			}
+			wantOwner := ""
+			if tt.wantOwner {
+				wantOwner = stored[0].BusinessID
+			}
+			if owners[0] != wantOwner {
+				t.Errorf("sent to owner %q, want %q", owners[0], wantOwner)
+			}
+		})
+	}
+}
+
+func TestEventHeaders(t *testing.T) {
+	var got []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			CustomHeaders map[string]string `json:"custom_headers"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		got = append(got, body.CustomHeaders)
+		json.NewEncoder(w).Encode(convoy.APIResponse{Status: true, Message: "Event created"})
+	}))
+	defer server.Close()
+
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 1, testIngestOptions(t))
+	stored, err := store.ListEvents(context.Background())
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	headers, err := decodeHeaders(stored[0].Headers)
+	if err != nil {
+		t.Fatalf("reading stored headers: %v", err)
+	}
+	if headers[headerBusinessID] != stored[0].BusinessID || len(headers[headerTraceID]) != 32 {
+		t.Errorf("stored headers %v, want the business ID and a trace ID", headers)
+	}
+
+	publisher := &convoyPublisher{client: convoy.New(server.URL, "key", "project")}
+	opts := testWorkerOptions()
+	opts.Once = true
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Convoy got %d events, want 1", len(got))
+	}
+	want := map[string]string{"Content-Type": "application/json"}
+	for name, value := range headers {
+		want[name] = value
+	}
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("Convoy got headers %v, want %v", got[0], want)
+	}
+}
+
+func TestConvoyPublisherRefusedCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(convoy.APIResponse{Status: false, Message: "invalid api key"})
+	}))
+	defer server.Close()
+
+	publisher := &convoyPublisher{client: convoyConfig{BaseURL: server.URL, APIKey: "key", ProjectID: "project"}.client()}
+	_, err := publisher.Publish(context.Background(), &outboundEvent{Event: db.Event{ID: "evt_1", DeliveryMode: deliveryFanout}, Payload: []byte("{}")})
+	var refused sinkAuthError
+	if !errors.As(err, &refused) || refused.status != http.StatusUnauthorized {
+		t.Errorf("Publish() error = %v, want the refused credentials reported", err)
+	}
+	if !isFatalError(err) {
+		t.Errorf("isFatalError(%v) = false, want refused credentials to be fatal", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitError reports that the sink answered 429 Too Many Requests.
+// retryAfter is zero when the response didn't say how long to wait.
+type rateLimitError struct {
+	retryAfter time.Duration
+	err        error
+}
+
+func (e rateLimitError) Error() string {
+	return e.err.Error()
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// rateLimitNote is filled in by rateLimitTransport when the request it
+// travels with is rate limited. status is the status of the last response,
+// which also tells refused credentials apart from other failures.
+type rateLimitNote struct {
+	limited    bool
+	retryAfter time.Duration
+	status     int
+}
+
+type rateLimitNoteKey struct{}
+
+// withRateLimitNote returns a context whose requests report a 429 response
+// in the returned note
+func withRateLimitNote(ctx context.Context) (context.Context, *rateLimitNote) {
+	note := &rateLimitNote{}
+	return context.WithValue(ctx, rateLimitNoteKey{}, note), note
+}
+
+// rateLimitTransport records the status of every response, and for a 429 its
+// Retry-After header, in the request's rateLimitNote. convoy-go only returns
+// the error message of a failed call, so this is the one place the status
+// and headers can be seen.
+type rateLimitTransport struct {
+	next http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if note, ok := req.Context().Value(rateLimitNoteKey{}).(*rateLimitNote); ok {
+		note.status = resp.StatusCode
+		if resp.StatusCode == http.StatusTooManyRequests {
+			note.limited = true
+			note.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+	}
+	return resp, err
+}
+
+// throttle holds the worker back after the sink rate limited it, so no new
+// events are sent and the next batch waits until the pause is over
+type throttle struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// pause stops sending for at least d
+func (t *throttle) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// remaining returns how much of the pause is left
+func (t *throttle) remaining() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Until(t.until)
+}
+
+// newRPSLimiter returns a limiter spacing out calls to at most rps a
+// second, shared by every goroutine calling Wait. Calls aren't allowed to
+// burst: each one waits an interval after the previous one.
+func newRPSLimiter(rps float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(rps), 1)
+}
+
+// rateLimitedPublisher waits for the limiter before every call to the
+// publisher, so however many goroutines dispatch events the sink never sees
+// more than --max-rps requests a second
+type rateLimitedPublisher struct {
+	next    Publisher
+	limiter *rate.Limiter
+}
+
+func (p *rateLimitedPublisher) String() string {
+	return fmt.Sprint(p.next)
+}
+
+func (p *rateLimitedPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	if err := p.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return p.next.Publish(ctx, event)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// timedPublisher records when each call to it was made
+type timedPublisher struct {
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+func (p *timedPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, time.Now())
+	return "", nil
+}
+
+func TestMaxRPS(t *testing.T) {
+	const rps = 50
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 20, testIngestOptions(t))
+
+	// Every event of the batch is dispatched at once
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.BatchSize = 20
+	opts.Concurrency = 20
+	opts.MaxRPS = rps
+	publisher := &timedPublisher{}
+	if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	if len(publisher.calls) != 20 {
+		t.Fatalf("publisher called %d times, want 20", len(publisher.calls))
+	}
+	first, last := publisher.calls[0], publisher.calls[0]
+	for _, call := range publisher.calls {
+		if call.Before(first) {
+			first = call
+		}
+		if call.After(last) {
+			last = call
+		}
+	}
+	observed := float64(len(publisher.calls)-1) / last.Sub(first).Seconds()
+	if observed > rps*1.1 {
+		t.Errorf("published at %.1f requests/s, want at most %d", observed, rps)
+	}
+}
+
+func TestRPSLimiterWaitCancelled(t *testing.T) {
+	limiter := newRPSLimiter(2)
+	start := time.Now()
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("first wait: %v", err)
+	}
+
+	// The next turn is half a second away, so the wait ends with ctx
+	// instead
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := limiter.Wait(ctx); err == nil {
+		t.Fatalf("cancelled wait succeeded, want the context's error")
+	}
+	if waited := time.Since(start); waited > 250*time.Millisecond {
+		t.Errorf("cancelled wait took %v, want it to return with its context", waited)
+	}
+
+	// and gives its turn back, so the next caller isn't held up by it
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("wait after the cancelled one: %v", err)
+	}
+	if waited := time.Since(start); waited > 800*time.Millisecond {
+		t.Errorf("turn after a cancelled wait came %v after the first, want the cancelled one's 500ms", waited)
+	}
+}
+
+func TestWorkerWaitsRetryAfter(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 1, testIngestOptions(t))
+
+	// The sink rate limits the first delivery for a second and takes the
+	// next, noting when each came and when the event was due again
+	var mu sync.Mutex
+	var requests []time.Time
+	var nextRetryAt time.Time
+	retried := make(chan struct{})
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, time.Now())
+		if len(requests) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if events, err := store.ListEvents(context.Background()); err == nil && len(events) == 1 {
+			nextRetryAt = events[0].NextRetryAt.Time
+		}
+		if len(requests) == 2 {
+			close(retried)
+		}
+	}))
+	defer sink.Close()
+
+	// A backoff far shorter than the sink asked for
+	opts := testWorkerOptions()
+	opts.Retry.BaseDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- runWorker(ctx, store, newHTTPPublisher(sink.URL, "", 0), opts) }()
+	select {
+	case <-retried:
+	case <-time.After(10 * time.Second):
+		t.Error("the rate limited event wasn't sent again")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) < 2 {
+		t.FailNow()
+	}
+	if wait := requests[1].Sub(requests[0]); wait < time.Second {
+		t.Errorf("sent again after %v, want at least the second the sink asked for", wait)
+	}
+	if wait := nextRetryAt.Sub(requests[0]); wait < time.Second {
+		t.Errorf("retry scheduled %v after the 429, want at least a second", wait)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+)
+
+// maxWebhookBytes bounds the body the receiver reads from a single request
+const maxWebhookBytes = 1 << 20
+
+// receiver logs the webhooks delivered by Convoy or --sink http, checking
+// their signature when it has a secret
+type receiver struct {
+	secret   string
+	convoy   *convoy.Webhook
+	received atomic.Int64
+}
+
+func newReceiver(secret string) *receiver {
+	return &receiver{
+		secret: secret,
+		convoy: convoy.NewWebhook(&convoy.WebhookOpts{Secret: secret}),
+	}
+}
+
+// verify checks the body against whichever signature header came with it:
+// Convoy's X-Convoy-Signature or the X-Signature set by httpSink. It
+// returns the kind of signature that was checked.
+func (rc *receiver) verify(r *http.Request, body []byte) (string, error) {
+	if rc.secret == "" {
+		return "unchecked", nil
+	}
+	if header := r.Header.Get(convoy.DefaultSigHeader); header != "" {
+		if err := rc.convoy.VerifyPayload(body, header); err != nil {
+			return "convoy", err
+		}
+		return "convoy", nil
+	}
+	if header := r.Header.Get(signatureHeader); header != "" {
+		got, err := hex.DecodeString(header)
+		if err != nil {
+			return "http", fmt.Errorf("invalid %s header: %v", signatureHeader, err)
+		}
+		want, _ := hex.DecodeString(sign(rc.secret, body))
+		if !hmac.Equal(got, want) {
+			return "http", fmt.Errorf("signature does not match")
+		}
+		return "http", nil
+	}
+	return "", fmt.Errorf("no %s or %s header", convoy.DefaultSigHeader, signatureHeader)
+}
+
+// eventType names the webhook's event, from the header httpSink sets or
+// else the envelope built by newEvent or its CloudEvent
+func eventType(r *http.Request, body []byte) string {
+	if t := r.Header.Get("X-Event-Type"); t != "" {
+		return t
+	}
+	var envelope struct {
+		EventType string `json:"event_type"`
+		Type      string `json:"type"`
+	}
+	if json.Unmarshal(body, &envelope) == nil {
+		if envelope.EventType != "" {
+			return envelope.EventType
+		}
+		if envelope.Type != "" {
+			return envelope.Type
+		}
+	}
+	return "unknown"
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+
+	signature, err := rc.verify(r, body)
+	if err != nil {
+		slog.Warn("Rejected webhook", "signature", signature, "error", err)
+		http.Error(w, "invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	count := rc.received.Add(1)
+	slog.Info("Received webhook", "count", count, "event_type", eventType(r, body), "event_id", r.Header.Get("X-Event-ID"), "signature", signature)
+
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, body, "", "  ") == nil {
+		fmt.Println(pretty.String())
+	} else {
+		fmt.Println(string(body))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// runReceiver serves webhooks on addr until ctx is cancelled
+func runReceiver(ctx context.Context, addr, secret string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", addr, err)
+	}
+
+	rc := newReceiver(secret)
+	server := &http.Server{Handler: rc, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Receiving webhooks", "addr", listener.Addr().String(), "signatures", enabled(secret != ""))
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("receiver stopped: %v", err)
+	}
+	slog.Info("Shutting down receiver", "received", rc.received.Load())
+	return nil
+}
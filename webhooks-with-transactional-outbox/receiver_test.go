@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestReceiverSignatures(t *testing.T) {
+	const secret = "receiver-secret"
+	body := `{"event_type":"invoice.created","data":{"id":"INV-1"}}`
+	tampered := strings.Replace(body, "INV-1", "INV-2", 1)
+
+	tests := []struct {
+		name     string
+		secret   string
+		header   string
+		sig      string
+		body     string
+		wantCode int
+	}{
+		{"signed", secret, signatureHeader, sign(secret, []byte(body)), body, http.StatusOK},
+		{"tampered", secret, signatureHeader, sign(secret, []byte(body)), tampered, http.StatusBadRequest},
+		{"other secret", secret, signatureHeader, sign("other", []byte(body)), body, http.StatusBadRequest},
+		{"not hex", secret, signatureHeader, "not-hex", body, http.StatusBadRequest},
+		{"convoy signed", secret, convoy.DefaultSigHeader, sign(secret, []byte(body)), body, http.StatusOK},
+		{"convoy tampered", secret, convoy.DefaultSigHeader, sign(secret, []byte(body)), tampered, http.StatusBadRequest},
+		{"unsigned", secret, "", "", body, http.StatusBadRequest},
+		{"no secret", "", "", "", body, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newReceiver(tt.secret)
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.sig)
+			}
+			rec := httptest.NewRecorder()
+			rc.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("receiver answered %d, want %d", rec.Code, tt.wantCode)
+			}
+			// Only accepted webhooks are counted
+			want := int64(0)
+			if tt.wantCode == http.StatusOK {
+				want = 1
+			}
+			if got := rc.received.Load(); got != want {
+				t.Errorf("receiver counted %d webhooks, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestReceiverAcceptsHTTPSink(t *testing.T) {
+	rc := newReceiver("shared-secret")
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	out := &outboundEvent{
+		Event:       db.Event{ID: "evt-1", BusinessID: "biz-1", EventType: "invoice.created"},
+		Payload:     []byte(`{"id":"INV-1"}`),
+		ContentType: "application/json",
+	}
+	if _, err := newHTTPPublisher(server.URL, "shared-secret", 0).Publish(context.Background(), out); err != nil {
+		t.Errorf("publishing with the receiver's secret: %v", err)
+	}
+	_, err := newHTTPPublisher(server.URL, "wrong-secret", 0).Publish(context.Background(), out)
+	var permanent permanentError
+	if !errors.As(err, &permanent) {
+		t.Errorf("publishing with another secret gave %v, want it rejected for good", err)
+	}
+	if got := rc.received.Load(); got != 1 {
+		t.Errorf("receiver accepted %d webhooks, want 1", got)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+const (
+	// reconcilePageSize is how many Convoy events are fetched per page
+	reconcilePageSize = 100
+
+	// reconcileSlack widens the window Convoy is searched in, since Convoy
+	// creates an event a moment before the worker marks it processed
+	reconcileSlack = time.Minute
+)
+
+// reconcileReport compares the events processed locally in a window with the
+// events Convoy holds for it. Missing lists the local events whose delivery
+// IDs Convoy doesn't know, Extra the Convoy events no local event names.
+// Unrecorded counts the processed events without a delivery ID, which can't
+// be checked.
+type reconcileReport struct {
+	Matched    int
+	Missing    []db.GetProcessedEventsBetweenRow
+	Extra      []convoy.EventResponse
+	Unrecorded int
+}
+
+// parseReconcileWindow parses the --from and --to of reconcile, which default
+// to the day up to now
+func parseReconcileWindow(from, to string, now time.Time) (time.Time, time.Time, error) {
+	end := now.UTC()
+	if to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid --to %q: %v", to, err)
+		}
+		end = t.UTC()
+	}
+	start := end.Add(-24 * time.Hour)
+	if from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid --from %q: %v", from, err)
+		}
+		start = t.UTC()
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("--from must be before --to")
+	}
+	return start, end, nil
+}
+
+// listConvoyEvents fetches every Convoy event created between start and end,
+// following the page cursor until Convoy has no more
+func listConvoyEvents(ctx context.Context, client *convoy.Client, start, end time.Time) ([]convoy.EventResponse, error) {
+	params := &convoy.EventParams{
+		ListParams: convoy.ListParams{PerPage: reconcilePageSize},
+		StartDate:  start,
+		EndDate:    end,
+	}
+
+	var events []convoy.EventResponse
+	for page := 1; ; page++ {
+		response, err := client.Events.All(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("error listing page %d of Convoy events: %v", page, err)
+		}
+		events = append(events, response.Content...)
+
+		next := response.Pagination.NextPageCursor
+		if !response.Pagination.HasNextPage || next == "" || next == params.NextPageCursor {
+			return events, nil
+		}
+		params.NextPageCursor = next
+	}
+}
+
+// reconcile lists the events processed between start and end and checks
+// each of their delivery IDs against the events Convoy created in that time
+func reconcile(ctx context.Context, queries Querier, client *convoy.Client, start, end time.Time) (reconcileReport, error) {
+	var report reconcileReport
+
+	local, err := queries.GetProcessedEventsBetween(ctx, db.GetProcessedEventsBetweenParams{
+		WindowStart: sql.NullTime{Time: start, Valid: true},
+		WindowEnd:   sql.NullTime{Time: end, Valid: true},
+	})
+	if err != nil {
+		return report, fmt.Errorf("error listing processed events: %v", err)
+	}
+
+	remote, err := listConvoyEvents(ctx, client, start.Add(-reconcileSlack), end.Add(reconcileSlack))
+	if err != nil {
+		return report, err
+	}
+	inConvoy := make(map[string]bool, len(remote))
+	for _, event := range remote {
+		inConvoy[event.UID] = true
+	}
+
+	// A fanout to several endpoints stores every Convoy event ID, joined
+	// with commas, and all of them must be found
+	named := map[string]bool{}
+	for _, event := range local {
+		if !event.DeliveryID.Valid || event.DeliveryID.String == "" {
+			report.Unrecorded++
+			continue
+		}
+		found := true
+		for _, uid := range strings.Split(event.DeliveryID.String, ",") {
+			named[uid] = true
+			if !inConvoy[uid] {
+				found = false
+			}
+		}
+		if found {
+			report.Matched++
+		} else {
+			report.Missing = append(report.Missing, event)
+		}
+	}
+
+	// Only Convoy events inside the window itself count as extra, the slack
+	// is there to find the local ones
+	for _, event := range remote {
+		if named[event.UID] || event.CreatedAt.Before(start) || !event.CreatedAt.Before(end) {
+			continue
+		}
+		report.Extra = append(report.Extra, event)
+	}
+	return report, nil
+}
+
+// runReconcile prints the discrepancies between the outbox and Convoy over a
+// window, and fails when an event processed locally is missing from Convoy
+func runReconcile(ctx context.Context, queries Querier, client *convoy.Client, start, end time.Time) error {
+	report, err := reconcile(ctx, queries, client, start, end)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range report.Missing {
+		fmt.Printf("Missing: event %s (%s, %s) processed at %s with delivery ID %s\n",
+			event.ID, event.EventType, event.BusinessID, event.ProcessedAt.Time.Format(time.RFC3339), event.DeliveryID.String)
+	}
+	for _, event := range report.Extra {
+		fmt.Printf("Extra: Convoy event %s (%s) created at %s\n", event.UID, event.EventType, event.CreatedAt.Format(time.RFC3339))
+	}
+
+	fmt.Printf("Reconciled %s to %s: %d matched, %d missing, %d extra, %d without a delivery ID\n",
+		start.Format(time.RFC3339), end.Format(time.RFC3339), report.Matched, len(report.Missing), len(report.Extra), report.Unrecorded)
+	if len(report.Missing) > 0 {
+		return fmt.Errorf("%d processed events are missing from Convoy", len(report.Missing))
+	}
+	return nil
+}
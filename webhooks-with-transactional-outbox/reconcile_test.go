@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+)
+
+// fakeConvoyEvents serves the Convoy events API from a fixed list, perPage
+// events at a time, counting the pages requested
+func fakeConvoyEvents(t *testing.T, events []convoy.EventResponse, perPage int, pages *int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/project/events" {
+			http.NotFound(w, r)
+			return
+		}
+		*pages++
+
+		offset := 0
+		if cursor := r.URL.Query().Get("next_page_cursor"); cursor != "" {
+			offset, _ = strconv.Atoi(cursor)
+		}
+		end := min(offset+perPage, len(events))
+		list := convoy.ListEventResponse{Content: events[offset:end]}
+		if end < len(events) {
+			list.Pagination = convoy.Pagination{HasNextPage: true, NextPageCursor: strconv.Itoa(end)}
+		}
+
+		data, _ := json.Marshal(list)
+		raw := json.RawMessage(data)
+		json.NewEncoder(w).Encode(convoy.APIResponse{Status: true, Message: "Events fetched", Data: &raw})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestReconcile(t *testing.T) {
+	store, _ := newTestStore(t)
+	seedInvoices(t, store, 5, testIngestOptions(t))
+
+	opts := testWorkerOptions()
+	opts.Once = true
+	if err := runWorker(context.Background(), store, &fakePublisher{deliveryPrefix: "convoy_"}, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	processed, err := store.ListEvents(context.Background())
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+
+	// Convoy has every delivery but the first, and one event the outbox
+	// never sent
+	now := time.Now().UTC()
+	var remote []convoy.EventResponse
+	for _, event := range processed[1:] {
+		remote = append(remote, convoy.EventResponse{UID: event.DeliveryID.String, EventType: event.EventType, CreatedAt: now})
+	}
+	remote = append(remote, convoy.EventResponse{UID: "convoy_stranger", EventType: "invoice.created", CreatedAt: now})
+
+	var pages int
+	server := fakeConvoyEvents(t, remote, 2, &pages)
+	client := convoyConfig{BaseURL: server.URL, APIKey: "key", ProjectID: "project"}.client()
+
+	report, err := reconcile(context.Background(), store, client, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("reconciling: %v", err)
+	}
+
+	if pages != 3 {
+		t.Errorf("fetched %d pages of Convoy events, want all 3", pages)
+	}
+	if report.Matched != len(processed)-1 {
+		t.Errorf("%d events matched, want %d", report.Matched, len(processed)-1)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].ID != processed[0].ID {
+		t.Errorf("missing %v, want only event %s", report.Missing, processed[0].ID)
+	}
+	if len(report.Extra) != 1 || report.Extra[0].UID != "convoy_stranger" {
+		t.Errorf("extra %v, want only convoy_stranger", report.Extra)
+	}
+
+	if err := runReconcile(context.Background(), store, client, now.Add(-time.Hour), now.Add(time.Hour)); err == nil {
+		t.Errorf("reconcile with a missing event succeeded, want an error")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// redactedValue replaces the value of every field named by --redact-fields
+// in a logged payload
+const redactedValue = "[REDACTED]"
+
+// redactJSON returns payload with the value of each object field named in
+// fields masked, at any depth, so an invoice wrapped in an envelope or a
+// CloudEvent is masked as well. Other fields pass through unchanged, though
+// the keys of each object come out sorted. A payload that isn't JSON, such
+// as one encoded with a binary codec, can't be checked field by field and
+// is replaced as a whole.
+func redactJSON(payload []byte, fields []string) []byte {
+	if len(fields) == 0 {
+		return payload
+	}
+	redact := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		redact[field] = true
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return []byte(fmt.Sprintf("[%d bytes, not JSON]", len(payload)))
+	}
+	redacted, err := json.Marshal(redactValue(document, redact))
+	if err != nil {
+		return []byte(fmt.Sprintf("[%d bytes, not JSON]", len(payload)))
+	}
+	return redacted
+}
+
+func redactValue(value any, redact map[string]bool) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if redact[key] {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactValue(field, redact)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item, redact)
+		}
+	}
+	return value
+}
+
+// invoiceLogAttrs returns the fields logging the data of an invoice stored
+// with event: none unless --log-payloads is set, as the amount and payload
+// are financial data, and otherwise both with --redact-fields masked
+func invoiceLogAttrs(invoice Invoice, event Event, opts ingestOptions) []any {
+	if !opts.LogPayloads {
+		return nil
+	}
+	amount := formatAmount(invoice.AmountCents, invoice.Currency)
+	for _, field := range opts.RedactFields {
+		if field == "amount_cents" {
+			amount = redactedValue
+		}
+	}
+	return []any{"amount", amount, "currency", invoice.Currency, "payload", string(redactJSON(event.Payload, opts.RedactFields))}
+}
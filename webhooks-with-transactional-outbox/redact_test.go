@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	payload := []byte(`{"event_type":"invoice.created","data":{"id":"INV-1","amount_cents":1999,"currency":"USD","description":"Consulting for Jane Doe","lines":[{"description":"Day rate"}]}}`)
+
+	var got, want any
+	if err := json.Unmarshal(redactJSON(payload, []string{"amount_cents", "description"}), &got); err != nil {
+		t.Fatalf("decoding redacted payload: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"event_type":"invoice.created","data":{"id":"INV-1","amount_cents":"[REDACTED]","currency":"USD","description":"[REDACTED]","lines":[{"description":"[REDACTED]"}]}}`), &want); err != nil {
+		t.Fatalf("decoding expected payload: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redacted to %v, want %v", got, want)
+	}
+
+	if redacted := redactJSON(payload, nil); string(redacted) != string(payload) {
+		t.Errorf("redacting no fields gave %s, want the payload unchanged", redacted)
+	}
+	if redacted := string(redactJSON([]byte{0x0a, 0x05, 'I', 'N', 'V'}, []string{"amount_cents"})); redacted != "[5 bytes, not JSON]" {
+		t.Errorf("redacting a binary payload gave %q, want it replaced", redacted)
+	}
+}
+
+func TestInvoiceLogAttrs(t *testing.T) {
+	invoice := generateInvoice(businessIDs[0])
+	invoice.AmountCents = 1999
+	invoice.Currency = "USD"
+	invoice.Description = "Consulting for Jane Doe"
+	payload, err := json.Marshal(invoice)
+	if err != nil {
+		t.Fatalf("encoding invoice: %v", err)
+	}
+	event := Event{Type: "invoice.created", Payload: payload}
+
+	opts := testIngestOptions(t)
+	if attrs := invoiceLogAttrs(invoice, event, opts); attrs != nil {
+		t.Errorf("logged %v without --log-payloads, want nothing", attrs)
+	}
+
+	opts.LogPayloads = true
+	attrs := invoiceLogAttrs(invoice, event, opts)
+	if attrs[1] != "19.99" || !strings.Contains(attrs[5].(string), "Jane Doe") {
+		t.Errorf("logged %v, want the amount and payload", attrs)
+	}
+
+	opts.RedactFields = []string{"amount_cents", "description"}
+	attrs = invoiceLogAttrs(invoice, event, opts)
+	if attrs[1] != redactedValue || strings.Contains(attrs[5].(string), "Jane Doe") || strings.Contains(attrs[5].(string), "1999") {
+		t.Errorf("logged %v, want the amount and description masked", attrs)
+	}
+	if !strings.Contains(attrs[5].(string), invoice.ID) {
+		t.Errorf("logged %v, want the fields not named passed through", attrs)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// retryPolicy decides when a failed event is attempted again and when the
+// worker gives up on it
+type retryPolicy struct {
+	MaxRetries int64
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// permanentError marks a failure that retrying can't fix, such as an empty
+// payload, so the event is dead-lettered straight away
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// delay returns how long to wait before the next attempt of an event that
+// has already been retried retryCount times: BaseDelay doubled per retry,
+// capped at MaxDelay. Doubling as many times as MaxDelay/BaseDelay has bits
+// already passes MaxDelay, so the shift is only done below that, where it
+// can't overflow.
+func (r retryPolicy) delay(retryCount int64) time.Duration {
+	if r.BaseDelay <= 0 || r.MaxDelay <= r.BaseDelay {
+		return r.MaxDelay
+	}
+	if retryCount < 0 {
+		retryCount = 0
+	}
+	if retryCount >= int64(bits.Len64(uint64(r.MaxDelay/r.BaseDelay))) {
+		return r.MaxDelay
+	}
+	return r.BaseDelay << retryCount
+}
+
+// recordFailure schedules the event for another attempt, or moves it to the
+// dead-letter table once it has already been retried MaxRetries times or
+// the failure is permanent
+func (p *eventProcessor) recordFailure(ctx context.Context, event db.Event, cause error) {
+	// Bookkeeping must land even if the worker is shutting down
+	ctx, cancel := dbContext(context.WithoutCancel(ctx), p.dbTimeout)
+	defer cancel()
+
+	var permanent permanentError
+	if errors.As(cause, &permanent) || event.RetryCount >= p.retry.MaxRetries {
+		if err := p.deadLetter(ctx, event.ID, deadLetterFailed, cause.Error()); err != nil {
+			slog.Error("Error dead-lettering event", "event_id", event.ID, "error", err)
+			return
+		}
+		slog.Warn("Event moved to the dead-letter table", "event_id", event.ID, "business_id", event.BusinessID, "event_type", event.EventType, "attempts", event.RetryCount+1, "error", cause)
+		return
+	}
+
+	delay := p.retry.delay(event.RetryCount)
+	var limited rateLimitError
+	if errors.As(cause, &limited) && limited.retryAfter > delay {
+		delay = limited.retryAfter
+	}
+	queries, done := p.writeQueries()
+	err := queries.IncrementEventRetry(ctx, db.IncrementEventRetryParams{
+		NextRetryAt: sql.NullTime{Time: time.Now().UTC().Add(delay), Valid: true},
+		LastError:   sql.NullString{String: cause.Error(), Valid: true},
+		ID:          event.ID,
+	})
+	done()
+	if err != nil {
+		slog.Error("Error scheduling retry", "event_id", event.ID, "error", err)
+		return
+	}
+	slog.Info("Retrying event", "event_id", event.ID, "delay", delay.String(), "retry", event.RetryCount+1, "max_retries", p.retry.MaxRetries)
+}
+
+// validate checks the retry settings given on the command line
+func (r retryPolicy) validate() error {
+	if r.MaxRetries < 0 {
+		return fmt.Errorf("max retries must not be negative")
+	}
+	if r.BaseDelay <= 0 {
+		return fmt.Errorf("retry base delay must be positive")
+	}
+	if r.MaxDelay < r.BaseDelay {
+		return fmt.Errorf("retry max delay must not be less than the base delay")
+	}
+	return nil
+}
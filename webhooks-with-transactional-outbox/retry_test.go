@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := retryPolicy{BaseDelay: 5 * time.Second, MaxDelay: 10 * time.Minute}
+	tests := []struct {
+		name       string
+		policy     retryPolicy
+		retryCount int64
+		want       time.Duration
+	}{
+		{"first retry", policy, 0, 5 * time.Second},
+		{"doubled", policy, 1, 10 * time.Second},
+		{"doubled again", policy, 4, 80 * time.Second},
+		{"last below the cap", policy, 6, 320 * time.Second},
+		{"capped", policy, 7, 10 * time.Minute},
+		{"would wrap to negative", policy, 31, 10 * time.Minute},
+		{"would wrap to positive", policy, 32, 10 * time.Minute},
+		// A base of 2^33+1ns doubled 31 times wraps to 2^31ns, about 2s,
+		// below the cap
+		{"would wrap below the cap", retryPolicy{BaseDelay: 1<<33 + 1, MaxDelay: time.Hour}, 31, time.Hour},
+		{"past the bits of a duration", policy, 64, 10 * time.Minute},
+		{"largest count", policy, math.MaxInt64, 10 * time.Minute},
+		{"cap a power of two away", retryPolicy{BaseDelay: time.Second, MaxDelay: 8 * time.Second}, 3, 8 * time.Second},
+		{"one past it", retryPolicy{BaseDelay: time.Second, MaxDelay: 8 * time.Second}, 4, 8 * time.Second},
+		{"largest cap", retryPolicy{BaseDelay: time.Nanosecond, MaxDelay: math.MaxInt64}, 62, 1 << 62},
+		{"largest cap passed", retryPolicy{BaseDelay: time.Nanosecond, MaxDelay: math.MaxInt64}, 63, math.MaxInt64},
+		{"base at the cap", retryPolicy{BaseDelay: time.Minute, MaxDelay: time.Minute}, 3, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.retryCount); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.retryCount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayNeverDecreases(t *testing.T) {
+	policy := retryPolicy{BaseDelay: 3 * time.Second, MaxDelay: 24 * time.Hour}
+	previous := time.Duration(0)
+	for retryCount := int64(0); retryCount < 200; retryCount++ {
+		d := policy.delay(retryCount)
+		if d < previous || d > policy.MaxDelay {
+			t.Fatalf("delay(%d) = %v after %v, want between it and %v", retryCount, d, previous, policy.MaxDelay)
+		}
+		previous = d
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+)
+
+// convoyRoute sends the events whose type starts with Prefix to another
+// Convoy project
+type convoyRoute struct {
+	Prefix    string
+	ProjectID string `json:"project_id"`
+	APIKey    string `json:"api_key"`
+}
+
+// loadRoutes reads a --routing-file, a JSON object mapping event type
+// prefixes to the project and API key their events are sent with, e.g.
+//
+//	{"invoice.": {"project_id": "billing", "api_key": "..."}}
+func loadRoutes(path string) ([]convoyRoute, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading routing file: %v", err)
+	}
+	var byPrefix map[string]convoyRoute
+	if err := json.Unmarshal(contents, &byPrefix); err != nil {
+		return nil, fmt.Errorf("error parsing routing file %s: %v", path, err)
+	}
+
+	routes := make([]convoyRoute, 0, len(byPrefix))
+	for prefix, route := range byPrefix {
+		if prefix == "" {
+			return nil, fmt.Errorf("routing file %s has a route with an empty prefix", path)
+		}
+		if route.ProjectID == "" || route.APIKey == "" {
+			return nil, fmt.Errorf("route %q in %s needs both a project_id and an api_key", prefix, path)
+		}
+		route.Prefix = prefix
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// convoyRouter picks the Convoy client of each event by its type. The
+// route with the longest matching prefix wins, and events no route matches
+// go to the default project. Clients are built the first time their
+// project is used and kept for the life of the worker.
+type convoyRouter struct {
+	defaults convoyConfig
+	routes   []convoyRoute
+
+	mu      sync.Mutex
+	clients map[string]*convoy.Client
+}
+
+func newConvoyRouter(defaults convoyConfig, routes []convoyRoute) *convoyRouter {
+	routes = append([]convoyRoute(nil), routes...)
+	sort.Slice(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+	return &convoyRouter{defaults: defaults, routes: routes, clients: map[string]*convoy.Client{}}
+}
+
+func (r *convoyRouter) String() string {
+	return fmt.Sprintf("%d routes", len(r.routes))
+}
+
+// client returns the client of the project eventType is routed to
+func (r *convoyRouter) client(eventType string) *convoy.Client {
+	cfg := r.defaults
+	for _, route := range r.routes {
+		if strings.HasPrefix(eventType, route.Prefix) {
+			cfg.ProjectID, cfg.APIKey = route.ProjectID, route.APIKey
+			break
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[cfg.ProjectID]
+	if !ok {
+		client = cfg.client()
+		r.clients[cfg.ProjectID] = client
+	}
+	return client
+}
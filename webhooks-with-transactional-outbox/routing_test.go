@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	convoy "github.com/frain-dev/convoy-go/v2"
+)
+
+func TestConvoyRouting(t *testing.T) {
+	// The project and API key each event type reached Convoy with
+	var mu sync.Mutex
+	sent := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			EventType string `json:"event_type"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		project := strings.Split(strings.TrimPrefix(r.URL.Path, "/projects/"), "/")[0]
+		mu.Lock()
+		sent[body.EventType] = project + " " + r.Header.Get("Authorization")
+		mu.Unlock()
+		json.NewEncoder(w).Encode(convoy.APIResponse{Status: true, Message: "Event created"})
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "routes.json")
+	routesJSON := `{
+		"invoice.": {"project_id": "billing", "api_key": "billing-key"},
+		"ledger.": {"project_id": "accounting", "api_key": "accounting-key"}
+	}`
+	if err := os.WriteFile(path, []byte(routesJSON), 0o600); err != nil {
+		t.Fatalf("writing routing file: %v", err)
+	}
+	routes, err := loadRoutes(path)
+	if err != nil {
+		t.Fatalf("loading routes: %v", err)
+	}
+
+	store, _ := newTestStore(t)
+	opts := testIngestOptions(t)
+	opts.Mapper, err = buildEventMapper([]string{"ledger.entry.added"})
+	if err != nil {
+		t.Fatalf("building event mapper: %v", err)
+	}
+	seedInvoices(t, store, 1, opts)
+
+	defaults := convoyConfig{BaseURL: server.URL, ProjectID: "default", APIKey: "default-key"}
+	publisher := &convoyPublisher{client: defaults.client(), router: newConvoyRouter(defaults, routes)}
+	workerOpts := testWorkerOptions()
+	workerOpts.Once = true
+	if err := runWorker(context.Background(), store, publisher, workerOpts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+
+	want := map[string]string{
+		"invoice.created":    "billing Bearer billing-key",
+		"ledger.entry.added": "accounting Bearer accounting-key",
+	}
+	for eventType, wantSent := range want {
+		if sent[eventType] != wantSent {
+			t.Errorf("%s sent to %q, want %q", eventType, sent[eventType], wantSent)
+		}
+	}
+}
+
+func TestConvoyRouterClients(t *testing.T) {
+	router := newConvoyRouter(convoyConfig{BaseURL: "http://convoy", ProjectID: "default", APIKey: "key"}, []convoyRoute{
+		{Prefix: "invoice.", ProjectID: "billing", APIKey: "billing-key"},
+		{Prefix: "invoice.paid", ProjectID: "payments", APIKey: "payments-key"},
+	})
+
+	// Clients are cached per project, and the longest prefix wins
+	billing := router.client("invoice.created")
+	if router.client("invoice.sent") != billing {
+		t.Errorf("two events of the billing project got different clients")
+	}
+	if payments := router.client("invoice.paid"); payments == billing {
+		t.Errorf("invoice.paid went to the billing project, want payments")
+	}
+	unrouted := router.client("payment.received")
+	if unrouted == billing || router.client("customer.created") != unrouted {
+		t.Errorf("events without a route didn't share the default project's client")
+	}
+	if len(router.clients) != 3 {
+		t.Errorf("built %d clients, want one per project", len(router.clients))
+	}
+}
+
+func TestLoadRoutesNeedsCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(path, []byte(`{"invoice.": {"project_id": "billing"}}`), 0o600); err != nil {
+		t.Fatalf("writing routing file: %v", err)
+	}
+	if _, err := loadRoutes(path); err == nil {
+		t.Errorf("loading a route without an api_key succeeded, want an error")
+	}
+}
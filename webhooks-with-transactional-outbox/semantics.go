@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// Delivery semantics, chosen with --delivery-semantics. Both deliver at
+// least once; they differ in where a crash leaves an event and whether two
+// workers can send the same event at the same time.
+const (
+	// semanticsSendThenMark fetches a batch, sends it and then marks the
+	// delivered events processed. On Postgres the batch is locked with FOR
+	// UPDATE SKIP LOCKED until it is marked.
+	semanticsSendThenMark = "send-then-mark"
+
+	// semanticsClaimFirst marks a batch processing and commits before
+	// sending it, then marks the delivered events processed and reverts
+	// the rest to pending
+	semanticsClaimFirst = "claim-first"
+)
+
+// deliveryTradeoffs explains what each delivery semantics risks, logged
+// when the worker starts so the choice is visible where it matters
+var deliveryTradeoffs = map[string]string{
+	semanticsSendThenMark: "events are marked processed only after they are sent: a crash or a failed mark in between leaves them pending to be sent again, and on SQLite, which has no row locks, two workers can send the same event at once",
+	semanticsClaimFirst:   "events are committed as processing before they are sent, so no other worker sends them at the same time: a crash in between leaves them processing until --visibility-timeout hands them back to be sent again, so they wait longer after a crash",
+}
+
+// resolveDeliverySemantics returns the delivery semantics of a worker,
+// claim-first for a pool on SQLite and send-then-mark otherwise when none
+// was chosen
+func resolveDeliverySemantics(opts workerOptions) string {
+	if opts.DeliverySemantics != "" {
+		return opts.DeliverySemantics
+	}
+	if opts.Driver != driverPostgres && opts.DispatchMode == dispatchPool {
+		return semanticsClaimFirst
+	}
+	return semanticsSendThenMark
+}
+
+// validateDeliverySemantics checks semantics against the dispatch mode.
+// Claiming takes the oldest events of the queue whatever their business,
+// so it can't keep the order of --dispatch-mode per-business.
+func validateDeliverySemantics(semantics, dispatchMode string) error {
+	switch semantics {
+	case "", semanticsSendThenMark:
+		return nil
+	case semanticsClaimFirst:
+		if dispatchMode != dispatchPool {
+			return fmt.Errorf("--delivery-semantics %s requires --dispatch-mode %s", semanticsClaimFirst, dispatchPool)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid delivery semantics %q: must be %q or %q", semantics, semanticsSendThenMark, semanticsClaimFirst)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// crashingPublisher crashes the worker it runs in by panicking, either
+// before an event is delivered or right after, before the worker could
+// mark it. Deliveries that got through are appended to a file, as they
+// outlive the process.
+type crashingPublisher struct {
+	afterSend bool
+	sent      string
+}
+
+func (p *crashingPublisher) Publish(ctx context.Context, event *outboundEvent) (string, error) {
+	if p.afterSend {
+		f, err := os.OpenFile(p.sent, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(f, idempotencyKey(event.Event))
+		f.Close()
+	}
+	panic("worker crashed delivering " + event.Event.ID)
+}
+
+// TestCrashingWorker is the worker TestDeliverySemanticsCrash runs in a
+// separate process, to crash it for real
+func TestCrashingWorker(t *testing.T) {
+	dbPath := os.Getenv("OUTBOX_CRASH_DB")
+	if dbPath == "" {
+		t.Skip("only run by TestDeliverySemanticsCrash")
+	}
+	cfg := testDBConfig(t)
+	cfg.Path = dbPath
+	store, err := openStore(cfg)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer store.Close()
+
+	opts := testWorkerOptions()
+	opts.WorkerID = "crashed"
+	opts.DeliverySemantics = os.Getenv("OUTBOX_CRASH_SEMANTICS")
+	opts.Once = true
+	publisher := &crashingPublisher{afterSend: os.Getenv("OUTBOX_CRASH_POINT") == "after-send", sent: os.Getenv("OUTBOX_CRASH_SENT")}
+	runWorker(context.Background(), store, publisher, opts)
+	t.Fatalf("worker returned without crashing")
+}
+
+func TestDeliverySemanticsCrash(t *testing.T) {
+	for _, semantics := range []string{semanticsSendThenMark, semanticsClaimFirst} {
+		for _, point := range []string{"before-send", "after-send"} {
+			t.Run(semantics+"/"+point, func(t *testing.T) {
+				store, dbConn := newTestStore(t)
+				seedInvoices(t, store, 1, testIngestOptions(t))
+				var dbPath string
+				if err := dbConn.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&dbPath); err != nil {
+					t.Fatalf("finding database file: %v", err)
+				}
+
+				sent := filepath.Join(t.TempDir(), "sent")
+				cmd := exec.Command(os.Args[0], "-test.run=^TestCrashingWorker$")
+				cmd.Env = append(os.Environ(), "OUTBOX_CRASH_DB="+dbPath, "OUTBOX_CRASH_SEMANTICS="+semantics, "OUTBOX_CRASH_POINT="+point, "OUTBOX_CRASH_SENT="+sent)
+				out, err := cmd.CombinedOutput()
+				if err == nil || !strings.Contains(string(out), "worker crashed delivering") {
+					t.Fatalf("worker didn't crash: %v\n%s", err, out)
+				}
+				var crashSent []string
+				if data, err := os.ReadFile(sent); err == nil {
+					crashSent = strings.Fields(string(data))
+				}
+
+				var status, claimedBy string
+				if err := dbConn.QueryRow("SELECT status, COALESCE(claimed_by, '') FROM events").Scan(&status, &claimedBy); err != nil {
+					t.Fatalf("reading event: %v", err)
+				}
+
+				opts := testWorkerOptions()
+				opts.WorkerID = "survivor"
+				opts.DeliverySemantics = semantics
+				opts.Once = true
+				publisher := &fakePublisher{}
+				if semantics == semanticsClaimFirst {
+					// The crashed worker's claim holds the event back from
+					// every other worker until the visibility timeout
+					if status != "processing" || claimedBy != "crashed" {
+						t.Fatalf("event %s claimed by %q after the crash, want processing by the crashed worker", status, claimedBy)
+					}
+					if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+						t.Fatalf("running worker: %v", err)
+					}
+					if len(publisher.published) != 0 {
+						t.Fatalf("event sent again within the visibility timeout")
+					}
+					past := time.Now().UTC().Add(-time.Hour)
+					if _, err := dbConn.Exec("UPDATE events SET claimed_at = ?", past); err != nil {
+						t.Fatalf("backdating claim: %v", err)
+					}
+				} else if status != "pending" {
+					t.Fatalf("event %s after the crash, want pending", status)
+				}
+
+				// Either way the event is delivered at least once, with the
+				// same idempotency key when twice so Convoy drops the repeat
+				if err := runWorker(context.Background(), store, publisher, opts); err != nil {
+					t.Fatalf("running worker: %v", err)
+				}
+				if len(publisher.published) != 1 {
+					t.Fatalf("event sent %d times after the crash, want once", len(publisher.published))
+				}
+				wantCrashSent := 0
+				if point == "after-send" {
+					wantCrashSent = 1
+				}
+				if len(crashSent) != wantCrashSent {
+					t.Errorf("crashed worker sent %d events, want %d", len(crashSent), wantCrashSent)
+				}
+				if len(crashSent) > 0 && crashSent[0] != publisher.keys[0] {
+					t.Errorf("event resent with key %s, first sent with %s", publisher.keys[0], crashSent[0])
+				}
+				if states := eventStates(t, dbConn); len(states) != 1 || states[0].status != "processed" {
+					t.Errorf("event states %+v, want processed", states)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// maxInvoiceBytes bounds the body of a single POST /invoices request
+const maxInvoiceBytes = 64 << 10
+
+// invoiceRequest is the body of POST /invoices. The ID and creation time are
+// assigned by the server.
+type invoiceRequest struct {
+	BusinessID  string `json:"business_id"`
+	AmountCents int64  `json:"amount_cents"`
+	Currency    string `json:"currency"`
+	Status      string `json:"status"`
+	Description string `json:"description"`
+}
+
+// apiError is the body of every error response of the ingest API. Fields is
+// only set for a request that failed validation.
+type apiError struct {
+	Error  string              `json:"error"`
+	Fields []invoiceFieldError `json:"fields,omitempty"`
+}
+
+// ingestServer writes the invoices POSTed to it into the outbox, each with
+// its events in one transaction, just as ingest does
+type ingestServer struct {
+	store Store
+	opts  ingestOptions
+}
+
+// handler routes the requests of the ingest API
+func (s *ingestServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/invoices", s.createInvoice)
+	return mux
+}
+
+// createInvoice serves POST /invoices, answering 201 with the stored
+// invoice, 400 when the body is malformed or invalid, and 500 when the
+// database fails, in which case nothing was written
+func (s *ingestServer) createInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	var req invoiceRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxInvoiceBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid JSON body: %v", err)})
+		return
+	}
+
+	invoice := Invoice{
+		ID:          "INV-" + newInvoiceUUID(),
+		BusinessID:  req.BusinessID,
+		AmountCents: req.AmountCents,
+		Currency:    req.Currency,
+		Status:      req.Status,
+		CreatedAt:   time.Now(),
+		Description: req.Description,
+	}
+	events, err := createInvoiceWithEvents(r.Context(), s.store, invoice, s.opts)
+	var invalid *invoiceValidationError
+	switch {
+	case errors.As(err, &invalid):
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid invoice", Fields: invalid.Fields})
+		return
+	case err != nil:
+		slog.Error("Error storing posted invoice", "invoice_id", invoice.ID, "business_id", invoice.BusinessID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "error storing invoice"})
+		return
+	}
+
+	for _, event := range events {
+		attrs := []any{"invoice_id", invoice.ID, "business_id", invoice.BusinessID, "event_type", event.Type}
+		slog.Info("Created invoice and event", append(attrs, invoiceLogAttrs(invoice, event, s.opts)...)...)
+	}
+	writeJSON(w, http.StatusCreated, invoice)
+}
+
+// writeJSON writes v as the JSON body of a response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// runServe serves the ingest API on addr until ctx is cancelled
+func runServe(ctx context.Context, store Store, addr string, opts ingestOptions) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", addr, err)
+	}
+
+	s := &ingestServer{store: store, opts: opts}
+	server := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving ingest API", "addr", listener.Addr().String(), "queue", opts.Queue)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("ingest API stopped: %v", err)
+	}
+	slog.Info("Shutting down ingest API")
+	return nil
+}
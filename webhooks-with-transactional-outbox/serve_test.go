@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countRows counts the invoices and events stored
+func countRows(t *testing.T, dbConn *sql.DB) (invoices, events int) {
+	t.Helper()
+	if err := dbConn.QueryRow("SELECT (SELECT COUNT(*) FROM invoices), (SELECT COUNT(*) FROM events)").Scan(&invoices, &events); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	return invoices, events
+}
+
+func TestServeCreateInvoice(t *testing.T) {
+	valid := `{"business_id": "` + businessIDs[0] + `", "amount_cents": 25000, "currency": "EUR", "status": "sent", "description": "Design work"}`
+
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		failEvents  bool
+		wantStatus  int
+		wantStored  bool
+		wantInvalid []string
+	}{
+		{name: "created", method: http.MethodPost, body: valid, wantStatus: http.StatusCreated, wantStored: true},
+		{name: "malformed JSON", method: http.MethodPost, body: `{"amount":`, wantStatus: http.StatusBadRequest},
+		{name: "unknown field", method: http.MethodPost, body: `{"id": "INV-1"}`, wantStatus: http.StatusBadRequest},
+		{
+			name:        "invalid invoice",
+			method:      http.MethodPost,
+			body:        `{"business_id": "acme", "amount_cents": 0, "currency": "EUR", "status": "sent"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantInvalid: []string{"amount_cents", "business_id"},
+		},
+		{name: "event write fails", method: http.MethodPost, body: valid, failEvents: true, wantStatus: http.StatusInternalServerError},
+		{name: "wrong method", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, dbConn := newTestStore(t)
+			opts := testIngestOptions(t)
+			if tt.failEvents {
+				// The invoice is inserted before the codec fails its event
+				opts.Codec = failingCodec{}
+			}
+			server := &ingestServer{store: store, opts: opts}
+
+			rec := httptest.NewRecorder()
+			server.handler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/invoices", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d (%s), want %d", rec.Code, rec.Body.String(), tt.wantStatus)
+			}
+
+			invoices, events := countRows(t, dbConn)
+			if !tt.wantStored {
+				if invoices != 0 || events != 0 {
+					t.Errorf("%d invoices and %d events stored, want none", invoices, events)
+				}
+			} else if invoices != 1 || events != 1 {
+				t.Errorf("%d invoices and %d events stored, want the invoice and its invoice.created event", invoices, events)
+			}
+
+			if tt.wantStatus == http.StatusCreated {
+				var invoice Invoice
+				if err := json.Unmarshal(rec.Body.Bytes(), &invoice); err != nil {
+					t.Fatalf("decoding response: %v", err)
+				}
+				stored, err := store.ListEvents(context.Background())
+				if err != nil {
+					t.Fatalf("listing events: %v", err)
+				}
+				if invoice.ID == "" || invoice.Description != "Design work" || stored[0].AggregateID.String != invoice.ID {
+					t.Errorf("responded with invoice %+v, want the stored one", invoice)
+				}
+			}
+
+			if tt.wantInvalid != nil {
+				var body apiError
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatalf("decoding response: %v", err)
+				}
+				var fields []string
+				for _, field := range body.Fields {
+					fields = append(fields, field.Field)
+				}
+				if strings.Join(fields, ",") != strings.Join(tt.wantInvalid, ",") {
+					t.Errorf("response names fields %v, want %v", fields, tt.wantInvalid)
+				}
+			}
+		})
+	}
+}
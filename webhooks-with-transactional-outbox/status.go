@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+)
+
+// queueDepth is how far a queue is behind: how many events are waiting and
+// how long the oldest of them has been waiting
+type queueDepth struct {
+	Pending          int64
+	OldestPendingAge time.Duration
+}
+
+// readQueueDepth counts the pending events of a queue and the age of the
+// oldest one, which is zero when nothing is pending
+func readQueueDepth(ctx context.Context, queries Querier, queue string) (queueDepth, error) {
+	var depth queueDepth
+
+	pending, err := queries.CountPendingEvents(ctx, queue)
+	if err != nil {
+		return depth, fmt.Errorf("error counting pending events: %v", err)
+	}
+	depth.Pending = pending
+
+	oldest, err := queries.GetOldestPendingEventCreatedAt(ctx, queue)
+	if err != nil && err != sql.ErrNoRows {
+		return depth, fmt.Errorf("error fetching oldest pending event: %v", err)
+	}
+	if oldest.Valid {
+		depth.OldestPendingAge = time.Since(oldest.Time)
+	}
+	return depth, nil
+}
+
+// runStatus writes the state of a queue to w: its depth, the events being
+// processed, processed and dead-lettered, and the last delivery
+func runStatus(ctx context.Context, w io.Writer, queries Querier, queue string) error {
+	depth, err := readQueueDepth(ctx, queries, queue)
+	if err != nil {
+		return err
+	}
+	processing, err := queries.CountProcessingEvents(ctx, queue)
+	if err != nil {
+		return fmt.Errorf("error counting processing events: %v", err)
+	}
+	processed, err := queries.CountProcessedEvents(ctx, queue)
+	if err != nil {
+		return fmt.Errorf("error counting processed events: %v", err)
+	}
+	deadLettered, err := queries.CountDeadLetterEvents(ctx, queue)
+	if err != nil {
+		return fmt.Errorf("error counting dead-lettered events: %v", err)
+	}
+
+	oldest := "-"
+	if depth.Pending > 0 {
+		oldest = depth.OldestPendingAge.Truncate(time.Second).String()
+	}
+
+	// Only publishers that return an ID record deliveries
+	lastDelivery := "-"
+	last, err := queries.GetLastDelivery(ctx, queue)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error fetching last delivery: %v", err)
+	}
+	if err == nil {
+		lastDelivery = fmt.Sprintf("%s (event %s)", last.DeliveryID.String, last.ID)
+	}
+
+	fmt.Fprintf(w, "Queue:               %s\n", queue)
+	fmt.Fprintf(w, "Pending events:      %d\n", depth.Pending)
+	fmt.Fprintf(w, "Oldest pending age:  %s\n", oldest)
+	fmt.Fprintf(w, "Processing events:   %d\n", processing)
+	fmt.Fprintf(w, "Processed events:    %d\n", processed)
+	fmt.Fprintf(w, "Dead-lettered:       %d\n", deadLettered)
+	fmt.Fprintf(w, "Last delivery:       %s\n", lastDelivery)
+	return nil
+}
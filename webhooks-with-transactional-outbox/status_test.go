@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	store, dbConn := newTestStore(t)
+	seedInvoices(t, store, 7, testIngestOptions(t))
+	events, err := store.ListEvents(ctx)
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+
+	// Of the seven events two are processed, one with a delivery ID, two
+	// dead-lettered and the three left pending have waited an hour
+	err = store.MarkEventAsProcessedWithDeliveryID(ctx, db.MarkEventAsProcessedWithDeliveryIDParams{
+		DeliveryID: sql.NullString{String: "msg-1", Valid: true},
+		ID:         events[0].ID,
+	})
+	if err != nil {
+		t.Fatalf("marking event: %v", err)
+	}
+	if err := store.MarkEventAsProcessed(ctx, events[1].ID); err != nil {
+		t.Fatalf("marking event: %v", err)
+	}
+	for _, event := range events[2:4] {
+		if err := moveToDeadLetter(ctx, store, event.ID, deadLetterFailed, "webhook returned 400"); err != nil {
+			t.Fatalf("dead-lettering event: %v", err)
+		}
+	}
+	if _, err := dbConn.Exec("UPDATE events SET created_at = ? WHERE status = 'pending'", time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatalf("backdating events: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runStatus(ctx, &out, store, defaultQueue); err != nil {
+		t.Fatalf("running status: %v", err)
+	}
+	want := []string{
+		"Queue:               default",
+		"Pending events:      3",
+		"Oldest pending age:  1h0m",
+		"Processing events:   0",
+		"Processed events:    2",
+		"Dead-lettered:       2",
+		"Last delivery:       msg-1 (event " + events[0].ID + ")",
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("status printed\n%s\nwant %d lines", out.String(), len(want))
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Errorf("line %d is %q, want %q", i+1, line, want[i])
+		}
+	}
+
+	// Another queue has none of them
+	out.Reset()
+	if err := runStatus(ctx, &out, store, "other"); err != nil {
+		t.Fatalf("running status: %v", err)
+	}
+	if !strings.Contains(out.String(), "Pending events:      0\n") || !strings.Contains(out.String(), "Dead-lettered:       0\n") {
+		t.Errorf("status of an empty queue printed\n%s", out.String())
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// Querier is every query the commands run: those sqlc generates, and the
+// Postgres row lock written by hand in db/claim.go
+type Querier interface {
+	db.Querier
+	LockPendingEvents(ctx context.Context, arg db.GetPendingEventsParams) ([]db.Event, error)
+}
+
+// Store is the datastore the commands work against. The run functions only
+// depend on this interface, so a test can hand them an in-memory fake
+// instead of a database. sqlStore is the implementation over sqlc.
+type Store interface {
+	Querier
+
+	// Begin starts a transaction, whose queries run in it until it is
+	// committed or rolled back. opts may set its isolation level, nil
+	// leaves the driver's default.
+	Begin(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+
+	// Ping checks that the database can still be reached
+	Ping(ctx context.Context) error
+}
+
+// Tx is a transaction started by Store.Begin
+type Tx interface {
+	Querier
+	Commit() error
+	Rollback() error
+}
+
+// sqlStore is a Store over a SQLite or Postgres connection pool. Reopen
+// replaces the pool, so its queries look it up on every call through
+// poolDBTX rather than holding on to it. With --prepared they run through
+// statements prepared on the pool, replaced along with it.
+type sqlStore struct {
+	*db.Queries
+	cfg dbConfig
+
+	mu    sync.RWMutex
+	pool  *sql.DB
+	stmts *db.Statements
+}
+
+func newSQLStore(cfg dbConfig, pool *sql.DB) *sqlStore {
+	s := &sqlStore{cfg: cfg, pool: pool}
+	if cfg.Prepared {
+		s.stmts = db.Prepare(pool)
+	}
+	if cfg.Driver == driverPostgres {
+		s.Queries = db.NewPostgres(poolDBTX{s})
+	} else {
+		s.Queries = db.New(poolDBTX{s})
+	}
+	s.Queries = s.Queries.WithTables(cfg.tables())
+	return s
+}
+
+// conn returns the current connection pool
+func (s *sqlStore) conn() *sql.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pool
+}
+
+// dbtx returns what the queries run on: the prepared statements of the
+// current pool with --prepared, or else the pool itself. Queries in a
+// transaction are prepared by the driver as usual.
+func (s *sqlStore) dbtx() db.DBTX {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stmts != nil {
+		return s.stmts
+	}
+	return s.pool
+}
+
+func (s *sqlStore) Begin(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	tx, err := s.conn().BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Queries: s.Queries.InTx(tx), tx: tx}, nil
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.conn().PingContext(ctx)
+}
+
+// Reopen replaces the connection pool with a new one once the database can
+// be reached, and closes the old one. Queries still running on the old pool
+// fail, and are retried by their callers.
+func (s *sqlStore) Reopen(ctx context.Context) error {
+	pool, err := sql.Open(s.cfg.Driver, s.cfg.dataSource())
+	if err != nil {
+		return err
+	}
+	if err := pool.PingContext(ctx); err != nil {
+		pool.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	old, oldStmts := s.pool, s.stmts
+	s.pool = pool
+	if oldStmts != nil {
+		s.stmts = db.Prepare(pool)
+	}
+	s.mu.Unlock()
+	if oldStmts != nil {
+		oldStmts.Close()
+	}
+	old.Close()
+	return nil
+}
+
+// Close closes the prepared statements, if any, and the connection pool
+func (s *sqlStore) Close() error {
+	s.mu.RLock()
+	stmts := s.stmts
+	s.mu.RUnlock()
+	if stmts != nil {
+		stmts.Close()
+	}
+	return s.conn().Close()
+}
+
+// poolDBTX runs the queries of a sqlStore on its current pool
+type poolDBTX struct {
+	store *sqlStore
+}
+
+func (p poolDBTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.store.dbtx().ExecContext(ctx, query, args...)
+}
+
+func (p poolDBTX) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.store.dbtx().PrepareContext(ctx, query)
+}
+
+func (p poolDBTX) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.store.dbtx().QueryContext(ctx, query, args...)
+}
+
+func (p poolDBTX) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.store.dbtx().QueryRowContext(ctx, query, args...)
+}
+
+// sqlTx is a Tx over a *sql.Tx
+type sqlTx struct {
+	*db.Queries
+	tx *sql.Tx
+}
+
+func (t *sqlTx) Commit() error   { return t.tx.Commit() }
+func (t *sqlTx) Rollback() error { return t.tx.Rollback() }
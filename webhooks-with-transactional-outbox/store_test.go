@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+// openPreparedTestStores opens a fresh temporary database twice, as
+// newTestStore does, once running its queries as text and once through
+// prepared statements, both closed when tb finishes
+func openPreparedTestStores(tb testing.TB) (plain, prepared *sqlStore) {
+	tb.Helper()
+	cfg := testDBConfig(tb)
+	plain, err := openStore(cfg)
+	if err != nil {
+		tb.Fatalf("opening database: %v", err)
+	}
+	tb.Cleanup(func() { plain.Close() })
+	if _, err := plain.conn().Exec(cfg.schema()); err != nil {
+		tb.Fatalf("applying schema: %v", err)
+	}
+	if err := seedDatabase(context.Background(), plain); err != nil {
+		tb.Fatalf("seeding businesses: %v", err)
+	}
+
+	cfg.Prepared = true
+	prepared, err = openStore(cfg)
+	if err != nil {
+		tb.Fatalf("opening database: %v", err)
+	}
+	tb.Cleanup(func() { prepared.Close() })
+	return plain, prepared
+}
+
+func TestPreparedStoreMatchesPlain(t *testing.T) {
+	ctx := context.Background()
+	plain, prepared := openPreparedTestStores(t)
+	seedInvoices(t, prepared, 10, testIngestOptions(t))
+
+	// Each query is run twice, so the second run reuses its statement
+	pending := db.GetPendingEventsParams{Queue: "default", Limit: 100}
+	for i := 0; i < 2; i++ {
+		want, err := plain.GetPendingEvents(ctx, pending)
+		if err != nil {
+			t.Fatalf("fetching events: %v", err)
+		}
+		got, err := prepared.GetPendingEvents(ctx, pending)
+		if err != nil {
+			t.Fatalf("fetching events prepared: %v", err)
+		}
+		if len(want) != 10-5*i || !reflect.DeepEqual(got, want) {
+			t.Fatalf("prepared fetch returned %d events, want the same %d as unprepared", len(got), len(want))
+		}
+
+		for _, event := range want[:5] {
+			if err := prepared.MarkEventAsProcessed(ctx, event.ID); err != nil {
+				t.Fatalf("marking event prepared: %v", err)
+			}
+		}
+		wantCount, err := plain.CountPendingEvents(ctx, "default")
+		if err != nil {
+			t.Fatalf("counting events: %v", err)
+		}
+		gotCount, err := prepared.CountPendingEvents(ctx, "default")
+		if err != nil {
+			t.Fatalf("counting events prepared: %v", err)
+		}
+		if wantCount != int64(5-5*i) || gotCount != wantCount {
+			t.Fatalf("prepared count %d, unprepared %d, want %d", gotCount, wantCount, 5-5*i)
+		}
+	}
+	if n := prepared.stmts.Len(); n == 0 {
+		t.Errorf("no statements prepared")
+	}
+
+	// A reopened pool gets statements of its own
+	if err := prepared.Reopen(ctx); err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	if n := prepared.stmts.Len(); n != 0 {
+		t.Errorf("%d statements carried over to the reopened pool, want none", n)
+	}
+	if count, err := prepared.CountPendingEvents(ctx, "default"); err != nil || count != 0 {
+		t.Errorf("counting after reopening got %d, %v, want 0", count, err)
+	}
+}
+
+func TestPreparedInListBuckets(t *testing.T) {
+	ctx := context.Background()
+	_, prepared := openPreparedTestStores(t)
+	seedInvoices(t, prepared, 45, testIngestOptions(t))
+	events, err := prepared.GetPendingEvents(ctx, db.GetPendingEventsParams{Queue: "default", Limit: 100})
+	if err != nil || len(events) != 45 {
+		t.Fatalf("fetching events got %d, %v, want 45", len(events), err)
+	}
+	before := prepared.stmts.Len()
+
+	// Lists of 1 to 9 IDs are padded to 1, 2, 4, 8 and 16 of them
+	for n := 1; n <= 9; n++ {
+		var ids []string
+		for _, event := range events[:n] {
+			ids = append(ids, event.ID)
+		}
+		events = events[n:]
+		if err := prepared.MarkEventsAsProcessed(ctx, ids); err != nil {
+			t.Fatalf("marking %d events prepared: %v", n, err)
+		}
+	}
+	if n := prepared.stmts.Len() - before; n != 5 {
+		t.Errorf("marking lists of 1 to 9 events prepared %d statements, want 5", n)
+	}
+	if count, err := prepared.CountPendingEvents(ctx, "default"); err != nil || count != 0 {
+		t.Errorf("counting after marking got %d, %v, want every event processed", count, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/frain-dev/webhooks-with-transactional-outbox/db"
+)
+
+func TestTableNamesValidate(t *testing.T) {
+	for _, tables := range []db.Tables{
+		db.DefaultTables,
+		{Events: "outbox_events", Invoices: "Invoices2"},
+		{Events: "_events", Invoices: "billing_invoices"},
+	} {
+		if err := tables.Validate(); err != nil {
+			t.Errorf("%+v: %v", tables, err)
+		}
+	}
+
+	for _, tables := range []db.Tables{
+		{Events: "", Invoices: "invoices"},
+		{Events: "events; DROP TABLE businesses", Invoices: "invoices"},
+		{Events: "events", Invoices: `"invoices"`},
+		{Events: "outbox-events", Invoices: "invoices"},
+		{Events: "1events", Invoices: "invoices"},
+		{Events: "events", Invoices: "EVENTS"},
+		{Events: "dead_letter_events", Invoices: "invoices"},
+	} {
+		if err := tables.Validate(); err == nil {
+			t.Errorf("%+v validated, want an error", tables)
+		}
+	}
+}
+
+func TestTableNamesRename(t *testing.T) {
+	tables := db.Tables{Events: "outbox_events", Invoices: "outbox_invoices"}
+	for query, want := range map[string]string{
+		"SELECT id FROM events WHERE event_type = 'events'":                 "SELECT id FROM outbox_events WHERE event_type = 'events'",
+		"INSERT INTO dead_letter_events SELECT * FROM events":               "INSERT INTO dead_letter_events SELECT * FROM outbox_events",
+		"UPDATE worker_cursors SET events_processed = events_processed + 1": "UPDATE worker_cursors SET events_processed = events_processed + 1",
+		"CREATE INDEX idx_invoices_status ON invoices(status)":              "CREATE INDEX idx_outbox_invoices_status ON outbox_invoices(status)",
+		"-- events can't move\nDELETE FROM events":                          "-- events can't move\nDELETE FROM outbox_events",
+		"ALTER TABLE invoices_new RENAME TO invoices":                       "ALTER TABLE outbox_invoices_new RENAME TO outbox_invoices",
+	} {
+		if got := tables.Rename(query); got != want {
+			t.Errorf("Rename(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestCustomTableNames(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDBConfig(t)
+	cfg.Tables = db.Tables{Events: "outbox_events", Invoices: "outbox_invoices"}
+	store, err := openStore(cfg)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer store.Close()
+	dbConn := store.conn()
+
+	// The database already has tables of the default names, meaning
+	// something else
+	if _, err := dbConn.Exec("CREATE TABLE events (name TEXT); CREATE TABLE invoices (number INTEGER); INSERT INTO events VALUES ('launch')"); err != nil {
+		t.Fatalf("creating the existing tables: %v", err)
+	}
+
+	migrations, err := cfg.migrations()
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	if _, err := newMigrator(dbConn, migrations).up(ctx); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	if err := seedDatabase(ctx, store); err != nil {
+		t.Fatalf("seeding businesses: %v", err)
+	}
+
+	seedInvoices(t, store, 3, testIngestOptions(t))
+	publisher := &fakePublisher{}
+	opts := testWorkerOptions()
+	opts.Once = true
+	if err := runWorker(ctx, store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	if len(publisher.published) != 3 {
+		t.Errorf("published %d events, want 3", len(publisher.published))
+	}
+
+	for query, want := range map[string]int{
+		"SELECT COUNT(*) FROM outbox_invoices":                             3,
+		"SELECT COUNT(*) FROM outbox_events WHERE status = 'processed'":    3,
+		"SELECT COUNT(*) FROM events WHERE name = 'launch'":                1,
+		"SELECT COUNT(*) FROM invoices":                                    0,
+		"SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'idx_events%'": 0,
+	} {
+		var got int
+		if err := dbConn.QueryRow(query).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if got != want {
+			t.Errorf("%s = %d, want %d", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// headerTraceparent carries the trace context of the ingest span from the
+// stored event to the worker, in the W3C Trace Context format
+const headerTraceparent = "traceparent"
+
+// tracerShutdownTimeout is how long the last spans have to reach the
+// collector on exit
+const tracerShutdownTimeout = 5 * time.Second
+
+// tracePropagator reads and writes the traceparent of event headers
+var tracePropagator = propagation.TraceContext{}
+
+// tracerScope names the instrumentation the spans come from
+const tracerScope = "github.com/frain-dev/webhooks-with-transactional-outbox"
+
+// otlpTraceURL returns the OTLP/HTTP traces URL of the collector at
+// endpoint, adding the /v1/traces path when it isn't there
+func otlpTraceURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid --otlp-endpoint %q: must be an http or https URL", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// newOTLPTracerProvider returns a tracer provider exporting the spans of
+// service in batches to the OTLP/HTTP collector at endpoint, or nil when
+// endpoint is empty. A batch that can't be sent is logged by OpenTelemetry
+// and dropped, so tracing never holds up ingest or delivery.
+func newOTLPTracerProvider(endpoint, service string) (*sdktrace.TracerProvider, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	traceURL, err := otlpTraceURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// Creating the exporter doesn't connect, so this only fails on options
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(traceURL))
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP exporter: %v", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	), nil
+}
+
+// providerTracer returns the tracer of provider, nil when tracing is
+// disabled
+func providerTracer(provider *sdktrace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		return nil
+	}
+	return provider.Tracer(tracerScope, trace.WithInstrumentationVersion(version))
+}
+
+// flushTracer shuts provider down on exit, giving the last spans up to
+// tracerShutdownTimeout to reach the collector. It is a no-op on a nil
+// provider.
+func flushTracer(provider *sdktrace.TracerProvider) {
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		slog.Warn("Error exporting the last spans", "error", err)
+	}
+}
+
+// startSpan starts a span of tracer named name, with a span that records
+// nothing when tracer is nil
+func startSpan(ctx context.Context, tracer trace.Tracer, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer(tracerScope)
+	}
+	return tracer.Start(ctx, name, opts...)
+}
+
+// endSpan ends span, marking it failed with err when it is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
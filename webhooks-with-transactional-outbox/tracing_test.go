@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// namedSpans returns the spans exporter got called name
+func namedSpans(exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStubs {
+	var spans tracetest.SpanStubs
+	for _, s := range exporter.GetSpans() {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTraceFromIngestToDelivery(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(ctx)
+	tracer := providerTracer(provider)
+
+	ingestOpts := testIngestOptions(t)
+	ingestOpts.Rate = time.Millisecond
+	ingestOpts.Count = 1
+	ingestOpts.BusinessIDs = businessIDs
+	ingestOpts.Tracer = tracer
+	if err := runIngest(ctx, store, ingestOpts); err != nil {
+		t.Fatalf("running ingest: %v", err)
+	}
+	ingested := namedSpans(exporter, "ingest invoice")
+	if len(ingested) != 1 {
+		t.Fatalf("got %d ingest spans, want 1", len(ingested))
+	}
+
+	publisher := &fakePublisher{}
+	opts := testWorkerOptions()
+	opts.Once = true
+	opts.Tracer = tracer
+	if err := runWorker(ctx, store, publisher, opts); err != nil {
+		t.Fatalf("running worker: %v", err)
+	}
+	delivered := namedSpans(exporter, "deliver event")
+	if len(delivered) != 1 {
+		t.Fatalf("got %d delivery spans, want 1", len(delivered))
+	}
+
+	ingest, delivery := ingested[0].SpanContext, delivered[0]
+	if ingested[0].SpanKind != trace.SpanKindProducer || delivery.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("spans are %v and %v, want producer and consumer", ingested[0].SpanKind, delivery.SpanKind)
+	}
+	if delivery.Parent.SpanID() != ingest.SpanID() || delivery.SpanContext.TraceID() != ingest.TraceID() {
+		t.Errorf("delivery span has parent %s in trace %s, want the ingest span %s", delivery.Parent.SpanID(), delivery.SpanContext.TraceID(), ingest.SpanID())
+	}
+	if len(delivery.Links) != 1 || delivery.Links[0].SpanContext.SpanID() != ingest.SpanID() || delivery.Links[0].SpanContext.TraceID() != ingest.TraceID() {
+		t.Errorf("delivery span links to %v, want the ingest span", delivery.Links)
+	}
+	want := fmt.Sprintf("00-%s-%s-01", delivery.SpanContext.TraceID(), delivery.SpanContext.SpanID())
+	if got := publisher.headers[0][headerTraceparent]; got != want {
+		t.Errorf("delivered with traceparent %q, want the delivery span's %q", got, want)
+	}
+}
+
+func TestOTLPTraceURL(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"http://localhost:4318":           "http://localhost:4318/v1/traces",
+		"https://collector:4318/":         "https://collector:4318/v1/traces",
+		"http://collector/otlp/v1/traces": "http://collector/otlp/v1/traces",
+		"grpc://localhost:4317":           "",
+		"localhost:4318":                  "",
+		"http://":                         "",
+	} {
+		got, err := otlpTraceURL(endpoint)
+		if want == "" {
+			if err == nil {
+				t.Errorf("otlpTraceURL(%q) = %q, want an error", endpoint, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("otlpTraceURL(%q) = %q, %v, want %q", endpoint, got, err, want)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got collectortrace.ExportTraceServiceRequest
+	var path, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, &got); err != nil {
+			t.Errorf("decoding export: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := newOTLPTracerProvider(server.URL, "test")
+	if err != nil {
+		t.Fatalf("creating tracer: %v", err)
+	}
+	tracer := providerTracer(provider)
+	ctx, parent := tracer.Start(context.Background(), "parent", trace.WithSpanKind(trace.SpanKindProducer))
+	_, child := tracer.Start(ctx, "child", trace.WithSpanKind(trace.SpanKindConsumer))
+	child.End()
+	parent.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("exporting spans: %v", err)
+	}
+
+	if path != "/v1/traces" || contentType != "application/x-protobuf" {
+		t.Errorf("spans posted to %q as %q, want /v1/traces as protobuf", path, contentType)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans[0].Spans) != 2 {
+		t.Fatalf("collector got %v, want both spans", &got)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if spans[0].Name != "child" || string(spans[0].ParentSpanId) != string(spans[1].SpanId) || string(spans[0].TraceId) != string(spans[1].TraceId) {
+		t.Errorf("collector got spans %v, want the child of the parent span", spans)
+	}
+}